@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"lol.mleku.dev/chk"
+	"lol.mleku.dev/log"
+	"next.orly.dev/app/config"
+	"next.orly.dev/pkg/crypto/p256k"
+	"next.orly.dev/pkg/database"
+	"next.orly.dev/pkg/encoders/event"
+	"next.orly.dev/pkg/encoders/filter"
+	"next.orly.dev/pkg/encoders/hex"
+	"next.orly.dev/pkg/replication"
+	"next.orly.dev/pkg/utils"
+)
+
+// runAdmin runs one of the config.AdminCommands on the database and returns
+// the exit code of the program.
+func runAdmin(cfg *config.C, cmd string, args []string) (code int) {
+	var err error
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var db *database.D
+	if db, err = database.New(
+		ctx, cancel, cfg.DataDir, cfg.DBLogLevel,
+	); chk.E(err) {
+		return 1
+	}
+	defer db.Close()
+	switch cmd {
+	case "export":
+		err = adminExport(ctx, db, args)
+	case "import":
+		err = adminImport(db, args)
+	case "compact":
+		err = db.Compact()
+	case "stats":
+		err = adminStats(ctx, db)
+	case "verify":
+		err = adminVerify(ctx, db)
+	case "wipe":
+		err = adminWipe(db, args)
+	case "promote":
+		err = adminPromote(cfg, db)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", cmd, err)
+		return 1
+	}
+	return
+}
+
+// adminExport writes the stored events, or those matching the filter in the
+// first argument, to stdout as JSONL.
+func adminExport(ctx context.Context, db *database.D, args []string) (err error) {
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	if len(args) == 0 {
+		db.Export(ctx, w)
+		return
+	}
+	f := filter.New()
+	if _, err = f.Unmarshal([]byte(args[0])); err != nil {
+		return fmt.Errorf("invalid filter %s: %w", args[0], err)
+	}
+	var count int
+	if err = db.ScanEvents(
+		ctx, func(_ uint64, ev *event.E) (err error) {
+			if !f.Matches(ev) {
+				return
+			}
+			if _, err = w.Write(ev.Serialize()); err != nil {
+				return
+			}
+			count++
+			return w.WriteByte('\n')
+		},
+	); err != nil {
+		return
+	}
+	log.I.F("exported %d events", count)
+	return
+}
+
+// adminImport saves the events in the JSONL file named by the first argument,
+// or stdin if it is -.
+func adminImport(db *database.D, args []string) (err error) {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: import <file|->")
+	}
+	var r io.Reader = os.Stdin
+	if args[0] != "-" {
+		var f *os.File
+		if f, err = os.Open(args[0]); err != nil {
+			return
+		}
+		defer f.Close()
+		r = f
+	}
+	var count int
+	if count, err = db.ImportEvents(r); err != nil {
+		return
+	}
+	fmt.Printf("imported %d events\n", count)
+	return
+}
+
+// adminStats prints the number of stored events of each kind, the number and
+// size of the keys of each index and the size of the database files.
+func adminStats(ctx context.Context, db *database.D) (err error) {
+	kinds := make(map[uint16]int)
+	var total int
+	if err = db.ScanEvents(
+		ctx, func(_ uint64, ev *event.E) (err error) {
+			kinds[ev.Kind]++
+			total++
+			return
+		},
+	); err != nil {
+		return
+	}
+	ks := make([]int, 0, len(kinds))
+	for k := range kinds {
+		ks = append(ks, int(k))
+	}
+	sort.Ints(ks)
+	fmt.Printf("events: %d\n\n%-8s %12s\n", total, "kind", "events")
+	for _, k := range ks {
+		fmt.Printf("%-8d %12d\n", k, kinds[uint16(k)])
+	}
+	var stats map[string]*database.KeyStats
+	if stats, err = db.KeyStats(); err != nil {
+		return
+	}
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Printf("\n%-16s %12s %14s\n", "keys", "count", "bytes")
+	for _, name := range names {
+		fmt.Printf(
+			"%-16s %12d %14d\n", name, stats[name].Keys, stats[name].Bytes,
+		)
+	}
+	lsm, vlog := db.Size()
+	fmt.Printf("\nlsm tree: %d bytes\nvalue log: %d bytes\n", lsm, vlog)
+	return
+}
+
+// adminVerify checks that the ID of each stored event is the hash of its
+// canonical form and that its signature is valid, printing the events that
+// fail.
+func adminVerify(ctx context.Context, db *database.D) (err error) {
+	var checked, bad int
+	if err = db.ScanEvents(
+		ctx, func(ser uint64, ev *event.E) (err error) {
+			checked++
+			var problem string
+			if !utils.FastEqual(ev.GetIDBytes(), ev.ID) {
+				problem = "id does not match content"
+			} else {
+				keys := new(p256k.Signer)
+				if err = keys.InitPub(ev.Pubkey); err != nil {
+					problem = "invalid pubkey: " + err.Error()
+				} else if valid, _ := keys.Verify(ev.ID, ev.Sig); !valid {
+					problem = "invalid signature"
+				}
+				err = nil
+			}
+			if problem != "" {
+				bad++
+				fmt.Printf(
+					"serial %d id %s kind %d: %s\n", ser, hex.Enc(ev.ID),
+					ev.Kind, problem,
+				)
+			}
+			return
+		},
+	); err != nil {
+		return
+	}
+	fmt.Printf("checked %d events, %d invalid\n", checked, bad)
+	if bad > 0 {
+		err = fmt.Errorf("%d invalid events", bad)
+	}
+	return
+}
+
+// adminWipe wipes the database, keeping the relay identity and subscriptions
+// if asked to. It refuses to run without --yes.
+func adminWipe(db *database.D, args []string) (err error) {
+	var o database.WipeOptions
+	var confirmed bool
+	for _, a := range args {
+		switch a {
+		case "--yes":
+			confirmed = true
+		case "--keep-identity":
+			o.KeepIdentity = true
+		case "--keep-subscriptions":
+			o.KeepSubscriptions = true
+		default:
+			return fmt.Errorf("unknown argument %s", a)
+		}
+	}
+	if !confirmed {
+		return fmt.Errorf(
+			"usage: wipe --yes [--keep-identity] [--keep-subscriptions]",
+		)
+	}
+	if err = db.WipeWith(o); err != nil {
+		return
+	}
+	fmt.Println("database wiped")
+	return
+}
+
+// adminPromote records that the relay no longer follows the leader it is
+// configured with, so it starts as a leader.
+func adminPromote(cfg *config.C, db *database.D) (err error) {
+	if cfg.LeaderURL == "" {
+		return fmt.Errorf("ORLY_LEADER_URL is not set, the relay is not a follower")
+	}
+	if err = replication.Promote(db, cfg.LeaderURL); err != nil {
+		return
+	}
+	fmt.Printf("promoted, no longer following %s\n", cfg.LeaderURL)
+	return
+}
@@ -0,0 +1,249 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"lol.mleku.dev/chk"
+	"lol.mleku.dev/log"
+	"next.orly.dev/pkg/acl"
+	"next.orly.dev/pkg/database"
+	"next.orly.dev/pkg/encoders/envelopes/authenvelope"
+	"next.orly.dev/pkg/encoders/envelopes/changesenvelope"
+	"next.orly.dev/pkg/encoders/envelopes/closedenvelope"
+	"next.orly.dev/pkg/encoders/envelopes/eoseenvelope"
+	"next.orly.dev/pkg/encoders/reason"
+	acli "next.orly.dev/pkg/interfaces/acl"
+)
+
+const (
+	// changesBatch is the number of changes read from the database in one
+	// transaction, after which the feed is flushed to the consumer.
+	changesBatch = 1000
+	// changesPoll is how often a followed feed checks for new changes once
+	// it has caught up.
+	changesPoll = time.Second
+)
+
+// feedAllowed returns whether a pubkey may read the change feed, which
+// contains every stored event regardless of who may read it, so it is only
+// for the configured admins and owners and pubkeys with admin access.
+func (s *Server) feedAllowed(pubkey []byte, remote string) bool {
+	if len(pubkey) == 0 {
+		return false
+	}
+	if s.isAdminOrOwner(pubkey) {
+		return true
+	}
+	return acli.Rank(acl.Registry.GetAccessLevel(pubkey, remote)) >=
+		acli.Rank(acli.Admin)
+}
+
+// streamChanges calls write with the changes of the feed from a serial in
+// batches, calling flush after each batch, until limit changes were written
+// if it is not zero. If follow is set it then waits for new changes until the
+// context is canceled, calling caughtUp each time there are no more.
+func (s *Server) streamChanges(
+	ctx context.Context, from uint64, limit int, follow bool,
+	write func(ch *database.Change) (err error), flush, caughtUp func(),
+) (err error) {
+	var written int
+	for {
+		batch := changesBatch
+		if limit > 0 && limit-written < batch {
+			batch = limit - written
+		}
+		var n int
+		if err = s.Changes(
+			ctx, from, batch, func(ch *database.Change) (err error) {
+				if err = write(ch); err != nil {
+					return
+				}
+				from = ch.Serial + 1
+				n++
+				return
+			},
+		); err != nil {
+			return
+		}
+		written += n
+		flush()
+		if limit > 0 && written >= limit {
+			return
+		}
+		if n == batch {
+			continue
+		}
+		if caughtUp != nil {
+			caughtUp()
+		}
+		if !follow {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(changesPoll):
+		}
+	}
+}
+
+// handleChanges streams the change feed as JSONL: a JSON object for each
+// stored event or deletion, in order of their serials, with the serial and
+// either the event or the hex ID of the deleted event. The after parameter is
+// the serial of the last change the consumer has read, without which the feed
+// starts from the beginning; limit bounds the number of changes, and with
+// follow the response stays open and new changes are sent as they happen.
+// While a followed feed has no new changes an empty line is sent each time it
+// checks for them, which tells the consumer it has caught up and keeps the
+// response alive. Admins only.
+func (s *Server) handleChanges(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.feedAllowed(authedPubkey(r), GetRemoteFromReq(r)) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	q := r.URL.Query()
+	var from uint64
+	if a := q.Get("after"); a != "" {
+		after, err := strconv.ParseUint(a, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid after", http.StatusBadRequest)
+			return
+		}
+		from = after + 1
+	}
+	var limit int
+	if l := q.Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit < 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	follow, _ := strconv.ParseBool(q.Get("follow"))
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	var heartbeat func()
+	if follow {
+		heartbeat = func() {
+			if _, err := w.Write([]byte{'\n'}); err == nil {
+				flush()
+			}
+		}
+	}
+	var buf []byte
+	if err := s.streamChanges(
+		r.Context(), from, limit, follow,
+		func(ch *database.Change) (err error) {
+			buf = append(ch.Marshal(buf[:0]), '\n')
+			_, err = w.Write(buf)
+			return
+		}, flush, heartbeat,
+	); err != nil && r.Context().Err() == nil {
+		log.E.F("change feed to %s failed: %v", GetRemoteFromReq(r), err)
+	}
+}
+
+// HandleChanges processes a CHANGES envelope, the websocket variant of the
+// change feed. The changes from the cursor are sent as CHANGE envelopes and
+// followed by an EOSE, after which new changes are sent as they happen until
+// the subscription is closed with CLOSE or the connection ends.
+//
+// A CHANGES for a subscription that is already open replaces it.
+func (l *Listener) HandleChanges(msg []byte) (err error) {
+	env := changesenvelope.New()
+	if _, err = env.Unmarshal(msg); chk.E(err) {
+		return
+	}
+	sub := string(env.Subscription)
+	if l.rateLimited(l.rateLimits.Reqs, 1) {
+		return closedenvelope.NewFrom(
+			env.Subscription,
+			reason.RateLimited.F("too many subscriptions, slow down"),
+		).Write(l)
+	}
+	l.closeChanges(sub)
+	if rejection := l.SubscriptionsExceeded(sub); rejection != "" {
+		return closedenvelope.NewFrom(
+			env.Subscription, reason.Blocked.F("%s", rejection),
+		).Write(l)
+	}
+	pubkey := l.authedPubkey.Load()
+	if len(pubkey) == 0 {
+		if err = authenvelope.NewChallengeWith(l.challenge.Load()).
+			Write(l); chk.E(err) {
+			return
+		}
+		return closedenvelope.NewFrom(
+			env.Subscription,
+			reason.AuthRequired.F("the change feed is only for admins"),
+		).Write(l)
+	}
+	if !l.feedAllowed(pubkey, l.remote) {
+		return closedenvelope.NewFrom(
+			env.Subscription,
+			reason.Restricted.F("the change feed is only for admins"),
+		).Write(l)
+	}
+	var from uint64
+	if env.After >= 0 {
+		from = uint64(env.After) + 1
+	}
+	ctx, cancel := context.WithCancel(l.ctx)
+	if l.changes == nil {
+		l.changes = make(map[string]context.CancelFunc)
+	}
+	l.changes[sub] = cancel
+	go func() {
+		defer cancel()
+		var buf []byte
+		var eose bool
+		if err := l.streamChanges(
+			ctx, from, 0, true,
+			func(ch *database.Change) (err error) {
+				buf = ch.Marshal(buf[:0])
+				return changesenvelope.NewChangeFrom(
+					env.Subscription, buf,
+				).Write(l)
+			},
+			func() {},
+			func() {
+				if !eose {
+					eose = true
+					chk.E(eoseenvelope.NewFrom(env.Subscription).Write(l))
+				}
+			},
+		); err != nil && ctx.Err() == nil {
+			log.E.F("change feed to %s failed: %v", l.remote, err)
+			chk.E(
+				closedenvelope.NewFrom(
+					env.Subscription, reason.Error.F("%s", err.Error()),
+				).Write(l),
+			)
+		}
+	}()
+	return
+}
+
+// closeChanges stops a change feed subscription, returning whether there was
+// one.
+func (l *Listener) closeChanges(sub string) bool {
+	cancel, ok := l.changes[sub]
+	if ok {
+		cancel()
+		delete(l.changes, sub)
+	}
+	return ok
+}
@@ -27,7 +27,7 @@ type C struct {
 	DataDir             string        `env:"ORLY_DATA_DIR" usage:"storage location for the event store" default:"~/.local/share/ORLY"`
 	Listen              string        `env:"ORLY_LISTEN" default:"0.0.0.0" usage:"network listen address"`
 	Port                int           `env:"ORLY_PORT" default:"3334" usage:"port to listen on"`
-	HealthPort          int           `env:"ORLY_HEALTH_PORT" default:"0" usage:"optional health check HTTP port; 0 disables"`
+	HealthPort          int           `env:"ORLY_HEALTH_PORT" default:"0" usage:"optional health check HTTP port, also serving Prometheus metrics at /metrics; 0 disables"`
 	EnableShutdown      bool          `env:"ORLY_ENABLE_SHUTDOWN" default:"false" usage:"if true, expose /shutdown on the health port to gracefully stop the process (for profiling)"`
 	LogLevel            string        `env:"ORLY_LOG_LEVEL" default:"info" usage:"relay log level: fatal error warn info debug trace"`
 	DBLogLevel          string        `env:"ORLY_DB_LOG_LEVEL" default:"info" usage:"database log level: fatal error warn info debug trace"`
@@ -39,9 +39,46 @@ type C struct {
 	IPWhitelist         []string      `env:"ORLY_IP_WHITELIST" usage:"comma-separated list of IP addresses to allow access from, matches on prefixes to allow private subnets, eg 10.0.0 = 10.0.0.0/8"`
 	Admins              []string      `env:"ORLY_ADMINS" usage:"comma-separated list of admin npubs"`
 	Owners              []string      `env:"ORLY_OWNERS" usage:"comma-separated list of owner npubs, who have full control of the relay for wipe and restart and other functions"`
-	ACLMode             string        `env:"ORLY_ACL_MODE" usage:"ACL mode: follows,none" default:"none"`
+	ACLMode             string        `env:"ORLY_ACL_MODE" usage:"ACL mode, or a chain of them separated by commas that are asked in order: blocklist,follows,groups,whitelist,paid,wot,none" default:"none"`
+	BlocklistFile       string        `env:"ORLY_BLOCKLIST_FILE" usage:"file of npubs or hex pubkeys, one per line, denied all access in blocklist ACL mode"`
+	WhitelistFile       string        `env:"ORLY_WHITELIST_FILE" usage:"file of npubs or hex pubkeys, one per line, given write access in whitelist ACL mode"`
+	WhitelistPrivate    bool          `env:"ORLY_WHITELIST_PRIVATE" default:"false" usage:"in whitelist ACL mode, also deny reads to pubkeys not on the whitelist"`
+	WotDepth            int           `env:"ORLY_WOT_DEPTH" default:"2" usage:"in wot ACL mode, how many follows away from the admins a pubkey may be to get write access"`
+	WotMinFollowers     int           `env:"ORLY_WOT_MIN_FOLLOWERS" default:"0" usage:"in wot ACL mode, how many pubkeys of the previous depth must follow a pubkey beyond the direct follows of the admins"`
+	WotRefresh          time.Duration `env:"ORLY_WOT_REFRESH" default:"1h" usage:"in wot ACL mode, how often the follow graph is rebuilt from the database; 0 disables"`
 	SpiderMode          string        `env:"ORLY_SPIDER_MODE" usage:"spider mode: none,follows" default:"none"`
 	SpiderFrequency     time.Duration `env:"ORLY_SPIDER_FREQUENCY" usage:"spider frequency in seconds" default:"1h"`
+	ExpirationInterval  time.Duration `env:"ORLY_EXPIRATION_INTERVAL" usage:"how often to purge events with a past NIP-40 expiration; 0 disables" default:"10m"`
+	TombstoneRetention  time.Duration `env:"ORLY_TOMBSTONE_RETENTION" usage:"how long the tombstones of deleted events are kept in the change feed; followers further behind miss the deletions; 0 keeps them forever" default:"720h"`
+	PowMinDifficulty    int           `env:"ORLY_POW_MIN_DIFFICULTY" default:"0" usage:"minimum NIP-13 proof of work difficulty for all events; 0 disables"`
+	PowKindDifficulty   []string      `env:"ORLY_POW_KIND_DIFFICULTY" usage:"comma-separated list of kind:difficulty pairs requiring more proof of work for some kinds, eg 1:20,7:16"`
+	PowUnfollowed       int           `env:"ORLY_POW_UNFOLLOWED_DIFFICULTY" default:"0" usage:"proof of work difficulty that lets pubkeys not followed under the follows ACL publish; 0 disables"`
+	KindPolicy          string        `env:"ORLY_KIND_POLICY" usage:"JSON file of rules for ranges of kinds saying who may write and read them and their maximum size and age, replaced by edits through /api/policy"`
+	WritePolicyPlugin   string        `env:"ORLY_WRITE_POLICY_PLUGIN" usage:"executable that is sent each new event as a JSON line on stdin and answers accept, reject or shadowReject on stdout, as strfry write policy plugins do"`
+	WritePolicyTimeout  time.Duration `env:"ORLY_WRITE_POLICY_TIMEOUT" default:"2s" usage:"how long the write policy plugin may take to answer before it is restarted"`
+	WritePolicyFailOpen bool          `env:"ORLY_WRITE_POLICY_FAIL_OPEN" default:"false" usage:"accept events when the write policy plugin fails or times out, instead of rejecting them"`
+	WebhooksFile        string        `env:"ORLY_WEBHOOKS_FILE" usage:"JSON file of webhooks, each with a name, a URL and a NIP-01 filter, that stored events matching the filter are posted to, signed by the relay identity"`
+	LeaderURL           string        `env:"ORLY_LEADER_URL" usage:"URL of an ORLY relay to run as a hot standby follower of, replicating its events and deletions from its change feed; the relay identity must be an admin of the leader"`
+	FollowerWrites      string        `env:"ORLY_FOLLOWER_WRITES" default:"forward" usage:"what a follower does with events published to it: forward them to the leader, or reject them"`
+	DMInbox             bool          `env:"ORLY_DM_INBOX" default:"false" usage:"act as a NIP-17 DM inbox: kinds 4, 1059 and 10050 require auth and are only released to their author or p tagged recipient"`
+	DMInboxLocalOnly    bool          `env:"ORLY_DM_INBOX_LOCAL_ONLY" default:"false" usage:"in DM inbox mode, reject gift wraps whose recipient does not have write access to the relay"`
+	MaxMessageLength    int           `env:"ORLY_MAX_MESSAGE_LENGTH" default:"1000000" usage:"maximum size in bytes of a websocket message from a client"`
+	MaxSubscriptions    int           `env:"ORLY_MAX_SUBSCRIPTIONS" default:"100" usage:"maximum number of open subscriptions on one connection; 0 is unlimited"`
+	MaxFilters          int           `env:"ORLY_MAX_FILTERS" default:"50" usage:"maximum number of filters in one REQ; 0 is unlimited"`
+	MaxLimit            int           `env:"ORLY_MAX_LIMIT" default:"5000" usage:"limit that the limit of each filter is clamped to; 0 is unlimited"`
+	MaxEventTags        int           `env:"ORLY_MAX_EVENT_TAGS" default:"2500" usage:"maximum number of tags in an event; 0 is unlimited"`
+	MaxContentLength    int           `env:"ORLY_MAX_CONTENT_LENGTH" default:"0" usage:"maximum number of characters in the content of an event; 0 is unlimited"`
+	CreatedAtLowerLimit time.Duration `env:"ORLY_CREATED_AT_LOWER_LIMIT" default:"0" usage:"how far in the past the created_at of a new event may be; 0 is unlimited"`
+	CreatedAtUpperLimit time.Duration `env:"ORLY_CREATED_AT_UPPER_LIMIT" default:"15m" usage:"how far in the future the created_at of a new event may be; 0 is unlimited"`
+	RateLimitEvents     int           `env:"ORLY_RATE_LIMIT_EVENTS" default:"0" usage:"EVENT messages per minute allowed from one IP address or pubkey; 0 disables, e.g. 60"`
+	RateLimitReqs       int           `env:"ORLY_RATE_LIMIT_REQS" default:"0" usage:"REQ, COUNT and NEG-OPEN messages per minute allowed from one IP address or pubkey; 0 disables, e.g. 120"`
+	RateLimitBytes      int           `env:"ORLY_RATE_LIMIT_BYTES" default:"0" usage:"bytes per minute a client may send from one IP address or pubkey; 0 disables, e.g. 10000000"`
+	RateLimitScale      int           `env:"ORLY_RATE_LIMIT_SCALE" default:"4" usage:"multiplier of the rate limits for clients with write access, admins are not limited"`
+	RateLimitStrikes    int           `env:"ORLY_RATE_LIMIT_STRIKES" default:"20" usage:"number of rate limited messages after which a connection is closed; 0 never closes"`
+	WSCompression       string        `env:"ORLY_WS_COMPRESSION" default:"no-context-takeover" usage:"websocket permessage-deflate mode for clients and outbound connections: disabled,no-context-takeover,context-takeover"`
+	WSCompressionMin    int           `env:"ORLY_WS_COMPRESSION_MIN" default:"512" usage:"minimum size in bytes of a websocket message to compress"`
+	TrustProxy          bool          `env:"ORLY_TRUST_PROXY" default:"false" usage:"trust the X-Forwarded-Proto and X-Forwarded-Host headers of a reverse proxy in front of the relay when checking the URL of NIP-98 auth events"`
+	AuthMaxBody         int64         `env:"ORLY_AUTH_MAX_BODY" default:"10485760" usage:"largest request body in bytes that is read to check the payload of a NIP-98 auth event"`
 	BootstrapRelays     []string      `env:"ORLY_BOOTSTRAP_RELAYS" usage:"comma-separated list of bootstrap relay URLs for initial sync"`
 	NWCUri              string        `env:"ORLY_NWC_URI" usage:"NWC (Nostr Wallet Connect) connection string for Lightning payments"`
 	SubscriptionEnabled bool          `env:"ORLY_SUBSCRIPTION_ENABLED" default:"false" usage:"enable subscription-based access control requiring payment for non-directory events"`
@@ -156,6 +193,30 @@ func IdentityRequested() (requested bool) {
 	return
 }
 
+// AdminCommands are the subcommands that open the database directly to
+// maintain it while the relay is not running.
+var AdminCommands = []string{
+	"export", "import", "compact", "stats", "verify", "wipe", "promote",
+}
+
+// AdminRequested checks if the first command line argument is one of the
+// AdminCommands.
+//
+// Return Values
+//   - cmd: the subcommand in lower case, or empty if none was provided.
+//   - args: the command line arguments after the subcommand.
+func AdminRequested() (cmd string, args []string) {
+	if len(os.Args) > 1 {
+		c := strings.ToLower(os.Args[1])
+		for _, a := range AdminCommands {
+			if c == a {
+				return c, os.Args[2:]
+			}
+		}
+	}
+	return
+}
+
 // KV is a key/value pair.
 type KV struct{ Key, Value string }
 
@@ -289,10 +350,24 @@ func PrintHelp(cfg *C, printer io.Writer) {
 	)
 	_, _ = fmt.Fprintf(
 		printer,
-		`Usage: %s [env|help]
+		`Usage: %s [env|help|identity|export|import|compact|stats|verify|wipe|promote]
 
 - env: print environment variables configuring %s
 - help: print this help text
+- identity: print the relay identity secret and pubkey
+- export [filter]: write the stored events, or those matching a JSON filter,
+  as JSONL to stdout
+- import file: save the events in a JSONL file, or stdin if the file is -
+- compact: flatten the database and reclaim value log space
+- stats: print the number of events of each kind and the size of each index
+- verify: check the IDs and signatures of all stored events
+- wipe --yes [--keep-identity] [--keep-subscriptions]: delete the events,
+  indexes and markers, and the relay identity and subscriptions unless kept
+- promote: stop following the leader of ORLY_LEADER_URL, to run as a leader
+  until a different leader is configured
+
+The subcommands after identity open the database directly, and must be run
+while the relay is stopped.
 
 `,
 		cfg.AppName, cfg.AppName,
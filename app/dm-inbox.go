@@ -0,0 +1,77 @@
+package app
+
+import (
+	"next.orly.dev/pkg/acl"
+	"next.orly.dev/pkg/encoders/event"
+	"next.orly.dev/pkg/encoders/filter"
+	"next.orly.dev/pkg/encoders/hex"
+	"next.orly.dev/pkg/encoders/kind"
+	"next.orly.dev/pkg/utils"
+)
+
+// dmInboxVisible returns whether an event of one of the DM inbox kinds may be
+// released to a pubkey, which must be its author or tagged in a p tag.
+func dmInboxVisible(ev *event.E, pub []byte) bool {
+	if len(pub) == 0 {
+		return false
+	}
+	if utils.FastEqual(ev.Pubkey, pub) {
+		return true
+	}
+	for _, p := range ev.Tags.GetAll([]byte("p")) {
+		if pk, err := hex.Dec(string(p.Value())); err == nil &&
+			utils.FastEqual(pk, pub) {
+			return true
+		}
+	}
+	return false
+}
+
+// dmRecipientLocal returns whether a recipient in the p tags of an event has
+// write access to the relay. It needs an ACL that decides who the users of
+// the relay are, so it is refused at startup with the none ACL, which gives
+// everyone write access.
+func dmRecipientLocal(ev *event.E) bool {
+	for _, p := range ev.Tags.GetAll([]byte("p")) {
+		pk, err := hex.Dec(string(p.Value()))
+		if err != nil {
+			continue
+		}
+		switch acl.Registry.GetAccessLevel(pk, "") {
+		case "write", "admin", "owner":
+			return true
+		}
+	}
+	return false
+}
+
+// requestsDMInbox returns whether any of a set of filters explicitly asks for
+// one of the DM inbox kinds.
+func requestsDMInbox(fs *filter.S) bool {
+	if fs == nil {
+		return false
+	}
+	for _, f := range *fs {
+		if f == nil || f.Kinds == nil {
+			continue
+		}
+		for _, k := range f.Kinds.K {
+			if kind.IsDMInbox(k.K) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// mayMatchDMInbox returns whether a filter may match events of the DM inbox
+// kinds, because it has no kinds or includes one of them.
+func mayMatchDMInbox(f *filter.F) bool {
+	if f == nil {
+		return false
+	}
+	if f.Kinds == nil || f.Kinds.Len() == 0 {
+		return true
+	}
+	return requestsDMInbox(&filter.S{f})
+}
@@ -0,0 +1,130 @@
+package app
+
+import (
+	"testing"
+
+	"next.orly.dev/pkg/acl"
+	"next.orly.dev/pkg/encoders/event"
+	"next.orly.dev/pkg/encoders/filter"
+	"next.orly.dev/pkg/encoders/hex"
+	"next.orly.dev/pkg/encoders/kind"
+	"next.orly.dev/pkg/encoders/tag"
+	"next.orly.dev/pkg/utils"
+)
+
+var (
+	dmAuthor    = make([]byte, 32)
+	dmRecipient = make([]byte, 32)
+	dmStranger  = make([]byte, 32)
+)
+
+func init() {
+	dmAuthor[0], dmRecipient[0], dmStranger[0] = 1, 2, 3
+}
+
+// dmTestACL gives write access to the recipient of the test messages only.
+type dmTestACL struct{}
+
+func (dmTestACL) Configure(cfg ...any) (err error) { return }
+
+func (dmTestACL) GetAccessLevel(pub []byte, address string) (level string) {
+	if utils.FastEqual(pub, dmRecipient) {
+		return "write"
+	}
+	return "read"
+}
+
+func (dmTestACL) GetACLInfo() (name, description, documentation string) {
+	return "dmtest", "", ""
+}
+
+func (dmTestACL) Type() string { return "dmtest" }
+
+func (dmTestACL) Syncer() {}
+
+// newDM returns an event of a kind from the author of the test messages,
+// tagging the given pubkeys.
+func newDM(k uint16, p ...[]byte) (ev *event.E) {
+	ev = event.New()
+	ev.Kind = k
+	ev.Pubkey = dmAuthor
+	ev.Tags = tag.NewS()
+	for _, pk := range p {
+		ev.Tags.Append(tag.NewFromAny("p", hex.Enc(pk)))
+	}
+	return
+}
+
+func TestDMInboxVisible(t *testing.T) {
+	ev := newDM(kind.GiftWrap.K, dmRecipient)
+	for _, c := range []struct {
+		name string
+		pub  []byte
+		want bool
+	}{
+		{"unauthenticated", nil, false},
+		{"author", dmAuthor, true},
+		{"recipient", dmRecipient, true},
+		{"stranger", dmStranger, false},
+	} {
+		if got := dmInboxVisible(ev, c.pub); got != c.want {
+			t.Errorf("%s: dmInboxVisible = %v, want %v", c.name, got, c.want)
+		}
+	}
+	ev.Tags = tag.NewS(tag.NewFromAny("p", "not hex"))
+	if dmInboxVisible(ev, dmRecipient) {
+		t.Error("invalid p tag made a gift wrap visible")
+	}
+}
+
+func TestRequestsDMInbox(t *testing.T) {
+	withKinds := func(k ...*kind.K) (f *filter.F) {
+		f = filter.New()
+		f.Kinds = kind.NewS(k...)
+		return
+	}
+	if requestsDMInbox(nil) {
+		t.Error("nil filters request the DM inbox")
+	}
+	if requestsDMInbox(filter.NewS(filter.New())) {
+		t.Error("a filter without kinds explicitly requests the DM inbox")
+	}
+	if requestsDMInbox(filter.NewS(withKinds(kind.TextNote))) {
+		t.Error("a text note filter requests the DM inbox")
+	}
+	if !requestsDMInbox(
+		filter.NewS(withKinds(kind.TextNote), withKinds(kind.GiftWrap)),
+	) {
+		t.Error("a gift wrap filter does not request the DM inbox")
+	}
+	if !mayMatchDMInbox(filter.New()) {
+		t.Error("a filter without kinds may not match the DM inbox")
+	}
+	if mayMatchDMInbox(withKinds(kind.TextNote)) {
+		t.Error("a text note filter may match the DM inbox")
+	}
+	if !mayMatchDMInbox(withKinds(kind.EncryptedDirectMessage)) {
+		t.Error("a kind 4 filter may not match the DM inbox")
+	}
+}
+
+func TestDMRecipientLocal(t *testing.T) {
+	acls, prev := acl.Registry.ACL, acl.Registry.Active.Load()
+	acl.Registry.Register(dmTestACL{})
+	acl.Registry.Active.Store("dmtest")
+	t.Cleanup(
+		func() {
+			acl.Registry.Active.Store(prev)
+			acl.Registry.ACL = acls
+		},
+	)
+	if !dmRecipientLocal(newDM(kind.GiftWrap.K, dmStranger, dmRecipient)) {
+		t.Error("gift wrap to a local user was refused")
+	}
+	if dmRecipientLocal(newDM(kind.GiftWrap.K, dmStranger)) {
+		t.Error("gift wrap to a stranger was accepted")
+	}
+	if dmRecipientLocal(newDM(kind.GiftWrap.K)) {
+		t.Error("gift wrap without recipients was accepted")
+	}
+}
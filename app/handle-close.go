@@ -23,6 +23,8 @@ func (l *Listener) HandleClose(req []byte) (err error) {
 	if len(env.ID) == 0 {
 		return errors.New("CLOSE has no <id>")
 	}
+	delete(l.subscriptions, string(env.ID))
+	l.closeChanges(string(env.ID))
 	l.publishers.Receive(
 		&W{
 			Cancel: true,
@@ -0,0 +1,390 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"lol.mleku.dev/chk"
+	"lol.mleku.dev/log"
+	"next.orly.dev/pkg/acl"
+	"next.orly.dev/pkg/database"
+	"next.orly.dev/pkg/encoders/envelopes/authenvelope"
+	"next.orly.dev/pkg/encoders/envelopes/closedenvelope"
+	"next.orly.dev/pkg/encoders/envelopes/countenvelope"
+	"next.orly.dev/pkg/encoders/event"
+	"next.orly.dev/pkg/encoders/filter"
+	"next.orly.dev/pkg/encoders/hex"
+	"next.orly.dev/pkg/encoders/kind"
+	"next.orly.dev/pkg/encoders/reason"
+	"next.orly.dev/pkg/encoders/tag"
+	"next.orly.dev/pkg/utils"
+	"next.orly.dev/pkg/utils/normalize"
+)
+
+// HandleCount processes a NIP-45 COUNT envelope. It applies the same access
+// control as HandleReq and counts the matching events from the indexes
+// without fetching the event bodies.
+//
+// Privileged kinds are only counted for the author or a pubkey in a p tag when
+// an ACL is active, unless the requester is an admin.
+func (l *Listener) HandleCount(msg []byte) (err error) {
+	env := countenvelope.New()
+	if _, err = env.Unmarshal(msg); chk.E(err) {
+		return normalize.Error.Errorf(err.Error())
+	}
+	log.D.C(
+		func() string {
+			return fmt.Sprintf(
+				"COUNT sub=%s filters=%d", env.Subscription, len(env.Filters),
+			)
+		},
+	)
+	if l.rateLimited(l.rateLimits.Reqs, 1) {
+		if err = closedenvelope.NewFrom(
+			env.Subscription,
+			reason.RateLimited.F("too many requests, slow down"),
+		).Write(l); chk.E(err) {
+			return
+		}
+		return
+	}
+	// send a challenge to the client to auth if an ACL is active
+	if acl.Registry.Active.Load() != "none" {
+		if err = authenvelope.NewChallengeWith(l.challenge.Load()).
+			Write(l); chk.E(err) {
+			return
+		}
+	}
+	// check the limitations published in the relay information (NIP-11)
+	if rejection := l.FiltersExceeded(len(env.Filters)); rejection != "" {
+		if err = closedenvelope.NewFrom(
+			env.Subscription, reason.Invalid.F("%s", rejection),
+		).Write(l); chk.E(err) {
+			return
+		}
+		return
+	}
+	// refuse blocked IPs and banned pubkeys of the NIP-86 management API
+	if l.requesterBanned() {
+		if err = closedenvelope.NewFrom(
+			env.Subscription, reason.Blocked.F("access is banned"),
+		).Write(l); chk.E(err) {
+			return
+		}
+		return
+	}
+	accessLevel := acl.Registry.GetAccessLevel(l.authedPubkey.Load(), l.remote)
+	switch accessLevel {
+	case "none":
+		if err = closedenvelope.NewFrom(
+			env.Subscription,
+			reason.AuthRequired.F("user not authed or has no read access"),
+		).Write(l); chk.E(err) {
+			return
+		}
+		return
+	default:
+		// user has read access or better, continue
+	}
+	// a DM inbox only counts direct messages for authenticated recipients
+	if l.Config.DMInbox && len(l.authedPubkey.Load()) == 0 &&
+		requestsDMInbox(&env.Filters) {
+		if err = authenvelope.NewChallengeWith(l.challenge.Load()).
+			Write(l); chk.E(err) {
+			return
+		}
+		if err = closedenvelope.NewFrom(
+			env.Subscription,
+			reason.AuthRequired.F(
+				"direct messages are only sent to their recipients",
+			),
+		).Write(l); chk.E(err) {
+			return
+		}
+		return
+	}
+	queryCtx, queryCancel := context.WithTimeout(l.ctx, 30*time.Second)
+	defer queryCancel()
+	// serials are collected in a set so an event matched by more than one
+	// filter is only counted once
+	matches := make(map[uint64]struct{})
+	for _, f := range env.Filters {
+		if f == nil {
+			continue
+		}
+		var set map[uint64]struct{}
+		if set, err = l.filterSerials(queryCtx, f, accessLevel); chk.E(err) {
+			log.E.F("COUNT failed for filter: %v", err)
+			err = nil
+			continue
+		}
+		for ser := range set {
+			matches[ser] = struct{}{}
+		}
+	}
+	var res *countenvelope.Response
+	if res, err = countenvelope.NewResponseFrom(
+		env.Subscription, len(matches),
+	); chk.E(err) {
+		return
+	}
+	if err = res.Write(l); chk.E(err) {
+		return
+	}
+	log.D.F("COUNT %s: sent count %d", env.Subscription, len(matches))
+	return
+}
+
+// filterSerials returns the serials of the events matching a filter that the
+// requester is allowed to see.
+func (l *Listener) filterSerials(
+	c context.Context, f *filter.F, accessLevel string,
+) (set map[uint64]struct{}, err error) {
+	if set, err = l.privilegedSerials(c, f, accessLevel); chk.E(err) {
+		return
+	}
+	err = l.dropHidden(c, f, set, l.Config.DMInbox && mayMatchDMInbox(f))
+	return
+}
+
+// dropHidden removes from the set of serials matching a filter the events
+// that REQ would not send the requester: expired and deleted events, the bans
+// of the NIP-86 management API, the events of private NIP-29 groups, the
+// kinds the kind policy does not release to the requester, and, if dm is set,
+// the direct messages of a DM inbox addressed to others. All of these are
+// found from the indexes, so no event is fetched.
+func (l *Listener) dropHidden(
+	c context.Context, f *filter.F, set map[uint64]struct{}, dm bool,
+) (err error) {
+	if len(set) == 0 {
+		return
+	}
+	if err = l.DropExpiredDeleted(set); chk.E(err) {
+		return
+	}
+	if l.managementBans() {
+		if err = l.dropBanned(c, f, set); chk.E(err) {
+			return
+		}
+	}
+	pk := l.authedPubkey.Load()
+	if groups := acl.Registry.HiddenGroups(pk); len(groups) > 0 {
+		h := tag.NewFromAny("#h")
+		h.T = append(h.T, groups...)
+		fg := filter.F{
+			Kinds: f.Kinds,
+			Tags:  tag.NewS(h),
+			Since: f.Since,
+			Until: f.Until,
+		}
+		if err = l.dropMatching(c, &fg, set); chk.E(err) {
+			return
+		}
+	}
+	if kinds := l.policyHiddenKinds(f, pk, l.remote); len(kinds) > 0 {
+		fp := *f
+		fp.Kinds = kind.NewS(kinds...)
+		if err = l.dropMatching(c, &fp, set); chk.E(err) {
+			return
+		}
+	}
+	if dm {
+		err = l.withholdKinds(c, f, set, requestedKinds(f, kind.DMInbox), pk)
+	}
+	return
+}
+
+// dropBanned removes from the set of serials matching a filter the banned
+// events and the events of banned pubkeys.
+func (l *Listener) dropBanned(
+	c context.Context, f *filter.F, set map[uint64]struct{},
+) (err error) {
+	var entries []database.ManagedEntry
+	if entries, err = l.ListManaged(database.BannedEvents); chk.E(err) {
+		return
+	}
+	for _, e := range entries {
+		var id []byte
+		if id, err = hex.Dec(e.Key); err != nil {
+			err = nil
+			continue
+		}
+		if ser, serr := l.GetSerialById(id); serr == nil && ser != nil {
+			delete(set, ser.Get())
+		}
+	}
+	if entries, err = l.ListManaged(database.BannedPubkeys); chk.E(err) {
+		return
+	}
+	if len(entries) == 0 {
+		return
+	}
+	var pks [][]byte
+	for _, e := range entries {
+		var pk []byte
+		if pk, err = hex.Dec(e.Key); err != nil {
+			err = nil
+			continue
+		}
+		pks = append(pks, pk)
+	}
+	fb := filter.F{
+		Kinds:   f.Kinds,
+		Authors: tag.NewFromBytesSlice(pks...),
+		Since:   f.Since,
+		Until:   f.Until,
+	}
+	return l.dropMatching(c, &fb, set)
+}
+
+// dropMatching removes from a set of serials the events that match a filter.
+func (l *Listener) dropMatching(
+	c context.Context, f *filter.F, set map[uint64]struct{},
+) (err error) {
+	var drop map[uint64]struct{}
+	if drop, err = l.QueryForSerialSet(c, f); chk.E(err) {
+		return
+	}
+	for ser := range drop {
+		delete(set, ser)
+	}
+	return
+}
+
+// requestedKinds returns those of a list of kinds that a filter asks for,
+// which are all of them if the filter names no kinds.
+func requestedKinds(f *filter.F, kinds []*kind.K) (res []*kind.K) {
+	if f.Kinds.Len() == 0 {
+		return kinds
+	}
+	for _, k := range f.Kinds.K {
+		for _, kk := range kinds {
+			if k.K == kk.K {
+				res = append(res, k)
+			}
+		}
+	}
+	return
+}
+
+// privilegedSerials returns the serials of the events matching a filter,
+// without the privileged events the requester may not see.
+func (l *Listener) privilegedSerials(
+	c context.Context, f *filter.F, accessLevel string,
+) (set map[uint64]struct{}, err error) {
+	if l.Config.ACLMode == "none" || accessLevel == "admin" {
+		return l.QueryForSerialSet(c, f)
+	}
+	pk := l.authedPubkey.Load()
+	if f.Ids.Len() > 0 {
+		// an ids filter is bounded by the number of ids, and the ids index
+		// carries no kind, so check these against the events themselves
+		return l.countIdsFilter(c, f, pk)
+	}
+	if set, err = l.QueryForSerialSet(c, f); chk.E(err) {
+		return
+	}
+	err = l.withholdKinds(c, f, set, requestedKinds(f, kind.Privileged), pk)
+	return
+}
+
+// withholdKinds removes from the set of serials matching a filter the events
+// of some kinds that are neither authored by pk nor addressed to it in a p
+// tag.
+func (l *Listener) withholdKinds(
+	c context.Context, f *filter.F, set map[uint64]struct{}, kinds []*kind.K,
+	pk []byte,
+) (err error) {
+	if len(kinds) == 0 || len(set) == 0 {
+		return
+	}
+	// the events of the kinds that match the filter
+	fp := *f
+	fp.Kinds = kind.NewS(kinds...)
+	var privSet map[uint64]struct{}
+	if privSet, err = l.QueryForSerialSet(c, &fp); chk.E(err) {
+		return
+	}
+	if len(privSet) == 0 {
+		return
+	}
+	// the events of the kinds that are authored by or addressed to pk
+	allowed := make(map[uint64]struct{})
+	if len(pk) > 0 {
+		for _, fa := range []*filter.F{
+			{
+				Kinds:   fp.Kinds,
+				Authors: tag.NewFromBytesSlice(pk),
+				Since:   f.Since,
+				Until:   f.Until,
+			},
+			{
+				Kinds: fp.Kinds,
+				Tags:  tag.NewS(tag.NewFromAny("#p", hex.Enc(pk))),
+				Since: f.Since,
+				Until: f.Until,
+			},
+		} {
+			var s map[uint64]struct{}
+			if s, err = l.QueryForSerialSet(c, fa); chk.E(err) {
+				return
+			}
+			for ser := range s {
+				allowed[ser] = struct{}{}
+			}
+		}
+	}
+	for ser := range privSet {
+		if _, ok := allowed[ser]; !ok {
+			delete(set, ser)
+		}
+	}
+	return
+}
+
+// countIdsFilter counts an ids filter by fetching the events, so the kind of
+// each can be checked against the privileged kinds.
+func (l *Listener) countIdsFilter(
+	c context.Context, f *filter.F, pk []byte,
+) (set map[uint64]struct{}, err error) {
+	var evs event.S
+	if evs, err = l.QueryEvents(c, f); chk.E(err) {
+		return
+	}
+	set = make(map[uint64]struct{})
+	for _, ev := range evs {
+		if !f.Matches(ev) ||
+			(kind.IsPrivileged(ev.Kind) && !privilegedVisibleTo(ev, pk)) {
+			ev.Free()
+			continue
+		}
+		// key by serial so it dedupes against the index-based filters
+		if ser, e := l.GetSerialById(ev.ID); e == nil && ser != nil {
+			set[ser.Get()] = struct{}{}
+		}
+		ev.Free()
+	}
+	return
+}
+
+// privilegedVisibleTo reports whether a privileged event may be seen by the
+// given pubkey, which is the case when it is the author or is in a p tag.
+func privilegedVisibleTo(ev *event.E, pk []byte) bool {
+	if len(pk) == 0 {
+		return false
+	}
+	if utils.FastEqual(ev.Pubkey, pk) {
+		return true
+	}
+	for _, pTag := range ev.Tags.GetAll([]byte("p")) {
+		pt, err := hex.Dec(string(pTag.Value()))
+		if err != nil {
+			continue
+		}
+		if utils.FastEqual(pt, pk) {
+			return true
+		}
+	}
+	return false
+}
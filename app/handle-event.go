@@ -9,11 +9,14 @@ import (
 	"lol.mleku.dev/chk"
 	"lol.mleku.dev/log"
 	"next.orly.dev/pkg/acl"
+	"next.orly.dev/pkg/database"
 	"next.orly.dev/pkg/encoders/envelopes/authenvelope"
 	"next.orly.dev/pkg/encoders/envelopes/eventenvelope"
-	"next.orly.dev/pkg/encoders/envelopes/okenvelope"
+	"next.orly.dev/pkg/encoders/event"
+	"next.orly.dev/pkg/encoders/hex"
 	"next.orly.dev/pkg/encoders/kind"
-	"next.orly.dev/pkg/encoders/reason"
+	"next.orly.dev/pkg/policy"
+	"next.orly.dev/pkg/protocol/pow"
 	"next.orly.dev/pkg/utils"
 )
 
@@ -31,6 +34,16 @@ func (l *Listener) HandleEvent(msg []byte) (err error) {
 	if len(msg) > 0 {
 		log.I.F("extra '%s'", msg)
 	}
+	// requests to vanish are never rate limited
+	if env.E.Kind != kind.RequestToVanish.K &&
+		l.rateLimited(l.rateLimits.Events, 1) {
+		if err = Ok.RateLimited(
+			l, env, "too many events, slow down",
+		); chk.E(err) {
+			return
+		}
+		return
+	}
 	// check the event ID is correct
 	calculatedId := env.E.GetIDBytes()
 	if !utils.FastEqual(calculatedId, env.E.ID) {
@@ -63,17 +76,81 @@ func (l *Listener) HandleEvent(msg []byte) (err error) {
 		}
 		return
 	}
+	// reject events that have already expired (NIP-40)
+	if database.CheckExpiration(env.E) {
+		if err = Ok.Invalid(
+			l, env, "event has already expired",
+		); chk.E(err) {
+			return
+		}
+		return
+	}
+	// check the limitations published in the relay information (NIP-11)
+	if rejection := l.EventLimitsExceeded(env.E); rejection != "" {
+		if err = Ok.Invalid(l, env, rejection); chk.E(err) {
+			return
+		}
+		return
+	}
+	// a signed request to vanish (NIP-62) is honoured from any pubkey,
+	// regardless of the ACL, bans and proof of work
+	if env.E.Kind == kind.RequestToVanish.K {
+		if f := l.follower(); f != nil {
+			return l.forwardEvent(f, env)
+		}
+		return l.HandleVanishRequest(env)
+	}
+	// check the proof of work (NIP-13)
+	if err = pow.Check(env.E, l.PowRequired(env.E.Kind)); err != nil {
+		if err = Ok.PoW(l, env, "%s", err.Error()); chk.E(err) {
+			return
+		}
+		return
+	}
+	// apply the bans and kind lists of the NIP-86 management API
+	if l.IPBlocked(l.remote) {
+		if err = Ok.Blocked(l, env, "IP address is blocked"); chk.E(err) {
+			return
+		}
+		return
+	}
+	if rejection := l.ManagementRejects(env.E); rejection != "" {
+		if err = Ok.Blocked(l, env, rejection); chk.E(err) {
+			return
+		}
+		return
+	}
 	// check permissions of user
 	accessLevel := acl.Registry.GetAccessLevel(l.authedPubkey.Load(), l.remote)
+	// pubkeys allowed through the management API may write regardless of the
+	// ACL
+	if (accessLevel == "none" || accessLevel == "read") &&
+		len(l.authedPubkey.Load()) > 0 && l.IsManaged(
+		database.AllowedPubkeys, hex.Enc(l.authedPubkey.Load()),
+	) {
+		accessLevel = "write"
+	}
+	// under the follows ACL, pubkeys that are not followed may publish events
+	// with enough proof of work
+	if accessLevel == "read" && acl.Registry.Has("follows") &&
+		l.Config.PowUnfollowed > 0 &&
+		pow.Check(env.E, l.Config.PowUnfollowed) == nil {
+		accessLevel = "write"
+	}
+	// under the paid ACL, directory events such as profiles, follow lists
+	// and relay lists may be published without a subscription
+	if accessLevel == "read" && acl.Registry.Has("paid") &&
+		kind.IsDirectoryEvent(env.E.Kind) {
+		accessLevel = "write"
+	}
 	switch accessLevel {
 	case "none":
 		log.D.F(
 			"handle event: sending 'OK,false,auth-required...' to %s", l.remote,
 		)
-		if err = okenvelope.NewFrom(
-			env.Id(), false,
-			reason.AuthRequired.F("auth required for write access"),
-		).Write(l); chk.E(err) {
+		if err = Ok.AuthRequired(
+			l, env, "auth required for write access",
+		); chk.E(err) {
 			// return
 		}
 		log.D.F("handle event: sending challenge to %s", l.remote)
@@ -87,10 +164,9 @@ func (l *Listener) HandleEvent(msg []byte) (err error) {
 			"handle event: sending 'OK,false,auth-required:...' to %s",
 			l.remote,
 		)
-		if err = okenvelope.NewFrom(
-			env.Id(), false,
-			reason.AuthRequired.F("auth required for write access"),
-		).Write(l); chk.E(err) {
+		if err = Ok.AuthRequired(
+			l, env, "auth required for write access",
+		); chk.E(err) {
 			return
 		}
 		log.D.F("handle event: sending challenge to %s", l.remote)
@@ -117,6 +193,84 @@ func (l *Listener) HandleEvent(msg []byte) (err error) {
 			return
 		}
 	}
+	// NIP-29 groups require events to belong to a group the author may post to
+	if rejection := acl.Registry.CheckGroupEvent(env.E); rejection != "" {
+		if err = Ok.Blocked(l, env, rejection); chk.E(err) {
+			return
+		}
+		return
+	}
+	// the kind policy decides who may publish each kind, and how large and
+	// old its events may be
+	if rule := l.kindPolicy.Rule(env.E.Kind); rule != nil {
+		rejection, authRequired := rule.WriteRejected(
+			env.E, l.authedPubkey.Load(), accessLevel, time.Now(),
+		)
+		if authRequired {
+			if err = Ok.AuthRequired(l, env, "%s", rejection); chk.E(err) {
+				return
+			}
+			if err = authenvelope.NewChallengeWith(l.challenge.Load()).
+				Write(l); chk.E(err) {
+				return
+			}
+			return
+		}
+		if rejection != "" {
+			if err = Ok.Restricted(l, env, "%s", rejection); chk.E(err) {
+				return
+			}
+			return
+		}
+	}
+	// a DM inbox may only accept gift wraps for its own users
+	if l.Config.DMInbox && l.Config.DMInboxLocalOnly &&
+		env.E.Kind == kind.GiftWrap.K && !dmRecipientLocal(env.E) {
+		if err = Ok.Restricted(
+			l, env, "gift wrap recipient is not a user of this relay",
+		); chk.E(err) {
+			return
+		}
+		return
+	}
+	// an external write policy plugin may reject events, or accept them
+	// without storing them
+	if l.writePolicy != nil {
+		res := l.writePolicy.Check(
+			env.E, l.remote, l.authedPubkey.Load(), accessLevel,
+		)
+		switch res.Action {
+		case policy.Reject:
+			if res.Msg == "" {
+				res.Msg = "rejected by write policy"
+			}
+			if err = Ok.Blocked(l, env, "%s", res.Msg); chk.E(err) {
+				return
+			}
+			return
+		case policy.ShadowReject:
+			log.D.F("write policy shadow rejected event %0x", env.E.ID)
+			if err = Ok.Ok(l, env, ""); chk.E(err) {
+				return
+			}
+			return
+		}
+	}
+	// a follower forwards writes to its leader, from which they come back
+	// through the change feed
+	if f := l.follower(); f != nil {
+		return l.forwardEvent(f, env)
+	}
+	// ephemeral events are only relayed to current subscribers, never stored
+	if kind.IsEphemeral(env.E.Kind) {
+		if err = Ok.Ok(l, env, ""); chk.E(err) {
+			return
+		}
+		clonedEvent := env.E.Clone()
+		go l.publishers.Deliver(clonedEvent)
+		log.D.F("delivered ephemeral event %0x", env.E.ID)
+		return
+	}
 	// if the event is a delete, process the delete
 	if env.E.Kind == kind.EventDeletion.K {
 		if err = l.HandleDelete(env); err != nil {
@@ -169,25 +323,29 @@ func (l *Listener) HandleEvent(msg []byte) (err error) {
 	clonedEvent := env.E.Clone()
 	go l.publishers.Deliver(clonedEvent)
 	log.D.F("saved event %0x", env.E.ID)
-	var isNewFromAdmin bool
-	for _, admin := range l.Admins {
-		if utils.FastEqual(admin, env.E.Pubkey) {
-			isNewFromAdmin = true
-			break
-		}
+	// queue the event for the webhooks whose filters it matches
+	if l.webhooks != nil {
+		l.webhooks.Enqueue(env.E)
+	}
+	// update the web of trust with a new follow list
+	if env.E.Kind == kind.FollowList.K {
+		go acl.Registry.ApplyFollowList(env.E.Clone())
 	}
-	if isNewFromAdmin {
+	// update the state of NIP-29 groups and deliver the new state events
+	for _, ev := range acl.Registry.ApplyGroupEvent(env.E) {
+		go l.publishers.Deliver(ev)
+	}
+	if l.isAdminOrOwner(env.E.Pubkey) {
 		log.I.F("new event from admin %0x", env.E.Pubkey)
-		// if a follow list was saved, reconfigure ACLs now that it is persisted
-		if env.E.Kind == kind.FollowList.K ||
-			env.E.Kind == kind.RelayListMetadata.K {
-			// Run ACL reconfiguration asynchronously to prevent blocking websocket operations
-			go func() {
-				if err := acl.Registry.Configure(); chk.E(err) {
-					log.E.F("failed to reconfigure ACL: %v", err)
-				}
-			}()
-		}
+		// if a follow list or whitelist was saved, reconfigure the ACLs that
+		// are configured from it now that it is persisted. Run ACL
+		// reconfiguration asynchronously to prevent blocking websocket
+		// operations
+		go func(ev *event.E) {
+			if err := acl.Registry.Reload(ev); chk.E(err) {
+				log.E.F("failed to reconfigure ACL: %v", err)
+			}
+		}(env.E.Clone())
 	}
 	return
 }
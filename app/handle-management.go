@@ -0,0 +1,368 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"lol.mleku.dev/chk"
+	"lol.mleku.dev/log"
+	"next.orly.dev/pkg/database"
+	"next.orly.dev/pkg/encoders/event"
+	"next.orly.dev/pkg/encoders/hex"
+	"next.orly.dev/pkg/utils"
+)
+
+// ManagementContentType is the content type of NIP-86 relay management
+// requests.
+const ManagementContentType = "application/nostr+json+rpc"
+
+// managementRequest is a NIP-86 JSON-RPC request.
+type managementRequest struct {
+	Method string `json:"method"`
+	Params []any  `json:"params"`
+}
+
+// managementResponse is a NIP-86 JSON-RPC response.
+type managementResponse struct {
+	Result any    `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// managementMethods are the NIP-86 methods the relay supports.
+var managementMethods = []string{
+	"supportedmethods",
+	"banpubkey", "allowpubkey", "listbannedpubkeys", "listallowedpubkeys",
+	"banevent", "allowevent", "listbannedevents",
+	"changerelayname", "changerelaydescription", "changerelayicon",
+	"allowkind", "disallowkind", "listallowedkinds", "listdisallowedkinds",
+	"blockip", "unblockip", "listblockedips",
+}
+
+// HandleManagement serves the NIP-86 relay management API. Requests must be
+// authorized by a NIP-98 header with a payload tag, signed by an admin or
+// owner.
+func (s *Server) HandleManagement(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	valid, pubkey, err := s.checkAuth(r, true)
+	if err != nil || !valid {
+		msg := "NIP-98 authorization required"
+		if err != nil {
+			msg = err.Error()
+		}
+		s.writeManagement(w, http.StatusUnauthorized, nil, msg)
+		return
+	}
+	if !s.isAdminOrOwner(pubkey) {
+		s.writeManagement(
+			w, http.StatusUnauthorized, nil, "pubkey is not an admin or owner",
+		)
+		return
+	}
+	var body []byte
+	if body, err = io.ReadAll(r.Body); chk.E(err) {
+		s.writeManagement(
+			w, http.StatusBadRequest, nil, "failed to read request body",
+		)
+		return
+	}
+	var req managementRequest
+	if err = json.Unmarshal(body, &req); err != nil {
+		s.writeManagement(w, http.StatusBadRequest, nil, "invalid request")
+		return
+	}
+	log.I.F("management: %0x called %s %v", pubkey, req.Method, req.Params)
+	var result any
+	if result, err = s.management(req.Method, req.Params); err != nil {
+		s.writeManagement(w, http.StatusOK, nil, err.Error())
+		return
+	}
+	s.writeManagement(w, http.StatusOK, result, "")
+}
+
+func (s *Server) writeManagement(
+	w http.ResponseWriter, status int, result any, errMsg string,
+) {
+	w.WriteHeader(status)
+	b, err := json.Marshal(&managementResponse{Result: result, Error: errMsg})
+	if chk.E(err) {
+		return
+	}
+	w.Write(b)
+}
+
+// management runs a NIP-86 method and returns its result.
+func (s *Server) management(method string, params []any) (
+	result any, err error,
+) {
+	switch method {
+	case "supportedmethods":
+		return managementMethods, nil
+	case "banpubkey":
+		var pk string
+		if pk, err = hexParam(params, 0, 32); err != nil {
+			return
+		}
+		if err = s.AddManaged(
+			database.BannedPubkeys, pk, stringParam(params, 1),
+		); chk.E(err) {
+			return
+		}
+		if err = s.RemoveManaged(database.AllowedPubkeys, pk); chk.E(err) {
+			return
+		}
+		return true, nil
+	case "allowpubkey":
+		var pk string
+		if pk, err = hexParam(params, 0, 32); err != nil {
+			return
+		}
+		if err = s.AddManaged(
+			database.AllowedPubkeys, pk, stringParam(params, 1),
+		); chk.E(err) {
+			return
+		}
+		if err = s.RemoveManaged(database.BannedPubkeys, pk); chk.E(err) {
+			return
+		}
+		return true, nil
+	case "listbannedpubkeys":
+		return s.listManaged(database.BannedPubkeys, "pubkey")
+	case "listallowedpubkeys":
+		return s.listManaged(database.AllowedPubkeys, "pubkey")
+	case "banevent":
+		var id string
+		if id, err = hexParam(params, 0, 32); err != nil {
+			return
+		}
+		if err = s.AddManaged(
+			database.BannedEvents, id, stringParam(params, 1),
+		); chk.E(err) {
+			return
+		}
+		// a banned event is removed, and can't be stored again
+		eid, _ := hex.Dec(id)
+		if e := s.DeleteEvent(s.Ctx, eid); e != nil {
+			log.D.F("management: banned event %s was not stored: %v", id, e)
+		}
+		return true, nil
+	case "allowevent":
+		var id string
+		if id, err = hexParam(params, 0, 32); err != nil {
+			return
+		}
+		if err = s.RemoveManaged(database.BannedEvents, id); chk.E(err) {
+			return
+		}
+		return true, nil
+	case "listbannedevents":
+		return s.listManaged(database.BannedEvents, "id")
+	case "changerelayname":
+		return s.changeRelayInfo(database.RelayInfoName, params)
+	case "changerelaydescription":
+		return s.changeRelayInfo(database.RelayInfoDescription, params)
+	case "changerelayicon":
+		return s.changeRelayInfo(database.RelayInfoIcon, params)
+	case "allowkind", "disallowkind":
+		var k uint16
+		if k, err = kindParam(params, 0); err != nil {
+			return
+		}
+		add, remove := database.AllowedKinds, database.DisallowedKinds
+		if method == "disallowkind" {
+			add, remove = remove, add
+		}
+		key := strconv.Itoa(int(k))
+		if err = s.AddManaged(add, key, ""); chk.E(err) {
+			return
+		}
+		if err = s.RemoveManaged(remove, key); chk.E(err) {
+			return
+		}
+		return true, nil
+	case "listallowedkinds":
+		return s.listKinds(database.AllowedKinds)
+	case "listdisallowedkinds":
+		return s.listKinds(database.DisallowedKinds)
+	case "blockip":
+		ip := stringParam(params, 0)
+		if net.ParseIP(ip) == nil {
+			err = fmt.Errorf("invalid IP address %q", ip)
+			return
+		}
+		if err = s.AddManaged(
+			database.BlockedIPs, ip, stringParam(params, 1),
+		); chk.E(err) {
+			return
+		}
+		return true, nil
+	case "unblockip":
+		ip := stringParam(params, 0)
+		if net.ParseIP(ip) == nil {
+			err = fmt.Errorf("invalid IP address %q", ip)
+			return
+		}
+		if err = s.RemoveManaged(database.BlockedIPs, ip); chk.E(err) {
+			return
+		}
+		return true, nil
+	case "listblockedips":
+		return s.listManaged(database.BlockedIPs, "ip")
+	default:
+		err = fmt.Errorf("unsupported method %q", method)
+		return
+	}
+}
+
+// listManaged returns a management list in the NIP-86 form, objects with the
+// key under the given name and the reason.
+func (s *Server) listManaged(list, name string) (result any, err error) {
+	var entries []database.ManagedEntry
+	if entries, err = s.ListManaged(list); chk.E(err) {
+		return
+	}
+	res := make([]map[string]string, 0, len(entries))
+	for _, e := range entries {
+		m := map[string]string{name: e.Key}
+		if e.Reason != "" {
+			m["reason"] = e.Reason
+		}
+		res = append(res, m)
+	}
+	return res, nil
+}
+
+func (s *Server) listKinds(list string) (result any, err error) {
+	var entries []database.ManagedEntry
+	if entries, err = s.ListManaged(list); chk.E(err) {
+		return
+	}
+	kinds := make([]int, 0, len(entries))
+	for _, e := range entries {
+		if k, e := strconv.Atoi(e.Key); e == nil {
+			kinds = append(kinds, k)
+		}
+	}
+	return kinds, nil
+}
+
+func (s *Server) changeRelayInfo(field string, params []any) (
+	result any, err error,
+) {
+	if err = s.SetRelayInfo(field, stringParam(params, 0)); chk.E(err) {
+		return
+	}
+	return true, nil
+}
+
+func stringParam(params []any, i int) (v string) {
+	if i < len(params) {
+		v, _ = params[i].(string)
+	}
+	return
+}
+
+// hexParam returns a parameter that must be hex of a given length in bytes,
+// in lower case.
+func hexParam(params []any, i, size int) (v string, err error) {
+	v = strings.ToLower(stringParam(params, i))
+	var b []byte
+	if b, err = hex.Dec(v); err != nil || len(b) != size {
+		err = fmt.Errorf("parameter %d must be %d bytes of hex", i, size)
+	}
+	return
+}
+
+func kindParam(params []any, i int) (k uint16, err error) {
+	if i < len(params) {
+		if f, ok := params[i].(float64); ok && f >= 0 && f <= 65535 &&
+			f == float64(uint16(f)) {
+			return uint16(f), nil
+		}
+	}
+	err = fmt.Errorf("parameter %d must be a kind number", i)
+	return
+}
+
+// isAdminOrOwner returns whether a pubkey is one of the configured admins or
+// owners.
+func (s *Server) isAdminOrOwner(pubkey []byte) bool {
+	for _, pk := range s.Admins {
+		if utils.FastEqual(pk, pubkey) {
+			return true
+		}
+	}
+	for _, pk := range s.Owners {
+		if utils.FastEqual(pk, pubkey) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIP returns the IP address of a remote address, which may have a
+// port.
+func remoteIP(remote string) string {
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		return host
+	}
+	return remote
+}
+
+// IPBlocked returns whether the IP of a remote address has been blocked by
+// NIP-86 blockip.
+func (s *Server) IPBlocked(remote string) bool {
+	return s.IsManaged(database.BlockedIPs, remoteIP(remote))
+}
+
+// ManagementRejects returns the reason an event may not be stored according
+// to the NIP-86 management state, or an empty string if it may be.
+func (s *Server) ManagementRejects(ev *event.E) (reason string) {
+	if s.IsManaged(database.BannedEvents, hex.Enc(ev.ID)) {
+		return "event is banned"
+	}
+	if s.IsManaged(database.BannedPubkeys, hex.Enc(ev.Pubkey)) {
+		return "pubkey is banned"
+	}
+	k := strconv.Itoa(int(ev.Kind))
+	if s.IsManaged(database.DisallowedKinds, k) {
+		return "kind " + k + " is not allowed"
+	}
+	if s.HasManaged(database.AllowedKinds) &&
+		!s.IsManaged(database.AllowedKinds, k) {
+		return "kind " + k + " is not allowed"
+	}
+	return
+}
+
+// ManagementHides returns whether an event is withheld from readers by the
+// NIP-86 management state, because it or its author is banned.
+func (s *Server) ManagementHides(ev *event.E) bool {
+	return s.IsManaged(database.BannedEvents, hex.Enc(ev.ID)) ||
+		s.IsManaged(database.BannedPubkeys, hex.Enc(ev.Pubkey))
+}
+
+// managementBans returns whether any events or pubkeys are banned, so the
+// results of a query need to be checked with ManagementHides.
+func (s *Server) managementBans() bool {
+	return s.HasManaged(database.BannedEvents) ||
+		s.HasManaged(database.BannedPubkeys)
+}
+
+// requesterBanned returns whether the IP address or the authed pubkey of a
+// listener is blocked by the NIP-86 management API.
+func (l *Listener) requesterBanned() bool {
+	if l.IPBlocked(l.remote) {
+		return true
+	}
+	pk := l.authedPubkey.Load()
+	return len(pk) > 0 && l.IsManaged(database.BannedPubkeys, hex.Enc(pk))
+}
@@ -7,10 +7,15 @@ import (
 	"lol.mleku.dev/log"
 	"next.orly.dev/pkg/encoders/envelopes"
 	"next.orly.dev/pkg/encoders/envelopes/authenvelope"
+	"next.orly.dev/pkg/encoders/envelopes/changesenvelope"
 	"next.orly.dev/pkg/encoders/envelopes/closeenvelope"
+	"next.orly.dev/pkg/encoders/envelopes/countenvelope"
 	"next.orly.dev/pkg/encoders/envelopes/eventenvelope"
+	"next.orly.dev/pkg/encoders/envelopes/forwardenvelope"
+	"next.orly.dev/pkg/encoders/envelopes/negentropyenvelope"
 	"next.orly.dev/pkg/encoders/envelopes/noticeenvelope"
 	"next.orly.dev/pkg/encoders/envelopes/reqenvelope"
+	"next.orly.dev/pkg/metrics"
 )
 
 func (l *Listener) HandleMessage(msg []byte, remote string) {
@@ -38,8 +43,18 @@ func (l *Listener) HandleMessage(msg []byte, remote string) {
 	}
 	
 	log.D.F("%s identified envelope type: %s (payload_len=%d)", remote, t, len(rem))
+
+	if l.rateLimited(l.rateLimits.Bytes, float64(len(msg))) {
+		if err = noticeenvelope.NewFrom(
+			"rate-limited: too much data, slow down",
+		).Write(l); chk.E(err) {
+			return
+		}
+		return
+	}
 	
-	// Process the identified envelope type
+	// Process the identified envelope type, counting unknown types together
+	counted := t
 	switch t {
 	case eventenvelope.L:
 		log.D.F("%s processing EVENT envelope", remote)
@@ -49,16 +64,37 @@ func (l *Listener) HandleMessage(msg []byte, remote string) {
 		log.D.F("%s processing REQ envelope", remote)
 		l.reqCount++
 		err = l.HandleReq(rem)
+	case countenvelope.L:
+		log.D.F("%s processing COUNT envelope", remote)
+		err = l.HandleCount(rem)
 	case closeenvelope.L:
 		log.D.F("%s processing CLOSE envelope", remote)
 		err = l.HandleClose(rem)
 	case authenvelope.L:
 		log.D.F("%s processing AUTH envelope", remote)
 		err = l.HandleAuth(rem)
+	case negentropyenvelope.LOpen:
+		log.D.F("%s processing NEG-OPEN envelope", remote)
+		err = l.HandleNegOpen(rem)
+	case negentropyenvelope.LMsg:
+		log.D.F("%s processing NEG-MSG envelope", remote)
+		err = l.HandleNegMsg(rem)
+	case negentropyenvelope.LClose:
+		log.D.F("%s processing NEG-CLOSE envelope", remote)
+		err = l.HandleNegClose(rem)
+	case changesenvelope.L:
+		log.D.F("%s processing CHANGES envelope", remote)
+		err = l.HandleChanges(rem)
+	case forwardenvelope.L:
+		log.D.F("%s processing FORWARD envelope", remote)
+		l.eventCount++
+		err = l.HandleForward(rem)
 	default:
+		counted = "unknown"
 		err = fmt.Errorf("unknown envelope type %s", t)
 		log.E.F("%s unknown envelope type: %s (payload: %q)", remote, t, string(rem))
 	}
+	metrics.Envelopes.Inc(counted)
 	
 	// Handle any processing errors
 	if err != nil {
@@ -0,0 +1,204 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"lol.mleku.dev/chk"
+	"lol.mleku.dev/log"
+	"next.orly.dev/pkg/acl"
+	"next.orly.dev/pkg/database/indexes/types"
+	"next.orly.dev/pkg/encoders/envelopes/authenvelope"
+	"next.orly.dev/pkg/encoders/envelopes/negentropyenvelope"
+	"next.orly.dev/pkg/encoders/filter"
+	"next.orly.dev/pkg/encoders/reason"
+	"next.orly.dev/pkg/interfaces/store"
+	"next.orly.dev/pkg/protocol/negentropy"
+)
+
+const (
+	// NegentropyMaxRecords is the largest set a NEG-OPEN may reconcile, above
+	// which the relay answers with a NEG-ERR.
+	NegentropyMaxRecords = 1000000
+	// NegentropyMaxOpen is the number of reconciliations a connection may
+	// have open at once, each of which holds its set in memory.
+	NegentropyMaxOpen = 4
+	// negentropyFrameSizeLimit bounds the negentropy messages the relay
+	// sends, which are doubled in size by the hex encoding.
+	negentropyFrameSizeLimit = DefaultMaxMessageSize / 4
+)
+
+// HandleNegOpen processes a NIP-77 NEG-OPEN envelope. The set of events
+// matching the filter is read from the FullIdPubkey index, so no event bodies
+// are decoded, and the reply to the client's initial message is sent as a
+// NEG-MSG.
+//
+// A NEG-OPEN for a subscription that is already open replaces it.
+func (l *Listener) HandleNegOpen(msg []byte) (err error) {
+	env := negentropyenvelope.NewOpen()
+	if _, err = env.Unmarshal(msg); chk.E(err) {
+		return
+	}
+	if l.rateLimited(l.rateLimits.Reqs, 1) {
+		return negentropyenvelope.NewErrFrom(
+			env.Subscription,
+			reason.RateLimited.F("too many requests, slow down"),
+		).Write(l)
+	}
+	sub := string(env.Subscription)
+	delete(l.negentropy, sub)
+	if len(l.negentropy) >= NegentropyMaxOpen {
+		return negentropyenvelope.NewErrFrom(
+			env.Subscription, reason.Blocked.F(
+				"too many open reconciliations, the maximum is %d",
+				NegentropyMaxOpen,
+			),
+		).Write(l)
+	}
+	// reconciliations count against the maximum number of subscriptions
+	if rejection := l.SubscriptionsExceeded(sub); rejection != "" {
+		return negentropyenvelope.NewErrFrom(
+			env.Subscription, reason.Blocked.F("%s", rejection),
+		).Write(l)
+	}
+	// send a challenge to the client to auth if an ACL is active
+	if acl.Registry.Active.Load() != "none" {
+		if err = authenvelope.NewChallengeWith(l.challenge.Load()).
+			Write(l); chk.E(err) {
+			return
+		}
+	}
+	// refuse blocked IPs and banned pubkeys of the NIP-86 management API
+	if l.requesterBanned() {
+		return negentropyenvelope.NewErrFrom(
+			env.Subscription, reason.Blocked.F("access is banned"),
+		).Write(l)
+	}
+	accessLevel := acl.Registry.GetAccessLevel(l.authedPubkey.Load(), l.remote)
+	if accessLevel == "none" {
+		return negentropyenvelope.NewErrFrom(
+			env.Subscription,
+			reason.AuthRequired.F("user not authed or has no read access"),
+		).Write(l)
+	}
+	// a DM inbox only reconciles direct messages with their recipients
+	if l.Config.DMInbox && len(l.authedPubkey.Load()) == 0 &&
+		requestsDMInbox(&filter.S{env.Filter}) {
+		if err = authenvelope.NewChallengeWith(l.challenge.Load()).
+			Write(l); chk.E(err) {
+			return
+		}
+		return negentropyenvelope.NewErrFrom(
+			env.Subscription, reason.AuthRequired.F(
+				"direct messages are only sent to their recipients",
+			),
+		).Write(l)
+	}
+	queryCtx, queryCancel := context.WithTimeout(l.ctx, 30*time.Second)
+	defer queryCancel()
+	var set map[uint64]struct{}
+	if set, err = l.filterSerials(queryCtx, env.Filter, accessLevel); chk.E(err) {
+		return negentropyenvelope.NewErrFrom(
+			env.Subscription, reason.Error.F("%s", err.Error()),
+		).Write(l)
+	}
+	if len(set) > NegentropyMaxRecords {
+		return negentropyenvelope.NewErrFrom(
+			env.Subscription, reason.Blocked.F("this query is too big"),
+		).Write(l)
+	}
+	// fetch the ids and timestamps in serial order, which is the order of
+	// the index
+	ordered := make([]uint64, 0, len(set))
+	for ser := range set {
+		ordered = append(ordered, ser)
+	}
+	sort.Slice(ordered, func(i, j int) bool { return ordered[i] < ordered[j] })
+	sers := make(types.Uint40s, 0, len(ordered))
+	for _, ser := range ordered {
+		s := new(types.Uint40)
+		if err = s.Set(ser); chk.E(err) {
+			return
+		}
+		sers = append(sers, s)
+	}
+	var fidpks []*store.IdPkTs
+	if fidpks, err = l.GetFullIdPubkeyBySerials(sers); chk.E(err) {
+		return negentropyenvelope.NewErrFrom(
+			env.Subscription, reason.Error.F("%s", err.Error()),
+		).Write(l)
+	}
+	storage := negentropy.NewStorage(len(fidpks))
+	for _, fidpk := range fidpks {
+		if err = storage.Insert(uint64(fidpk.Ts), fidpk.Id); chk.E(err) {
+			return
+		}
+	}
+	var n *negentropy.T
+	if n, err = negentropy.New(storage, negentropyFrameSizeLimit); chk.E(err) {
+		return
+	}
+	var reply []byte
+	if reply, _, _, err = n.Reconcile(env.Message); err != nil {
+		log.D.F("NEG-OPEN %s from %s: %v", sub, l.remote, err)
+		return negentropyenvelope.NewErrFrom(
+			env.Subscription, reason.Invalid.F("%s", err.Error()),
+		).Write(l)
+	}
+	if l.negentropy == nil {
+		l.negentropy = make(map[string]*negentropy.T)
+	}
+	l.negentropy[sub] = n
+	log.D.F(
+		"NEG-OPEN %s from %s: reconciling %d events", sub, l.remote,
+		storage.Size(),
+	)
+	return negentropyenvelope.NewMsgFrom(env.Subscription, reply).Write(l)
+}
+
+// HandleNegMsg processes a NIP-77 NEG-MSG envelope, continuing an open
+// reconciliation.
+func (l *Listener) HandleNegMsg(msg []byte) (err error) {
+	env := negentropyenvelope.NewMsg()
+	if _, err = env.Unmarshal(msg); chk.E(err) {
+		return
+	}
+	sub := string(env.Subscription)
+	n, ok := l.negentropy[sub]
+	if !ok {
+		return negentropyenvelope.NewErrFrom(
+			env.Subscription, reason.Closed.F("no open reconciliation"),
+		).Write(l)
+	}
+	var reply []byte
+	if reply, _, _, err = n.Reconcile(env.Message); err != nil {
+		delete(l.negentropy, sub)
+		log.D.F("NEG-MSG %s from %s: %v", sub, l.remote, err)
+		return negentropyenvelope.NewErrFrom(
+			env.Subscription, reason.Invalid.F("%s", err.Error()),
+		).Write(l)
+	}
+	return negentropyenvelope.NewMsgFrom(env.Subscription, reply).Write(l)
+}
+
+// HandleNegClose processes a NIP-77 NEG-CLOSE envelope, discarding the state
+// of a reconciliation.
+func (l *Listener) HandleNegClose(msg []byte) (err error) {
+	env := negentropyenvelope.NewClose()
+	if _, err = env.Unmarshal(msg); chk.E(err) {
+		return
+	}
+	if len(env.Subscription) == 0 {
+		return errors.New("NEG-CLOSE has no <id>")
+	}
+	delete(l.negentropy, string(env.Subscription))
+	return
+}
+
+// openSubscriptions returns the number of subscriptions, change feeds and
+// reconciliations open on the connection.
+func (l *Listener) openSubscriptions() int {
+	return len(l.subscriptions) + len(l.negentropy) + len(l.changes)
+}
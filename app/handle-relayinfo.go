@@ -5,10 +5,13 @@ import (
 	"net/http"
 	"sort"
 	"strings"
+	"time"
 
 	"lol.mleku.dev/chk"
 	"lol.mleku.dev/log"
+	"next.orly.dev/pkg/acl"
 	"next.orly.dev/pkg/crypto/p256k"
+	"next.orly.dev/pkg/database"
 	"next.orly.dev/pkg/encoders/hex"
 	"next.orly.dev/pkg/protocol/relayinfo"
 	"next.orly.dev/pkg/version"
@@ -44,9 +47,14 @@ func (s *Server) HandleRelayInfo(w http.ResponseWriter, r *http.Request) {
 		relayinfo.CommandResults,
 		relayinfo.ParameterizedReplaceableEvents,
 		relayinfo.ExpirationTimestamp,
+		relayinfo.CountingResults,
+		relayinfo.RequestToVanish,
 		relayinfo.ProtectedEvents,
 		relayinfo.RelayListMetadata,
 		relayinfo.SearchCapability,
+		relayinfo.NegentropySyncing,
+		relayinfo.RelayManagementAPI,
+		relayinfo.HTTPAuth,
 	)
 	if s.Config.ACLMode != "none" {
 		supportedNIPs = relayinfo.GetList(
@@ -61,11 +69,23 @@ func (s *Server) HandleRelayInfo(w http.ResponseWriter, r *http.Request) {
 			relayinfo.CommandResults,
 			relayinfo.ParameterizedReplaceableEvents,
 			relayinfo.ExpirationTimestamp,
+			relayinfo.CountingResults,
+			relayinfo.RequestToVanish,
 			relayinfo.ProtectedEvents,
 			relayinfo.RelayListMetadata,
 			relayinfo.SearchCapability,
+			relayinfo.NegentropySyncing,
+			relayinfo.RelayManagementAPI,
+			relayinfo.HTTPAuth,
 		)
 	}
+	if s.Config.PowMinDifficulty > 0 || len(s.Config.PowKindDifficulty) > 0 ||
+		s.Config.PowUnfollowed > 0 {
+		supportedNIPs = append(supportedNIPs, relayinfo.ProofOfWork.N())
+	}
+	if acl.Registry.Has("groups") {
+		supportedNIPs = append(supportedNIPs, relayinfo.RelayBasedGroups.N())
+	}
 	sort.Sort(supportedNIPs)
 	log.T.Ln("supported NIPs", supportedNIPs)
 	// Construct description with dashboard URL
@@ -88,12 +108,34 @@ func (s *Server) HandleRelayInfo(w http.ResponseWriter, r *http.Request) {
 		Nips:        supportedNIPs,
 		Software:    version.URL,
 		Version:     strings.TrimPrefix(version.V, "v"),
-		Limitation: relayinfo.Limits{
-			AuthRequired:     s.Config.ACLMode != "none",
-			RestrictedWrites: s.Config.ACLMode != "none",
-			PaymentRequired:  s.Config.MonthlyPriceSats > 0,
-		},
-		Icon: "https://i.nostr.build/6wGXAn7Zaw9mHxFg.png",
+		Limitation:  s.Limits(),
+		Icon:        "https://i.nostr.build/6wGXAn7Zaw9mHxFg.png",
+	}
+	info.Limitation.AuthRequired = s.Config.ACLMode != "none"
+	info.Limitation.RestrictedWrites = s.Config.ACLMode != "none"
+	if s.Config.MonthlyPriceSats > 0 &&
+		(acl.Registry.Has("paid") || s.Config.SubscriptionEnabled) {
+		info.Limitation.PaymentRequired = true
+		info.Fees = &relayinfo.Fees{
+			Subscription: []relayinfo.Subscription{
+				{
+					Amount: int(s.Config.MonthlyPriceSats),
+					Unit:   "sats",
+					Period: int((30 * 24 * time.Hour).Seconds()),
+				},
+			},
+		}
+	}
+	info.Limitation.MinPowDifficulty = s.Config.PowMinDifficulty
+	// values changed through the NIP-86 management API override the defaults
+	if v := s.GetRelayInfo(database.RelayInfoName); v != "" {
+		info.Name = v
+	}
+	if v := s.GetRelayInfo(database.RelayInfoDescription); v != "" {
+		info.Description = v
+	}
+	if v := s.GetRelayInfo(database.RelayInfoIcon); v != "" {
+		info.Icon = v
 	}
 	if err := json.NewEncoder(w).Encode(info); chk.E(err) {
 	}
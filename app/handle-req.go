@@ -36,6 +36,15 @@ func (l *Listener) HandleReq(msg []byte) (err error) {
 		return normalize.Error.Errorf(err.Error())
 	}
 	log.D.C(func() string { return fmt.Sprintf("REQ sub=%s filters=%d", env.Subscription, len(*env.Filters)) })
+	if l.rateLimited(l.rateLimits.Reqs, 1) {
+		if err = closedenvelope.NewFrom(
+			env.Subscription,
+			reason.RateLimited.F("too many subscriptions, slow down"),
+		).Write(l); chk.E(err) {
+			return
+		}
+		return
+	}
 	// send a challenge to the client to auth if an ACL is active
 	if acl.Registry.Active.Load() != "none" {
 		if err = authenvelope.NewChallengeWith(l.challenge.Load()).
@@ -43,6 +52,15 @@ func (l *Listener) HandleReq(msg []byte) (err error) {
 			return
 		}
 	}
+	// refuse blocked IPs and banned pubkeys of the NIP-86 management API
+	if l.requesterBanned() {
+		if err = closedenvelope.NewFrom(
+			env.Subscription, reason.Blocked.F("access is banned"),
+		).Write(l); chk.E(err) {
+			return
+		}
+		return
+	}
 	// check permissions of user
 	accessLevel := acl.Registry.GetAccessLevel(l.authedPubkey.Load(), l.remote)
 	switch accessLevel {
@@ -58,6 +76,55 @@ func (l *Listener) HandleReq(msg []byte) (err error) {
 	default:
 		// user has read access or better, continue
 	}
+	// a DM inbox only releases direct messages to authenticated recipients
+	if l.Config.DMInbox && len(l.authedPubkey.Load()) == 0 &&
+		requestsDMInbox(env.Filters) {
+		if err = authenvelope.NewChallengeWith(l.challenge.Load()).
+			Write(l); chk.E(err) {
+			return
+		}
+		if err = closedenvelope.NewFrom(
+			env.Subscription,
+			reason.AuthRequired.F("direct messages are only sent to their recipients"),
+		).Write(l); chk.E(err) {
+			return
+		}
+		return
+	}
+	// kinds that the kind policy restricts need the reader to authenticate
+	if len(l.authedPubkey.Load()) == 0 && l.policyReadAuth(env.Filters) {
+		if err = authenvelope.NewChallengeWith(l.challenge.Load()).
+			Write(l); chk.E(err) {
+			return
+		}
+		if err = closedenvelope.NewFrom(
+			env.Subscription,
+			reason.AuthRequired.F("requested kinds are restricted to some readers"),
+		).Write(l); chk.E(err) {
+			return
+		}
+		return
+	}
+	// check the limitations published in the relay information (NIP-11)
+	if rejection := l.FiltersExceeded(len(*env.Filters)); rejection != "" {
+		if err = closedenvelope.NewFrom(
+			env.Subscription, reason.Invalid.F("%s", rejection),
+		).Write(l); chk.E(err) {
+			return
+		}
+		return
+	}
+	if rejection := l.SubscriptionsExceeded(
+		string(env.Subscription),
+	); rejection != "" {
+		if err = closedenvelope.NewFrom(
+			env.Subscription, reason.Blocked.F("%s", rejection),
+		).Write(l); chk.E(err) {
+			return
+		}
+		return
+	}
+	l.ClampLimits(env.Filters)
 	var events event.S
 	// Create a single context for all filter queries, tied to the connection context, to prevent leaks and support timely cancellation
 	queryCtx, queryCancel := context.WithTimeout(
@@ -128,10 +195,33 @@ func (l *Listener) HandleReq(msg []byte) (err error) {
 		}
 	}()
 	var tmp event.S
+	bans := l.managementBans()
+	policy := l.kindPolicy.ReadRestricted()
 privCheck:
 	for _, ev := range events {
-		// Check for private tag first
-		privateTags := ev.Tags.GetAll([]byte("private"))
+		// banned events and authors are withheld (NIP-86)
+		if bans && l.ManagementHides(ev) {
+			continue
+		}
+		// private NIP-29 groups can only be read by their members
+		if !acl.Registry.CanReadGroupEvent(ev, l.authedPubkey.Load()) {
+			continue
+		}
+		if l.Config.DMInbox && kind.IsDMInbox(ev.Kind) &&
+			!dmInboxVisible(ev, l.authedPubkey.Load()) {
+			continue
+		}
+		if policy && !l.PolicyReadable(ev, l.authedPubkey.Load(), l.remote) {
+			continue
+		}
+		// Check for private tag first, ignoring bare private tags such as
+		// the one of the metadata of private NIP-29 groups
+		var privateTags []*tag.T
+		for _, t := range ev.Tags.GetAll([]byte("private")) {
+			if len(t.Value()) > 0 {
+				privateTags = append(privateTags, t)
+			}
+		}
 		if len(privateTags) > 0 && accessLevel != "admin" {
 			pk := l.authedPubkey.Load()
 			if pk == nil {
@@ -305,6 +395,10 @@ privCheck:
 	receiver := make(event.C, 32)
 	// if the subscription should be cancelled, do so
 	if !cancel {
+		if l.subscriptions == nil {
+			l.subscriptions = make(map[string]struct{})
+		}
+		l.subscriptions[string(env.Subscription)] = struct{}{}
 		l.publishers.Receive(
 			&W{
 				Conn:         l.conn,
@@ -313,10 +407,12 @@ privCheck:
 				Receiver:     receiver,
 				Filters:      env.Filters,
 				AuthedPubkey: l.authedPubkey.Load(),
+				traffic:      l.traffic,
 			},
 		)
 	} else {
 		// suppress server-sent CLOSED; client will close subscription if desired
+		delete(l.subscriptions, string(env.Subscription))
 	}
 	log.D.F("HandleReq: COMPLETED processing from %s", l.remote)
 	return
@@ -0,0 +1,102 @@
+package app
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"lol.mleku.dev/chk"
+	"lol.mleku.dev/log"
+	"next.orly.dev/pkg/encoders/envelopes/eventenvelope"
+	"next.orly.dev/pkg/encoders/event"
+	"next.orly.dev/pkg/utils/normalize"
+)
+
+// AllRelays is the value of the relay tag of a NIP-62 request to vanish that
+// targets every relay.
+const AllRelays = "ALL_RELAYS"
+
+// vanishTargetsRelay returns whether a NIP-62 request to vanish names this
+// relay, either by its URL or as all relays.
+func (l *Listener) vanishTargetsRelay(ev *event.E) bool {
+	var urls []string
+	if l.Config.RelayURL != "" {
+		urls = append(urls, sameRelayURL(l.Config.RelayURL))
+	}
+	if l.req != nil {
+		urls = append(urls, sameRelayURL(l.ServiceURL(l.req)))
+	}
+	for _, t := range ev.Tags.GetAll([]byte("relay")) {
+		v := string(t.Value())
+		if v == AllRelays {
+			return true
+		}
+		for _, u := range urls {
+			if u != "" && sameRelayURL(v) == u {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func sameRelayURL(u string) string {
+	return strings.TrimSuffix(string(normalize.URL(u)), "/")
+}
+
+// HandleVanishRequest stores a NIP-62 request to vanish that names this relay
+// and deletes the events of its pubkey in the background, so the websocket is
+// not blocked while they are removed.
+func (l *Listener) HandleVanishRequest(
+	env *eventenvelope.Submission,
+) (err error) {
+	if !l.vanishTargetsRelay(env.E) {
+		return Ok.Restricted(
+			l, env, "request to vanish does not name this relay",
+		)
+	}
+	saveCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if _, _, err = l.SaveEvent(saveCtx, env.E); err != nil {
+		if strings.HasPrefix(err.Error(), "blocked:") {
+			errStr := err.Error()[len("blocked: "):len(err.Error())]
+			if err = Ok.Error(l, env, errStr); chk.E(err) {
+				return
+			}
+			return
+		}
+		chk.E(err)
+		return
+	}
+	// the request takes effect as soon as it is stored, before the events of
+	// its pubkey are deleted
+	if err = l.MarkVanished(env.E); chk.E(err) {
+		return
+	}
+	if err = Ok.Ok(l, env, ""); chk.E(err) {
+		return
+	}
+	go l.publishers.Deliver(env.E.Clone())
+	go l.HandleVanish(env.E.Clone())
+	return
+}
+
+// HandleVanish deletes everything stored from the pubkey of a NIP-62 request
+// to vanish that has been saved, and logs it for the admins.
+func (l *Listener) HandleVanish(ev *event.E) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	count, err := l.Vanish(ctx, ev)
+	if chk.E(err) {
+		log.E.F(
+			"request to vanish from %0x failed after deleting %d events: %v",
+			ev.Pubkey, count, err,
+		)
+		return
+	}
+	log.I.F(
+		"request to vanish from %0x (%s): deleted %d events older than %d",
+		ev.Pubkey, l.remote, count, ev.CreatedAt,
+	)
+	ev.Free()
+}
@@ -12,6 +12,7 @@ import (
 	"lol.mleku.dev/log"
 	"next.orly.dev/pkg/encoders/envelopes/authenvelope"
 	"next.orly.dev/pkg/encoders/hex"
+	"next.orly.dev/pkg/utils/bytecount"
 	"next.orly.dev/pkg/utils/units"
 )
 
@@ -53,6 +54,11 @@ func (s *Server) HandleWebsocket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 whitelist:
+	if s.IPBlocked(remote) {
+		log.T.F("IP blocked: %s", remote)
+		http.Error(w, "IP address is blocked", http.StatusForbidden)
+		return
+	}
 	ctx, cancel := context.WithCancel(s.Ctx)
 	defer cancel()
 	var err error
@@ -61,15 +67,21 @@ whitelist:
 	acceptOptions := &websocket.AcceptOptions{
 		OriginPatterns: []string{"*"}, // Allow all origins for proxy compatibility
 		// Don't check origin when behind a proxy - let the proxy handle it
-		InsecureSkipVerify: true,
+		InsecureSkipVerify:   true,
+		CompressionMode:      s.compression.Mode,
+		CompressionThreshold: s.compression.Threshold,
 	}
-
-	if conn, err = websocket.Accept(w, r, acceptOptions); chk.E(err) {
+	// count the bytes on the wire of the hijacked connection
+	traffic := new(bytecount.Counter)
+	if conn, err = websocket.Accept(
+		traffic.ResponseWriter(w), r, acceptOptions,
+	); chk.E(err) {
 		log.E.F("websocket accept failed from %s: %v", remote, err)
 		return
 	}
 	log.T.F("websocket accepted from %s path=%s", remote, r.URL.String())
-	conn.SetReadLimit(DefaultMaxMessageSize)
+	defer s.trackTraffic(traffic)()
+	conn.SetReadLimit(int64(s.maxMessageLength()))
 	defer conn.CloseNow()
 	listener := &Listener{
 		ctx:       ctx,
@@ -78,6 +90,7 @@ whitelist:
 		remote:    remote,
 		req:       r,
 		startTime: time.Now(),
+		traffic:   traffic,
 	}
 	chal := make([]byte, 32)
 	rand.Read(chal)
@@ -102,7 +115,7 @@ whitelist:
 
 		// Cancel all subscriptions for this connection
 		log.D.F("cancelling subscriptions for %s", remote)
-		listener.publishers.Receive(&W{Cancel: true})
+		listener.publishers.Receive(&W{Cancel: true, Conn: conn})
 
 		// Log detailed connection statistics
 		dur := time.Since(listener.startTime)
@@ -111,6 +124,7 @@ whitelist:
 			remote, listener.msgCount, listener.reqCount, listener.eventCount,
 			dur,
 		)
+		log.D.F("ws connection traffic %s: %s", remote, traffic)
 
 		// Log any remaining connection state
 		if listener.authedPubkey.Load() != nil {
@@ -190,6 +204,7 @@ whitelist:
 			writeCancel()
 			continue
 		}
+		traffic.RawIn.Add(uint64(len(msg)))
 		// log.T.F("received message from %s: %s", remote, string(msg))
 		listener.HandleMessage(msg, remote)
 	}
@@ -0,0 +1,228 @@
+package app
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"lol.mleku.dev/chk"
+	"lol.mleku.dev/log"
+	"next.orly.dev/pkg/acl"
+	"next.orly.dev/pkg/encoders/hex"
+	acli "next.orly.dev/pkg/interfaces/acl"
+	"next.orly.dev/pkg/protocol/httpauth"
+	"next.orly.dev/pkg/utils"
+)
+
+// accessRank orders the access levels so a required level can be compared
+// with the level of a pubkey.
+var accessRank = map[string]int{
+	"none":     0,
+	acli.Read:  1,
+	acli.Write: 2,
+	acli.Admin: 3,
+	acli.Owner: 4,
+}
+
+type pubkeyKey struct{}
+
+// errNotAuthenticated is returned by requestPubkey when a request carries
+// neither a NIP-98 header nor a login cookie.
+var errNotAuthenticated = errors.New("not authenticated")
+
+// SessionCookie is the name of the cookie set by the web UI login.
+const SessionCookie = "orly_auth"
+
+// SessionLifetime is how long a login cookie is valid for.
+const SessionLifetime = 30 * 24 * time.Hour
+
+// checkAuth verifies the NIP-98 Authorization header of a request, with the
+// body size limit and reverse proxy setting of the configuration.
+func (s *Server) checkAuth(r *http.Request, requirePayload bool) (
+	valid bool, pubkey []byte, err error,
+) {
+	return httpauth.CheckAuthWith(
+		r, httpauth.Options{
+			RequirePayload: requirePayload,
+			MaxBody:        s.Config.AuthMaxBody,
+			TrustProxy:     s.Config.TrustProxy,
+		},
+	)
+}
+
+// requestPubkey returns the pubkey that authenticated an HTTP request, from a
+// NIP-98 Authorization header, or failing that the signed cookie set by the
+// web UI login. A request with a body must carry a NIP-98 payload tag that
+// commits to the body, so a captured header can't be replayed with another.
+func (s *Server) requestPubkey(r *http.Request) (pubkey []byte, err error) {
+	var valid bool
+	requirePayload := r.Method == http.MethodPost ||
+		r.Method == http.MethodPut || r.Method == http.MethodPatch
+	if valid, pubkey, err = s.checkAuth(r, requirePayload); err != nil {
+		log.D.F("NIP-98 auth failed from %s: %v", GetRemoteFromReq(r), err)
+		return
+	}
+	if valid {
+		return
+	}
+	var c *http.Cookie
+	if c, err = r.Cookie(SessionCookie); err != nil || c.Value == "" {
+		err = errNotAuthenticated
+		return
+	}
+	if pubkey, err = s.sessionPubkey(c.Value); err != nil {
+		log.D.F("invalid login cookie from %s: %v", GetRemoteFromReq(r), err)
+		err = errNotAuthenticated
+		return
+	}
+	return
+}
+
+// sessionKey returns the key that login cookies are signed with, derived
+// from the relay identity secret so sessions survive a restart.
+func (s *Server) sessionKey() (key []byte, err error) {
+	var secret []byte
+	if secret, err = s.D.GetOrCreateRelayIdentitySecret(); chk.E(err) {
+		return
+	}
+	h := sha256.Sum256(append([]byte(SessionCookie+":"), secret...))
+	return h[:], nil
+}
+
+// sessionValue returns the value of a login cookie for a pubkey, which is
+// the hex pubkey, the unix time it expires at and an HMAC of both, so it
+// can't be forged or extended.
+func (s *Server) sessionValue(pubkey []byte) (v string, err error) {
+	var key []byte
+	if key, err = s.sessionKey(); err != nil {
+		return
+	}
+	expiry := strconv.FormatInt(time.Now().Add(SessionLifetime).Unix(), 10)
+	return hex.Enc(pubkey) + "." + expiry + "." +
+		hex.Enc(sessionMAC(key, pubkey, expiry)), nil
+}
+
+// sessionMAC returns the HMAC of a login cookie for a pubkey that expires at
+// a unix time.
+func sessionMAC(key, pubkey []byte, expiry string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(pubkey)
+	mac.Write([]byte("." + expiry))
+	return mac.Sum(nil)
+}
+
+// sessionPubkey returns the pubkey of a login cookie value made by
+// sessionValue, if its HMAC is valid and it has not expired.
+func (s *Server) sessionPubkey(v string) (pubkey []byte, err error) {
+	parts := strings.Split(v, ".")
+	if len(parts) != 3 {
+		err = errors.New("login cookie is not signed")
+		return
+	}
+	var key, got []byte
+	if key, err = s.sessionKey(); err != nil {
+		return
+	}
+	if pubkey, err = hex.Dec(parts[0]); err != nil || len(pubkey) != 32 {
+		pubkey = nil
+		err = errors.New("login cookie has an invalid pubkey")
+		return
+	}
+	if got, err = hex.Dec(parts[2]); err != nil {
+		pubkey = nil
+		err = errors.New("login cookie has an invalid signature")
+		return
+	}
+	if !hmac.Equal(got, sessionMAC(key, pubkey, parts[1])) {
+		pubkey = nil
+		err = errors.New("login cookie has an invalid signature")
+		return
+	}
+	var expiry int64
+	if expiry, err = strconv.ParseInt(parts[1], 10, 64); err != nil ||
+		time.Now().Unix() > expiry {
+		pubkey = nil
+		err = errors.New("login cookie has expired")
+	}
+	return
+}
+
+// RequireAccess wraps an HTTP handler so it is only called for requests
+// authenticated by NIP-98 or the web UI login, by a pubkey that has at least
+// the given access level. The pubkey is available to the handler through
+// authedPubkey.
+func (s *Server) RequireAccess(
+	level string, next http.HandlerFunc,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pubkey, err := s.requestPubkey(r)
+		if err != nil {
+			msg := "Not authenticated"
+			if !errors.Is(err, errNotAuthenticated) {
+				msg = err.Error()
+			}
+			http.Error(w, msg, http.StatusUnauthorized)
+			return
+		}
+		got := acl.Registry.GetAccessLevel(pubkey, r.RemoteAddr)
+		if accessRank[got] < accessRank[level] {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(
+			w, r.WithContext(
+				context.WithValue(r.Context(), pubkeyKey{}, pubkey),
+			),
+		)
+	}
+}
+
+// RequireOwner wraps a handler so only the owners of the relay can call it,
+// authenticated by NIP-98 alone since the handlers it guards are destructive.
+// The owner's pubkey is stored in the request context like RequireAccess.
+func (s *Server) RequireOwner(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requirePayload := r.Method == http.MethodPost ||
+			r.Method == http.MethodPut || r.Method == http.MethodPatch
+		valid, pubkey, err := s.checkAuth(r, requirePayload)
+		if err != nil || !valid {
+			msg := "NIP-98 authentication required"
+			if err != nil {
+				msg = err.Error()
+			}
+			http.Error(w, msg, http.StatusUnauthorized)
+			return
+		}
+		if !s.isOwner(pubkey) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(
+			w, r.WithContext(
+				context.WithValue(r.Context(), pubkeyKey{}, pubkey),
+			),
+		)
+	}
+}
+
+// isOwner returns whether a pubkey is one of the owners of the relay.
+func (s *Server) isOwner(pubkey []byte) bool {
+	for _, o := range s.Owners {
+		if utils.FastEqual(o, pubkey) {
+			return true
+		}
+	}
+	return false
+}
+
+// authedPubkey returns the pubkey stored in the context of a request by
+// RequireAccess.
+func authedPubkey(r *http.Request) (pubkey []byte) {
+	pubkey, _ = r.Context().Value(pubkeyKey{}).([]byte)
+	return
+}
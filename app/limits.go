@@ -0,0 +1,120 @@
+package app
+
+import (
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"next.orly.dev/pkg/encoders/event"
+	"next.orly.dev/pkg/encoders/filter"
+	"next.orly.dev/pkg/encoders/timestamp"
+	"next.orly.dev/pkg/protocol/relayinfo"
+)
+
+// maxMessageLength returns the configured maximum size of a websocket
+// message, or DefaultMaxMessageSize if it is not set.
+func (s *Server) maxMessageLength() int {
+	if s.Config != nil && s.Config.MaxMessageLength > 0 {
+		return s.Config.MaxMessageLength
+	}
+	return DefaultMaxMessageSize
+}
+
+// EventLimitsExceeded returns the reason an event is outside the limitations
+// published in the relay information document, or an empty string if it is
+// within them.
+func (s *Server) EventLimitsExceeded(ev *event.E) (reason string) {
+	cfg := s.Config
+	if cfg.MaxEventTags > 0 && ev.Tags != nil && ev.Tags.Len() > cfg.MaxEventTags {
+		return fmt.Sprintf(
+			"event has %d tags, the maximum is %d", ev.Tags.Len(),
+			cfg.MaxEventTags,
+		)
+	}
+	if cfg.MaxContentLength > 0 {
+		if n := utf8.RuneCount(ev.Content); n > cfg.MaxContentLength {
+			return fmt.Sprintf(
+				"content has %d characters, the maximum is %d", n,
+				cfg.MaxContentLength,
+			)
+		}
+	}
+	now := time.Now()
+	if cfg.CreatedAtLowerLimit > 0 &&
+		ev.CreatedAt < now.Add(-cfg.CreatedAtLowerLimit).Unix() {
+		return fmt.Sprintf(
+			"created_at is more than %v in the past", cfg.CreatedAtLowerLimit,
+		)
+	}
+	if cfg.CreatedAtUpperLimit > 0 &&
+		ev.CreatedAt > now.Add(cfg.CreatedAtUpperLimit).Unix() {
+		return fmt.Sprintf(
+			"created_at is more than %v in the future", cfg.CreatedAtUpperLimit,
+		)
+	}
+	return
+}
+
+// FiltersExceeded returns the reason a request with n filters is over the
+// maximum number of filters, or an empty string if it is not.
+func (s *Server) FiltersExceeded(n int) (reason string) {
+	if max := s.Config.MaxFilters; max > 0 && n > max {
+		return fmt.Sprintf("too many filters, %d, the maximum is %d", n, max)
+	}
+	return
+}
+
+// SubscriptionsExceeded returns the reason opening a subscription would take
+// the connection over the maximum number of subscriptions, or an empty string
+// if it would not. Reusing the id of an open subscription replaces it.
+func (l *Listener) SubscriptionsExceeded(sub string) (reason string) {
+	max := l.Config.MaxSubscriptions
+	if max <= 0 {
+		return
+	}
+	if _, open := l.subscriptions[sub]; open {
+		return
+	}
+	if l.openSubscriptions() >= max {
+		return fmt.Sprintf("too many subscriptions, the maximum is %d", max)
+	}
+	return
+}
+
+// ClampLimits lowers the limit of each filter that is above the maximum
+// limit to the maximum.
+func (s *Server) ClampLimits(fs *filter.S) {
+	if s.Config.MaxLimit <= 0 || fs == nil {
+		return
+	}
+	max := uint(s.Config.MaxLimit)
+	for _, f := range *fs {
+		if f == nil {
+			continue
+		}
+		if f.Limit != nil && *f.Limit > max {
+			f.Limit = &max
+		}
+	}
+}
+
+// Limits returns the limitations of the relay for the relay information
+// document.
+func (s *Server) Limits() (l relayinfo.Limits) {
+	cfg := s.Config
+	l = relayinfo.Limits{
+		MaxMessageLength: s.maxMessageLength(),
+		MaxSubscriptions: cfg.MaxSubscriptions,
+		MaxFilters:       cfg.MaxFilters,
+		MaxLimit:         cfg.MaxLimit,
+		MaxEventTags:     cfg.MaxEventTags,
+		MaxContentLength: cfg.MaxContentLength,
+	}
+	if cfg.CreatedAtLowerLimit > 0 {
+		l.Oldest = timestamp.FromUnix(int64(cfg.CreatedAtLowerLimit.Seconds()))
+	}
+	if cfg.CreatedAtUpperLimit > 0 {
+		l.Newest = timestamp.FromUnix(int64(cfg.CreatedAtUpperLimit.Seconds()))
+	}
+	return
+}
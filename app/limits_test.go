@@ -0,0 +1,142 @@
+package app
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"next.orly.dev/app/config"
+	"next.orly.dev/pkg/encoders/event"
+	"next.orly.dev/pkg/encoders/filter"
+	"next.orly.dev/pkg/encoders/tag"
+	"next.orly.dev/pkg/encoders/timestamp"
+	"next.orly.dev/pkg/protocol/negentropy"
+)
+
+func TestEventLimitsExceeded(t *testing.T) {
+	s := &Server{
+		Config: &config.C{
+			MaxEventTags:        2,
+			MaxContentLength:    5,
+			CreatedAtLowerLimit: time.Hour,
+			CreatedAtUpperLimit: 15 * time.Minute,
+		},
+	}
+	now := timestamp.Now().V
+	newEv := func(content string, createdAt int64, tags int) *event.E {
+		ev := event.New()
+		ev.Content = []byte(content)
+		ev.CreatedAt = createdAt
+		ev.Tags = tag.NewS()
+		for i := 0; i < tags; i++ {
+			ev.Tags.Append(tag.NewFromAny("t", "limit"))
+		}
+		return ev
+	}
+	for _, c := range []struct {
+		name   string
+		ev     *event.E
+		reason string
+	}{
+		{"within limits", newEv("hello", now, 2), ""},
+		{"multibyte content", newEv("héllö", now, 0), ""},
+		{"too many tags", newEv("", now, 3), "tags"},
+		{"content too long", newEv("hello!", now, 0), "characters"},
+		{"too old", newEv("", now-7200, 0), "past"},
+		{"too new", newEv("", now+3600, 0), "future"},
+	} {
+		got := s.EventLimitsExceeded(c.ev)
+		if c.reason == "" && got != "" {
+			t.Errorf("%s: rejected with %q", c.name, got)
+		} else if !strings.Contains(got, c.reason) {
+			t.Errorf("%s: got %q, want a reason about %s", c.name, got, c.reason)
+		}
+	}
+	// zero limits are unlimited
+	s.Config = &config.C{}
+	if got := s.EventLimitsExceeded(newEv("hello!", 0, 3)); got != "" {
+		t.Errorf("unlimited relay rejected event with %q", got)
+	}
+}
+
+func TestClampLimits(t *testing.T) {
+	s := &Server{Config: &config.C{MaxLimit: 100}}
+	below, above := uint(10), uint(1000)
+	fs := filter.NewS(
+		&filter.F{Limit: &below}, &filter.F{Limit: &above}, &filter.F{}, nil,
+	)
+	s.ClampLimits(fs)
+	if *(*fs)[0].Limit != 10 {
+		t.Errorf("limit below the maximum changed to %d", *(*fs)[0].Limit)
+	}
+	if *(*fs)[1].Limit != 100 {
+		t.Errorf("limit above the maximum is %d, want 100", *(*fs)[1].Limit)
+	}
+	if (*fs)[2].Limit != nil {
+		t.Error("filter without a limit was given one")
+	}
+	if above != 1000 {
+		t.Error("clamping wrote through the limit of the filter")
+	}
+}
+
+func TestFiltersExceeded(t *testing.T) {
+	s := &Server{Config: &config.C{MaxFilters: 2}}
+	if got := s.FiltersExceeded(2); got != "" {
+		t.Errorf("2 filters rejected with %q", got)
+	}
+	if got := s.FiltersExceeded(3); got == "" {
+		t.Error("3 filters accepted with a maximum of 2")
+	}
+	s.Config.MaxFilters = 0
+	if got := s.FiltersExceeded(1000); got != "" {
+		t.Errorf("unlimited filters rejected with %q", got)
+	}
+}
+
+func TestSubscriptionsExceeded(t *testing.T) {
+	l := &Listener{
+		Server:        &Server{Config: &config.C{MaxSubscriptions: 2}},
+		subscriptions: map[string]struct{}{"a": {}},
+		negentropy:    map[string]*negentropy.T{},
+	}
+	if got := l.SubscriptionsExceeded("b"); got != "" {
+		t.Errorf("second subscription rejected with %q", got)
+	}
+	l.negentropy["n"] = nil
+	if got := l.SubscriptionsExceeded("b"); got == "" {
+		t.Error("reconciliations are not counted against the maximum")
+	}
+	if got := l.SubscriptionsExceeded("a"); got != "" {
+		t.Errorf("replacing an open subscription rejected with %q", got)
+	}
+	l.Config.MaxSubscriptions = 0
+	if got := l.SubscriptionsExceeded("b"); got != "" {
+		t.Errorf("unlimited subscriptions rejected with %q", got)
+	}
+}
+
+func TestStrikesDecay(t *testing.T) {
+	l := &Listener{
+		Server: &Server{
+			rateLimits: NewRateLimits(&config.C{RateLimitEvents: 1}),
+		},
+		remote: "192.0.2.1:1234",
+	}
+	if l.rateLimited(l.rateLimits.Events, 1) {
+		t.Fatal("the first event was rate limited")
+	}
+	for i := 0; i < 2; i++ {
+		if !l.rateLimited(l.rateLimits.Events, 1) {
+			t.Fatal("an event over the limit was not rate limited")
+		}
+	}
+	if l.strikes != 2 {
+		t.Fatalf("expected 2 strikes, got %d", l.strikes)
+	}
+	l.struckAt = l.struckAt.Add(-2 * strikeDecay)
+	l.rateLimited(l.rateLimits.Events, 1)
+	if l.strikes != 1 {
+		t.Errorf("strikes did not decay, got %d", l.strikes)
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"github.com/coder/websocket"
 	"lol.mleku.dev/chk"
 	"lol.mleku.dev/log"
+	"next.orly.dev/pkg/protocol/negentropy"
+	"next.orly.dev/pkg/utils/bytecount"
 	"next.orly.dev/pkg/utils/atomic"
 )
 
@@ -20,6 +22,25 @@ type Listener struct {
 	challenge    atomic.Bytes
 	authedPubkey atomic.Bytes
 	startTime    time.Time
+	// open NIP-77 reconciliations by subscription id
+	negentropy map[string]*negentropy.T
+	// open subscriptions by id, counted against the maximum
+	subscriptions map[string]struct{}
+	// open change feed subscriptions by id, counted against the maximum
+	changes map[string]context.CancelFunc
+	// number of rate limited messages, and when the last one was
+	strikes  int
+	struckAt time.Time
+	// access level of the connection for the rate limits, cached for the
+	// authed pubkey it was asked for
+	level   string
+	levelPk []byte
+	levelAt time.Time
+	// forwarded is set on the listener of an event a follower forwarded on
+	// behalf of a client
+	forwarded bool
+	// bytes sent and received before compression and on the wire
+	traffic *bytecount.Counter
 	// Diagnostics: per-connection counters
 	msgCount     int
 	reqCount     int
@@ -78,6 +99,7 @@ func (l *Listener) Write(p []byte) (n int, err error) {
 	writeDuration := time.Since(writeStart)
 	totalDuration := time.Since(start)
 	n = msgLen
+	l.traffic.RawOut.Add(uint64(n))
 	
 	log.D.F("ws->%s WRITE SUCCESS: len=%d duration=%v write_duration=%v", 
 		l.remote, n, totalDuration, writeDuration)
@@ -4,18 +4,23 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"os"
 
 	"lol.mleku.dev/chk"
 	"lol.mleku.dev/log"
 	"next.orly.dev/app/config"
 	"next.orly.dev/pkg/crypto/keys"
+	"next.orly.dev/pkg/crypto/p256k"
 	"next.orly.dev/pkg/database"
 	"next.orly.dev/pkg/encoders/bech32encoding"
+	"next.orly.dev/pkg/policy"
 	"next.orly.dev/pkg/protocol/publish"
+	"next.orly.dev/pkg/protocol/ws"
+	"next.orly.dev/pkg/webhook"
 )
 
 func Run(
-	ctx context.Context, cfg *config.C, db *database.D,
+	ctx context.Context, cfg *config.C, db *database.D, services *Services,
 ) (quit chan struct{}) {
 	// shutdown handler
 	go func() {
@@ -38,13 +43,61 @@ func Run(
 		}
 		adminKeys = append(adminKeys, pk)
 	}
+	// get the owners
+	var ownerKeys [][]byte
+	for _, owner := range cfg.Owners {
+		if len(owner) == 0 {
+			continue
+		}
+		var pk []byte
+		if pk, err = bech32encoding.NpubOrHexToPublicKeyBinary(owner); chk.E(err) {
+			continue
+		}
+		ownerKeys = append(ownerKeys, pk)
+	}
+	pub := NewPublisher(ctx)
+	pub.DMInbox = cfg.DMInbox
 	// start listener
 	l := &Server{
 		Ctx:        ctx,
 		Config:     cfg,
 		D:          db,
-		publishers: publish.New(NewPublisher(ctx)),
+		publishers: publish.New(pub),
 		Admins:     adminKeys,
+		Owners:     ownerKeys,
+		powKinds:   parsePowKinds(cfg.PowKindDifficulty),
+		rateLimits: NewRateLimits(cfg),
+		services:   services,
+	}
+	if services != nil {
+		services.SetDeliver(l.replicated)
+	}
+	pub.Hidden = l.ManagementHides
+	pub.Readable = l.PolicyReadable
+	l.collectMetrics(pub)
+	if err = l.loadKindPolicy(); err != nil {
+		log.E.F("invalid kind policy: %v", err)
+		os.Exit(1)
+	}
+	if cfg.WritePolicyPlugin != "" {
+		l.writePolicy = policy.NewPlugin(
+			ctx, cfg.WritePolicyPlugin, cfg.WritePolicyTimeout,
+			cfg.WritePolicyFailOpen,
+		)
+	}
+	if cfg.WebhooksFile != "" {
+		if l.webhooks, err = newWebhooks(ctx, cfg.WebhooksFile, db); err != nil {
+			log.E.F("invalid webhooks: %v", err)
+			os.Exit(1)
+		}
+		go l.webhooks.Run()
+	}
+	go l.rateLimits.Run(ctx)
+	if l.compression, err = ws.NewCompression(
+		cfg.WSCompression, cfg.WSCompressionMin,
+	); chk.E(err) {
+		log.W.F("websocket compression disabled: %v", err)
+		err = nil
 	}
 	// Initialize the user interface
 	l.UserInterface()
@@ -88,3 +141,34 @@ func Run(
 	quit = make(chan struct{})
 	return
 }
+
+// newWebhooks loads the webhooks of a file, which sign their requests with the
+// relay identity key.
+func newWebhooks(
+	ctx context.Context, path string, db *database.D,
+) (s *webhook.S, err error) {
+	var hooks []*webhook.Hook
+	if hooks, err = webhook.Load(path); err != nil {
+		return
+	}
+	var sign *p256k.Signer
+	if sign, err = identitySigner(db); err != nil {
+		return
+	}
+	log.I.F("loaded %d webhooks from %s", len(hooks), path)
+	return webhook.New(ctx, db, hooks, sign), nil
+}
+
+// identitySigner returns a signer of the relay identity key, which is created
+// if there is none, such as after a wipe.
+func identitySigner(db *database.D) (sign *p256k.Signer, err error) {
+	var skb []byte
+	if skb, err = db.GetOrCreateRelayIdentitySecret(); chk.E(err) {
+		return
+	}
+	sign = new(p256k.Signer)
+	if err = sign.InitSec(skb); chk.E(err) {
+		return
+	}
+	return
+}
@@ -0,0 +1,23 @@
+package app
+
+import (
+	"next.orly.dev/pkg/metrics"
+)
+
+// collectMetrics sets the gauges of the state of the relay that is read when
+// the metrics are written: the open connections, the subscriptions of the
+// publisher and the size of the database.
+func (s *Server) collectMetrics(pub *P) {
+	metrics.Default.OnCollect(
+		func() {
+			s.trafficMx.Lock()
+			connections := len(s.openTraffic)
+			s.trafficMx.Unlock()
+			metrics.Connections.Set(float64(connections))
+			metrics.Subscriptions.Set(float64(pub.Subscriptions()))
+			lsm, vlog := s.D.Size()
+			metrics.BadgerSize.Set(float64(lsm), "lsm")
+			metrics.BadgerSize.Set(float64(vlog), "vlog")
+		},
+	)
+}
@@ -4,6 +4,7 @@ import (
 	"next.orly.dev/pkg/encoders/envelopes/eventenvelope"
 	"next.orly.dev/pkg/encoders/envelopes/okenvelope"
 	"next.orly.dev/pkg/encoders/reason"
+	"next.orly.dev/pkg/metrics"
 )
 
 // OK represents a function that processes events or operations, using provided
@@ -13,6 +14,20 @@ type OK func(
 	l *Listener, env eventenvelope.I, format string, params ...any,
 ) (err error)
 
+// writeOK sends an OK reply, accepting the event if r is nil and rejecting it
+// with the reason otherwise, and counts it.
+func writeOK(
+	l *Listener, env eventenvelope.I, r reason.R, format string,
+	params ...any,
+) (err error) {
+	if r == nil {
+		metrics.OKs.Inc("true", "")
+		return okenvelope.NewFrom(env.Id(), true, []byte{}).Write(l)
+	}
+	metrics.OKs.Inc("false", r.S())
+	return okenvelope.NewFrom(env.Id(), false, r.F(format, params...)).Write(l)
+}
+
 // OKs provides a collection of handler functions for managing different types
 // of operational outcomes, each corresponding to specific error or status
 // conditions such as authentication requirements, rate limiting, and invalid
@@ -39,80 +54,60 @@ var Ok = OKs{
 		l *Listener, env eventenvelope.I, format string,
 		params ...any,
 	) (err error) {
-		return okenvelope.NewFrom(
-			env.Id(), true, []byte{},
-		).Write(l)
+		return writeOK(l, env, nil, format, params...)
 	},
 	AuthRequired: func(
 		l *Listener, env eventenvelope.I, format string,
 		params ...any,
 	) (err error) {
-		return okenvelope.NewFrom(
-			env.Id(), false, reason.AuthRequired.F(format, params...),
-		).Write(l)
+		return writeOK(l, env, reason.AuthRequired, format, params...)
 	},
 	PoW: func(
 		l *Listener, env eventenvelope.I, format string,
 		params ...any,
 	) (err error) {
-		return okenvelope.NewFrom(
-			env.Id(), false, reason.PoW.F(format, params...),
-		).Write(l)
+		return writeOK(l, env, reason.PoW, format, params...)
 	},
 	Duplicate: func(
 		l *Listener, env eventenvelope.I, format string,
 		params ...any,
 	) (err error) {
-		return okenvelope.NewFrom(
-			env.Id(), false, reason.Duplicate.F(format, params...),
-		).Write(l)
+		return writeOK(l, env, reason.Duplicate, format, params...)
 	},
 	Blocked: func(
 		l *Listener, env eventenvelope.I, format string,
 		params ...any,
 	) (err error) {
-		return okenvelope.NewFrom(
-			env.Id(), false, reason.Blocked.F(format, params...),
-		).Write(l)
+		return writeOK(l, env, reason.Blocked, format, params...)
 	},
 	RateLimited: func(
 		l *Listener, env eventenvelope.I, format string,
 		params ...any,
 	) (err error) {
-		return okenvelope.NewFrom(
-			env.Id(), false, reason.RateLimited.F(format, params...),
-		).Write(l)
+		return writeOK(l, env, reason.RateLimited, format, params...)
 	},
 	Invalid: func(
 		l *Listener, env eventenvelope.I, format string,
 		params ...any,
 	) (err error) {
-		return okenvelope.NewFrom(
-			env.Id(), false, reason.Invalid.F(format, params...),
-		).Write(l)
+		return writeOK(l, env, reason.Invalid, format, params...)
 	},
 	Error: func(
 		l *Listener, env eventenvelope.I, format string,
 		params ...any,
 	) (err error) {
-		return okenvelope.NewFrom(
-			env.Id(), false, reason.Error.F(format, params...),
-		).Write(l)
+		return writeOK(l, env, reason.Error, format, params...)
 	},
 	Unsupported: func(
 		l *Listener, env eventenvelope.I, format string,
 		params ...any,
 	) (err error) {
-		return okenvelope.NewFrom(
-			env.Id(), false, reason.Unsupported.F(format, params...),
-		).Write(l)
+		return writeOK(l, env, reason.Unsupported, format, params...)
 	},
 	Restricted: func(
 		l *Listener, env eventenvelope.I, format string,
 		params ...any,
 	) (err error) {
-		return okenvelope.NewFrom(
-			env.Id(), false, reason.Restricted.F(format, params...),
-		).Write(l)
+		return writeOK(l, env, reason.Restricted, format, params...)
 	},
 }
@@ -22,6 +22,7 @@ import (
 	"next.orly.dev/pkg/encoders/kind"
 	"next.orly.dev/pkg/encoders/tag"
 	"next.orly.dev/pkg/encoders/timestamp"
+	"next.orly.dev/pkg/metrics"
 	"next.orly.dev/pkg/protocol/nwc"
 )
 
@@ -99,7 +100,18 @@ func (pp *PaymentProcessor) Stop() {
 
 // listenForPayments subscribes to NWC notifications and processes payments
 func (pp *PaymentProcessor) listenForPayments() error {
-	return pp.nwcClient.SubscribeNotifications(pp.ctx, pp.handleNotification)
+	return pp.nwcClient.SubscribeNotifications(
+		pp.ctx, func(
+			notificationType string, notification map[string]any,
+		) (err error) {
+			if err = pp.handleNotification(
+				notificationType, notification,
+			); err != nil {
+				metrics.Payments.Inc("failed")
+			}
+			return
+		},
+	)
 }
 
 // runFollowSyncLoop periodically syncs the relay identity follow list with active subscribers
@@ -352,6 +364,7 @@ Log in to the relay dashboard to access your configuration at: %s`,
 		return fmt.Errorf("failed to save expiry warning note: %w", err)
 	}
 
+	metrics.PaymentNotes.Inc("expiry_warning")
 	log.I.F("created expiry warning note for user %s (expires %s)", hex.Enc(userPubkey), expiryTime.Format("2006-01-02"))
 	return nil
 }
@@ -455,6 +468,7 @@ Log in to the relay dashboard to access your configuration at: %s`,
 		return fmt.Errorf("failed to save trial reminder note: %w", err)
 	}
 
+	metrics.PaymentNotes.Inc("trial_reminder")
 	log.I.F("created trial reminder note for user %s (trial ends %s)", hex.Enc(userPubkey), trialEnd.Format("2006-01-02"))
 	return nil
 }
@@ -554,6 +568,9 @@ func (pp *PaymentProcessor) handleNotification(
 		log.E.F("failed to record payment: %v", err)
 	}
 
+	metrics.Payments.Inc("processed")
+	metrics.PaymentSats.Add(float64(satsReceived))
+
 	// Log helpful identifiers
 	var payerHex = hex.Enc(pubkey)
 	if userNpub == "" {
@@ -563,7 +580,7 @@ func (pp *PaymentProcessor) handleNotification(
 	}
 
 	// Update ACL follows cache and relay follow list immediately
-	if pp.config != nil && pp.config.ACLMode == "follows" {
+	if acl.Registry.Has("follows") {
 		acl.Registry.AddFollow(pubkey)
 	}
 	// Trigger an immediate follow-list sync in background (best-effort)
@@ -656,6 +673,7 @@ func (pp *PaymentProcessor) createPaymentNote(payerPubkey []byte, satsReceived i
 		return fmt.Errorf("failed to save payment note: %w", err)
 	}
 
+	metrics.PaymentNotes.Inc("payment")
 	log.I.F("created payment note for %s with private authorization", hex.Enc(payerPubkey))
 	return nil
 }
@@ -753,6 +771,7 @@ Enjoy your time on the relay!`, monthlyPrice, monthlyPrice, string(relayNpubForC
 		return fmt.Errorf("failed to save welcome note: %w", err)
 	}
 
+	metrics.PaymentNotes.Inc("welcome")
 	log.I.F("created welcome note for first-time user %s", hex.Enc(userPubkey))
 	return nil
 }
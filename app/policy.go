@@ -0,0 +1,163 @@
+package app
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+
+	"lol.mleku.dev/chk"
+	"lol.mleku.dev/log"
+	"next.orly.dev/pkg/acl"
+	"next.orly.dev/pkg/database"
+	"next.orly.dev/pkg/encoders/event"
+	"next.orly.dev/pkg/encoders/filter"
+	"next.orly.dev/pkg/encoders/kind"
+	"next.orly.dev/pkg/policy"
+)
+
+// kindPolicyMarker is the database marker holding the kind policy edited
+// through the admin API, which takes precedence over the policy file.
+const kindPolicyMarker = "kind-policy"
+
+func init() { database.KeepOnWipe(kindPolicyMarker) }
+
+// loadKindPolicy loads the kind policy stored by the admin API, or else the
+// policy file of the configuration.
+func (s *Server) loadKindPolicy() (err error) {
+	var b []byte
+	var from string
+	if s.D != nil && s.HasMarker(kindPolicyMarker) {
+		if b, err = s.GetMarker(kindPolicyMarker); chk.E(err) {
+			return
+		}
+		from = "database"
+	} else if s.Config.KindPolicy != "" {
+		if b, err = os.ReadFile(s.Config.KindPolicy); chk.E(err) {
+			return
+		}
+		from = s.Config.KindPolicy
+	} else {
+		return
+	}
+	var rules []policy.Rule
+	if rules, err = policy.Parse(b); chk.E(err) {
+		return
+	}
+	s.kindPolicy.Set(rules)
+	log.I.F("loaded kind policy of %d rules from %s", len(rules), from)
+	return
+}
+
+// PolicyReadable returns whether the kind policy lets a reader, authenticated
+// as pub or not at all when it is empty, from an address receive an event.
+func (s *Server) PolicyReadable(ev *event.E, pub []byte, address string) bool {
+	rule := s.kindPolicy.Rule(ev.Kind)
+	if rule == nil || !rule.ReadRestricted() {
+		return true
+	}
+	return rule.CanRead(pub, acl.Registry.GetAccessLevel(pub, address))
+}
+
+// policyHiddenKinds returns the kinds that a filter may match, which are all
+// kinds if it names none, that the kind policy does not release to a reader
+// authenticated as pub, or not at all when it is empty, from an address.
+func (s *Server) policyHiddenKinds(
+	f *filter.F, pub []byte, address string,
+) (kinds []*kind.K) {
+	if !s.kindPolicy.ReadRestricted() {
+		return
+	}
+	level := acl.Registry.GetAccessLevel(pub, address)
+	hidden := func(k uint16) bool {
+		rule := s.kindPolicy.Rule(k)
+		return rule != nil && rule.ReadRestricted() && !rule.CanRead(pub, level)
+	}
+	if f.Kinds.Len() > 0 {
+		for _, k := range f.Kinds.K {
+			if hidden(k.K) {
+				kinds = append(kinds, k)
+			}
+		}
+		return
+	}
+	seen := make(map[uint16]struct{})
+	for _, rule := range s.kindPolicy.Rules() {
+		min, max := rule.Bounds()
+		for k := uint32(min); k <= uint32(max); k++ {
+			if _, ok := seen[uint16(k)]; ok {
+				continue
+			}
+			seen[uint16(k)] = struct{}{}
+			if hidden(uint16(k)) {
+				kinds = append(kinds, kind.New(uint16(k)))
+			}
+		}
+	}
+	return
+}
+
+// policyReadAuth returns whether any of a set of filters explicitly asks for
+// a kind that the kind policy only releases to some readers, which an
+// unauthenticated client is asked to authenticate for.
+func (s *Server) policyReadAuth(fs *filter.S) bool {
+	if fs == nil || !s.kindPolicy.ReadRestricted() {
+		return false
+	}
+	for _, f := range *fs {
+		if f == nil || f.Kinds == nil {
+			continue
+		}
+		for _, k := range f.Kinds.K {
+			if rule := s.kindPolicy.Rule(k.K); rule != nil &&
+				rule.ReadRestricted() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// handleKindPolicy returns the kind policy on GET, and replaces it with the
+// JSON array of rules of the body on POST, storing it in the database. Admins
+// only.
+func (s *Server) handleKindPolicy(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		body, err := io.ReadAll(r.Body)
+		if chk.E(err) {
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+		var rules []policy.Rule
+		if rules, err = policy.Parse(body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err = s.SetMarker(kindPolicyMarker, body); chk.E(err) {
+			http.Error(
+				w, "Failed to store policy", http.StatusInternalServerError,
+			)
+			return
+		}
+		s.kindPolicy.Set(rules)
+		log.I.F("kind policy replaced with %d rules", len(rules))
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rules := s.kindPolicy.Rules()
+	if rules == nil {
+		rules = []policy.Rule{}
+	}
+	jsonData, err := json.Marshal(rules)
+	if chk.E(err) {
+		http.Error(
+			w, "Error generating response", http.StatusInternalServerError,
+		)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonData)
+}
@@ -0,0 +1,45 @@
+package app
+
+import (
+	"strconv"
+	"strings"
+
+	"lol.mleku.dev/log"
+)
+
+// parsePowKinds parses the kind:difficulty pairs of the per kind proof of
+// work setting.
+func parsePowKinds(pairs []string) (m map[uint16]int) {
+	m = make(map[uint16]int)
+	for _, p := range pairs {
+		k, d, ok := strings.Cut(strings.TrimSpace(p), ":")
+		if !ok {
+			log.W.F("invalid kind proof of work setting %q", p)
+			continue
+		}
+		ki, err := strconv.ParseUint(k, 10, 16)
+		if err != nil {
+			log.W.F("invalid kind in proof of work setting %q", p)
+			continue
+		}
+		di, err := strconv.Atoi(d)
+		if err != nil {
+			log.W.F("invalid difficulty in proof of work setting %q", p)
+			continue
+		}
+		m[uint16(ki)] = di
+	}
+	return
+}
+
+// PowRequired returns the NIP-13 difficulty an event of a kind must have,
+// which is the greater of the global and the per kind setting.
+func (s *Server) PowRequired(k uint16) (min int) {
+	if s.Config != nil {
+		min = s.Config.PowMinDifficulty
+	}
+	if d, ok := s.powKinds[k]; ok && d > min {
+		min = d
+	}
+	return
+}
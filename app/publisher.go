@@ -9,6 +9,7 @@ import (
 	"github.com/coder/websocket"
 	"lol.mleku.dev/chk"
 	"lol.mleku.dev/log"
+	"next.orly.dev/pkg/acl"
 	"next.orly.dev/pkg/encoders/envelopes/eventenvelope"
 	"next.orly.dev/pkg/encoders/event"
 	"next.orly.dev/pkg/encoders/filter"
@@ -16,7 +17,9 @@ import (
 	"next.orly.dev/pkg/encoders/kind"
 	"next.orly.dev/pkg/interfaces/publisher"
 	"next.orly.dev/pkg/interfaces/typer"
+	"next.orly.dev/pkg/metrics"
 	"next.orly.dev/pkg/utils"
+	"next.orly.dev/pkg/utils/bytecount"
 )
 
 const Type = "socketapi"
@@ -24,6 +27,8 @@ const Type = "socketapi"
 type Subscription struct {
 	remote       string
 	AuthedPubkey []byte
+	// traffic counts the bytes of the events delivered to the connection.
+	traffic *bytecount.Counter
 	*filter.S
 }
 
@@ -54,6 +59,9 @@ type W struct {
 
 	// AuthedPubkey is the authenticated pubkey associated with the listener (if any).
 	AuthedPubkey []byte
+
+	// traffic counts the bytes sent to the listener.
+	traffic *bytecount.Counter
 }
 
 func (w *W) Type() (typeName string) { return Type }
@@ -63,6 +71,16 @@ func (w *W) Type() (typeName string) { return Type }
 // subscriber connections and their filter configurations.
 type P struct {
 	c context.Context
+	// DMInbox restricts delivery of the DM inbox kinds to their author and
+	// recipients.
+	DMInbox bool
+	// Hidden, if set, returns whether an event is withheld from every
+	// subscriber, such as by the bans of the NIP-86 management API.
+	Hidden func(ev *event.E) bool
+	// Readable, if set, returns whether a subscriber, authenticated as pub or
+	// not at all when it is empty, from an address may receive an event,
+	// such as by the kind policy.
+	Readable func(ev *event.E, pub []byte, address string) bool
 	// Mx is the mutex for the Map.
 	Mx sync.RWMutex
 	// Map is the map of subscribers and subscriptions from the websocket api.
@@ -122,6 +140,7 @@ func (p *P) Receive(msg typer.T) {
 			subs = make(map[string]Subscription)
 			subs[m.Id] = Subscription{
 				S: m.Filters, remote: m.remote, AuthedPubkey: m.AuthedPubkey,
+				traffic: m.traffic,
 			}
 			p.Map[m.Conn] = subs
 			// log.D.C(
@@ -136,6 +155,7 @@ func (p *P) Receive(msg typer.T) {
 		} else {
 			subs[m.Id] = Subscription{
 				S: m.Filters, remote: m.remote, AuthedPubkey: m.AuthedPubkey,
+				traffic: m.traffic,
 			}
 			// log.D.C(
 			// 	func() string {
@@ -162,6 +182,9 @@ func (p *P) Receive(msg typer.T) {
 // for unauthenticated users when events are privileged.
 func (p *P) Deliver(ev *event.E) {
 	var err error
+	if p.Hidden != nil && p.Hidden(ev) {
+		return
+	}
 	// Snapshot the deliveries under read lock to avoid holding locks during I/O
 	p.Mx.RLock()
 	type delivery struct {
@@ -191,6 +214,18 @@ func (p *P) Deliver(ev *event.E) {
 		)
 	}
 	for _, d := range deliveries {
+		// private NIP-29 groups can only be read by their members
+		if !acl.Registry.CanReadGroupEvent(ev, d.sub.AuthedPubkey) {
+			continue
+		}
+		if p.DMInbox && kind.IsDMInbox(ev.Kind) &&
+			!dmInboxVisible(ev, d.sub.AuthedPubkey) {
+			continue
+		}
+		if p.Readable != nil &&
+			!p.Readable(ev, d.sub.AuthedPubkey, d.sub.remote) {
+			continue
+		}
 		// If the event is privileged, enforce that the subscriber's authed pubkey matches
 		// either the event pubkey or appears in any 'p' tag of the event.
 		if kind.IsPrivileged(ev.Kind) && len(d.sub.AuthedPubkey) > 0 {
@@ -253,7 +288,10 @@ func (p *P) Deliver(ev *event.E) {
  		if writeCtx.Err() != nil {
  			log.E.F("subscription delivery TIMEOUT: event=%s to=%s after %v (limit=%v)", 
  				hex.Enc(ev.ID), d.sub.remote, deliveryDuration, DefaultWriteTimeout)
- 		}
+			metrics.DeliveryFailures.Inc("timeout")
+		} else {
+			metrics.DeliveryFailures.Inc("error")
+		}
 		
  		// Log connection cleanup
  		log.D.F("removing failed subscriber connection: %s", d.sub.remote)
@@ -264,6 +302,9 @@ func (p *P) Deliver(ev *event.E) {
  		continue
  	}
 	
+		if d.sub.traffic != nil {
+			d.sub.traffic.RawOut.Add(uint64(len(msgData)))
+		}
  	deliveryDuration := time.Since(deliveryStart)
  	log.D.F("subscription delivery SUCCESS: event=%s to=%s sub=%s duration=%v len=%d", 
  		hex.Enc(ev.ID), d.sub.remote, d.id, deliveryDuration, len(msgData))
@@ -276,6 +317,16 @@ func (p *P) Deliver(ev *event.E) {
 	}
 }
 
+// Subscriptions returns the number of open subscriptions.
+func (p *P) Subscriptions() (n int) {
+	p.Mx.RLock()
+	defer p.Mx.RUnlock()
+	for _, subs := range p.Map {
+		n += len(subs)
+	}
+	return
+}
+
 // removeSubscriberId removes a specific subscription from a subscriber
 // websocket.
 func (p *P) removeSubscriberId(ws *websocket.Conn, id string) {
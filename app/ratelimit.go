@@ -0,0 +1,132 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/coder/websocket"
+	"lol.mleku.dev/log"
+	"next.orly.dev/app/config"
+	"next.orly.dev/pkg/acl"
+	"next.orly.dev/pkg/encoders/hex"
+	"next.orly.dev/pkg/utils"
+	"next.orly.dev/pkg/utils/ratelimit"
+)
+
+const (
+	// levelTTL is how long the access level of a connection is cached for the
+	// rate limits, so it is not asked of the ACLs for every message.
+	levelTTL = time.Minute
+	// strikeDecay is how long a connection must go without being rate limited
+	// for one of its strikes to be forgiven.
+	strikeDecay = time.Minute
+)
+
+// RateLimits are the token buckets that throttle clients, keyed by their IP
+// address and by their authed pubkey, so neither reconnecting nor switching
+// keys escapes them.
+type RateLimits struct {
+	// Events limits EVENT messages.
+	Events *ratelimit.Limiter
+	// Reqs limits REQ, COUNT and NEG-OPEN messages.
+	Reqs *ratelimit.Limiter
+	// Bytes limits the size of all messages sent by a client.
+	Bytes *ratelimit.Limiter
+	// scale multiplies the limits for clients with write access.
+	scale float64
+	// strikes is how many rate limited messages close a connection.
+	strikes int
+}
+
+// NewRateLimits creates the rate limits from the configured per minute
+// rates, allowing a burst of one minute's worth.
+func NewRateLimits(cfg *config.C) (r *RateLimits) {
+	perMinute := func(n int) *ratelimit.Limiter {
+		return ratelimit.New(float64(n)/60, float64(n))
+	}
+	r = &RateLimits{
+		Events:  perMinute(cfg.RateLimitEvents),
+		Reqs:    perMinute(cfg.RateLimitReqs),
+		Bytes:   perMinute(cfg.RateLimitBytes),
+		scale:   float64(cfg.RateLimitScale),
+		strikes: cfg.RateLimitStrikes,
+	}
+	if r.scale < 1 {
+		r.scale = 1
+	}
+	return
+}
+
+// Run prunes the idle buckets every minute until the context is canceled.
+func (r *RateLimits) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.Events.Prune()
+			r.Reqs.Prune()
+			r.Bytes.Prune()
+		}
+	}
+}
+
+// rateLimited takes cost tokens from the buckets of a limiter for the
+// address and authed pubkey of the listener, and returns true if there were
+// not enough. Admins are never limited, and clients with write access get a
+// higher limit. Too many rate limited messages close the connection, but a
+// strike is forgiven for every minute without one.
+func (l *Listener) rateLimited(lim *ratelimit.Limiter, cost float64) bool {
+	if lim == nil || lim.Rate <= 0 {
+		return false
+	}
+	pk := l.authedPubkey.Load()
+	scale := 1.0
+	switch l.rateLevel(pk) {
+	case "admin", "owner":
+		return false
+	case "write":
+		scale = l.rateLimits.scale
+	}
+	keys := []string{"ip:" + remoteIP(l.remote)}
+	if len(pk) > 0 {
+		keys = append(keys, "pk:"+hex.Enc(pk))
+	}
+	if lim.Allow(cost, scale, keys...) {
+		return false
+	}
+	// the connection of a forwarded event is the follower's, which is not
+	// closed for the client's strikes
+	if l.forwarded {
+		return true
+	}
+	now := time.Now()
+	if n := int(now.Sub(l.struckAt) / strikeDecay); n > 0 {
+		l.strikes = max(l.strikes-n, 0)
+	}
+	l.strikes++
+	l.struckAt = now
+	log.D.F("rate limited %s, strike %d", l.remote, l.strikes)
+	if l.rateLimits.strikes > 0 && l.strikes >= l.rateLimits.strikes {
+		log.I.F(
+			"closing connection from %s after %d rate limited messages",
+			l.remote, l.strikes,
+		)
+		l.conn.Close(websocket.StatusPolicyViolation, "rate limited")
+	}
+	return true
+}
+
+// rateLevel returns the access level of the connection for the rate limits,
+// which is asked of the ACLs again when the client authenticates or the
+// cached level is older than levelTTL.
+func (l *Listener) rateLevel(pk []byte) string {
+	if l.levelAt.IsZero() || time.Since(l.levelAt) > levelTTL ||
+		!utils.FastEqual(pk, l.levelPk) {
+		l.level = acl.Registry.GetAccessLevel(pk, l.remote)
+		l.levelPk, l.levelAt = pk, time.Now()
+	}
+	return l.level
+}
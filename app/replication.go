@@ -0,0 +1,208 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"lol.mleku.dev/chk"
+	"lol.mleku.dev/log"
+	"next.orly.dev/pkg/acl"
+	"next.orly.dev/pkg/encoders/envelopes/authenvelope"
+	"next.orly.dev/pkg/encoders/envelopes/eventenvelope"
+	"next.orly.dev/pkg/encoders/envelopes/forwardenvelope"
+	"next.orly.dev/pkg/encoders/event"
+	"next.orly.dev/pkg/encoders/hex"
+	"next.orly.dev/pkg/encoders/kind"
+	"next.orly.dev/pkg/encoders/reason"
+	"next.orly.dev/pkg/replication"
+	"next.orly.dev/pkg/utils"
+)
+
+// forwardTimeout is how long a follower waits for the leader to answer an
+// event it forwarded.
+const forwardTimeout = 10 * time.Second
+
+// leaderReasons are the machine readable prefixes of the OK messages of the
+// leader that are passed on to clients.
+var leaderReasons = []reason.R{
+	reason.AuthRequired, reason.PoW, reason.Duplicate, reason.Blocked,
+	reason.RateLimited, reason.Invalid, reason.Unsupported, reason.Restricted,
+	reason.Error,
+}
+
+// follower returns the follower of the leader, or nil if the relay is a
+// leader.
+func (s *Server) follower() *replication.Follower {
+	if s.services == nil {
+		return nil
+	}
+	return s.services.Follower()
+}
+
+// replicated delivers an event saved by the follower to the subscribers and
+// updates the web of trust with a new follow list, as for events published
+// to the relay.
+func (s *Server) replicated(ev *event.E) {
+	s.publishers.Deliver(ev)
+	if ev.Kind == kind.FollowList.K {
+		go acl.Registry.ApplyFollowList(ev.Clone())
+	}
+}
+
+// forwardEvent forwards an event published to a follower to its leader, with
+// the pubkey the client authenticated as and its address, and answers with
+// the OK of the leader, or rejects it if the follower does not forward
+// writes. When the leader requires the client to authenticate, the follower
+// sends its own challenge, as the client authenticates to the follower. A
+// forwarded event is stored by the follower when it comes back through the
+// change feed, except an ephemeral event, which is delivered to the
+// subscribers of the follower right away.
+func (l *Listener) forwardEvent(
+	f *replication.Follower, env *eventenvelope.Submission,
+) (err error) {
+	if l.Config.FollowerWrites == "reject" {
+		return Ok.Blocked(
+			l, env, "this relay is a read only follower of another relay",
+		)
+	}
+	ctx, cancel := context.WithTimeout(l.Ctx(), forwardTimeout)
+	defer cancel()
+	if err = f.Forward(
+		ctx, env.E, l.authedPubkey.Load(), l.remote,
+	); err != nil {
+		var rejected *replication.Rejected
+		if !errors.As(err, &rejected) {
+			log.E.F("forwarding event %0x to the leader: %v", env.E.ID, err)
+			return Ok.Error(l, env, "could not forward the event to the leader")
+		}
+		r, msg := leaderReason(rejected.Msg)
+		if err = writeOK(l, env, r, "%s", msg); chk.E(err) {
+			return
+		}
+		if utils.FastEqual(r, reason.AuthRequired) {
+			err = authenvelope.NewChallengeWith(l.challenge.Load()).Write(l)
+		}
+		return
+	}
+	if err = Ok.Ok(l, env, ""); chk.E(err) {
+		return
+	}
+	if kind.IsEphemeral(env.E.Kind) {
+		go l.publishers.Deliver(env.E.Clone())
+	}
+	log.D.F("forwarded event %0x to the leader", env.E.ID)
+	return
+}
+
+// leaderReason splits the message of a rejection by the leader into its
+// prefix and the rest.
+func leaderReason(msg string) (r reason.R, rest string) {
+	for _, r = range leaderReasons {
+		if r.IsPrefix([]byte(msg)) {
+			rest = strings.TrimPrefix(msg[len(r):], ":")
+			return r, strings.TrimSpace(rest)
+		}
+	}
+	return reason.Error, "leader: " + msg
+}
+
+// HandleForward handles an event a follower forwarded on behalf of a client.
+// Only the admins that may read the change feed may forward events, and the
+// event is then handled as if the client had published it, with the pubkey
+// it authenticated as to the follower and its address, so the ACL, the IP
+// blocks and the rate limits of the relay apply to the client rather than
+// the follower.
+func (l *Listener) HandleForward(msg []byte) (err error) {
+	env := forwardenvelope.New()
+	if _, err = env.Unmarshal(msg); chk.E(err) {
+		return
+	}
+	pubkey := l.authedPubkey.Load()
+	if len(pubkey) == 0 {
+		// the challenge goes first, so the follower has it when it gets the
+		// OK and authenticates
+		if err = authenvelope.NewChallengeWith(l.challenge.Load()).
+			Write(l); chk.E(err) {
+			return
+		}
+		return Ok.AuthRequired(l, env, "only admins may forward events")
+	}
+	if !l.feedAllowed(pubkey, l.remote) {
+		return Ok.Restricted(l, env, "only admins may forward events")
+	}
+	log.D.F(
+		"handling event %0x forwarded by %s for %s", env.Event.ID, l.remote,
+		env.Remote,
+	)
+	return l.forwardedBy(env).HandleEvent(
+		eventenvelope.NewSubmissionWith(env.Event).Marshal(nil),
+	)
+}
+
+// forwardedBy returns a listener for an event forwarded by a follower, which
+// answers on the connection of the follower but has the address and authed
+// pubkey of the client. The challenge of the follower is kept, so an
+// auth-required answer does not replace it.
+func (l *Listener) forwardedBy(env *forwardenvelope.T) (fl *Listener) {
+	fl = &Listener{
+		ctx:       l.ctx,
+		Server:    l.Server,
+		conn:      l.conn,
+		remote:    env.Remote,
+		req:       l.req,
+		startTime: l.startTime,
+		traffic:   l.traffic,
+		forwarded: true,
+	}
+	fl.challenge.Store(l.challenge.Load())
+	fl.authedPubkey.Store(env.Pubkey)
+	return
+}
+
+// handlePromote stops following the leader, so the relay runs as a leader
+// from then on, also after it restarts. Owners only.
+func (s *Server) handlePromote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.follower() == nil {
+		http.Error(w, "Not a follower", http.StatusConflict)
+		return
+	}
+	log.W.F("promotion requested by owner %s", hex.Enc(authedPubkey(r)))
+	if err := s.services.Promote(); chk.E(err) {
+		http.Error(
+			w, "Promotion failed: "+err.Error(), http.StatusInternalServerError,
+		)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"success": true, "message": "Promoted to leader"}`))
+}
+
+// HandleReplication reports the role of the relay, and the lag behind the
+// leader of a follower, as JSON on the health port. If the max_lag parameter
+// is given, a follower that was last caught up longer ago than that answers
+// with 503, for the health checks of load balancers.
+func (s *Services) HandleReplication(w http.ResponseWriter, r *http.Request) {
+	st := s.ReplicationStatus()
+	code := http.StatusOK
+	if ml := r.URL.Query().Get("max_lag"); ml != "" {
+		maxLag, err := time.ParseDuration(ml)
+		if err != nil {
+			http.Error(w, "Invalid max_lag", http.StatusBadRequest)
+			return
+		}
+		if st.Role == "follower" && st.LagSeconds > maxLag.Seconds() {
+			code = http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	chk.E(json.NewEncoder(w).Encode(st))
+}
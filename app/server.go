@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"io"
 	"log"
+	"mime"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
@@ -21,8 +22,12 @@ import (
 	"next.orly.dev/pkg/encoders/filter"
 	"next.orly.dev/pkg/encoders/hex"
 	"next.orly.dev/pkg/encoders/tag"
+	acli "next.orly.dev/pkg/interfaces/acl"
 	"next.orly.dev/pkg/protocol/auth"
 	"next.orly.dev/pkg/protocol/publish"
+	"next.orly.dev/pkg/protocol/ws"
+	"next.orly.dev/pkg/utils/bytecount"
+	"next.orly.dev/pkg/webhook"
 )
 
 type Server struct {
@@ -32,8 +37,30 @@ type Server struct {
 	remote     string
 	publishers *publish.S
 	Admins     [][]byte
+	Owners     [][]byte
 	*database.D
 
+	// proof of work difficulty required for specific kinds
+	powKinds map[uint16]int
+	// who may write and read each kind
+	kindPolicy policy.Table
+	// optional external plugin deciding whether events are accepted
+	writePolicy *policy.Plugin
+	// optional webhooks sent the stored events matching their filters
+	webhooks *webhook.S
+	// the workers started again when the relay restarts
+	services *Services
+
+	rateLimits *RateLimits
+
+	// websocket compression negotiated with clients
+	compression ws.Compression
+	// bytes sent and received by closed client connections
+	traffic bytecount.Counter
+	// the counters of the open client connections
+	trafficMx   sync.Mutex
+	openTraffic map[*bytecount.Counter]struct{}
+
 	// optional reverse proxy for dev web server
 	devProxy *httputil.ReverseProxy
 
@@ -87,6 +114,13 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if ct, _, err := mime.ParseMediaType(
+		r.Header.Get("Content-Type"),
+	); err == nil && ct == ManagementContentType {
+		s.HandleManagement(w, r)
+		return
+	}
+
 	if s.mux == nil {
 		http.Error(w, "Upgrade required", http.StatusUpgradeRequired)
 		return
@@ -199,13 +233,38 @@ func (s *Server) UserInterface() {
 	s.mux.HandleFunc("/api/auth/status", s.handleAuthStatus)
 	s.mux.HandleFunc("/api/auth/logout", s.handleAuthLogout)
 	s.mux.HandleFunc("/api/permissions/", s.handlePermissions)
-	// Export endpoints
-	s.mux.HandleFunc("/api/export", s.handleExport)
-	s.mux.HandleFunc("/api/export/mine", s.handleExportMine)
+	// Export endpoints, authenticated by NIP-98 or the signed login cookie
+	s.mux.HandleFunc("/api/export", s.RequireAccess(acli.Admin, s.handleExport))
+	s.mux.HandleFunc(
+		"/api/export/mine", s.RequireAccess(acli.Read, s.handleExportMine),
+	)
 	// Events endpoints
-	s.mux.HandleFunc("/api/events/mine", s.handleEventsMine)
+	s.mux.HandleFunc(
+		"/api/events/mine", s.RequireAccess(acli.Read, s.handleEventsMine),
+	)
 	// Import endpoint (admin only)
-	s.mux.HandleFunc("/api/import", s.handleImport)
+	s.mux.HandleFunc("/api/import", s.RequireAccess(acli.Admin, s.handleImport))
+	// Websocket traffic and compression savings (admin only)
+	s.mux.HandleFunc("/api/traffic", s.RequireAccess(acli.Admin, s.handleTraffic))
+	// Web of trust graph statistics (admin only)
+	s.mux.HandleFunc("/api/acl/wot", s.RequireAccess(acli.Admin, s.handleWotStats))
+	// Webhook delivery status (admin only)
+	s.mux.HandleFunc(
+		"/api/webhooks", s.RequireAccess(acli.Admin, s.handleWebhooks),
+	)
+	// Per kind write and read policy (admin only)
+	s.mux.HandleFunc(
+		"/api/policy", s.RequireAccess(acli.Admin, s.handleKindPolicy),
+	)
+	// Serial ordered change feed (admins only)
+	s.mux.HandleFunc(
+		"/api/changes", s.RequireAccess(acli.Read, s.handleChanges),
+	)
+	// Database wipe and in-process restart (owners only, NIP-98)
+	s.mux.HandleFunc("/api/wipe", s.RequireOwner(s.handleWipe))
+	s.mux.HandleFunc("/api/restart", s.RequireOwner(s.handleRestart))
+	// Promotion of a follower to leader (owners only, NIP-98)
+	s.mux.HandleFunc("/api/promote", s.RequireOwner(s.handlePromote))
 }
 
 // handleLoginInterface serves the main user interface for login
@@ -308,14 +367,19 @@ func (s *Server) handleAuthLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Authentication successful: set a simple session cookie with the pubkey
+	// Authentication successful: set a session cookie signed by the relay
+	session, err := s.sessionValue(evt.Pubkey)
+	if chk.E(err) {
+		w.Write([]byte(`{"success": false, "error": "failed to create session"}`))
+		return
+	}
 	cookie := &http.Cookie{
-		Name:     "orly_auth",
-		Value:    hex.Enc(evt.Pubkey),
+		Name:     SessionCookie,
+		Value:    session,
 		Path:     "/",
 		HttpOnly: true,
 		SameSite: http.SameSiteLaxMode,
-		MaxAge:   60 * 60 * 24 * 30, // 30 days
+		MaxAge:   int(SessionLifetime.Seconds()),
 	}
 	http.SetCookie(w, cookie)
 	w.Write([]byte(`{"success": true, "pubkey": "` + hex.Enc(evt.Pubkey) + `", "message": "Authentication successful"}`))
@@ -329,13 +393,10 @@ func (s *Server) handleAuthStatus(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	// Check for auth cookie
-	if c, err := r.Cookie("orly_auth"); err == nil && c.Value != "" {
-		// Validate pubkey format (hex)
-		if _, err := hex.Dec(c.Value); !chk.E(err) {
-			w.Write([]byte(`{"authenticated": true, "pubkey": "` + c.Value + `"}`))
-			return
-		}
+	// Check for NIP-98 auth or the auth cookie
+	if pubkey, err := s.requestPubkey(r); err == nil {
+		w.Write([]byte(`{"authenticated": true, "pubkey": "` + hex.Enc(pubkey) + `"}`))
+		return
 	}
 	w.Write([]byte(`{"authenticated": false}`))
 }
@@ -349,7 +410,7 @@ func (s *Server) handleAuthLogout(w http.ResponseWriter, r *http.Request) {
 	// Expire the cookie
 	http.SetCookie(
 		w, &http.Cookie{
-			Name:     "orly_auth",
+			Name:     SessionCookie,
 			Value:    "",
 			Path:     "/",
 			MaxAge:   -1,
@@ -407,28 +468,62 @@ func (s *Server) handlePermissions(w http.ResponseWriter, r *http.Request) {
 	w.Write(jsonData)
 }
 
-// handleExport streams all events as JSONL (NDJSON). Admins only.
-func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+// handleWotStats reports the size of the follow graph of the web of trust
+// ACL. Admins only.
+func (s *Server) handleWotStats(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	stats, ok := acl.Registry.WotStats()
+	if !ok {
+		http.Error(w, "web of trust ACL is not active", http.StatusNotFound)
+		return
+	}
+	jsonData, err := json.Marshal(stats)
+	if chk.E(err) {
+		http.Error(
+			w, "Error generating response", http.StatusInternalServerError,
+		)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonData)
+}
 
-	// Require auth cookie
-	c, err := r.Cookie("orly_auth")
-	if err != nil || c.Value == "" {
-		http.Error(w, "Not authenticated", http.StatusUnauthorized)
+// handleWebhooks reports the deliveries of the webhooks, pending and given
+// up. Admins only.
+func (s *Server) handleWebhooks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.webhooks == nil {
+		http.Error(w, "no webhooks are configured", http.StatusNotFound)
+		return
+	}
+	status, err := s.webhooks.Status()
+	if chk.E(err) {
+		http.Error(
+			w, "Error reading webhook queue", http.StatusInternalServerError,
+		)
 		return
 	}
-	requesterPubHex := c.Value
-	requesterPub, err := hex.Dec(requesterPubHex)
+	jsonData, err := json.Marshal(status)
 	if chk.E(err) {
-		http.Error(w, "Invalid auth cookie", http.StatusUnauthorized)
+		http.Error(
+			w, "Error generating response", http.StatusInternalServerError,
+		)
 		return
 	}
-	// Check permissions
-	if acl.Registry.GetAccessLevel(requesterPub, r.RemoteAddr) != "admin" {
-		http.Error(w, "Forbidden", http.StatusForbidden)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonData)
+}
+
+// handleExport streams all events as JSONL (NDJSON). Admins only.
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
@@ -461,17 +556,7 @@ func (s *Server) handleExportMine(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Require auth cookie
-	c, err := r.Cookie("orly_auth")
-	if err != nil || c.Value == "" {
-		http.Error(w, "Not authenticated", http.StatusUnauthorized)
-		return
-	}
-	pubkey, err := hex.Dec(c.Value)
-	if chk.E(err) {
-		http.Error(w, "Invalid auth cookie", http.StatusUnauthorized)
-		return
-	}
+	pubkey := authedPubkey(r)
 
 	w.Header().Set("Content-Type", "application/x-ndjson")
 	filename := "my-events-" + time.Now().UTC().Format("20060102-150405Z") + ".jsonl"
@@ -490,23 +575,6 @@ func (s *Server) handleImport(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Require auth cookie
-	c, err := r.Cookie("orly_auth")
-	if err != nil || c.Value == "" {
-		http.Error(w, "Not authenticated", http.StatusUnauthorized)
-		return
-	}
-	requesterPub, err := hex.Dec(c.Value)
-	if chk.E(err) {
-		http.Error(w, "Invalid auth cookie", http.StatusUnauthorized)
-		return
-	}
-	// Admins only
-	if acl.Registry.GetAccessLevel(requesterPub, r.RemoteAddr) != "admin" {
-		http.Error(w, "Forbidden", http.StatusForbidden)
-		return
-	}
-
 	ct := r.Header.Get("Content-Type")
 	if strings.HasPrefix(ct, "multipart/form-data") {
 		if err := r.ParseMultipartForm(32 << 20); chk.E(err) { // 32MB memory, rest to temp files
@@ -540,17 +608,7 @@ func (s *Server) handleEventsMine(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Require auth cookie
-	c, err := r.Cookie("orly_auth")
-	if err != nil || c.Value == "" {
-		http.Error(w, "Not authenticated", http.StatusUnauthorized)
-		return
-	}
-	pubkey, err := hex.Dec(c.Value)
-	if chk.E(err) {
-		http.Error(w, "Invalid auth cookie", http.StatusUnauthorized)
-		return
-	}
+	pubkey := authedPubkey(r)
 
 	// Parse pagination parameters
 	query := r.URL.Query()
@@ -0,0 +1,218 @@
+package app
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"lol.mleku.dev/chk"
+	"lol.mleku.dev/errorf"
+	"lol.mleku.dev/log"
+	"next.orly.dev/app/config"
+	"next.orly.dev/pkg/acl"
+	"next.orly.dev/pkg/crypto/p256k"
+	"next.orly.dev/pkg/database"
+	"next.orly.dev/pkg/encoders/event"
+	"next.orly.dev/pkg/metrics"
+	"next.orly.dev/pkg/replication"
+	"next.orly.dev/pkg/spider"
+)
+
+// Services are the workers that depend on the contents of the database, the
+// syncers of the ACLs, the spider and the follower of a leader, which are
+// started again with a new context when the relay restarts.
+type Services struct {
+	ctx    context.Context
+	cfg    *config.C
+	db     *database.D
+	mx     sync.Mutex
+	cancel context.CancelFunc
+	// runCtx is the context of the services since they were last started
+	runCtx context.Context
+	spider *spider.Spider
+	// follower replicates the leader, or is nil if the relay is a leader
+	follower       *replication.Follower
+	followerCancel context.CancelFunc
+	// deliver sends the events saved by the follower to the subscribers,
+	// set by Run once they exist
+	deliver atomic.Pointer[func(ev *event.E)]
+}
+
+// NewServices returns the services of the relay, to be started with Start.
+func NewServices(
+	ctx context.Context, cfg *config.C, db *database.D,
+) (s *Services) {
+	s = &Services{ctx: ctx, cfg: cfg, db: db}
+	metrics.Default.OnCollect(s.collectMetrics)
+	return
+}
+
+// Start configures the ACLs and starts their syncers and the follower of the
+// leader if one is configured, or else the follows syncer and the spider.
+func (s *Services) Start() (err error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.start()
+}
+
+func (s *Services) start() (err error) {
+	var ctx context.Context
+	ctx, s.cancel = context.WithCancel(s.ctx)
+	s.runCtx = ctx
+	if err = acl.Registry.Configure(s.cfg, s.db, ctx); chk.E(err) {
+		s.cancel()
+		return
+	}
+	// the follows syncer and the spider save the events they fetch, which
+	// a follower gets from its leader instead
+	acl.Registry.SyncerExcept("follows")
+	if err = s.startFollower(ctx); chk.E(err) {
+		s.stop()
+		return
+	}
+	if s.follower == nil {
+		s.startFetchers()
+	}
+	return
+}
+
+// startFetchers starts the follows syncer and the spider, which fetch events
+// from other relays, on a leader.
+func (s *Services) startFetchers() {
+	acl.Registry.SyncerOf("follows")
+	spiderCtx, spiderCancel := context.WithCancel(s.runCtx)
+	s.spider = spider.New(s.db, s.cfg, spiderCtx, spiderCancel)
+	s.spider.Start()
+}
+
+// startFollower starts replicating the leader if one is configured and the
+// relay was not promoted away from it.
+func (s *Services) startFollower(ctx context.Context) (err error) {
+	if s.cfg.LeaderURL == "" {
+		return
+	}
+	switch s.cfg.FollowerWrites {
+	case "forward", "reject":
+	default:
+		return errorf.E(
+			"invalid ORLY_FOLLOWER_WRITES %q, expected forward or reject",
+			s.cfg.FollowerWrites,
+		)
+	}
+	if replication.Promoted(s.db, s.cfg.LeaderURL) {
+		log.W.F(
+			"promoted to leader, not following %s", s.cfg.LeaderURL,
+		)
+		return
+	}
+	var skb []byte
+	if skb, err = s.db.GetOrCreateRelayIdentitySecret(); chk.E(err) {
+		return
+	}
+	sign := new(p256k.Signer)
+	if err = sign.InitSec(skb); chk.E(err) {
+		return
+	}
+	var followerCtx context.Context
+	followerCtx, s.followerCancel = context.WithCancel(ctx)
+	s.follower = replication.New(
+		followerCtx, s.db, s.cfg.LeaderURL, sign, s.replicated,
+	)
+	go s.follower.Run()
+	return
+}
+
+// SetDeliver sets the function that sends the events saved by the follower
+// to the subscribers.
+func (s *Services) SetDeliver(deliver func(ev *event.E)) {
+	s.deliver.Store(&deliver)
+}
+
+// replicated is called by the follower with each event it saved.
+func (s *Services) replicated(ev *event.E) {
+	if deliver := s.deliver.Load(); deliver != nil {
+		(*deliver)(ev)
+	}
+}
+
+// Follower returns the follower of the leader, or nil if the relay is a
+// leader.
+func (s *Services) Follower() (f *replication.Follower) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.follower
+}
+
+// Promote stops following the leader and records it in the database, so the
+// relay stays a leader when it restarts, and starts the follows syncer and
+// the spider.
+func (s *Services) Promote() (err error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if s.follower == nil {
+		return errorf.E("the relay is not following a leader")
+	}
+	if err = replication.Promote(s.db, s.cfg.LeaderURL); chk.E(err) {
+		return
+	}
+	s.stopFollower()
+	s.startFetchers()
+	log.I.F("promoted to leader, no longer following %s", s.cfg.LeaderURL)
+	return
+}
+
+// ReplicationStatus returns the role of the relay, and the state of the
+// replication if it is a follower.
+func (s *Services) ReplicationStatus() (st replication.Status) {
+	if f := s.Follower(); f != nil {
+		return f.Status()
+	}
+	return replication.Status{Role: "leader", Cursor: -1}
+}
+
+// collectMetrics sets the gauges of the replication when the metrics are
+// written.
+func (s *Services) collectMetrics() {
+	st := s.ReplicationStatus()
+	metrics.ReplicationLag.Set(st.LagSeconds)
+	metrics.ReplicationCursor.Set(float64(st.Cursor))
+}
+
+// stopFollower stops replicating the leader.
+func (s *Services) stopFollower() {
+	if s.followerCancel != nil {
+		s.followerCancel()
+		s.followerCancel = nil
+	}
+	s.follower = nil
+}
+
+// Stop stops the syncers of the ACLs, the spider and the follower.
+func (s *Services) Stop() {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.stop()
+}
+
+func (s *Services) stop() {
+	s.stopFollower()
+	if s.spider != nil {
+		s.spider.Stop()
+		s.spider = nil
+	}
+	if s.cancel != nil {
+		s.cancel()
+		s.cancel = nil
+	}
+}
+
+// Restart stops the services, runs the database migrations, which is needed
+// after a wipe, and starts the services again.
+func (s *Services) Restart() (err error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	log.I.F("restarting relay services")
+	s.stop()
+	s.db.RunMigrations()
+	return s.start()
+}
@@ -0,0 +1,74 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"lol.mleku.dev/chk"
+	"next.orly.dev/pkg/utils/bytecount"
+)
+
+// trackTraffic adds the counter of a client connection to the traffic of the
+// server while it is open, and returns the function that moves its counts to
+// the total of the closed connections when it closes.
+func (s *Server) trackTraffic(c *bytecount.Counter) (done func()) {
+	s.trafficMx.Lock()
+	if s.openTraffic == nil {
+		s.openTraffic = make(map[*bytecount.Counter]struct{})
+	}
+	s.openTraffic[c] = struct{}{}
+	s.trafficMx.Unlock()
+	return func() {
+		s.trafficMx.Lock()
+		delete(s.openTraffic, c)
+		s.traffic.Add(c)
+		s.trafficMx.Unlock()
+	}
+}
+
+// Traffic returns the bytes sent and received by all client connections,
+// open and closed, before compression and on the wire.
+func (s *Server) Traffic() (t *bytecount.Counter) {
+	t = new(bytecount.Counter)
+	s.trafficMx.Lock()
+	defer s.trafficMx.Unlock()
+	t.Add(&s.traffic)
+	for c := range s.openTraffic {
+		t.Add(c)
+	}
+	return
+}
+
+// trafficDirection is the JSON form of the counts in one direction.
+type trafficDirection struct {
+	Raw   uint64  `json:"raw"`
+	Wire  uint64  `json:"wire"`
+	Saved float64 `json:"saved"`
+}
+
+// handleTraffic reports the websocket traffic of the clients and how much
+// compression saved. Admins only.
+func (s *Server) handleTraffic(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	t := s.Traffic()
+	in, out := t.Saved()
+	response := struct {
+		In  trafficDirection `json:"in"`
+		Out trafficDirection `json:"out"`
+	}{
+		In:  trafficDirection{t.RawIn.Load(), t.WireIn.Load(), in},
+		Out: trafficDirection{t.RawOut.Load(), t.WireOut.Load(), out},
+	}
+	jsonData, err := json.Marshal(response)
+	if chk.E(err) {
+		http.Error(
+			w, "Error generating response", http.StatusInternalServerError,
+		)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonData)
+}
@@ -0,0 +1,97 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"lol.mleku.dev/chk"
+	"lol.mleku.dev/log"
+	"next.orly.dev/pkg/crypto/p256k"
+	"next.orly.dev/pkg/database"
+	"next.orly.dev/pkg/encoders/hex"
+)
+
+// Restart restarts the relay in the process after the database was changed
+// under it: the services are stopped, the migrations run and the services
+// started again, the kind policy is reloaded since its marker may have been
+// wiped, and the webhooks sign with the relay identity key again, which is
+// new if it was wiped.
+func (s *Server) Restart() (err error) {
+	if s.services != nil {
+		if err = s.services.Restart(); chk.E(err) {
+			return
+		}
+	}
+	if s.webhooks != nil {
+		var sign *p256k.Signer
+		if sign, err = identitySigner(s.D); err != nil {
+			return
+		}
+		s.webhooks.SetSigner(sign)
+	}
+	s.kindPolicy.Set(nil)
+	if err = s.loadKindPolicy(); chk.E(err) {
+		return
+	}
+	log.I.F("relay restarted")
+	return
+}
+
+// handleWipe deletes the events, indexes and markers of the database, and
+// the relay identity and subscriptions unless the JSON body asks to keep
+// them, and then restarts the relay. The vanish tombstones, the kind policy
+// and the replication state are kept unless the body asks to wipe the
+// markers as well. Owners only.
+func (s *Server) handleWipe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var o database.WipeOptions
+	body, err := io.ReadAll(io.LimitReader(r.Body, 4096))
+	if chk.E(err) {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err = json.Unmarshal(body, &o); err != nil {
+			http.Error(w, "Invalid wipe options", http.StatusBadRequest)
+			return
+		}
+	}
+	log.W.F(
+		"database wipe requested by owner %s", hex.Enc(authedPubkey(r)),
+	)
+	if err = s.WipeWith(o); chk.E(err) {
+		http.Error(w, "Wipe failed", http.StatusInternalServerError)
+		return
+	}
+	if err = s.Restart(); chk.E(err) {
+		http.Error(
+			w, "Wiped, but restart failed: "+err.Error(),
+			http.StatusInternalServerError,
+		)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"success": true, "message": "Database wiped"}`))
+}
+
+// handleRestart restarts the relay in the process. Owners only.
+func (s *Server) handleRestart(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	log.I.F("restart requested by owner %s", hex.Enc(authedPubkey(r)))
+	if err := s.Restart(); chk.E(err) {
+		http.Error(
+			w, "Restart failed: "+err.Error(), http.StatusInternalServerError,
+		)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"success": true, "message": "Relay restarted"}`))
+}
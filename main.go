@@ -20,7 +20,7 @@ import (
 	"next.orly.dev/pkg/crypto/keys"
 	"next.orly.dev/pkg/database"
 	"next.orly.dev/pkg/encoders/hex"
-	"next.orly.dev/pkg/spider"
+	"next.orly.dev/pkg/metrics"
 	"next.orly.dev/pkg/version"
 )
 
@@ -78,6 +78,12 @@ func main() {
  	os.Exit(0)
  }
 
+	// Handle the admin subcommands, which open the database directly while
+	// the relay is stopped
+	if cmd, args := config.AdminRequested(); cmd != "" {
+		os.Exit(runAdmin(cfg, cmd, args))
+	}
+
  // If OpenPprofWeb is true and profiling is enabled, we need to ensure HTTP profiling is also enabled
 	if cfg.OpenPprofWeb && cfg.Pprof != "" && !cfg.PprofHTTP {
 		log.I.F("enabling HTTP pprof server to support web viewer")
@@ -169,6 +175,15 @@ func main() {
 		}
 
 	}
+	// with the none ACL everyone has write access, so there are no local
+	// users to restrict gift wraps to
+	if cfg.DMInbox && cfg.DMInboxLocalOnly && cfg.ACLMode == "none" {
+		log.E.F(
+			"ORLY_DM_INBOX_LOCAL_ONLY needs an ACL that decides who the " +
+				"users of the relay are, but ORLY_ACL_MODE is none",
+		)
+		os.Exit(1)
+	}
 	ctx, cancel := context.WithCancel(context.Background())
 	var db *database.D
 	if db, err = database.New(
@@ -176,17 +191,16 @@ func main() {
 	); chk.E(err) {
 		os.Exit(1)
 	}
+	go db.RunExpiration(cfg.ExpirationInterval)
+	go db.RunTombstonePruning(cfg.TombstoneRetention)
 	acl.Registry.Active.Store(cfg.ACLMode)
-	if err = acl.Registry.Configure(cfg, db, ctx); chk.E(err) {
+	// Configure the ACLs and start their syncers and the spider, which are
+	// started again when the relay restarts
+	services := app.NewServices(ctx, cfg, db)
+	if err = services.Start(); chk.E(err) {
 		os.Exit(1)
 	}
-	acl.Registry.Syncer()
-
-	// Initialize and start spider functionality if enabled
-	spiderCtx, spiderCancel := context.WithCancel(ctx)
-	spiderInstance := spider.New(db, cfg, spiderCtx, spiderCancel)
-	spiderInstance.Start()
-	defer spiderInstance.Stop()
+	defer services.Stop()
 
 	// Start HTTP pprof server if enabled
 	if cfg.PprofHTTP {
@@ -240,6 +254,10 @@ func main() {
 				log.I.F("health check ok")
 			},
 		)
+		// Prometheus metrics of the relay, database and publisher
+		mux.Handle("/metrics", metrics.Default.Handler())
+		// Role of the relay and lag of a follower behind its leader
+		mux.HandleFunc("/replication", services.HandleReplication)
 		// Optional shutdown endpoint to gracefully stop the process so profiling defers run
 		if cfg.EnableShutdown {
 			mux.HandleFunc(
@@ -275,7 +293,7 @@ func main() {
 		}()
 	}
 
-	quit := app.Run(ctx, cfg, db)
+	quit := app.Run(ctx, cfg, db, services)
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, os.Interrupt)
 	for {
@@ -1,15 +1,32 @@
 package acl
 
 import (
+	"strings"
+
+	"lol.mleku.dev/errorf"
+	"next.orly.dev/pkg/encoders/event"
 	"next.orly.dev/pkg/interfaces/acl"
 	"next.orly.dev/pkg/utils/atomic"
 )
 
 var Registry = &S{}
 
+// S is the registry of the ACLs. Active is the ACL mode, which names one ACL
+// or an ordered chain of ACLs separated by commas, such as
+// "blocklist,whitelist,paid,follows".
 type S struct {
 	ACL    []acl.I
 	Active atomic.String
+	// the *chain last parsed from Active
+	chain atomic.Value
+}
+
+// chain is the parsed form of an ACL mode.
+type chain struct {
+	mode string
+	acls []acl.I
+	// unknown lists the names in the mode that are not registered
+	unknown []string
 }
 
 type A struct{ S }
@@ -18,63 +35,224 @@ func (s *S) Register(i acl.I) {
 	(*s).ACL = append((*s).ACL, i)
 }
 
+// Chain returns the ACLs named by the active mode, in order.
+func (s *S) Chain() []acl.I { return s.parse().acls }
+
+func (s *S) parse() (c *chain) {
+	mode := s.Active.Load()
+	if c, _ = s.chain.Load().(*chain); c != nil && c.mode == mode {
+		return
+	}
+	c = &chain{mode: mode}
+	for _, name := range strings.Split(mode, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		var found bool
+		for _, i := range s.ACL {
+			if i.Type() == name {
+				c.acls = append(c.acls, i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			c.unknown = append(c.unknown, name)
+		}
+	}
+	s.chain.Store(c)
+	return
+}
+
+// Get returns the ACL of a type if it is part of the active chain, or nil.
+func (s *S) Get(typ string) acl.I {
+	for _, i := range s.Chain() {
+		if i.Type() == typ {
+			return i
+		}
+	}
+	return nil
+}
+
+// Has returns whether an ACL of a type is part of the active chain.
+func (s *S) Has(typ string) bool { return s.Get(typ) != nil }
+
 func (s *S) Configure(cfg ...any) (err error) {
-	for _, i := range s.ACL {
-		if i.Type() == s.Active.Load() {
-			err = i.Configure(cfg...)
+	c := s.parse()
+	if len(c.unknown) > 0 {
+		return errorf.E("unknown ACL %s", strings.Join(c.unknown, ","))
+	}
+	for _, i := range c.acls {
+		if err = i.Configure(cfg...); err != nil {
 			return
 		}
 	}
-	return err
+	return
 }
 
-func (s *S) GetAccessLevel(pub []byte, address string) (level string) {
-	for _, i := range s.ACL {
-		if i.Type() == s.Active.Load() {
-			level = i.GetAccessLevel(pub, address)
-			break
+// Reload configures again the ACLs of the chain that are configured from an
+// event that an admin stored, leaving the others as they are.
+func (s *S) Reload(ev *event.E) (err error) {
+	for _, i := range s.Chain() {
+		if r, ok := i.(acl.Reloader); ok && r.ReloadsOn(ev) {
+			if err = i.Configure(); err != nil {
+				return
+			}
 		}
 	}
 	return
 }
 
-func (s *S) GetACLInfo() (name, description, documentation string) {
-	for _, i := range s.ACL {
-		if i.Type() == s.Active.Load() {
-			name, description, documentation = i.GetACLInfo()
-			break
+// decide returns the verdict of an ACL in a chain.
+func decide(i acl.I, pub []byte, address string) (
+	verdict acl.Verdict, level string,
+) {
+	if d, ok := i.(acl.Decider); ok {
+		return d.Decide(pub, address)
+	}
+	level = i.GetAccessLevel(pub, address)
+	if acl.Rank(level) > acl.Rank(acl.Read) {
+		return acl.Allow, level
+	}
+	return acl.Abstain, level
+}
+
+// GetAccessLevel asks the ACLs of the chain in order, and returns the level
+// of the first that allows or denies the pubkey and address. If all of them
+// abstain, it returns the lowest of the levels they would give on their own.
+func (s *S) GetAccessLevel(pub []byte, address string) (level string) {
+	var fallback string
+	for n, i := range s.Chain() {
+		verdict, l := decide(i, pub, address)
+		if verdict != acl.Abstain {
+			return l
+		}
+		if n == 0 || acl.Rank(l) < acl.Rank(fallback) {
+			fallback = l
 		}
 	}
+	return fallback
+}
+
+func (s *S) GetACLInfo() (name, description, documentation string) {
+	var descriptions, docs []string
+	for _, i := range s.Chain() {
+		n, d, doc := i.GetACLInfo()
+		descriptions = append(descriptions, d)
+		docs = append(docs, n+": "+doc)
+	}
+	name = s.Type()
+	description = strings.Join(descriptions, ", then ")
+	documentation = strings.Join(docs, "\n\n")
+	if len(docs) > 1 {
+		documentation = "The ACLs are asked in order, and the first that " +
+			"allows or denies a pubkey decides its access level. If all of " +
+			"them abstain, the most restrictive of their levels applies.\n\n" +
+			documentation
+	}
 	return
 }
 
 func (s *S) Syncer() {
-	for _, i := range s.ACL {
-		if i.Type() == s.Active.Load() {
+	for _, i := range s.Chain() {
+		i.Syncer()
+	}
+}
+
+// SyncerOf starts the syncer of the ACL of a type, if it is in the chain.
+func (s *S) SyncerOf(typ string) {
+	if i := s.Get(typ); i != nil {
+		i.Syncer()
+	}
+}
+
+// SyncerExcept starts the syncers of the ACLs of the chain other than the
+// one of a type.
+func (s *S) SyncerExcept(typ string) {
+	for _, i := range s.Chain() {
+		if i.Type() != typ {
 			i.Syncer()
-			break
 		}
 	}
 }
 
+// Type returns the types of the ACLs of the chain, separated by commas.
 func (s *S) Type() (typ string) {
-	for _, i := range s.ACL {
-		if i.Type() == s.Active.Load() {
-			typ = i.Type()
-			break
-		}
+	var types []string
+	for _, i := range s.Chain() {
+		types = append(types, i.Type())
 	}
-	return
+	return strings.Join(types, ",")
 }
 
-// AddFollow forwards a pubkey to the active ACL if it supports dynamic follows
+// AddFollow forwards a pubkey to the follows ACL of the chain, which supports
+// dynamic follows.
 func (s *S) AddFollow(pub []byte) {
-	for _, i := range s.ACL {
-		if i.Type() == s.Active.Load() {
-			if f, ok := i.(*Follows); ok {
-				f.AddFollow(pub)
-			}
-			break
-		}
+	if f, ok := s.Get("follows").(*Follows); ok {
+		f.AddFollow(pub)
+	}
+}
+
+// StartTrial forwards a newly authenticated pubkey to the paid ACL of the
+// chain, to start its trial.
+func (s *S) StartTrial(pub []byte) {
+	if p, ok := s.Get("paid").(*Paid); ok {
+		p.StartTrial(pub)
+	}
+}
+
+// ApplyFollowList forwards a newly stored follow list to the web of trust
+// ACL of the chain.
+func (s *S) ApplyFollowList(ev *event.E) {
+	if w, ok := s.Get("wot").(*Wot); ok {
+		w.Update(ev)
+	}
+}
+
+// WotStats returns the statistics of the follow graph of the web of trust
+// ACL of the chain.
+func (s *S) WotStats() (stats WotStats, ok bool) {
+	var w *Wot
+	if w, ok = s.Get("wot").(*Wot); ok {
+		stats = w.Stats()
+	}
+	return
+}
+
+// CheckGroupEvent returns the reason an event may not be written according to
+// the groups ACL of the chain, which hosts NIP-29 groups, or an empty string
+// if it may.
+func (s *S) CheckGroupEvent(ev *event.E) (reason string) {
+	if g, ok := s.Get("groups").(*Groups); ok {
+		reason = g.CheckEvent(ev)
+	}
+	return
+}
+
+// CanReadGroupEvent returns whether a pubkey may read an event according to
+// the groups ACL of the chain, which hosts NIP-29 groups.
+func (s *S) CanReadGroupEvent(ev *event.E, pub []byte) bool {
+	if g, ok := s.Get("groups").(*Groups); ok {
+		return g.CanRead(ev, pub)
 	}
+	return true
+}
+
+// HiddenGroups returns the ids of the private NIP-29 groups of the groups ACL
+// of the chain whose events a pubkey may not read.
+func (s *S) HiddenGroups(pub []byte) (ids [][]byte) {
+	if g, ok := s.Get("groups").(*Groups); ok {
+		ids = g.HiddenFrom(pub)
+	}
+	return
+}
+
+// ApplyGroupEvent forwards a stored event to the groups ACL of the chain,
+// which hosts NIP-29 groups, and returns the group state events it
+// published.
+func (s *S) ApplyGroupEvent(ev *event.E) (published event.S) {
+	if g, ok := s.Get("groups").(*Groups); ok {
+		published = g.ApplyEvent(ev)
+	}
+	return
 }
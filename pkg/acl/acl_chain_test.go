@@ -0,0 +1,90 @@
+package acl
+
+import (
+	"strings"
+	"testing"
+
+	"next.orly.dev/app/config"
+	"next.orly.dev/pkg/crypto/p256k"
+	"next.orly.dev/pkg/database"
+	"next.orly.dev/pkg/encoders/hex"
+)
+
+func TestChain(t *testing.T) {
+	db, ctx, cleanup := newTestDB(t)
+	defer cleanup()
+	admin, alice, bob, carol, dave, stranger := newSigner(t), newSigner(t),
+		newSigner(t), newSigner(t), newSigner(t), newSigner(t)
+	prev := Registry.Active.Load()
+	defer Registry.Active.Store(prev)
+	Registry.Active.Store("bogus,whitelist")
+	if err := Registry.Configure(&config.C{}, db, ctx); err == nil ||
+		!strings.Contains(err.Error(), "bogus") {
+		t.Fatalf("unknown ACL in the chain was not refused: %v", err)
+	}
+	// carol is a member of the whitelist, but blocked; bob pays
+	cfg := &config.C{
+		Admins: []string{hex.Enc(admin.Pub())},
+		WhitelistFile: writeWhitelistFile(
+			t, hex.Enc(alice.Pub()), hex.Enc(carol.Pub()),
+		),
+		BlocklistFile: writeWhitelistFile(t, hex.Enc(carol.Pub())),
+	}
+	if err := db.ExtendSubscription(bob.Pub(), 30); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddManaged(
+		database.BannedPubkeys, hex.Enc(dave.Pub()), "spam",
+	); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddManaged(database.BlockedIPs, "10.0.0.1", ""); err != nil {
+		t.Fatal(err)
+	}
+	Registry.Active.Store("blocklist, whitelist,paid")
+	if err := Registry.Configure(cfg, db, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := Registry.Type(); got != "blocklist,whitelist,paid" {
+		t.Errorf("chain has type %q", got)
+	}
+	if !Registry.Has("paid") || Registry.Has("follows") {
+		t.Error("Has does not match the chain")
+	}
+	levels := func(address string, want map[*p256k.Signer]string) {
+		t.Helper()
+		for sign, level := range want {
+			got := Registry.GetAccessLevel(sign.Pub(), address)
+			if got != level {
+				t.Errorf("%0x has %s access, want %s", sign.Pub(), got, level)
+			}
+		}
+	}
+	levels(
+		"127.0.0.1:1234", map[*p256k.Signer]string{
+			admin: "admin", alice: "write", bob: "write", carol: "none",
+			dave: "none", stranger: "read",
+		},
+	)
+	levels("10.0.0.1:1234", map[*p256k.Signer]string{alice: "none"})
+	// the most restrictive level applies when every ACL abstains
+	cfg.WhitelistPrivate = true
+	levels(
+		"127.0.0.1:1234",
+		map[*p256k.Signer]string{stranger: "none", bob: "write"},
+	)
+	name, description, documentation := Registry.GetACLInfo()
+	if name != "blocklist,whitelist,paid" ||
+		strings.Count(description, ", then ") != 2 ||
+		!strings.Contains(documentation, "paid: ") {
+		t.Errorf(
+			"chain is described as %q, %q, %q", name, description,
+			documentation,
+		)
+	}
+	// a single ACL behaves as on its own
+	Registry.Active.Store("none")
+	if got := Registry.GetAccessLevel(nil, ""); got != "write" {
+		t.Errorf("none ACL gives %s access", got)
+	}
+}
@@ -0,0 +1,69 @@
+package acl
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"lol.mleku.dev/chk"
+	"next.orly.dev/pkg/crypto/p256k"
+	"next.orly.dev/pkg/database"
+	"next.orly.dev/pkg/encoders/event"
+	"next.orly.dev/pkg/encoders/tag"
+	"next.orly.dev/pkg/encoders/timestamp"
+)
+
+// newTestDB opens a database in a temporary directory that is removed by
+// the returned cleanup function.
+func newTestDB(t *testing.T) (
+	db *database.D, ctx context.Context, cleanup func(),
+) {
+	t.Helper()
+	tempDir, err := os.MkdirTemp("", "acl-db-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if db, err = database.New(ctx, cancel, tempDir, "error"); err != nil {
+		cancel()
+		os.RemoveAll(tempDir)
+		t.Fatalf("Failed to init DB: %v", err)
+	}
+	return db, ctx, func() {
+		cancel()
+		db.Close()
+		os.RemoveAll(tempDir)
+	}
+}
+
+// newSigner generates a new key.
+func newSigner(t *testing.T) (sign *p256k.Signer) {
+	t.Helper()
+	sign = new(p256k.Signer)
+	if err := sign.Generate(); chk.E(err) {
+		t.Fatal(err)
+	}
+	return
+}
+
+// clock gives each test event a later created_at than the one before, so the
+// order of replayed events is well defined.
+var clock = timestamp.Now().V - 100000
+
+// newEvent creates and signs an event of a kind with the given tags.
+func newEvent(
+	t *testing.T, sign *p256k.Signer, k uint16, tags ...*tag.T,
+) (ev *event.E) {
+	t.Helper()
+	ev = event.New()
+	ev.Kind = k
+	ev.Pubkey = sign.Pub()
+	clock++
+	ev.CreatedAt = clock
+	ev.Content = []byte("acl test")
+	ev.Tags = tag.NewS(tags...)
+	if err := ev.Sign(sign); chk.E(err) {
+		t.Fatal(err)
+	}
+	return
+}
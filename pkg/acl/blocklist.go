@@ -0,0 +1,154 @@
+package acl
+
+import (
+	"context"
+	"net"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"lol.mleku.dev/chk"
+	"lol.mleku.dev/errorf"
+	"lol.mleku.dev/log"
+	"next.orly.dev/app/config"
+	"next.orly.dev/pkg/database"
+	"next.orly.dev/pkg/encoders/hex"
+	"next.orly.dev/pkg/interfaces/acl"
+)
+
+// Blocklist is an ACL that denies the pubkeys listed in a file or banned with
+// the NIP-86 management API, and the IP addresses blocked with it, and
+// abstains for everyone else. It is meant to be the first ACL of a chain.
+type Blocklist struct {
+	Ctx context.Context
+	cfg *config.C
+	*database.D
+	blockedMx sync.RWMutex
+	blocked   map[string]struct{}
+	// modification time of the blocklist file when it was last loaded
+	loaded time.Time
+}
+
+func (b *Blocklist) Configure(cfg ...any) (err error) {
+	log.I.F("configuring blocklist ACL")
+	for _, ca := range cfg {
+		switch c := ca.(type) {
+		case *config.C:
+			b.cfg = c
+		case *database.D:
+			b.D = c
+		case context.Context:
+			b.Ctx = c
+		default:
+			err = errorf.E("invalid type: %T", reflect.TypeOf(ca))
+		}
+	}
+	if b.cfg == nil || b.D == nil {
+		err = errorf.E("both config and database must be set")
+		return
+	}
+	if b.Ctx == nil {
+		b.Ctx = context.Background()
+	}
+	blocked := make(map[string]struct{})
+	var loaded time.Time
+	if b.cfg.BlocklistFile != "" {
+		if loaded, err = loadPubkeyFile(
+			b.cfg.BlocklistFile, blocked,
+		); chk.E(err) {
+			return
+		}
+	}
+	b.blockedMx.Lock()
+	b.blocked, b.loaded = blocked, loaded
+	b.blockedMx.Unlock()
+	log.I.F("blocklist ACL loaded %d pubkeys", len(blocked))
+	return
+}
+
+// Blocked returns whether a pubkey or the IP of an address is blocked.
+func (b *Blocklist) Blocked(pub []byte, address string) bool {
+	if len(pub) > 0 {
+		pk := hex.Enc(pub)
+		b.blockedMx.RLock()
+		_, ok := b.blocked[pk]
+		b.blockedMx.RUnlock()
+		if ok {
+			return true
+		}
+		if b.D != nil && b.IsManaged(database.BannedPubkeys, pk) {
+			return true
+		}
+	}
+	if address != "" && b.D != nil {
+		ip := address
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			ip = host
+		}
+		return b.IsManaged(database.BlockedIPs, ip)
+	}
+	return false
+}
+
+// Decide denies blocked pubkeys and addresses, and abstains with write access
+// for everyone else.
+func (b *Blocklist) Decide(pub []byte, address string) (
+	verdict acl.Verdict, level string,
+) {
+	if b.Blocked(pub, address) {
+		return acl.Deny, "none"
+	}
+	return acl.Abstain, "write"
+}
+
+func (b *Blocklist) GetAccessLevel(pub []byte, address string) (level string) {
+	_, level = b.Decide(pub, address)
+	return
+}
+
+func (b *Blocklist) GetACLInfo() (name, description, documentation string) {
+	return "blocklist", "blocklist of pubkeys and addresses",
+		`This ACL mode denies all access to the pubkeys listed in the blocklist file or banned with the NIP-86 management API, and to the IP addresses blocked with it. It gives everyone else write access on its own, and leaves the decision to the next ACL in a chain.`
+}
+
+func (b *Blocklist) Type() string { return "blocklist" }
+
+// Syncer reloads the blocklist when the blocklist file is modified.
+func (b *Blocklist) Syncer() {
+	if b.cfg == nil || b.cfg.BlocklistFile == "" {
+		return
+	}
+	log.I.F("watching blocklist file %s", b.cfg.BlocklistFile)
+	ctx := b.Ctx
+	go func() {
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			fi, err := os.Stat(b.cfg.BlocklistFile)
+			if err != nil {
+				continue
+			}
+			b.blockedMx.RLock()
+			changed := !fi.ModTime().Equal(b.loaded)
+			b.blockedMx.RUnlock()
+			if !changed {
+				continue
+			}
+			log.I.F("blocklist file %s changed, reloading", b.cfg.BlocklistFile)
+			if err = b.Configure(); chk.E(err) {
+				log.E.F("failed to reload blocklist: %v", err)
+			}
+		}
+	}()
+}
+
+func init() {
+	log.T.F("registering blocklist ACL")
+	Registry.Register(new(Blocklist))
+}
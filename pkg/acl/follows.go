@@ -27,8 +27,11 @@ import (
 	"next.orly.dev/pkg/encoders/kind"
 	"next.orly.dev/pkg/encoders/tag"
 	"next.orly.dev/pkg/encoders/timestamp"
+	"next.orly.dev/pkg/metrics"
 	"next.orly.dev/pkg/protocol/publish"
+	"next.orly.dev/pkg/protocol/ws"
 	"next.orly.dev/pkg/utils"
+	"next.orly.dev/pkg/utils/bytecount"
 	"next.orly.dev/pkg/utils/normalize"
 	"next.orly.dev/pkg/utils/values"
 )
@@ -45,6 +48,16 @@ type Follows struct {
 	subsCancel context.CancelFunc
 }
 
+// ReloadsOn returns whether an event of an admin changes the follows, which
+// is a follow list or relay list, or a deletion that may have removed one.
+func (f *Follows) ReloadsOn(ev *event.E) bool {
+	switch ev.Kind {
+	case kind.FollowList.K, kind.RelayListMetadata.K, kind.EventDeletion.K:
+		return true
+	}
+	return false
+}
+
 func (f *Follows) Configure(cfg ...any) (err error) {
 	log.I.F("configuring follows ACL")
 	for _, ca := range cfg {
@@ -120,10 +133,15 @@ func (f *Follows) Configure(cfg ...any) (err error) {
 			}
 		}
 	}
+	// the channel is buffered so configuring again, as a restart of the relay
+	// does while no syncer is running, queues the update instead of blocking
 	if f.updated == nil {
-		f.updated = make(chan struct{})
+		f.updated = make(chan struct{}, 1)
 	} else {
-		f.updated <- struct{}{}
+		select {
+		case f.updated <- struct{}{}:
+		default:
+		}
 	}
 	return
 }
@@ -263,15 +281,23 @@ func (f *Follows) startSubscriptions(ctx context.Context) {
 				headers.Set("User-Agent", "ORLY-Relay/0.9.2")
 				headers.Set("Origin", "https://orly.dev")
 
-				// Use proper WebSocket dial options
-				dialOptions := &websocket.DialOptions{
-					HTTPHeader: headers,
+				// Use proper WebSocket dial options, counting the traffic
+				compression, err := ws.NewCompression(
+					f.cfg.WSCompression, f.cfg.WSCompressionMin,
+				)
+				if chk.E(err) {
+					compression = ws.Compression{}
 				}
+				traffic := new(bytecount.Counter)
+				dialOptions := ws.NewDialOptions(
+					headers, nil, compression, traffic,
+				)
 
 				c, _, err := websocket.Dial(connCtx, u, dialOptions)
 				cancel()
 				if err != nil {
 					log.W.F("follows syncer: dial %s failed: %v", u, err)
+					metrics.RelayErrors.Inc("follows", u)
 
 					// Handle different types of errors
 					if strings.Contains(err.Error(), "response status code 101 but got 403") {
@@ -307,6 +333,7 @@ func (f *Follows) startSubscriptions(ctx context.Context) {
 				}
 				backoff = time.Second
 				log.I.F("follows syncer: successfully connected to %s", u)
+				metrics.RelayConnected.Set(1, "follows", u)
 
 				// send REQ for kind 3 (follow lists), kind 10002 (relay lists), and all events from follows
 				ff := &filter.S{}
@@ -329,11 +356,15 @@ func (f *Follows) startSubscriptions(ctx context.Context) {
 				}
 				*ff = append(*ff, f1, f2, f3)
 				req := reqenvelope.NewFrom([]byte("follows-sync"), ff)
+				reqData := req.Marshal(nil)
+				traffic.RawOut.Add(uint64(len(reqData)))
 				if err = c.Write(
-					ctx, websocket.MessageText, req.Marshal(nil),
+					ctx, websocket.MessageText, reqData,
 				); chk.E(err) {
 					log.W.F("follows syncer: failed to send REQ to %s: %v", u, err)
 					_ = c.Close(websocket.StatusInternalError, "write failed")
+					metrics.RelayConnected.Set(0, "follows", u)
+					metrics.RelayErrors.Inc("follows", u)
 					continue
 				}
 				log.I.F("follows syncer: sent REQ to %s for kind 3, 10002, and all events (last 30 days) from followed users", u)
@@ -342,6 +373,7 @@ func (f *Follows) startSubscriptions(ctx context.Context) {
 					select {
 					case <-ctx.Done():
 						_ = c.Close(websocket.StatusNormalClosure, "ctx done")
+						metrics.RelayConnected.Set(0, "follows", u)
 						return
 					default:
 					}
@@ -350,6 +382,7 @@ func (f *Follows) startSubscriptions(ctx context.Context) {
 						_ = c.Close(websocket.StatusNormalClosure, "read err")
 						break
 					}
+					traffic.RawIn.Add(uint64(len(data)))
 					label, rem, err := envelopes.Identify(data)
 					if chk.E(err) {
 						continue
@@ -395,6 +428,7 @@ func (f *Follows) startSubscriptions(ctx context.Context) {
 							// ignore duplicates and continue
 						} else {
 							// Only dispatch if the event was newly saved (no error)
+							metrics.RelayEvents.Inc("follows", u)
 							if f.pubs != nil {
 								go f.pubs.Deliver(res.Event)
 							}
@@ -409,6 +443,8 @@ func (f *Follows) startSubscriptions(ctx context.Context) {
 						// ignore other labels
 					}
 				}
+				metrics.RelayConnected.Set(0, "follows", u)
+				log.D.F("follows syncer: traffic with %s: %s", u, traffic)
 				// loop reconnect
 			}
 		}()
@@ -417,15 +453,15 @@ func (f *Follows) startSubscriptions(ctx context.Context) {
 
 func (f *Follows) Syncer() {
 	log.I.F("starting follows syncer")
+	// the context is taken now, so the syncer of a previous configuration
+	// stops when its context is canceled, along with its subscriptions
+	syncCtx := f.Ctx
 	go func() {
 		// start immediately if Configure already ran
 		for {
 			var innerCancel context.CancelFunc
 			select {
-			case <-f.Ctx.Done():
-				if f.subsCancel != nil {
-					f.subsCancel()
-				}
+			case <-syncCtx.Done():
 				return
 			case <-f.updated:
 				// close and reopen subscriptions to users on the follow list and admins
@@ -433,7 +469,7 @@ func (f *Follows) Syncer() {
 					log.I.F("follows syncer: cancelling existing subscriptions")
 					f.subsCancel()
 				}
-				ctx, cancel := context.WithCancel(f.Ctx)
+				ctx, cancel := context.WithCancel(syncCtx)
 				f.subsCancel = cancel
 				innerCancel = cancel
 				log.I.F("follows syncer: (re)opening subscriptions")
@@ -445,7 +481,10 @@ func (f *Follows) Syncer() {
 			}
 		}
 	}()
-	f.updated <- struct{}{}
+	select {
+	case f.updated <- struct{}{}:
+	default:
+	}
 }
 
 // GetFollowedPubkeys returns a copy of the followed pubkeys list
@@ -0,0 +1,539 @@
+package acl
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"lol.mleku.dev/chk"
+	"lol.mleku.dev/errorf"
+	"lol.mleku.dev/log"
+	"next.orly.dev/app/config"
+	"next.orly.dev/pkg/crypto/p256k"
+	"next.orly.dev/pkg/database"
+	"next.orly.dev/pkg/encoders/bech32encoding"
+	"next.orly.dev/pkg/encoders/event"
+	"next.orly.dev/pkg/encoders/filter"
+	"next.orly.dev/pkg/encoders/hex"
+	"next.orly.dev/pkg/encoders/kind"
+	"next.orly.dev/pkg/encoders/tag"
+	"next.orly.dev/pkg/interfaces/acl"
+	"next.orly.dev/pkg/interfaces/signer"
+	"next.orly.dev/pkg/utils"
+)
+
+// Group is the state of a NIP-29 relay based group, built from the
+// moderation events sent to the relay.
+type Group struct {
+	ID      string
+	Name    string
+	About   string
+	Picture string
+	// Private groups can only be read by members.
+	Private bool
+	// Closed groups ignore join requests, members are only added by admins.
+	Closed bool
+	// Admins maps the hex pubkeys of the group admins to their roles.
+	Admins map[string][]string
+	// Members is the set of hex pubkeys of the group members.
+	Members map[string]struct{}
+}
+
+func (g *Group) isAdmin(pub []byte) (ok bool) {
+	_, ok = g.Admins[hex.Enc(pub)]
+	return
+}
+
+func (g *Group) isMember(pub []byte) (ok bool) {
+	_, ok = g.Members[hex.Enc(pub)]
+	return ok || g.isAdmin(pub)
+}
+
+// Groups is an ACL that hosts NIP-29 relay based groups. Authenticated users
+// get write access, but every event must belong to a group with an h tag, and
+// only members may write to a group, or read it if it is private. The state
+// of the groups is published as 39000-39003 events signed by the relay
+// identity key.
+type Groups struct {
+	Ctx context.Context
+	cfg *config.C
+	*database.D
+	sign     signer.I
+	groupsMx sync.RWMutex
+	admins   [][]byte
+	groups   map[string]*Group
+}
+
+func (g *Groups) Configure(cfg ...any) (err error) {
+	log.I.F("configuring groups ACL")
+	for _, ca := range cfg {
+		switch c := ca.(type) {
+		case *config.C:
+			g.cfg = c
+		case *database.D:
+			g.D = c
+		case context.Context:
+			g.Ctx = c
+		default:
+			err = errorf.E("invalid type: %T", reflect.TypeOf(ca))
+		}
+	}
+	if g.cfg == nil || g.D == nil {
+		err = errorf.E("both config and database must be set")
+		return
+	}
+	if g.Ctx == nil {
+		g.Ctx = context.Background()
+	}
+	// the relay identity is loaded each time, as it is new after a wipe
+	var skb []byte
+	if skb, err = g.D.GetOrCreateRelayIdentitySecret(); chk.E(err) {
+		return
+	}
+	sign := new(p256k.Signer)
+	if err = sign.InitSec(skb); chk.E(err) {
+		return
+	}
+	g.groupsMx.Lock()
+	defer g.groupsMx.Unlock()
+	g.sign = sign
+	g.admins = nil
+	var keys []string
+	keys = append(keys, g.cfg.Owners...)
+	keys = append(keys, g.cfg.Admins...)
+	for _, a := range keys {
+		var adm []byte
+		if adm, err = bech32encoding.NpubOrHexToPublicKeyBinary(a); chk.E(err) {
+			err = nil
+			continue
+		}
+		g.admins = append(g.admins, adm)
+	}
+	// rebuild the group state by replaying the stored moderation events
+	g.groups = make(map[string]*Group)
+	var evs event.S
+	if evs, err = g.D.QueryEvents(
+		g.Ctx, &filter.F{
+			Kinds: kind.NewS(
+				kind.GroupPutUser, kind.GroupRemoveUser,
+				kind.GroupEditMetadata, kind.GroupCreate, kind.GroupDelete,
+				kind.GroupJoinRequest, kind.GroupLeaveRequest,
+			),
+		},
+	); chk.E(err) {
+		return
+	}
+	sort.Slice(
+		evs, func(i, j int) bool { return evs[i].CreatedAt < evs[j].CreatedAt },
+	)
+	for _, ev := range evs {
+		if _, _, reason := g.apply(ev); reason != "" {
+			log.D.F("groups: not replaying event %0x: %s", ev.ID, reason)
+		}
+		ev.Free()
+	}
+	log.I.F("groups ACL loaded %d groups", len(g.groups))
+	return
+}
+
+func (g *Groups) GetAccessLevel(pub []byte, address string) (level string) {
+	g.groupsMx.RLock()
+	defer g.groupsMx.RUnlock()
+	if g.isRelayAdmin(pub) {
+		return "admin"
+	}
+	if len(pub) == 0 {
+		return "read"
+	}
+	return "write"
+}
+
+// Decide allows the relay admins and abstains for everyone else, as group
+// membership is checked for each event rather than by the access level.
+func (g *Groups) Decide(pub []byte, address string) (
+	verdict acl.Verdict, level string,
+) {
+	if level = g.GetAccessLevel(pub, address); level == "admin" {
+		verdict = acl.Allow
+	}
+	return
+}
+
+func (g *Groups) GetACLInfo() (name, description, documentation string) {
+	return "groups", "NIP-29 relay based groups",
+		`This ACL mode hosts NIP-29 groups. Events must carry the h tag of a group, only members may write to a group, and only members may read private groups. Groups are created by relay admins and moderated by group admins with kinds 9000-9020.`
+}
+
+func (g *Groups) Type() string { return "groups" }
+
+func (g *Groups) Syncer() {}
+
+func (g *Groups) isRelayAdmin(pub []byte) bool {
+	for _, a := range g.admins {
+		if utils.FastEqual(a, pub) {
+			return true
+		}
+	}
+	return false
+}
+
+// groupID returns the value of the h tag of an event.
+func groupID(ev *event.E) (id string) {
+	if t := ev.Tags.GetFirst([]byte("h")); t != nil {
+		id = string(t.Value())
+	}
+	return
+}
+
+// isStateKind returns whether a kind is one of the group state events signed
+// by the relay.
+func isStateKind(k uint16) bool {
+	return k >= kind.GroupMetadata.K && k <= kind.GroupRoles.K
+}
+
+// CheckEvent returns the reason an event may not be written to the relay, or
+// an empty string if it may.
+func (g *Groups) CheckEvent(ev *event.E) (reason string) {
+	g.groupsMx.RLock()
+	defer g.groupsMx.RUnlock()
+	return g.checkEvent(ev)
+}
+
+// checkEvent is CheckEvent with the lock held.
+func (g *Groups) checkEvent(ev *event.E) (reason string) {
+	if isStateKind(ev.Kind) {
+		if !utils.FastEqual(ev.Pubkey, g.sign.Pub()) {
+			return "group state events may only be published by the relay"
+		}
+		return
+	}
+	id := groupID(ev)
+	if id == "" {
+		if g.isRelayAdmin(ev.Pubkey) {
+			return
+		}
+		return "events must have the h tag of a group"
+	}
+	grp := g.groups[id]
+	if ev.Kind == kind.GroupCreate.K {
+		if grp != nil {
+			return "group " + id + " already exists"
+		}
+		if !g.isRelayAdmin(ev.Pubkey) {
+			return "only relay admins may create groups"
+		}
+		return
+	}
+	if grp == nil {
+		return "group " + id + " does not exist"
+	}
+	switch {
+	case ev.Kind == kind.GroupJoinRequest.K:
+		if grp.isMember(ev.Pubkey) {
+			return "already a member of group " + id
+		}
+	case ev.Kind == kind.GroupLeaveRequest.K:
+		if !grp.isMember(ev.Pubkey) {
+			return "not a member of group " + id
+		}
+	case ev.Kind >= kind.GroupModerationStart.K &&
+		ev.Kind <= kind.GroupModerationEnd.K:
+		if !grp.isAdmin(ev.Pubkey) && !g.isRelayAdmin(ev.Pubkey) {
+			return "only admins may moderate group " + id
+		}
+	default:
+		if !grp.isMember(ev.Pubkey) && !g.isRelayAdmin(ev.Pubkey) {
+			return "only members may post to group " + id
+		}
+	}
+	return
+}
+
+// CanRead returns whether a pubkey may read an event, which is only
+// restricted for events of private groups.
+func (g *Groups) CanRead(ev *event.E, pub []byte) bool {
+	if isStateKind(ev.Kind) {
+		return true
+	}
+	id := groupID(ev)
+	if id == "" {
+		return true
+	}
+	g.groupsMx.RLock()
+	defer g.groupsMx.RUnlock()
+	grp := g.groups[id]
+	if grp == nil || !grp.Private {
+		return true
+	}
+	return len(pub) > 0 && (grp.isMember(pub) || g.isRelayAdmin(pub))
+}
+
+// HiddenFrom returns the ids of the private groups whose events a pubkey may
+// not read.
+func (g *Groups) HiddenFrom(pub []byte) (ids [][]byte) {
+	g.groupsMx.RLock()
+	defer g.groupsMx.RUnlock()
+	if len(pub) > 0 && g.isRelayAdmin(pub) {
+		return
+	}
+	for id, grp := range g.groups {
+		if grp.Private && (len(pub) == 0 || !grp.isMember(pub)) {
+			ids = append(ids, []byte(id))
+		}
+	}
+	return
+}
+
+// ApplyEvent updates the group state from an event that has been stored, and
+// returns the group state events that were published as a result.
+func (g *Groups) ApplyEvent(ev *event.E) (published event.S) {
+	g.groupsMx.Lock()
+	changed, id, reason := g.apply(ev)
+	g.groupsMx.Unlock()
+	if reason != "" {
+		log.D.F("groups: not applying event %0x: %s", ev.ID, reason)
+		return
+	}
+	if ev.Kind == kind.GroupDeleteEvent.K {
+		for _, t := range ev.Tags.GetAll([]byte("e")) {
+			var eid []byte
+			var err error
+			if eid, err = hex.Dec(string(t.Value())); chk.E(err) {
+				continue
+			}
+			if err = g.D.DeleteEvent(g.Ctx, eid); err != nil {
+				log.D.F("group %s: deleting event %0x: %v", id, eid, err)
+			}
+		}
+	}
+	if ev.Kind == kind.GroupDelete.K {
+		g.deleteState(id)
+		return
+	}
+	if len(changed) == 0 {
+		return
+	}
+	return g.publish(id, changed...)
+}
+
+// apply updates the group state from an event, and returns the kinds of the
+// state events that need to be published. The signer is checked against the
+// current state, as the event may have been stored before it changed, so
+// the reason it is refused is returned instead if it may not change it. The
+// lock must be held.
+func (g *Groups) apply(ev *event.E) (
+	changed []uint16, id string, reason string,
+) {
+	if id = groupID(ev); id == "" {
+		return
+	}
+	if reason = g.checkEvent(ev); reason != "" {
+		return
+	}
+	pub := hex.Enc(ev.Pubkey)
+	if ev.Kind == kind.GroupCreate.K {
+		if g.groups[id] != nil {
+			return
+		}
+		g.groups[id] = &Group{
+			ID:      id,
+			Name:    id,
+			Admins:  map[string][]string{pub: {"admin"}},
+			Members: map[string]struct{}{pub: {}},
+		}
+		changed = []uint16{
+			kind.GroupMetadata.K, kind.GroupAdmins.K, kind.GroupMembers.K,
+			kind.GroupRoles.K,
+		}
+		return
+	}
+	grp := g.groups[id]
+	if grp == nil {
+		return
+	}
+	switch ev.Kind {
+	case kind.GroupPutUser.K:
+		for _, t := range ev.Tags.GetAll([]byte("p")) {
+			pk, ok := memberKey(t)
+			if !ok {
+				continue
+			}
+			grp.Members[pk] = struct{}{}
+			if t.Len() > 2 {
+				var roles []string
+				for _, r := range t.T[2:] {
+					roles = append(roles, string(r))
+				}
+				grp.Admins[pk] = roles
+			} else {
+				delete(grp.Admins, pk)
+			}
+		}
+		changed = []uint16{kind.GroupAdmins.K, kind.GroupMembers.K}
+	case kind.GroupRemoveUser.K:
+		for _, t := range ev.Tags.GetAll([]byte("p")) {
+			pk, ok := memberKey(t)
+			if !ok {
+				continue
+			}
+			delete(grp.Members, pk)
+			delete(grp.Admins, pk)
+		}
+		changed = []uint16{kind.GroupAdmins.K, kind.GroupMembers.K}
+	case kind.GroupEditMetadata.K:
+		for _, t := range ev.Tags.GetAll([]byte("name")) {
+			grp.Name = string(t.Value())
+		}
+		for _, t := range ev.Tags.GetAll([]byte("about")) {
+			grp.About = string(t.Value())
+		}
+		for _, t := range ev.Tags.GetAll([]byte("picture")) {
+			grp.Picture = string(t.Value())
+		}
+		if ev.Tags.GetFirst([]byte("private")) != nil {
+			grp.Private = true
+		}
+		if ev.Tags.GetFirst([]byte("public")) != nil {
+			grp.Private = false
+		}
+		if ev.Tags.GetFirst([]byte("closed")) != nil {
+			grp.Closed = true
+		}
+		if ev.Tags.GetFirst([]byte("open")) != nil {
+			grp.Closed = false
+		}
+		changed = []uint16{kind.GroupMetadata.K}
+	case kind.GroupDelete.K:
+		delete(g.groups, id)
+	case kind.GroupJoinRequest.K:
+		// join requests to closed groups wait for an admin to add the user
+		if !grp.Closed {
+			grp.Members[pub] = struct{}{}
+			changed = []uint16{kind.GroupMembers.K}
+		}
+	case kind.GroupLeaveRequest.K:
+		delete(grp.Members, pub)
+		delete(grp.Admins, pub)
+		changed = []uint16{kind.GroupAdmins.K, kind.GroupMembers.K}
+	}
+	return
+}
+
+// memberKey returns the pubkey of a p tag as lower case hex, if it is a
+// valid pubkey.
+func memberKey(t *tag.T) (pk string, ok bool) {
+	b, err := hex.Dec(string(t.Value()))
+	if err != nil || len(b) != 32 {
+		return
+	}
+	return hex.Enc(b), true
+}
+
+// publish signs and stores the given group state events of a group.
+func (g *Groups) publish(id string, kinds ...uint16) (published event.S) {
+	g.groupsMx.RLock()
+	grp := g.groups[id]
+	if grp == nil {
+		g.groupsMx.RUnlock()
+		return
+	}
+	sign := g.sign
+	var evs event.S
+	for _, k := range kinds {
+		ev := event.New()
+		ev.Kind = k
+		ev.CreatedAt = time.Now().Unix()
+		ev.Tags = tag.NewS(tag.NewFromAny("d", id))
+		switch k {
+		case kind.GroupMetadata.K:
+			ev.Tags.Append(tag.NewFromAny("name", grp.Name))
+			if grp.About != "" {
+				ev.Tags.Append(tag.NewFromAny("about", grp.About))
+			}
+			if grp.Picture != "" {
+				ev.Tags.Append(tag.NewFromAny("picture", grp.Picture))
+			}
+			if grp.Private {
+				ev.Tags.Append(tag.NewFromAny("private"))
+			} else {
+				ev.Tags.Append(tag.NewFromAny("public"))
+			}
+			if grp.Closed {
+				ev.Tags.Append(tag.NewFromAny("closed"))
+			} else {
+				ev.Tags.Append(tag.NewFromAny("open"))
+			}
+		case kind.GroupAdmins.K:
+			for _, pk := range sortedKeys(grp.Admins) {
+				t := tag.NewFromAny("p", pk)
+				for _, r := range grp.Admins[pk] {
+					t.T = append(t.T, []byte(r))
+				}
+				ev.Tags.Append(t)
+			}
+		case kind.GroupMembers.K:
+			for _, pk := range sortedKeys(grp.Members) {
+				ev.Tags.Append(tag.NewFromAny("p", pk))
+			}
+		case kind.GroupRoles.K:
+			ev.Tags.Append(
+				tag.NewFromAny(
+					"role", "admin", "can moderate the group",
+				),
+			)
+		}
+		evs = append(evs, ev)
+	}
+	g.groupsMx.RUnlock()
+	for _, ev := range evs {
+		if err := ev.Sign(sign); chk.E(err) {
+			continue
+		}
+		if _, _, err := g.D.SaveEvent(g.Ctx, ev); chk.E(err) {
+			continue
+		}
+		published = append(published, ev)
+	}
+	return
+}
+
+// deleteState removes the stored state events of a deleted group.
+func (g *Groups) deleteState(id string) {
+	g.groupsMx.RLock()
+	sign := g.sign
+	g.groupsMx.RUnlock()
+	evs, err := g.D.QueryEvents(
+		g.Ctx, &filter.F{
+			Kinds: kind.NewS(
+				kind.GroupMetadata, kind.GroupAdmins, kind.GroupMembers,
+				kind.GroupRoles,
+			),
+			Authors: tag.NewFromBytesSlice(sign.Pub()),
+			Tags:    tag.NewS(tag.NewFromAny("d", id)),
+		},
+	)
+	if chk.E(err) {
+		return
+	}
+	for _, ev := range evs {
+		if err = g.D.DeleteEvent(g.Ctx, ev.ID); chk.E(err) {
+			continue
+		}
+		ev.Free()
+	}
+}
+
+func sortedKeys[V any](m map[string]V) (keys []string) {
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return
+}
+
+func init() {
+	log.T.F("registering groups ACL")
+	Registry.Register(new(Groups))
+}
@@ -0,0 +1,165 @@
+package acl
+
+import (
+	"testing"
+
+	"next.orly.dev/app/config"
+	"next.orly.dev/pkg/crypto/p256k"
+	"next.orly.dev/pkg/encoders/event"
+	"next.orly.dev/pkg/encoders/hex"
+	"next.orly.dev/pkg/encoders/kind"
+	"next.orly.dev/pkg/encoders/tag"
+)
+
+func TestGroups(t *testing.T) {
+	db, ctx, cleanup := newTestDB(t)
+	defer cleanup()
+	admin, alice, bob := newSigner(t), newSigner(t), newSigner(t)
+	g := new(Groups)
+	if err := g.Configure(
+		&config.C{Admins: []string{hex.Enc(admin.Pub())}}, db, ctx,
+	); err != nil {
+		t.Fatal(err)
+	}
+	h := tag.NewFromAny("h", "g1")
+	// submit checks, stores and applies an event as HandleEvent does, and
+	// returns the state events that were published
+	submit := func(
+		sign *p256k.Signer, k uint16, tags ...*tag.T,
+	) (published event.S) {
+		t.Helper()
+		ev := newEvent(t, sign, k, tags...)
+		if reason := g.CheckEvent(ev); reason != "" {
+			t.Fatalf("kind %d was refused: %s", k, reason)
+		}
+		if _, _, err := db.SaveEvent(ctx, ev); err != nil {
+			t.Fatalf("failed to save kind %d: %v", k, err)
+		}
+		return g.ApplyEvent(ev)
+	}
+	published := submit(admin, kind.GroupCreate.K, h)
+	if len(published) != 4 {
+		t.Fatalf("expected 4 state events on create, got %d", len(published))
+	}
+	for i, k := range []uint16{
+		kind.GroupMetadata.K, kind.GroupAdmins.K, kind.GroupMembers.K,
+		kind.GroupRoles.K,
+	} {
+		if published[i].Kind != k {
+			t.Fatalf("state event %d has kind %d, not %d", i, published[i].Kind, k)
+		}
+		if ok, err := published[i].Verify(); err != nil || !ok {
+			t.Fatalf("state event of kind %d is not signed", k)
+		}
+	}
+	if g.CheckEvent(newEvent(t, alice, kind.GroupCreate.K, h)) == "" {
+		t.Fatal("a group was created twice")
+	}
+	// make the group private
+	published = submit(
+		admin, kind.GroupEditMetadata.K, h, tag.NewFromAny("private"),
+	)
+	if len(published) != 1 || published[0].Kind != kind.GroupMetadata.K ||
+		published[0].Tags.GetFirst([]byte("private")) == nil {
+		t.Fatal("metadata of the private group was not published")
+	}
+	// non-members can neither post nor read
+	note := newEvent(t, bob, kind.TextNote.K, h)
+	if g.CheckEvent(note) == "" {
+		t.Fatal("a non-member posted to the group")
+	}
+	if g.CanRead(note, bob.Pub()) || g.CanRead(note, nil) {
+		t.Fatal("a private group event was readable by a non-member")
+	}
+	if !g.CanRead(published[0], nil) {
+		t.Fatal("the metadata of a private group was not readable")
+	}
+	if hidden := g.HiddenFrom(bob.Pub()); len(hidden) != 1 ||
+		string(hidden[0]) != "g1" {
+		t.Fatalf("private group hidden from a non-member: %q", hidden)
+	}
+	// alice joins the open group
+	published = submit(alice, kind.GroupJoinRequest.K, h)
+	if len(published) != 1 || !hasP(published[0], alice.Pub()) {
+		t.Fatal("members list does not have alice after joining")
+	}
+	if !g.CanRead(note, alice.Pub()) {
+		t.Fatal("a member could not read the private group")
+	}
+	if hidden := g.HiddenFrom(alice.Pub()); len(hidden) != 0 {
+		t.Fatalf("private group hidden from a member: %q", hidden)
+	}
+	if reason := g.CheckEvent(
+		newEvent(t, alice, kind.TextNote.K, h),
+	); reason != "" {
+		t.Fatalf("a member could not post: %s", reason)
+	}
+	if g.CheckEvent(newEvent(t, alice, kind.GroupPutUser.K, h)) == "" {
+		t.Fatal("a member who is not an admin moderated the group")
+	}
+	// bob is added by an admin, and invalid pubkeys are ignored
+	published = submit(
+		admin, kind.GroupPutUser.K, h,
+		tag.NewFromAny("p", hex.Enc(bob.Pub())), tag.NewFromAny("p", "zz"),
+	)
+	var members *event.E
+	for _, ev := range published {
+		if ev.Kind == kind.GroupMembers.K {
+			members = ev
+		}
+	}
+	if members == nil || !hasP(members, bob.Pub()) {
+		t.Fatal("members list does not have bob after being put")
+	}
+	if _, ok := g.groups["g1"].Members["zz"]; ok {
+		t.Fatal("an invalid pubkey was added to the group")
+	}
+	// alice is removed, and bob leaves
+	submit(
+		admin, kind.GroupRemoveUser.K, h,
+		tag.NewFromAny("p", hex.Enc(alice.Pub())),
+	)
+	if g.CanRead(note, alice.Pub()) {
+		t.Fatal("a removed member could still read the private group")
+	}
+	published = submit(bob, kind.GroupLeaveRequest.K, h)
+	for _, ev := range published {
+		if ev.Kind == kind.GroupMembers.K && hasP(ev, bob.Pub()) {
+			t.Fatal("members list still has bob after leaving")
+		}
+	}
+	// moderation by a non-admin that got stored is neither applied nor
+	// replayed
+	putBob := newEvent(
+		t, bob, kind.GroupPutUser.K, h,
+		tag.NewFromAny("p", hex.Enc(bob.Pub()), "admin"),
+	)
+	if _, _, err := db.SaveEvent(ctx, putBob); err != nil {
+		t.Fatal(err)
+	}
+	if published = g.ApplyEvent(putBob); len(published) != 0 ||
+		g.groups["g1"].isMember(bob.Pub()) {
+		t.Fatal("a non-admin added themselves to the group")
+	}
+	// the state is rebuilt from the stored moderation events
+	g2 := new(Groups)
+	if err := g2.Configure(
+		&config.C{Admins: []string{hex.Enc(admin.Pub())}}, db, ctx,
+	); err != nil {
+		t.Fatal(err)
+	}
+	grp := g2.groups["g1"]
+	if grp == nil || !grp.Private || grp.isMember(alice.Pub()) ||
+		grp.isMember(bob.Pub()) || !grp.isMember(admin.Pub()) {
+		t.Fatalf("group state was not rebuilt: %+v", grp)
+	}
+}
+
+func hasP(ev *event.E, pub []byte) bool {
+	for _, p := range ev.Tags.GetAll([]byte("p")) {
+		if string(p.Value()) == hex.Enc(pub) {
+			return true
+		}
+	}
+	return false
+}
@@ -2,6 +2,7 @@ package acl
 
 import (
 	"lol.mleku.dev/log"
+	"next.orly.dev/pkg/interfaces/acl"
 )
 
 type None struct{}
@@ -12,6 +13,14 @@ func (n None) GetAccessLevel(pub []byte, address string) (level string) {
 	return "write"
 }
 
+// Decide abstains with write access, so a chain with the none ACL gives write
+// access to everyone no other ACL of it decides for.
+func (n None) Decide(pub []byte, address string) (
+	verdict acl.Verdict, level string,
+) {
+	return acl.Abstain, "write"
+}
+
 func (n None) GetACLInfo() (name, description, documentation string) {
 	return "none", "no ACL", "blanket write access for all clients"
 }
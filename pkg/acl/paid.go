@@ -0,0 +1,115 @@
+package acl
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"lol.mleku.dev/chk"
+	"lol.mleku.dev/errorf"
+	"lol.mleku.dev/log"
+	"next.orly.dev/app/config"
+	"next.orly.dev/pkg/database"
+	"next.orly.dev/pkg/encoders/bech32encoding"
+	"next.orly.dev/pkg/utils"
+)
+
+// Paid is an ACL that grants write access to pubkeys with an active trial or
+// paid subscription in the subscription store, which the payment processor
+// extends when an invoice is paid. Everyone else can read.
+type Paid struct {
+	Ctx context.Context
+	cfg *config.C
+	*database.D
+	adminsMx sync.RWMutex
+	admins   [][]byte
+}
+
+func (p *Paid) Configure(cfg ...any) (err error) {
+	log.I.F("configuring paid ACL")
+	for _, ca := range cfg {
+		switch c := ca.(type) {
+		case *config.C:
+			p.cfg = c
+		case *database.D:
+			p.D = c
+		case context.Context:
+			p.Ctx = c
+		default:
+			err = errorf.E("invalid type: %T", reflect.TypeOf(ca))
+		}
+	}
+	if p.cfg == nil || p.D == nil {
+		err = errorf.E("both config and database must be set")
+		return
+	}
+	if p.Ctx == nil {
+		p.Ctx = context.Background()
+	}
+	var admins [][]byte
+	var keys []string
+	keys = append(keys, p.cfg.Owners...)
+	keys = append(keys, p.cfg.Admins...)
+	for _, a := range keys {
+		var adm []byte
+		if adm, err = bech32encoding.NpubOrHexToPublicKeyBinary(a); chk.E(err) {
+			err = nil
+			continue
+		}
+		admins = append(admins, adm)
+	}
+	p.adminsMx.Lock()
+	p.admins = admins
+	p.adminsMx.Unlock()
+	return
+}
+
+func (p *Paid) GetAccessLevel(pub []byte, address string) (level string) {
+	if p.D == nil {
+		return "read"
+	}
+	p.adminsMx.RLock()
+	for _, a := range p.admins {
+		if utils.FastEqual(a, pub) {
+			p.adminsMx.RUnlock()
+			return "admin"
+		}
+	}
+	p.adminsMx.RUnlock()
+	if len(pub) == 0 {
+		return "read"
+	}
+	sub, err := p.D.GetSubscription(pub)
+	if chk.E(err) || sub == nil {
+		return "read"
+	}
+	if sub.Active(time.Now()) {
+		return "write"
+	}
+	return "read"
+}
+
+// StartTrial starts the trial of a pubkey that has no subscription yet.
+func (p *Paid) StartTrial(pub []byte) {
+	if p.D == nil || len(pub) != 32 {
+		return
+	}
+	if _, err := p.D.IsSubscriptionActive(pub); chk.E(err) {
+		log.E.F("failed to start trial for %0x: %v", pub, err)
+	}
+}
+
+func (p *Paid) GetACLInfo() (name, description, documentation string) {
+	return "paid", "write access for paying subscribers",
+		`This ACL mode grants write access to pubkeys with an active trial or paid subscription. A trial starts when a pubkey first authenticates, and subscriptions are extended by paying an invoice of the payment processor. Everyone else may read, and publish directory events such as profiles, follow lists and relay lists.`
+}
+
+func (p *Paid) Type() string { return "paid" }
+
+func (p *Paid) Syncer() {}
+
+func init() {
+	log.T.F("registering paid ACL")
+	Registry.Register(new(Paid))
+}
@@ -0,0 +1,43 @@
+package acl
+
+import (
+	"testing"
+
+	"next.orly.dev/app/config"
+	"next.orly.dev/pkg/encoders/hex"
+)
+
+func TestPaid(t *testing.T) {
+	db, ctx, cleanup := newTestDB(t)
+	defer cleanup()
+	admin, alice, bob := newSigner(t), newSigner(t), newSigner(t)
+	p := new(Paid)
+	if err := p.Configure(
+		&config.C{Admins: []string{hex.Enc(admin.Pub())}}, db, ctx,
+	); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.GetAccessLevel(admin.Pub(), ""); got != "admin" {
+		t.Errorf("admin has %s access", got)
+	}
+	if got := p.GetAccessLevel(nil, "127.0.0.1"); got != "read" {
+		t.Errorf("unauthenticated client has %s access", got)
+	}
+	// checking the access level does not start a trial
+	if got := p.GetAccessLevel(alice.Pub(), ""); got != "read" {
+		t.Errorf("pubkey without a subscription has %s access", got)
+	}
+	if sub, err := db.GetSubscription(alice.Pub()); err != nil || sub != nil {
+		t.Fatalf("checking the access level created a subscription: %v", err)
+	}
+	p.StartTrial(alice.Pub())
+	if got := p.GetAccessLevel(alice.Pub(), ""); got != "write" {
+		t.Errorf("pubkey on a trial has %s access", got)
+	}
+	if err := db.ExtendSubscription(bob.Pub(), 30); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.GetAccessLevel(bob.Pub(), ""); got != "write" {
+		t.Errorf("paying pubkey has %s access", got)
+	}
+}
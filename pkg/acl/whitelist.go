@@ -0,0 +1,226 @@
+package acl
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"lol.mleku.dev/chk"
+	"lol.mleku.dev/errorf"
+	"lol.mleku.dev/log"
+	"next.orly.dev/app/config"
+	"next.orly.dev/pkg/database"
+	"next.orly.dev/pkg/encoders/bech32encoding"
+	"next.orly.dev/pkg/encoders/event"
+	"next.orly.dev/pkg/encoders/filter"
+	"next.orly.dev/pkg/encoders/hex"
+	"next.orly.dev/pkg/encoders/kind"
+	"next.orly.dev/pkg/encoders/tag"
+	"next.orly.dev/pkg/utils"
+)
+
+// WhitelistD is the d tag of the NIP-51 follow set (kind 30000) that admins
+// publish to the relay to manage the members of the whitelist.
+const WhitelistD = "whitelist"
+
+// IsWhitelistEvent returns whether an event is a whitelist follow set, which
+// should reload the whitelist ACL when it is stored by an admin.
+func IsWhitelistEvent(ev *event.E) bool {
+	if ev.Kind != kind.FollowSets.K {
+		return false
+	}
+	d := ev.Tags.GetFirst([]byte("d"))
+	return d != nil && string(d.Value()) == WhitelistD
+}
+
+// Whitelist is an ACL that grants write access to a fixed set of members,
+// listed in a file, or in the whitelist follow sets of the admins. Everyone
+// else can read, unless the whitelist is private.
+type Whitelist struct {
+	Ctx context.Context
+	cfg *config.C
+	*database.D
+	membersMx sync.RWMutex
+	admins    [][]byte
+	members   map[string]struct{}
+	// modification time of the whitelist file when it was last loaded
+	loaded time.Time
+}
+
+// ReloadsOn returns whether an event of an admin changes the whitelist, which
+// is a whitelist follow set, or a deletion that may have removed one.
+func (w *Whitelist) ReloadsOn(ev *event.E) bool {
+	return IsWhitelistEvent(ev) || ev.Kind == kind.EventDeletion.K
+}
+
+func (w *Whitelist) Configure(cfg ...any) (err error) {
+	log.I.F("configuring whitelist ACL")
+	for _, ca := range cfg {
+		switch c := ca.(type) {
+		case *config.C:
+			w.cfg = c
+		case *database.D:
+			w.D = c
+		case context.Context:
+			w.Ctx = c
+		default:
+			err = errorf.E("invalid type: %T", reflect.TypeOf(ca))
+		}
+	}
+	if w.cfg == nil || w.D == nil {
+		err = errorf.E("both config and database must be set")
+		return
+	}
+	if w.Ctx == nil {
+		w.Ctx = context.Background()
+	}
+	var admins [][]byte
+	var keys []string
+	keys = append(keys, w.cfg.Owners...)
+	keys = append(keys, w.cfg.Admins...)
+	for _, a := range keys {
+		var adm []byte
+		if adm, err = bech32encoding.NpubOrHexToPublicKeyBinary(a); chk.E(err) {
+			err = nil
+			continue
+		}
+		admins = append(admins, adm)
+	}
+	members := make(map[string]struct{})
+	var loaded time.Time
+	if w.cfg.WhitelistFile != "" {
+		if loaded, err = loadPubkeyFile(
+			w.cfg.WhitelistFile, members,
+		); chk.E(err) {
+			return
+		}
+	}
+	if len(admins) > 0 {
+		var evs event.S
+		if evs, err = w.D.QueryEvents(
+			w.Ctx, &filter.F{
+				Authors: tag.NewFromBytesSlice(admins...),
+				Kinds:   kind.NewS(kind.FollowSets),
+				Tags:    tag.NewS(tag.NewFromAny("#d", WhitelistD)),
+			},
+		); chk.E(err) {
+			return
+		}
+		for _, ev := range evs {
+			for _, p := range ev.Tags.GetAll([]byte("p")) {
+				if pk, e := hex.Dec(string(p.Value())); e == nil &&
+					len(pk) == 32 {
+					members[hex.Enc(pk)] = struct{}{}
+				}
+			}
+			ev.Free()
+		}
+	}
+	w.membersMx.Lock()
+	w.admins, w.members, w.loaded = admins, members, loaded
+	w.membersMx.Unlock()
+	log.I.F("whitelist ACL loaded %d members", len(members))
+	return
+}
+
+// loadPubkeyFile adds the pubkeys in a file to members, one npub or hex
+// pubkey per line, ignoring blank lines and lines starting with #, and
+// returns its modification time.
+func loadPubkeyFile(path string, members map[string]struct{}) (
+	modified time.Time, err error,
+) {
+	var f *os.File
+	if f, err = os.Open(path); chk.E(err) {
+		return
+	}
+	defer f.Close()
+	var fi os.FileInfo
+	if fi, err = f.Stat(); chk.E(err) {
+		return
+	}
+	modified = fi.ModTime()
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pk, e := bech32encoding.NpubOrHexToPublicKeyBinary(line)
+		if e != nil {
+			log.W.F("pubkey file %s line %d: %v", path, n, e)
+			continue
+		}
+		members[hex.Enc(pk)] = struct{}{}
+	}
+	err = s.Err()
+	return
+}
+
+func (w *Whitelist) GetAccessLevel(pub []byte, address string) (level string) {
+	w.membersMx.RLock()
+	defer w.membersMx.RUnlock()
+	for _, a := range w.admins {
+		if utils.FastEqual(a, pub) {
+			return "admin"
+		}
+	}
+	if len(pub) > 0 {
+		if _, ok := w.members[hex.Enc(pub)]; ok {
+			return "write"
+		}
+	}
+	if w.cfg != nil && w.cfg.WhitelistPrivate {
+		return "none"
+	}
+	return "read"
+}
+
+func (w *Whitelist) GetACLInfo() (name, description, documentation string) {
+	return "whitelist", "static whitelist of members",
+		`This ACL mode grants write access to the pubkeys listed in the whitelist file and in the kind 30000 follow sets with the d tag "whitelist" published to the relay by admins. Everyone else may only read, or nothing at all if the whitelist is private.`
+}
+
+func (w *Whitelist) Type() string { return "whitelist" }
+
+// Syncer reloads the whitelist when the whitelist file is modified.
+func (w *Whitelist) Syncer() {
+	if w.cfg == nil || w.cfg.WhitelistFile == "" {
+		return
+	}
+	log.I.F("watching whitelist file %s", w.cfg.WhitelistFile)
+	ctx := w.Ctx
+	go func() {
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			fi, err := os.Stat(w.cfg.WhitelistFile)
+			if err != nil {
+				continue
+			}
+			w.membersMx.RLock()
+			changed := !fi.ModTime().Equal(w.loaded)
+			w.membersMx.RUnlock()
+			if !changed {
+				continue
+			}
+			log.I.F("whitelist file %s changed, reloading", w.cfg.WhitelistFile)
+			if err = w.Configure(); chk.E(err) {
+				log.E.F("failed to reload whitelist: %v", err)
+			}
+		}
+	}()
+}
+
+func init() {
+	log.T.F("registering whitelist ACL")
+	Registry.Register(new(Whitelist))
+}
@@ -0,0 +1,132 @@
+package acl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"next.orly.dev/app/config"
+	"next.orly.dev/pkg/encoders/bech32encoding"
+	"next.orly.dev/pkg/encoders/event"
+	"next.orly.dev/pkg/encoders/hex"
+	"next.orly.dev/pkg/encoders/kind"
+	"next.orly.dev/pkg/encoders/tag"
+)
+
+// writeWhitelistFile writes the lines of a whitelist file to a temporary
+// directory and returns its path.
+func writeWhitelistFile(t *testing.T, lines ...string) (path string) {
+	t.Helper()
+	path = filepath.Join(t.TempDir(), "whitelist")
+	var b []byte
+	for _, l := range lines {
+		b = append(b, l...)
+		b = append(b, '\n')
+	}
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestLoadPubkeyFile(t *testing.T) {
+	alice, bob := newSigner(t), newSigner(t)
+	npub, err := bech32encoding.BinToNpub(bob.Pub())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := writeWhitelistFile(
+		t, "# members", "", "  "+hex.Enc(alice.Pub())+"  ", string(npub),
+		"not a pubkey",
+	)
+	members := make(map[string]struct{})
+	if _, err = loadPubkeyFile(path, members); err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 {
+		t.Fatalf("expected 2 members, got %d", len(members))
+	}
+	for _, pk := range [][]byte{alice.Pub(), bob.Pub()} {
+		if _, ok := members[hex.Enc(pk)]; !ok {
+			t.Errorf("%0x is not a member", pk)
+		}
+	}
+	if _, err = loadPubkeyFile(
+		filepath.Join(t.TempDir(), "missing"), members,
+	); err == nil {
+		t.Error("loading a missing file did not fail")
+	}
+}
+
+func TestWhitelist(t *testing.T) {
+	db, ctx, cleanup := newTestDB(t)
+	defer cleanup()
+	admin, alice, bob, carol := newSigner(t), newSigner(t), newSigner(t),
+		newSigner(t)
+	set := newEvent(
+		t, admin, kind.FollowSets.K, tag.NewFromAny("d", WhitelistD),
+		tag.NewFromAny("p", hex.Enc(bob.Pub())),
+	)
+	if !IsWhitelistEvent(set) {
+		t.Fatal("the whitelist follow set is not recognised")
+	}
+	if IsWhitelistEvent(
+		newEvent(t, admin, kind.FollowSets.K, tag.NewFromAny("d", "friends")),
+	) {
+		t.Fatal("another follow set is taken for the whitelist")
+	}
+	if w := new(Whitelist); !w.ReloadsOn(set) ||
+		w.ReloadsOn(newEvent(t, admin, kind.FollowList.K)) {
+		t.Fatal("the whitelist reloads on the wrong events")
+	}
+	// a follow set of someone who is not an admin grants nothing
+	other := newEvent(
+		t, carol, kind.FollowSets.K, tag.NewFromAny("d", WhitelistD),
+		tag.NewFromAny("p", hex.Enc(carol.Pub())),
+	)
+	for _, ev := range []*event.E{set, other} {
+		if _, _, err := db.SaveEvent(ctx, ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := &config.C{
+		Admins:        []string{hex.Enc(admin.Pub())},
+		WhitelistFile: writeWhitelistFile(t, hex.Enc(alice.Pub())),
+	}
+	w := new(Whitelist)
+	if err := w.Configure(cfg, db, ctx); err != nil {
+		t.Fatal(err)
+	}
+	levels := func(want map[string][]byte) {
+		t.Helper()
+		for level, pk := range want {
+			if got := w.GetAccessLevel(pk, ""); got != level {
+				t.Errorf("%0x has %s access, want %s", pk, got, level)
+			}
+		}
+	}
+	levels(
+		map[string][]byte{
+			"admin": admin.Pub(), "write": alice.Pub(), "read": carol.Pub(),
+		},
+	)
+	if got := w.GetAccessLevel(bob.Pub(), ""); got != "write" {
+		t.Errorf("member of the follow set has %s access", got)
+	}
+	// a private whitelist gives nothing to everyone else
+	cfg.WhitelistPrivate = true
+	levels(map[string][]byte{"none": carol.Pub(), "write": alice.Pub()})
+	if got := w.GetAccessLevel(nil, "127.0.0.1"); got != "none" {
+		t.Errorf("unauthenticated client has %s access", got)
+	}
+	// deleting the follow set removes its members on the next reload
+	if err := db.DeleteEvent(ctx, set.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Configure(); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.GetAccessLevel(bob.Pub(), ""); got != "none" {
+		t.Errorf("removed member has %s access", got)
+	}
+}
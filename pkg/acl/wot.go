@@ -0,0 +1,412 @@
+package acl
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"lol.mleku.dev/chk"
+	"lol.mleku.dev/errorf"
+	"lol.mleku.dev/log"
+	"next.orly.dev/app/config"
+	"next.orly.dev/pkg/database"
+	"next.orly.dev/pkg/encoders/bech32encoding"
+	"next.orly.dev/pkg/encoders/event"
+	"next.orly.dev/pkg/encoders/filter"
+	"next.orly.dev/pkg/encoders/hex"
+	"next.orly.dev/pkg/encoders/kind"
+	"next.orly.dev/pkg/encoders/tag"
+	"next.orly.dev/pkg/utils"
+)
+
+// wotQueryAuthors is the number of authors whose follow lists are fetched
+// with one query while the graph is built.
+const wotQueryAuthors = 500
+
+// WotStats describes the follow graph of the web of trust ACL.
+type WotStats struct {
+	// Depths is the number of pubkeys at each depth, starting at 1 for the
+	// direct follows of the admins.
+	Depths []int `json:"depths"`
+	// Total is the number of pubkeys in the graph, not counting the admins.
+	Total int `json:"total"`
+	// Rebuilt is when the graph was last built.
+	Rebuilt time.Time `json:"rebuilt"`
+	// Duration is how long the last build took.
+	Duration time.Duration `json:"duration"`
+}
+
+// Wot is a web of trust ACL that grants write access to the pubkeys within a
+// number of follows of the admins, found in the kind 3 follow lists stored
+// in the relay. Beyond the direct follows of the admins, a pubkey may also
+// need to be followed by a minimum number of pubkeys of the depth before it.
+type Wot struct {
+	Ctx context.Context
+	cfg *config.C
+	*database.D
+	graphMx sync.RWMutex
+	admins  [][]byte
+	// depth of each pubkey in the graph by hex pubkey
+	depths map[string]int
+	stats  WotStats
+	// buildMx serializes builds and guards the follow lists
+	buildMx sync.Mutex
+	// follow lists of the pubkeys that were expanded by the last build, by
+	// hex pubkey, which updates replace
+	follows map[string][]string
+}
+
+func (w *Wot) Configure(cfg ...any) (err error) {
+	log.I.F("configuring web of trust ACL")
+	for _, ca := range cfg {
+		switch c := ca.(type) {
+		case *config.C:
+			w.cfg = c
+		case *database.D:
+			w.D = c
+		case context.Context:
+			w.Ctx = c
+		default:
+			err = errorf.E("invalid type: %T", reflect.TypeOf(ca))
+		}
+	}
+	if w.cfg == nil || w.D == nil {
+		err = errorf.E("both config and database must be set")
+		return
+	}
+	if w.Ctx == nil {
+		w.Ctx = context.Background()
+	}
+	var admins [][]byte
+	var keys []string
+	keys = append(keys, w.cfg.Owners...)
+	keys = append(keys, w.cfg.Admins...)
+	for _, a := range keys {
+		var adm []byte
+		if adm, err = bech32encoding.NpubOrHexToPublicKeyBinary(a); chk.E(err) {
+			err = nil
+			continue
+		}
+		admins = append(admins, adm)
+	}
+	w.graphMx.Lock()
+	w.admins = admins
+	w.graphMx.Unlock()
+	// a full rebuild reloads every follow list from the database
+	w.buildMx.Lock()
+	w.follows = make(map[string][]string)
+	w.buildMx.Unlock()
+	return w.rebuild()
+}
+
+// maxDepth returns the configured depth of the graph, at least 1.
+func (w *Wot) maxDepth() int {
+	if w.cfg.WotDepth < 1 {
+		return 1
+	}
+	return w.cfg.WotDepth
+}
+
+// rebuild walks the follow graph from the admins to the configured depth,
+// loading the follow lists that are not cached from the database.
+func (w *Wot) rebuild() (err error) {
+	w.buildMx.Lock()
+	defer w.buildMx.Unlock()
+	start := time.Now()
+	w.graphMx.RLock()
+	admins := w.admins
+	w.graphMx.RUnlock()
+	max := w.maxDepth()
+	depths := make(map[string]int)
+	frontier := make([]string, 0, len(admins))
+	for _, a := range admins {
+		frontier = append(frontier, hex.Enc(a))
+	}
+	// the admins are the root of the graph and are not counted in it
+	root := make(map[string]struct{}, len(frontier))
+	for _, a := range frontier {
+		root[a] = struct{}{}
+	}
+	expanded := make(map[string][]string)
+	stats := WotStats{Depths: make([]int, 0, max)}
+	for d := 1; d <= max && len(frontier) > 0; d++ {
+		if err = w.loadFollows(frontier); chk.E(err) {
+			return
+		}
+		followers := make(map[string]int)
+		for _, pk := range frontier {
+			expanded[pk] = w.follows[pk]
+			for _, f := range w.follows[pk] {
+				if _, ok := root[f]; ok {
+					continue
+				}
+				if _, ok := depths[f]; ok {
+					continue
+				}
+				followers[f]++
+			}
+		}
+		var next []string
+		for f, n := range followers {
+			if d >= 2 && n < w.cfg.WotMinFollowers {
+				continue
+			}
+			depths[f] = d
+			next = append(next, f)
+		}
+		stats.Depths = append(stats.Depths, len(next))
+		stats.Total += len(next)
+		frontier = next
+	}
+	// only the follow lists the graph was built from need to be kept
+	w.follows = expanded
+	stats.Rebuilt = time.Now()
+	stats.Duration = stats.Rebuilt.Sub(start)
+	w.graphMx.Lock()
+	w.depths, w.stats = depths, stats
+	w.graphMx.Unlock()
+	log.I.F(
+		"web of trust ACL built graph of %d pubkeys %v in %v", stats.Total,
+		stats.Depths, stats.Duration,
+	)
+	return
+}
+
+// loadFollows fetches the stored follow lists of the pubkeys that are not
+// cached yet.
+func (w *Wot) loadFollows(pks []string) (err error) {
+	var missing [][]byte
+	for _, pk := range pks {
+		if _, ok := w.follows[pk]; ok {
+			continue
+		}
+		// pubkeys without a follow list are cached as following nobody
+		w.follows[pk] = nil
+		var b []byte
+		if b, err = hex.Dec(pk); chk.E(err) {
+			err = nil
+			continue
+		}
+		missing = append(missing, b)
+	}
+	for len(missing) > 0 {
+		n := min(len(missing), wotQueryAuthors)
+		var evs event.S
+		if evs, err = w.D.QueryEvents(
+			w.Ctx, &filter.F{
+				Authors: tag.NewFromBytesSlice(missing[:n]...),
+				Kinds:   kind.NewS(kind.FollowList),
+			},
+		); chk.E(err) {
+			return
+		}
+		for _, ev := range evs {
+			w.follows[hex.Enc(ev.Pubkey)] = followedPubkeys(ev)
+			ev.Free()
+		}
+		missing = missing[n:]
+	}
+	return
+}
+
+// followedPubkeys returns the hex pubkeys in the p tags of a follow list.
+func followedPubkeys(ev *event.E) (pks []string) {
+	for _, p := range ev.Tags.GetAll([]byte("p")) {
+		if pk, err := hex.Dec(string(p.Value())); err == nil && len(pk) == 32 {
+			pks = append(pks, hex.Enc(pk))
+		}
+	}
+	return
+}
+
+// Update applies a newly stored follow list to the graph, if the author is an
+// admin or a pubkey whose follows are in the graph. A list that only adds
+// follows extends the graph from the author, while one that removes follows,
+// or any change when a minimum number of followers is required, rebuilds it
+// from the cached follow lists.
+func (w *Wot) Update(ev *event.E) {
+	if ev.Kind != kind.FollowList.K || w.cfg == nil {
+		return
+	}
+	author := hex.Enc(ev.Pubkey)
+	w.buildMx.Lock()
+	old, expanded := w.follows[author]
+	if !expanded {
+		w.buildMx.Unlock()
+		return
+	}
+	follows := followedPubkeys(ev)
+	w.follows[author] = follows
+	added, removed := diffFollows(old, follows)
+	if len(removed) == 0 && w.cfg.WotMinFollowers <= 1 && w.depths != nil {
+		err := w.extend(author, added)
+		w.buildMx.Unlock()
+		if chk.E(err) {
+			log.E.F("failed to update web of trust: %v", err)
+		}
+		return
+	}
+	w.buildMx.Unlock()
+	if err := w.rebuild(); chk.E(err) {
+		log.E.F("failed to update web of trust: %v", err)
+	}
+}
+
+// diffFollows returns the pubkeys that a follow list adds to and removes from
+// the one it replaces.
+func diffFollows(old, follows []string) (added, removed []string) {
+	had := make(map[string]struct{}, len(old))
+	for _, pk := range old {
+		had[pk] = struct{}{}
+	}
+	has := make(map[string]struct{}, len(follows))
+	for _, pk := range follows {
+		has[pk] = struct{}{}
+		if _, ok := had[pk]; !ok {
+			added = append(added, pk)
+		}
+	}
+	for _, pk := range old {
+		if _, ok := has[pk]; !ok {
+			removed = append(removed, pk)
+		}
+	}
+	return
+}
+
+// extend adds the new follows of a pubkey of the graph to it, and the pubkeys
+// that they bring closer to the admins, walking only from the new follows.
+// This is only valid when no minimum number of followers is required, as
+// then adding follows can only add pubkeys or lower their depth. The build
+// lock must be held, which also guards writes to the depths.
+func (w *Wot) extend(author string, added []string) (err error) {
+	if len(added) == 0 {
+		return
+	}
+	max := w.maxDepth()
+	w.graphMx.RLock()
+	root := make(map[string]struct{}, len(w.admins))
+	for _, a := range w.admins {
+		root[hex.Enc(a)] = struct{}{}
+	}
+	w.graphMx.RUnlock()
+	lowered := make(map[string]int)
+	depth := func(pk string) (d int, ok bool) {
+		if _, ok = root[pk]; ok {
+			return 0, true
+		}
+		if d, ok = lowered[pk]; ok {
+			return
+		}
+		d, ok = w.depths[pk]
+		return
+	}
+	from, _ := depth(author)
+	frontier := added
+	for d := from + 1; d <= max && len(frontier) > 0; d++ {
+		var next []string
+		for _, pk := range frontier {
+			if cur, ok := depth(pk); ok && cur <= d {
+				continue
+			}
+			lowered[pk] = d
+			next = append(next, pk)
+		}
+		if d == max || len(next) == 0 {
+			break
+		}
+		if err = w.loadFollows(next); chk.E(err) {
+			return
+		}
+		frontier = nil
+		for _, pk := range next {
+			frontier = append(frontier, w.follows[pk]...)
+		}
+	}
+	if len(lowered) == 0 {
+		return
+	}
+	w.graphMx.Lock()
+	defer w.graphMx.Unlock()
+	for pk, d := range lowered {
+		if old, ok := w.depths[pk]; ok {
+			w.stats.Depths[old-1]--
+		} else {
+			w.stats.Total++
+		}
+		for len(w.stats.Depths) < d {
+			w.stats.Depths = append(w.stats.Depths, 0)
+		}
+		w.stats.Depths[d-1]++
+		w.depths[pk] = d
+	}
+	log.D.F(
+		"web of trust ACL added %d pubkeys from the follows of %s", len(lowered),
+		author,
+	)
+	return
+}
+
+// Stats returns the statistics of the graph.
+func (w *Wot) Stats() (stats WotStats) {
+	w.graphMx.RLock()
+	defer w.graphMx.RUnlock()
+	stats = w.stats
+	stats.Depths = append([]int(nil), w.stats.Depths...)
+	return
+}
+
+func (w *Wot) GetAccessLevel(pub []byte, address string) (level string) {
+	w.graphMx.RLock()
+	defer w.graphMx.RUnlock()
+	for _, a := range w.admins {
+		if utils.FastEqual(a, pub) {
+			return "admin"
+		}
+	}
+	if len(pub) > 0 {
+		if _, ok := w.depths[hex.Enc(pub)]; ok {
+			return "write"
+		}
+	}
+	return "read"
+}
+
+func (w *Wot) GetACLInfo() (name, description, documentation string) {
+	return "wot", "web of trust of admins",
+		`This ACL mode grants write access to the pubkeys within a configured number of follows of the admins, found in the kind 3 follow lists stored in the relay. Beyond the direct follows of the admins, a pubkey may be required to be followed by a minimum number of pubkeys one step closer to the admins. Everyone else may only read.`
+}
+
+func (w *Wot) Type() string { return "wot" }
+
+// Syncer rebuilds the graph from the database periodically, to include
+// follow lists that were stored without passing through Update, such as by
+// the spider or an import.
+func (w *Wot) Syncer() {
+	if w.cfg == nil || w.cfg.WotRefresh <= 0 {
+		return
+	}
+	// the context is captured, so the loop ends with the context it was
+	// started with rather than that of a later Configure
+	ctx := w.Ctx
+	go func() {
+		ticker := time.NewTicker(w.cfg.WotRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := w.Configure(); chk.E(err) {
+				log.E.F("failed to rebuild web of trust: %v", err)
+			}
+		}
+	}()
+}
+
+func init() {
+	log.T.F("registering web of trust ACL")
+	Registry.Register(new(Wot))
+}
@@ -0,0 +1,90 @@
+package acl
+
+import (
+	"reflect"
+	"testing"
+
+	"next.orly.dev/app/config"
+	"next.orly.dev/pkg/crypto/p256k"
+	"next.orly.dev/pkg/encoders/event"
+	"next.orly.dev/pkg/encoders/hex"
+	"next.orly.dev/pkg/encoders/kind"
+	"next.orly.dev/pkg/encoders/tag"
+)
+
+func TestWot(t *testing.T) {
+	db, ctx, cleanup := newTestDB(t)
+	defer cleanup()
+	admin, alice, bob, carol := newSigner(t), newSigner(t), newSigner(t),
+		newSigner(t)
+	frank, greg, henry, ivan := newSigner(t), newSigner(t), newSigner(t),
+		newSigner(t)
+	follows := func(sign *p256k.Signer, pks ...*p256k.Signer) *event.E {
+		var tags []*tag.T
+		for _, pk := range pks {
+			tags = append(tags, tag.NewFromAny("p", hex.Enc(pk.Pub())))
+		}
+		return newEvent(t, sign, kind.FollowList.K, tags...)
+	}
+	for _, ev := range []*event.E{
+		follows(admin, alice, frank),
+		follows(alice, bob, carol),
+		follows(frank, carol),
+		follows(greg, carol),
+		follows(carol, henry),
+	} {
+		if _, _, err := db.SaveEvent(ctx, ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := &config.C{
+		Admins: []string{hex.Enc(admin.Pub())}, WotDepth: 2, WotMinFollowers: 2,
+	}
+	w := new(Wot)
+	if err := w.Configure(cfg, db, ctx); err != nil {
+		t.Fatal(err)
+	}
+	levels := func(want map[*p256k.Signer]string) {
+		t.Helper()
+		for sign, level := range want {
+			if got := w.GetAccessLevel(sign.Pub(), ""); got != level {
+				t.Errorf("%0x has %s access, want %s", sign.Pub(), got, level)
+			}
+		}
+	}
+	// bob has only one follower at depth 2, and henry is at depth 3
+	levels(
+		map[*p256k.Signer]string{
+			admin: "admin", alice: "write", frank: "write", carol: "write",
+			bob: "read", greg: "read", henry: "read",
+		},
+	)
+	stats := w.Stats()
+	if !reflect.DeepEqual(stats.Depths, []int{2, 1}) || stats.Total != 3 {
+		t.Fatalf("unexpected graph stats %+v", stats)
+	}
+	// a follow list from outside the graph changes nothing
+	w.Update(follows(greg, bob))
+	if w.Stats().Rebuilt != stats.Rebuilt {
+		t.Error("follow list from outside the graph rebuilt it")
+	}
+	// a new follow list in the graph updates it without the database
+	cfg.WotMinFollowers = 0
+	w.Update(follows(alice, bob, ivan))
+	levels(
+		map[*p256k.Signer]string{
+			bob: "write", ivan: "write", carol: "write", henry: "read",
+		},
+	)
+	if w.Stats().Total != 5 {
+		t.Errorf("expected 5 pubkeys in the graph, got %d", w.Stats().Total)
+	}
+	// follows added to the graph extend it without rebuilding it
+	stats = w.Stats()
+	w.Update(follows(frank, carol, greg))
+	levels(map[*p256k.Signer]string{greg: "write", henry: "read"})
+	if got := w.Stats(); got.Rebuilt != stats.Rebuilt ||
+		!reflect.DeepEqual(got.Depths, []int{2, 4}) || got.Total != 6 {
+		t.Errorf("unexpected graph stats after adding a follow %+v", got)
+	}
+}
@@ -0,0 +1,404 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"lol.mleku.dev/chk"
+	"lol.mleku.dev/errorf"
+	"lol.mleku.dev/log"
+	"next.orly.dev/pkg/database/indexes"
+	"next.orly.dev/pkg/database/indexes/types"
+	"next.orly.dev/pkg/encoders/event"
+	"next.orly.dev/pkg/encoders/hex"
+	"next.orly.dev/pkg/encoders/json"
+)
+
+// pendingSerials are the serials taken from the event sequence whose writes
+// have not finished. Writes commit out of the order of their serials, so the
+// change feed only passes the serials below the first pending one, or else
+// below next, and a reader never skips a serial that is committed after it
+// read past it.
+type pendingSerials struct {
+	sync.Mutex
+	set map[uint64]struct{}
+	// next is one more than the last serial taken, or found in the database
+	// when it was opened
+	next uint64
+}
+
+// initPending sets the serial after the last stored event or tombstone as
+// the end of the change feed, until the first serial is taken.
+func (d *D) initPending() (err error) {
+	d.pending.set = make(map[uint64]struct{})
+	return d.View(
+		func(txn *badger.Txn) (err error) {
+			for _, prefix := range []indexes.I{
+				indexes.EventPrefix, indexes.TombstonePrefix,
+			} {
+				it := txn.NewIterator(
+					badger.IteratorOptions{
+						Prefix: []byte(prefix), Reverse: true,
+					},
+				)
+				it.Seek(append([]byte(prefix), 0xff))
+				if it.Valid() {
+					var serial uint64
+					if serial, err = keySerial(it.Item().Key()); err != nil {
+						it.Close()
+						return
+					}
+					d.pending.next = max(d.pending.next, serial+1)
+				}
+				it.Close()
+			}
+			return
+		},
+	)
+}
+
+// nextSerial takes the next serial of the event sequence, which is pending
+// until done is called once the transaction that writes it has finished,
+// whether it committed or not.
+func (d *D) nextSerial() (serial uint64, done func(), err error) {
+	d.pending.Lock()
+	defer d.pending.Unlock()
+	if serial, err = d.seq.Next(); chk.E(err) {
+		return
+	}
+	d.pending.set[serial] = struct{}{}
+	d.pending.next = max(d.pending.next, serial+1)
+	done = func() {
+		d.pending.Lock()
+		defer d.pending.Unlock()
+		delete(d.pending.set, serial)
+	}
+	return
+}
+
+// committed returns the serial below which every serial taken from the
+// event sequence has been written or abandoned.
+func (d *D) committed() (end uint64) {
+	d.pending.Lock()
+	defer d.pending.Unlock()
+	end = d.pending.next
+	for serial := range d.pending.set {
+		end = min(end, serial)
+	}
+	return
+}
+
+// tombstoneValue returns the value of a tombstone, which is the unix time the
+// event was deleted at.
+func tombstoneValue(deleted time.Time) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(deleted.Unix()))
+}
+
+// RunTombstonePruning deletes the tombstones older than retention every hour,
+// until the database context is canceled. A retention of zero or less keeps
+// them forever.
+func (d *D) RunTombstonePruning(retention time.Duration) {
+	if retention <= 0 {
+		log.I.F("tombstone pruning disabled")
+		return
+	}
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+			var count int
+			var err error
+			if count, err = d.PruneTombstones(
+				time.Now().Add(-retention),
+			); chk.E(err) {
+				continue
+			}
+			if count > 0 {
+				log.I.F("pruned %d tombstones", count)
+			}
+		}
+	}
+}
+
+// PruneTombstones deletes the tombstones of events deleted before a time,
+// and returns how many were deleted. Consumers of the change feed that are
+// further behind than that miss those deletions. Tombstones written before
+// they recorded the time are given the current time, so they are pruned
+// after the same retention.
+func (d *D) PruneTombstones(before time.Time) (count int, err error) {
+	var prune, stamp [][]byte
+	if err = d.View(
+		func(txn *badger.Txn) (err error) {
+			it := txn.NewIterator(
+				badger.IteratorOptions{
+					Prefix: []byte(indexes.TombstonePrefix),
+				},
+			)
+			defer it.Close()
+			for it.Rewind(); it.Valid(); it.Next() {
+				item := it.Item()
+				var v []byte
+				if v, err = item.ValueCopy(nil); chk.E(err) {
+					return
+				}
+				switch {
+				case len(v) != 8:
+					stamp = append(stamp, item.KeyCopy(nil))
+				case int64(binary.BigEndian.Uint64(v)) < before.Unix():
+					prune = append(prune, item.KeyCopy(nil))
+				}
+			}
+			return
+		},
+	); chk.E(err) {
+		return
+	}
+	now := tombstoneValue(time.Now())
+	for _, key := range stamp {
+		if err = d.Update(
+			func(txn *badger.Txn) error { return txn.Set(key, now) },
+		); chk.E(err) {
+			return
+		}
+	}
+	for _, key := range prune {
+		if err = d.Update(
+			func(txn *badger.Txn) error { return txn.Delete(key) },
+		); chk.E(err) {
+			return
+		}
+		count++
+	}
+	return
+}
+
+// Change is an entry of the change feed: an event at the serial it was
+// stored with, or the tombstone of a deleted event at the serial taken when
+// it was deleted.
+type Change struct {
+	Serial uint64
+	// Event is the stored event, or nil for a tombstone.
+	Event *event.E
+	// Deleted is the ID of the deleted event of a tombstone.
+	Deleted []byte
+}
+
+// change is the JSON form of a Change.
+type change struct {
+	Serial  uint64          `json:"serial"`
+	Event   json.RawMessage `json:"event,omitempty"`
+	Deleted string          `json:"deleted,omitempty"`
+}
+
+// Marshal appends a change to a destination slice as a JSON object with the
+// serial and either the event or the hex ID of the deleted event.
+func (c *Change) Marshal(dst []byte) (b []byte) {
+	b = append(dst, `{"serial":`...)
+	b = strconv.AppendUint(b, c.Serial, 10)
+	if c.Event != nil {
+		b = append(b, `,"event":`...)
+		b = c.Event.Marshal(b)
+	} else {
+		b = append(b, `,"deleted":"`...)
+		b = hex.EncAppend(b, c.Deleted)
+		b = append(b, '"')
+	}
+	b = append(b, '}')
+	return
+}
+
+// Unmarshal reads a change from the JSON written by Marshal.
+func (c *Change) Unmarshal(b []byte) (err error) {
+	var j change
+	if err = json.Unmarshal(b, &j); err != nil {
+		return errorf.E("invalid change: %v", err)
+	}
+	*c = Change{Serial: j.Serial}
+	switch {
+	case len(j.Event) > 0:
+		c.Event = event.New()
+		if _, err = c.Event.Unmarshal(j.Event); err != nil {
+			return errorf.E("invalid event of change %d: %v", j.Serial, err)
+		}
+	case j.Deleted != "":
+		if c.Deleted, err = hex.Dec(j.Deleted); err != nil || len(c.Deleted) != 32 {
+			return errorf.E("invalid deleted id of change %d", j.Serial)
+		}
+	default:
+		return errorf.E("change %d has neither event nor deleted id", j.Serial)
+	}
+	return
+}
+
+// serialPrefix returns the prefix of an index followed by a serial, to seek to
+// the first key of the index with that serial or a greater one.
+func serialPrefix(prefix indexes.I, serial uint64) (b []byte, err error) {
+	buf := new(bytes.Buffer)
+	if _, err = prefix.Write(buf); chk.E(err) {
+		return
+	}
+	ser := new(types.Uint40)
+	if err = ser.Set(serial); chk.E(err) {
+		return
+	}
+	if err = ser.MarshalWrite(buf); chk.E(err) {
+		return
+	}
+	b = buf.Bytes()
+	return
+}
+
+// keySerial decodes the serial that follows the prefix of a key.
+func keySerial(key []byte) (serial uint64, err error) {
+	ser := new(types.Uint40)
+	if err = ser.UnmarshalRead(
+		bytes.NewBuffer(key[len(indexes.EventPrefix):]),
+	); chk.E(err) {
+		return
+	}
+	serial = ser.Get()
+	return
+}
+
+// Changes calls fn with the stored events and the tombstones of deleted
+// events with serials from a starting serial, in order of their serials,
+// until limit changes were read if it is not zero, fn returns an error or the
+// context is canceled. The event of a change is freed after fn returns.
+//
+// The serials start at zero, so a consumer that has read a change continues
+// from the serial after it. An event that was deleted is not in the feed at
+// its own serial, only as the tombstone, which consumers that read it before
+// can apply. Tombstones are kept for a retention period, see PruneTombstones.
+//
+// The feed ends before the first serial whose write has not finished, so a
+// change that commits after a consumer read past its serial is never
+// skipped; it is read on the next call instead.
+func (d *D) Changes(
+	c context.Context, from uint64, limit int,
+	fn func(ch *Change) (err error),
+) (err error) {
+	end := d.committed()
+	return d.View(
+		func(txn *badger.Txn) (err error) {
+			var evtSeek, tmbSeek []byte
+			if evtSeek, err = serialPrefix(
+				indexes.EventPrefix, from,
+			); err != nil {
+				return
+			}
+			if tmbSeek, err = serialPrefix(
+				indexes.TombstonePrefix, from,
+			); err != nil {
+				return
+			}
+			evts := txn.NewIterator(
+				badger.IteratorOptions{Prefix: []byte(indexes.EventPrefix)},
+			)
+			defer evts.Close()
+			tmbs := txn.NewIterator(
+				badger.IteratorOptions{
+					Prefix: []byte(indexes.TombstonePrefix),
+				},
+			)
+			defer tmbs.Close()
+			evts.Seek(evtSeek)
+			tmbs.Seek(tmbSeek)
+			var evtSer, tmbSer uint64
+			for n := 0; limit == 0 || n < limit; n++ {
+				if err = c.Err(); err != nil {
+					return
+				}
+				if evts.Valid() {
+					if evtSer, err = keySerial(evts.Item().Key()); err != nil {
+						return
+					}
+				}
+				if tmbs.Valid() {
+					if tmbSer, err = keySerial(tmbs.Item().Key()); err != nil {
+						return
+					}
+				}
+				if (!evts.Valid() || evtSer >= end) &&
+					(!tmbs.Valid() || tmbSer >= end) {
+					return
+				}
+				ch := new(Change)
+				switch {
+				case evts.Valid() && (!tmbs.Valid() || evtSer < tmbSer):
+					ch.Serial = evtSer
+					var v []byte
+					if v, err = evts.Item().ValueCopy(nil); chk.E(err) {
+						return
+					}
+					ch.Event = event.New()
+					if err = ch.Event.UnmarshalBinary(
+						bytes.NewBuffer(v),
+					); chk.E(err) {
+						return
+					}
+					evts.Next()
+				case tmbs.Valid():
+					ser, fid := indexes.TombstoneVars()
+					if err = indexes.TombstoneDec(ser, fid).UnmarshalRead(
+						bytes.NewBuffer(tmbs.Item().Key()),
+					); chk.E(err) {
+						return
+					}
+					ch.Serial = ser.Get()
+					ch.Deleted = fid.Bytes()
+					tmbs.Next()
+				default:
+					return
+				}
+				err = fn(ch)
+				if ch.Event != nil {
+					ch.Event.Free()
+				}
+				if err != nil {
+					return
+				}
+			}
+			return
+		},
+	)
+}
+
+// EventIdsBySerial returns the IDs of up to count stored events with serials
+// from start, in order of their serials, read from the FullIdPubkey index.
+func (d *D) EventIdsBySerial(start uint64, count int) (
+	evs [][]byte, err error,
+) {
+	var seek []byte
+	if seek, err = serialPrefix(indexes.FullIdPubkeyPrefix, start); err != nil {
+		return
+	}
+	err = d.View(
+		func(txn *badger.Txn) (err error) {
+			it := txn.NewIterator(
+				badger.IteratorOptions{
+					Prefix: []byte(indexes.FullIdPubkeyPrefix),
+				},
+			)
+			defer it.Close()
+			for it.Seek(seek); it.Valid() && len(evs) < count; it.Next() {
+				ser, fid, p, ca := indexes.FullIdPubkeyVars()
+				if err = indexes.FullIdPubkeyDec(
+					ser, fid, p, ca,
+				).UnmarshalRead(bytes.NewBuffer(it.Item().Key())); chk.E(err) {
+					return
+				}
+				evs = append(evs, fid.Bytes())
+			}
+			return
+		},
+	)
+	return
+}
@@ -0,0 +1,269 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"next.orly.dev/pkg/crypto/p256k"
+	"next.orly.dev/pkg/encoders/event"
+	"next.orly.dev/pkg/encoders/event/examples"
+	"next.orly.dev/pkg/encoders/timestamp"
+	"next.orly.dev/pkg/utils"
+)
+
+// readChanges returns copies of the changes from a serial.
+func readChanges(
+	t *testing.T, d *D, from uint64, limit int,
+) (changes []*Change) {
+	t.Helper()
+	if err := d.Changes(
+		context.Background(), from, limit, func(ch *Change) (err error) {
+			c := &Change{Serial: ch.Serial, Deleted: ch.Deleted}
+			if ch.Event != nil {
+				c.Event = ch.Event.Clone()
+			}
+			changes = append(changes, c)
+			return
+		},
+	); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestChanges(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, err := New(ctx, cancel, t.TempDir(), "error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err = db.ImportEvents(bytes.NewBuffer(examples.Cache)); err != nil {
+		t.Fatal(err)
+	}
+	// importing may already have replaced events, leaving tombstones
+	changes := readChanges(t, db, 0, 0)
+	var stored []*Change
+	for i, ch := range changes {
+		if i > 0 && ch.Serial <= changes[i-1].Serial {
+			t.Fatalf("serial %d after %d", ch.Serial, changes[i-1].Serial)
+		}
+		if ch.Event != nil {
+			stored = append(stored, ch)
+		}
+	}
+	if len(stored) < 3 {
+		t.Fatalf("expected events in the feed, got %d", len(stored))
+	}
+	// the IDs by serial are those of the feed
+	ids, err := db.EventIdsBySerial(stored[1].Serial, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || !utils.FastEqual(ids[0], stored[1].Event.ID) ||
+		!utils.FastEqual(ids[1], stored[2].Event.ID) {
+		t.Errorf("unexpected ids by serial %x", ids)
+	}
+	// a deleted event is replaced by a tombstone after the other changes
+	deleted := stored[0].Event
+	if err = db.DeleteEvent(ctx, deleted.ID); err != nil {
+		t.Fatal(err)
+	}
+	last := changes[len(changes)-1].Serial
+	after := readChanges(t, db, last+1, 0)
+	if len(after) != 1 || after[0].Event != nil ||
+		!utils.FastEqual(after[0].Deleted, deleted.ID) {
+		t.Fatalf("expected a tombstone of %x, got %+v", deleted.ID, after)
+	}
+	for _, ch := range readChanges(t, db, 0, 0) {
+		if ch.Event != nil && utils.FastEqual(ch.Event.ID, deleted.ID) {
+			t.Errorf("deleted event is still in the feed")
+		}
+	}
+	if limited := readChanges(t, db, 0, 2); len(limited) != 2 {
+		t.Errorf("expected 2 changes with a limit, got %d", len(limited))
+	}
+	// changes survive a round trip through their JSON
+	for _, ch := range []*Change{stored[1], after[0]} {
+		got := new(Change)
+		if err = got.Unmarshal(ch.Marshal(nil)); err != nil {
+			t.Fatal(err)
+		}
+		if got.Serial != ch.Serial ||
+			!utils.FastEqual(got.Deleted, ch.Deleted) ||
+			(ch.Event != nil &&
+				(got.Event == nil || !utils.FastEqual(got.Event.ID, ch.Event.ID))) {
+			t.Errorf("change %s did not round trip", ch.Marshal(nil))
+		}
+	}
+}
+
+// newNote returns a signed text note with a content.
+func newNote(t *testing.T, sign *p256k.Signer, content string) (ev *event.E) {
+	ev = event.New()
+	ev.Kind = 1
+	ev.CreatedAt = timestamp.Now().V
+	ev.Content = []byte(content)
+	if err := ev.Sign(sign); err != nil {
+		t.Error(err)
+	}
+	return
+}
+
+// TestChangesConcurrent reads the feed while events are saved and deleted
+// concurrently, and checks that a reader continuing from its cursor reads
+// every change that a full read finds afterwards.
+func TestChangesConcurrent(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, err := New(ctx, cancel, t.TempDir(), "error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	sign := new(p256k.Signer)
+	if err = sign.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	var writers sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		writers.Add(1)
+		go func() {
+			defer writers.Done()
+			for i := 0; i < 50; i++ {
+				ev := newNote(t, sign, strconv.Itoa(w)+":"+strconv.Itoa(i))
+				if _, _, err := db.SaveEvent(ctx, ev); err != nil {
+					t.Error(err)
+					return
+				}
+				if i%10 == 0 {
+					if err := db.DeleteEvent(ctx, ev.ID); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}
+		}()
+	}
+	stopped := make(chan struct{})
+	go func() {
+		writers.Wait()
+		close(stopped)
+	}()
+	read := make(map[uint64]struct{})
+	var from uint64
+	follow := func() {
+		for _, ch := range readChanges(t, db, from, 0) {
+			if ch.Serial < from {
+				t.Fatalf("serial %d read after %d", ch.Serial, from)
+			}
+			read[ch.Serial] = struct{}{}
+			from = ch.Serial + 1
+		}
+	}
+	for done := false; !done; {
+		select {
+		case <-stopped:
+			done = true
+		case <-time.After(time.Millisecond):
+		}
+		follow()
+	}
+	follow()
+	all := readChanges(t, db, 0, 0)
+	for _, ch := range all {
+		if _, ok := read[ch.Serial]; !ok {
+			t.Errorf("the reader skipped the change at serial %d", ch.Serial)
+		}
+	}
+	if len(all) != 8*50 {
+		t.Errorf("expected %d changes, got %d", 8*50, len(all))
+	}
+}
+
+func TestChangesPending(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, err := New(ctx, cancel, t.TempDir(), "error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	sign := new(p256k.Signer)
+	if err = sign.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	// a serial that is taken but not yet written holds back the later ones
+	_, done, err := db.nextSerial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = db.SaveEvent(ctx, newNote(t, sign, "later")); err != nil {
+		t.Fatal(err)
+	}
+	if changes := readChanges(t, db, 0, 0); len(changes) != 0 {
+		t.Fatalf("the feed passed a pending serial: %+v", changes)
+	}
+	done()
+	if changes := readChanges(t, db, 0, 0); len(changes) != 1 {
+		t.Fatalf(
+			"expected the saved event after the pending serial, got %d",
+			len(changes),
+		)
+	}
+	// the end of the feed is found again when the database is opened
+	db2 := &D{DB: db.DB}
+	if err = db2.initPending(); err != nil {
+		t.Fatal(err)
+	}
+	if db2.committed() != db.committed() {
+		t.Errorf(
+			"end of the feed %d when opened, %d before", db2.committed(),
+			db.committed(),
+		)
+	}
+}
+
+func TestPruneTombstones(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, err := New(ctx, cancel, t.TempDir(), "error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	sign := new(p256k.Signer)
+	if err = sign.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	ev := newNote(t, sign, "deleted")
+	if _, _, err = db.SaveEvent(ctx, ev); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.DeleteEvent(ctx, ev.ID); err != nil {
+		t.Fatal(err)
+	}
+	tombstones := func() (n int) {
+		for _, ch := range readChanges(t, db, 0, 0) {
+			if ch.Event == nil {
+				n++
+			}
+		}
+		return
+	}
+	if n, err := db.PruneTombstones(
+		time.Now().Add(-time.Hour),
+	); err != nil || n != 0 || tombstones() != 1 {
+		t.Fatalf("a recent tombstone was pruned: %d, %v", n, err)
+	}
+	if n, err := db.PruneTombstones(
+		time.Now().Add(time.Hour),
+	); err != nil || n != 1 || tombstones() != 0 {
+		t.Fatalf("an old tombstone was not pruned: %d, %v", n, err)
+	}
+}
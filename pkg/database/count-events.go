@@ -0,0 +1,161 @@
+package database
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"lol.mleku.dev/chk"
+	"next.orly.dev/pkg/database/indexes/types"
+	"next.orly.dev/pkg/encoders/filter"
+	"next.orly.dev/pkg/encoders/hex"
+	"next.orly.dev/pkg/encoders/kind"
+	"next.orly.dev/pkg/encoders/tag"
+	"next.orly.dev/pkg/interfaces/store"
+	"next.orly.dev/pkg/utils"
+)
+
+// CountEvents returns the number of stored events that match a filter. The
+// count is computed from the index keys only, so no event bodies are fetched
+// or decoded. The filter limit is ignored because a NIP-45 count is over the
+// whole result set.
+func (d *D) CountEvents(c context.Context, f *filter.F) (count int, err error) {
+	var set map[uint64]struct{}
+	if set, err = d.QueryForSerialSet(c, f); chk.E(err) {
+		return
+	}
+	count = len(set)
+	return
+}
+
+// QueryForSerialSet returns the set of serials of events that match a filter,
+// ignoring the filter's limit.
+//
+// QueryForSerials resolves each tag of a filter to its own index range and
+// returns the union of them. Here each tag is resolved on its own and the
+// results are intersected, so a filter with several tags yields the same
+// matches as the filter itself.
+//
+// A filter with Ids only resolves the Ids, the same as QueryForSerials.
+func (d *D) QueryForSerialSet(c context.Context, f *filter.F) (
+	set map[uint64]struct{}, err error,
+) {
+	ff := *f
+	ff.Limit = nil
+	if ff.Ids.Len() > 0 || ff.Tags.Len() < 2 {
+		var sers types.Uint40s
+		if sers, err = d.QueryForSerials(c, &ff); chk.E(err) {
+			return
+		}
+		set = make(map[uint64]struct{}, len(sers))
+		for _, ser := range sers {
+			set[ser.Get()] = struct{}{}
+		}
+		return
+	}
+	for _, t := range *ff.Tags {
+		if t.Len() < 2 {
+			continue
+		}
+		ft := ff
+		ft.Tags = tag.NewS(t)
+		var sers types.Uint40s
+		if sers, err = d.QueryForSerials(c, &ft); chk.E(err) {
+			return
+		}
+		if set == nil {
+			set = make(map[uint64]struct{}, len(sers))
+			for _, ser := range sers {
+				set[ser.Get()] = struct{}{}
+			}
+			continue
+		}
+		next := make(map[uint64]struct{})
+		for _, ser := range sers {
+			if _, ok := set[ser.Get()]; ok {
+				next[ser.Get()] = struct{}{}
+			}
+		}
+		set = next
+		if len(set) == 0 {
+			return
+		}
+	}
+	if set == nil {
+		set = make(map[uint64]struct{})
+	}
+	return
+}
+
+// DropExpiredDeleted removes from a set of serials the events that QueryEvents
+// would not return, because their NIP-40 expiration has passed or a deletion
+// event of their author names their id. Both are found from the indexes, so
+// no event bodies are fetched.
+func (d *D) DropExpiredDeleted(set map[uint64]struct{}) (err error) {
+	if len(set) == 0 {
+		return
+	}
+	var expired types.Uint40s
+	if expired, err = d.ExpiredSerials(time.Now().Unix()); chk.E(err) {
+		return
+	}
+	for _, ser := range expired {
+		delete(set, ser.Get())
+	}
+	sers := make(types.Uint40s, 0, len(set))
+	for ser := range set {
+		s := new(types.Uint40)
+		if err = s.Set(ser); chk.E(err) {
+			return
+		}
+		sers = append(sers, s)
+	}
+	sort.Slice(sers, func(i, j int) bool { return sers[i].Get() < sers[j].Get() })
+	var fidpks []*store.IdPkTs
+	if fidpks, err = d.GetFullIdPubkeyBySerials(sers); chk.E(err) {
+		return
+	}
+	for _, fidpk := range fidpks {
+		var deleted bool
+		if deleted, err = d.deletedById(fidpk); chk.E(err) {
+			return
+		}
+		if deleted {
+			delete(set, fidpk.Ser)
+		}
+	}
+	return
+}
+
+// deletedById returns whether a deletion event of the same author as an event
+// names it in an e tag, comparing the pubkey hashes of the FullIdPubkey index.
+func (d *D) deletedById(fidpk *store.IdPkTs) (deleted bool, err error) {
+	var idxs []Range
+	if idxs, err = GetIndexesFromFilter(
+		&filter.F{
+			Kinds: kind.NewS(kind.Deletion),
+			Tags:  tag.NewS(tag.NewFromAny("#e", hex.Enc(fidpk.Id))),
+		},
+	); chk.E(err) {
+		return
+	}
+	for _, idx := range idxs {
+		var sers types.Uint40s
+		if sers, err = d.GetSerialsByRange(idx); chk.E(err) {
+			return
+		}
+		if len(sers) == 0 {
+			continue
+		}
+		var dels []*store.IdPkTs
+		if dels, err = d.GetFullIdPubkeyBySerials(sers); chk.E(err) {
+			return
+		}
+		for _, del := range dels {
+			if utils.FastEqual(del.Pub, fidpk.Pub) {
+				return true, nil
+			}
+		}
+	}
+	return
+}
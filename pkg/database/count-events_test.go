@@ -0,0 +1,183 @@
+package database
+
+import (
+	"os"
+	"strconv"
+	"testing"
+
+	"lol.mleku.dev/chk"
+	"next.orly.dev/pkg/crypto/p256k"
+	"next.orly.dev/pkg/encoders/event"
+	"next.orly.dev/pkg/encoders/filter"
+	"next.orly.dev/pkg/encoders/hex"
+	"next.orly.dev/pkg/encoders/kind"
+	"next.orly.dev/pkg/encoders/tag"
+	"next.orly.dev/pkg/encoders/timestamp"
+	"next.orly.dev/pkg/utils/values"
+)
+
+func TestCountEvents(t *testing.T) {
+	db, ctx, cancel, tempDir := newTestDB(t)
+	defer func() {
+		cancel()
+		db.Close()
+		os.RemoveAll(tempDir)
+	}()
+
+	sign := new(p256k.Signer)
+	if err := sign.Generate(); chk.E(err) {
+		t.Fatalf("signer generate: %v", err)
+	}
+	now := timestamp.Now().V
+	// five notes tagged t=a, of which the even ones are also tagged t=b and
+	// the first three are tagged x=c
+	for i := 0; i < 5; i++ {
+		ev := event.New()
+		ev.Kind = kind.TextNote.K
+		ev.Pubkey = sign.Pub()
+		ev.CreatedAt = now - int64(i)
+		ev.Content = []byte("count me")
+		ev.Tags = tag.NewS(tag.NewFromAny("t", "a"))
+		if i%2 == 0 {
+			ev.Tags.Append(tag.NewFromAny("t", "b"))
+		}
+		if i < 3 {
+			ev.Tags.Append(tag.NewFromAny("x", "c"))
+		}
+		if err := ev.Sign(sign); chk.E(err) {
+			t.Fatal(err)
+		}
+		if _, _, err := db.SaveEvent(ctx, ev); err != nil {
+			t.Fatalf("save event %d: %v", i, err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		f    *filter.F
+		want int
+	}{
+		{
+			name: "kind",
+			f:    &filter.F{Kinds: kind.NewS(kind.TextNote)},
+			want: 5,
+		},
+		{
+			name: "limit is ignored",
+			f: &filter.F{
+				Kinds: kind.NewS(kind.TextNote),
+				Limit: values.ToUintPointer(2),
+			},
+			want: 5,
+		},
+		{
+			name: "single tag",
+			f: &filter.F{
+				Tags: tag.NewS(tag.NewFromAny("#t", "b")),
+			},
+			want: 3,
+		},
+		{
+			name: "tags are intersected",
+			f: &filter.F{
+				Kinds: kind.NewS(kind.TextNote),
+				Tags: tag.NewS(
+					tag.NewFromAny("#t", "b"),
+					tag.NewFromAny("#x", "c"),
+				),
+			},
+			want: 2,
+		},
+		{
+			name: "no match",
+			f:    &filter.F{Kinds: kind.NewS(kind.Reaction)},
+			want: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				count, err := db.CountEvents(ctx, tt.f)
+				if err != nil {
+					t.Fatalf("CountEvents: %v", err)
+				}
+				if count != tt.want {
+					t.Fatalf("got count %d, want %d", count, tt.want)
+				}
+			},
+		)
+	}
+}
+
+func TestDropExpiredDeleted(t *testing.T) {
+	db, ctx, cancel, tempDir := newTestDB(t)
+	defer func() {
+		cancel()
+		db.Close()
+		os.RemoveAll(tempDir)
+	}()
+
+	author, other := new(p256k.Signer), new(p256k.Signer)
+	for _, sign := range []*p256k.Signer{author, other} {
+		if err := sign.Generate(); chk.E(err) {
+			t.Fatalf("signer generate: %v", err)
+		}
+	}
+	now := timestamp.Now().V
+	save := func(sign *p256k.Signer, k uint16, tags *tag.S) (ev *event.E) {
+		ev = event.New()
+		ev.Kind = k
+		ev.CreatedAt = now
+		ev.Content = []byte("drop me")
+		ev.Tags = tags
+		if err := ev.Sign(sign); chk.E(err) {
+			t.Fatal(err)
+		}
+		if _, _, err := db.SaveEvent(ctx, ev); err != nil {
+			t.Fatalf("save event: %v", err)
+		}
+		return
+	}
+	kept := save(author, kind.TextNote.K, tag.NewS())
+	expired := save(
+		author, kind.TextNote.K, tag.NewS(
+			tag.NewFromAny("expiration", strconv.FormatInt(now-10, 10)),
+		),
+	)
+	deleted := save(author, kind.TextNote.K, tag.NewS())
+	// a deletion by another pubkey does not count
+	forged := save(author, kind.TextNote.K, tag.NewS())
+	save(
+		author, kind.Deletion.K,
+		tag.NewS(tag.NewFromAny("e", hex.Enc(deleted.ID))),
+	)
+	save(
+		other, kind.Deletion.K,
+		tag.NewS(tag.NewFromAny("e", hex.Enc(forged.ID))),
+	)
+	set, err := db.QueryForSerialSet(
+		ctx, &filter.F{Kinds: kind.NewS(kind.TextNote)},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set) != 4 {
+		t.Fatalf("got %d notes, want 4", len(set))
+	}
+	if err = db.DropExpiredDeleted(set); err != nil {
+		t.Fatal(err)
+	}
+	for _, ev := range []*event.E{kept, forged} {
+		ser, err := db.GetSerialById(ev.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := set[ser.Get()]; !ok {
+			t.Errorf("event %0x was dropped", ev.ID)
+		}
+	}
+	if len(set) != 2 {
+		t.Errorf("got %d notes, want 2 without %0x and %0x", len(set),
+			expired.ID, deleted.ID)
+	}
+}
@@ -2,10 +2,8 @@ package database
 
 import (
 	"context"
-	"errors"
 	"os"
 	"path/filepath"
-	"time"
 
 	"github.com/dgraph-io/badger/v4"
 	"github.com/dgraph-io/badger/v4/options"
@@ -23,6 +21,10 @@ type D struct {
 	Logger  *logger
 	*badger.DB
 	seq *badger.Sequence
+	// the serials taken from seq whose writes have not finished
+	pending pendingSerials
+	// the relay management lists, kept in memory
+	managed managedLists
 }
 
 func New(
@@ -75,19 +77,18 @@ func New(
 	if d.seq, err = d.DB.GetSequence([]byte("EVENTS"), 1000); chk.E(err) {
 		return
 	}
+	if err = d.initPending(); chk.E(err) {
+		return
+	}
 	// run code that updates indexes when new indexes have been added and bumps
 	// the version so they aren't run again.
 	d.RunMigrations()
-	// start up the expiration tag processing and shut down and clean up the
-	// database after the context is canceled.
+	if err = d.loadManaged(); chk.E(err) {
+		return
+	}
+	// shut down and clean up the database after the context is canceled.
 	go func() {
-		expirationTicker := time.NewTicker(time.Minute * 10)
-		select {
-		case <-expirationTicker.C:
-			d.DeleteExpired()
-			return
-		case <-d.ctx.Done():
-		}
+		<-d.ctx.Done()
 		d.cancel()
 		// d.seq.Release()
 		// d.DB.Close()
@@ -98,22 +99,10 @@ func New(
 // Path returns the path where the database files are stored.
 func (d *D) Path() string { return d.dataDir }
 
-func (d *D) Wipe() (err error) {
-	err = errors.New("not implemented")
-	return
-}
-
 func (d *D) SetLogLevel(level string) {
 	d.Logger.SetLogLevel(lol.GetLogLevel(level))
 }
 
-func (d *D) EventIdsBySerial(start uint64, count int) (
-	evs []uint64, err error,
-) {
-	err = errors.New("not implemented")
-	return
-}
-
 // Init initializes the database with the given path.
 func (d *D) Init(path string) (err error) {
 	// The database is already initialized in the New function,
@@ -3,6 +3,7 @@ package database
 import (
 	"bytes"
 	"context"
+	"time"
 
 	"github.com/dgraph-io/badger/v4"
 	"lol.mleku.dev/chk"
@@ -56,6 +57,14 @@ func (d *D) DeleteEventBySerial(
 	if err = indexes.EventEnc(ser).MarshalWrite(eventKey); chk.E(err) {
 		return
 	}
+	// Record the deletion for the change feed with a new serial, so it comes
+	// after the events stored before it
+	var tombstone []byte
+	var done func()
+	if tombstone, done, err = d.tombstoneKey(ev.ID); chk.E(err) {
+		return
+	}
+	defer done()
 	// Delete the event and all its indexes in a transaction
 	err = d.Update(
 		func(txn *badger.Txn) (err error) {
@@ -69,8 +78,39 @@ func (d *D) DeleteEventBySerial(
 					return
 				}
 			}
+			if err = txn.Set(tombstone, tombstoneValue(time.Now())); chk.E(err) {
+				return
+			}
 			return
 		},
 	)
 	return
 }
+
+// tombstoneKey returns the key of a tombstone of a deleted event, with the
+// next serial of the event sequence, and the function to call once it is
+// written.
+func (d *D) tombstoneKey(id []byte) (key []byte, done func(), err error) {
+	var serial uint64
+	if serial, done, err = d.nextSerial(); chk.E(err) {
+		return
+	}
+	defer func() {
+		if err != nil {
+			done()
+		}
+	}()
+	ser, fid := indexes.TombstoneVars()
+	if err = ser.Set(serial); chk.E(err) {
+		return
+	}
+	if err = fid.FromId(id); chk.E(err) {
+		return
+	}
+	buf := new(bytes.Buffer)
+	if err = indexes.TombstoneEnc(ser, fid).MarshalWrite(buf); chk.E(err) {
+		return
+	}
+	key = buf.Bytes()
+	return
+}
@@ -7,21 +7,71 @@ import (
 
 	"github.com/dgraph-io/badger/v4"
 	"lol.mleku.dev/chk"
+	"lol.mleku.dev/log"
 	"next.orly.dev/pkg/database/indexes"
 	"next.orly.dev/pkg/database/indexes/types"
 	"next.orly.dev/pkg/encoders/event"
 )
 
-func (d *D) DeleteExpired() {
-	var err error
-	var expiredSerials types.Uint40s
+// RunExpiration sweeps the expiration index for events whose NIP-40
+// expiration has passed every interval, until the database context is
+// canceled. An interval of zero or less disables the sweep.
+func (d *D) RunExpiration(interval time.Duration) {
+	if interval <= 0 {
+		log.I.F("expiration sweep disabled")
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+			var count int
+			var err error
+			if count, err = d.DeleteExpired(); chk.E(err) {
+				continue
+			}
+			if count > 0 {
+				log.I.F("expiration sweep purged %d events", count)
+			}
+		}
+	}
+}
+
+// DeleteExpired deletes all events with an expiration timestamp that is now
+// past, and returns how many were deleted.
+func (d *D) DeleteExpired() (count int, err error) {
 	// make the operation atomic and save on accesses to the system clock by
 	// setting the boundary at the current second
-	now := time.Now().Unix()
+	var expiredSerials types.Uint40s
+	if expiredSerials, err = d.ExpiredSerials(time.Now().Unix()); chk.E(err) {
+		return
+	}
+	// delete the events and their indexes
+	for _, ser := range expiredSerials {
+		var ev *event.E
+		var e error
+		if ev, e = d.FetchEventBySerial(ser); chk.E(e) {
+			continue
+		}
+		if e = d.DeleteEventBySerial(
+			context.Background(), ser, ev,
+		); chk.E(e) {
+			continue
+		}
+		count++
+	}
+	return
+}
+
+// ExpiredSerials returns the serials of the events with an expiration
+// timestamp at or before now, from the expiration index.
+func (d *D) ExpiredSerials(now int64) (expiredSerials types.Uint40s, err error) {
 	// search the expiration indexes for expiry timestamps that are now past
 	if err = d.View(
 		func(txn *badger.Txn) (err error) {
-			exp, ser := indexes.ExpirationVars()
 			expPrf := new(bytes.Buffer)
 			if _, err = indexes.ExpirationPrefix.Write(expPrf); chk.E(err) {
 				return
@@ -32,31 +82,25 @@ func (d *D) DeleteExpired() {
 				item := it.Item()
 				key := item.Key()
 				buf := bytes.NewBuffer(key)
+				// each serial is kept, so it needs its own variable
+				exp, ser := indexes.ExpirationVars()
 				if err = indexes.ExpirationDec(
 					exp, ser,
 				).UnmarshalRead(buf); chk.E(err) {
+					err = nil
 					continue
 				}
 				if int64(exp.Get()) > now {
-					// not expired yet
-					continue
+					// the keys are sorted by expiry, so the rest are not
+					// expired yet
+					break
 				}
 				expiredSerials = append(expiredSerials, ser)
 			}
 			return
 		},
 	); chk.E(err) {
+		return
 	}
-	// delete the events and their indexes
-	for _, ser := range expiredSerials {
-		var ev *event.E
-		if ev, err = d.FetchEventBySerial(ser); chk.E(err) {
-			continue
-		}
-		if err = d.DeleteEventBySerial(
-			context.Background(), ser, ev,
-		); chk.E(err) {
-			continue
-		}
-	}
+	return
 }
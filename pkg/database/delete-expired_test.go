@@ -0,0 +1,70 @@
+package database
+
+import (
+	"os"
+	"strconv"
+	"testing"
+
+	"lol.mleku.dev/chk"
+	"next.orly.dev/pkg/crypto/p256k"
+	"next.orly.dev/pkg/encoders/event"
+	"next.orly.dev/pkg/encoders/kind"
+	"next.orly.dev/pkg/encoders/tag"
+	"next.orly.dev/pkg/encoders/timestamp"
+)
+
+func TestDeleteExpired(t *testing.T) {
+	db, ctx, cancel, tempDir := newTestDB(t)
+	defer func() {
+		cancel()
+		db.Close()
+		os.RemoveAll(tempDir)
+	}()
+
+	sign := new(p256k.Signer)
+	if err := sign.Generate(); chk.E(err) {
+		t.Fatal(err)
+	}
+	now := timestamp.Now().V
+	var evs []*event.E
+	// one event that expired an hour ago and one that expires in an hour
+	for _, exp := range []int64{now - 3600, now + 3600} {
+		ev := event.New()
+		ev.Kind = kind.TextNote.K
+		ev.Pubkey = sign.Pub()
+		ev.CreatedAt = now - 7200
+		ev.Content = []byte("expiring")
+		ev.Tags = tag.NewS(
+			tag.NewFromAny("expiration", strconv.FormatInt(exp, 10)),
+		)
+		if err := ev.Sign(sign); chk.E(err) {
+			t.Fatal(err)
+		}
+		if _, _, err := db.SaveEvent(ctx, ev); err != nil {
+			t.Fatalf("Failed to save event: %v", err)
+		}
+		evs = append(evs, ev)
+	}
+
+	count, err := db.DeleteExpired()
+	if err != nil {
+		t.Fatalf("DeleteExpired: %v", err)
+	}
+	if count != 1 {
+		t.Fatalf("Expected 1 event purged, got %d", count)
+	}
+	if ser, err := db.GetSerialById(evs[0].ID); err == nil && ser != nil {
+		t.Fatal("expired event is still in the database")
+	}
+	if _, err = db.GetSerialById(evs[1].ID); err != nil {
+		t.Fatalf("unexpired event was deleted: %v", err)
+	}
+
+	// a second sweep has nothing left to do
+	if count, err = db.DeleteExpired(); err != nil {
+		t.Fatalf("DeleteExpired: %v", err)
+	}
+	if count != 0 {
+		t.Fatalf("Expected 0 events purged, got %d", count)
+	}
+}
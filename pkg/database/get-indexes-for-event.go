@@ -7,6 +7,7 @@ import (
 	"next.orly.dev/pkg/database/indexes"
 	. "next.orly.dev/pkg/database/indexes/types"
 	"next.orly.dev/pkg/encoders/event"
+	"next.orly.dev/pkg/encoders/ints"
 )
 
 // appendIndexBytes marshals an index to a byte slice and appends it to the idxs slice
@@ -153,6 +154,20 @@ func GetIndexesForEvent(ev *event.E, serial uint64) (
 	if err = appendIndexBytes(&idxs, kindPubkeyIndex); chk.E(err) {
 		return
 	}
+	// Expiration index, for events with a NIP-40 expiration tag
+	if ev.Tags != nil {
+		if expTag := ev.Tags.GetFirst([]byte("expiration")); expTag != nil {
+			expTS := ints.New(0)
+			if _, e := expTS.Unmarshal(expTag.Value()); e == nil {
+				exp := new(Uint64)
+				exp.Set(expTS.N)
+				expIndex := indexes.ExpirationEnc(exp, ser)
+				if err = appendIndexBytes(&idxs, expIndex); chk.E(err) {
+					return
+				}
+			}
+		}
+	}
 
 	// Word token indexes (from content)
 	if len(ev.Content) > 0 {
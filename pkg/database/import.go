@@ -31,58 +31,62 @@ func (d *D) Import(rr io.Reader) {
 	}
 
 	go func() {
-		var err error
-		// Create a scanner to read the buffer line by line
-		scan := bufio.NewScanner(tmp)
-		scanBuf := make([]byte, maxLen)
-		scan.Buffer(scanBuf, maxLen)
+		if _, err := d.ImportEvents(tmp); chk.E(err) {
+		}
+	}()
 
-		var count, total int
-		for scan.Scan() {
-			select {
-			case <-d.ctx.Done():
-				log.I.F("context closed")
-				return
-			default:
-			}
+	return
+}
 
-			b := scan.Bytes()
-			total += len(b) + 1
-			if len(b) < 1 {
-				continue
-			}
+// ImportEvents saves the events in a stream of line structured minified JSON
+// (JSONL), skipping lines that are not valid events or are rejected by
+// SaveEvent, and returns the number of events saved.
+func (d *D) ImportEvents(r io.Reader) (count int, err error) {
+	// Create a scanner to read the buffer line by line
+	scan := bufio.NewScanner(r)
+	scanBuf := make([]byte, maxLen)
+	scan.Buffer(scanBuf, maxLen)
 
-			ev := event.New()
-			if _, err = ev.Unmarshal(b); err != nil {
-				// return the pooled buffer on error
-				ev.Free()
-				continue
-			}
+	var total int
+	for scan.Scan() {
+		select {
+		case <-d.ctx.Done():
+			log.I.F("context closed")
+			err = d.ctx.Err()
+			return
+		default:
+		}
 
-			if _, _, err = d.SaveEvent(d.ctx, ev); err != nil {
-				// return the pooled buffer on error paths too
-				ev.Free()
-				continue
-			}
+		b := scan.Bytes()
+		total += len(b) + 1
+		if len(b) < 1 {
+			continue
+		}
 
-			// return the pooled buffer after successful save
+		ev := event.New()
+		if _, err = ev.Unmarshal(b); err != nil {
+			// return the pooled buffer on error
 			ev.Free()
-			b = nil
-			count++
-			if count%100 == 0 {
-				log.I.F("received %d events", count)
-				debug.FreeOSMemory()
-			}
+			continue
 		}
 
-		log.I.F("read %d bytes and saved %d events", total, count)
-		err = scan.Err()
-		if chk.E(err) {
+		if _, _, err = d.SaveEvent(d.ctx, ev); err != nil {
+			// return the pooled buffer on error paths too
+			ev.Free()
+			continue
 		}
 
-		// Help garbage collection
-		tmp = nil
-	}()
+		// return the pooled buffer after successful save
+		ev.Free()
+		b = nil
+		count++
+		if count%100 == 0 {
+			log.I.F("received %d events", count)
+			debug.FreeOSMemory()
+		}
+	}
 
+	log.I.F("read %d bytes and saved %d events", total, count)
+	err = scan.Err()
 	return
 }
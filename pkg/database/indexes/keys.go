@@ -72,6 +72,7 @@ const (
 	WordPrefix      = I("wrd") // word hash, serial
 	ExpirationPrefix = I("exp") // timestamp of expiration
 	VersionPrefix    = I("ver") // database version number, for triggering reindexes when new keys are added (policy is add-only).
+	TombstonePrefix  = I("tmb") // serial of a deletion, id of the deleted event
 )
 
 // Prefix returns the three byte human-readable prefixes that go in front of
@@ -107,6 +108,8 @@ func Prefix(prf int) (i I) {
 		return ExpirationPrefix
 	case Version:
 		return VersionPrefix
+	case Tombstone:
+		return TombstonePrefix
 	case Word:
 		return WordPrefix
 	}
@@ -152,6 +155,8 @@ func Identify(r io.Reader) (i int, err error) {
 		i = Expiration
 	case WordPrefix:
 		i = Word
+	case TombstonePrefix:
+		i = Tombstone
 	}
 	return
 }
@@ -457,3 +462,20 @@ func VersionDec(
 ) (enc *T) {
 	return New(NewPrefix(), ver)
 }
+
+// Tombstone records the deletion of an event in the order of the serials of
+// events, with a serial taken from the event sequence when it is deleted, so
+// the change feed can report it after the events stored before.
+//
+//	3 prefix|5 serial|32 ID
+var Tombstone = next()
+
+func TombstoneVars() (ser *types.Uint40, fid *types.Id) {
+	return new(types.Uint40), new(types.Id)
+}
+func TombstoneEnc(ser *types.Uint40, fid *types.Id) (enc *T) {
+	return New(NewPrefix(Tombstone), ser, fid)
+}
+func TombstoneDec(ser *types.Uint40, fid *types.Id) (enc *T) {
+	return New(NewPrefix(), ser, fid)
+}
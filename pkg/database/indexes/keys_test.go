@@ -97,6 +97,7 @@ func TestPrefixFunction(t *testing.T) {
 			"TagKindPubkey", TagKindPubkey,
 			TagKindPubkeyPrefix,
 		},
+		{"Tombstone", Tombstone, TombstonePrefix},
 		{"Invalid", -1, ""},
 	}
 
@@ -136,6 +137,7 @@ func TestIdentify(t *testing.T) {
 			"TagKindPubkey", TagKindPubkeyPrefix,
 			TagKindPubkey,
 		},
+		{"Tombstone", TombstonePrefix, Tombstone},
 	}
 
 	for _, tc := range testCases {
@@ -979,3 +981,55 @@ func TestKindPubkeyTagFunctions(t *testing.T) {
 		t.Errorf("Decoded serial %d, expected %d", newSer.Get(), ser.Get())
 	}
 }
+
+// TestTombstoneFunctions tests the Tombstone-related functions
+func TestTombstoneFunctions(t *testing.T) {
+	// Test TombstoneVars
+	ser, fid := TombstoneVars()
+	if ser == nil || fid == nil {
+		t.Fatalf("TombstoneVars should return non-nil values")
+	}
+
+	// Set values
+	ser.Set(12345)
+	idBytes := make([]byte, 32)
+	for i := range idBytes {
+		idBytes[i] = byte(i)
+	}
+	if err := fid.FromId(idBytes); chk.E(err) {
+		t.Fatalf("FromId failed: %v", err)
+	}
+
+	// Test TombstoneEnc
+	enc := TombstoneEnc(ser, fid)
+	if len(enc.Encs) != 3 {
+		t.Errorf(
+			"TombstoneEnc should create T with 3 encoders, got %d",
+			len(enc.Encs),
+		)
+	}
+
+	// Test marshaling and unmarshaling
+	buf := new(bytes.Buffer)
+	err := enc.MarshalWrite(buf)
+	if chk.E(err) {
+		t.Fatalf("MarshalWrite failed: %v", err)
+	}
+
+	// Create new variables for decoding
+	newSer, newFid := TombstoneVars()
+	newDec := TombstoneDec(newSer, newFid)
+
+	err = newDec.UnmarshalRead(bytes.NewBuffer(buf.Bytes()))
+	if chk.E(err) {
+		t.Fatalf("UnmarshalRead failed: %v", err)
+	}
+
+	// Verify the decoded values
+	if newSer.Get() != ser.Get() {
+		t.Errorf("Decoded serial %d, expected %d", newSer.Get(), ser.Get())
+	}
+	if !utils.FastEqual(newFid.Bytes(), fid.Bytes()) {
+		t.Errorf("Decoded id %x, expected %x", newFid.Bytes(), fid.Bytes())
+	}
+}
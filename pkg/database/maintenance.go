@@ -0,0 +1,135 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"runtime"
+
+	"github.com/dgraph-io/badger/v4"
+	"lol.mleku.dev/chk"
+	"lol.mleku.dev/log"
+	"next.orly.dev/pkg/database/indexes"
+	"next.orly.dev/pkg/database/indexes/types"
+	"next.orly.dev/pkg/encoders/event"
+)
+
+// ScanEvents calls fn with the serial and the event of each stored event in
+// order of their serials, stopping at the first error returned by fn or when
+// the context is canceled. The event is freed after fn returns.
+func (d *D) ScanEvents(
+	c context.Context, fn func(ser uint64, ev *event.E) (err error),
+) (err error) {
+	return d.View(
+		func(txn *badger.Txn) (err error) {
+			buf := new(bytes.Buffer)
+			if err = indexes.EventEnc(nil).MarshalWrite(buf); chk.E(err) {
+				return
+			}
+			it := txn.NewIterator(badger.IteratorOptions{Prefix: buf.Bytes()})
+			defer it.Close()
+			for it.Rewind(); it.Valid(); it.Next() {
+				if err = c.Err(); err != nil {
+					return
+				}
+				item := it.Item()
+				ser := new(types.Uint40)
+				if err = ser.UnmarshalRead(
+					bytes.NewBuffer(item.Key()[len(indexes.EventPrefix):]),
+				); chk.E(err) {
+					return
+				}
+				var v []byte
+				if v, err = item.ValueCopy(nil); chk.E(err) {
+					return
+				}
+				ev := event.New()
+				if err = ev.UnmarshalBinary(bytes.NewBuffer(v)); err != nil {
+					log.W.F("skipping undecodable event %d: %v", ser.Get(), err)
+					ev.Free()
+					err = nil
+					continue
+				}
+				err = fn(ser.Get(), ev)
+				ev.Free()
+				if err != nil {
+					return
+				}
+			}
+			return
+		},
+	)
+}
+
+// KeyStats is the number of keys of a kind and the bytes they take up.
+type KeyStats struct {
+	Keys  int64 `json:"keys"`
+	Bytes int64 `json:"bytes"`
+}
+
+// KeyStats counts the keys in the database and their estimated size,
+// grouped by the three byte prefix for the event and index keys, and by the
+// text before the first colon for the other records such as markers and
+// subscriptions.
+func (d *D) KeyStats() (stats map[string]*KeyStats, err error) {
+	stats = make(map[string]*KeyStats)
+	err = d.View(
+		func(txn *badger.Txn) (err error) {
+			it := txn.NewIterator(
+				badger.IteratorOptions{PrefetchValues: false},
+			)
+			defer it.Close()
+			for it.Rewind(); it.Valid(); it.Next() {
+				item := it.Item()
+				name := keyGroup(item.Key())
+				s, ok := stats[name]
+				if !ok {
+					s = new(KeyStats)
+					stats[name] = s
+				}
+				s.Keys++
+				s.Bytes += item.EstimatedSize()
+			}
+			return
+		},
+	)
+	return
+}
+
+// keyGroup returns the name of the group a key is counted in by KeyStats.
+func keyGroup(k []byte) string {
+	if len(k) >= 3 {
+		for i := 0; indexes.Prefix(i) != ""; i++ {
+			if indexes.Prefix(i) == indexes.I(k[:3]) {
+				return string(k[:3])
+			}
+		}
+	}
+	if i := bytes.IndexByte(k, ':'); i > 0 {
+		return string(k[:i])
+	}
+	return string(k)
+}
+
+// Compact flattens the LSM tree into its bottom level and then rewrites the
+// value log files until no more space can be reclaimed. It should be run
+// while nothing else is writing to the database.
+func (d *D) Compact() (err error) {
+	log.I.F("%s: flattening LSM tree", d.dataDir)
+	if err = d.DB.Flatten(runtime.NumCPU()); chk.E(err) {
+		return
+	}
+	var rewrites int
+	for {
+		log.I.F("%s: running value log GC", d.dataDir)
+		if err = d.DB.RunValueLogGC(0.5); err != nil {
+			if errors.Is(err, badger.ErrNoRewrite) {
+				err = nil
+			}
+			break
+		}
+		rewrites++
+	}
+	log.I.F("%s: compaction rewrote %d value log files", d.dataDir, rewrites)
+	return
+}
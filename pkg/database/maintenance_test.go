@@ -0,0 +1,76 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"next.orly.dev/pkg/encoders/event"
+	"next.orly.dev/pkg/encoders/event/examples"
+)
+
+func TestMaintenance(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, err := New(ctx, cancel, t.TempDir(), "error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var imported int
+	if imported, err = db.ImportEvents(
+		bytes.NewBuffer(examples.Cache),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if imported == 0 {
+		t.Fatal("no events were imported")
+	}
+	// events are scanned in order of their serials
+	var last uint64
+	var scanned int
+	kinds := make(map[uint16]int)
+	if err = db.ScanEvents(
+		ctx, func(ser uint64, ev *event.E) (err error) {
+			if ser <= last {
+				t.Errorf("serial %d after %d", ser, last)
+			}
+			last = ser
+			scanned++
+			kinds[ev.Kind]++
+			return
+		},
+	); err != nil {
+		t.Fatal(err)
+	}
+	if scanned == 0 || scanned > imported {
+		t.Fatalf("scanned %d events after importing %d", scanned, imported)
+	}
+	stats, err := db.KeyStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := stats["evt"]; s == nil || s.Keys != int64(scanned) || s.Bytes == 0 {
+		t.Errorf("unexpected event key stats %+v for %d events", s, scanned)
+	}
+	for _, name := range []string{"eid", "kc-", "pc-"} {
+		if s := stats[name]; s == nil || s.Keys < int64(scanned) {
+			t.Errorf("unexpected %s key stats %+v", name, s)
+		}
+	}
+	if err = db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	var after int
+	if err = db.ScanEvents(
+		ctx, func(uint64, *event.E) (err error) {
+			after++
+			return
+		},
+	); err != nil {
+		t.Fatal(err)
+	}
+	if after != scanned {
+		t.Errorf("%d events before compaction, %d after", scanned, after)
+	}
+}
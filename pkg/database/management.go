@@ -0,0 +1,205 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/dgraph-io/badger/v4"
+	"next.orly.dev/pkg/encoders/json"
+)
+
+// The lists of the NIP-86 relay management state. Each entry is keyed by the
+// hex pubkey or event id, the decimal kind, or the IP address.
+const (
+	BannedPubkeys   = "bannedpubkey"
+	AllowedPubkeys  = "allowedpubkey"
+	BannedEvents    = "bannedevent"
+	AllowedKinds    = "allowedkind"
+	DisallowedKinds = "disallowedkind"
+	BlockedIPs      = "blockedip"
+)
+
+// The relay information fields that can be changed by NIP-86.
+const (
+	RelayInfoName        = "name"
+	RelayInfoDescription = "description"
+	RelayInfoIcon        = "icon"
+)
+
+const (
+	managementPrefix = "mgmt:"
+	relayInfoPrefix  = "mgmt:relayinfo:"
+)
+
+// ManagedEntry is an entry in one of the relay management lists.
+type ManagedEntry struct {
+	Key    string `json:"key"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// managedLists is the copy of the relay management lists kept in memory, so
+// the checks made for every request and event do not open a transaction. It
+// is loaded when the database is opened and updated by AddManaged and
+// RemoveManaged.
+type managedLists struct {
+	sync.RWMutex
+	lists map[string]map[string]ManagedEntry
+}
+
+func managedKey(list, key string) []byte {
+	return []byte(managementPrefix + list + ":" + key)
+}
+
+// loadManaged reads the relay management lists into memory.
+func (d *D) loadManaged() (err error) {
+	lists := make(map[string]map[string]ManagedEntry)
+	if err = d.DB.View(
+		func(txn *badger.Txn) error {
+			it := txn.NewIterator(
+				badger.IteratorOptions{Prefix: []byte(managementPrefix)},
+			)
+			defer it.Close()
+			for it.Rewind(); it.Valid(); it.Next() {
+				k := string(it.Item().Key())
+				if strings.HasPrefix(k, relayInfoPrefix) {
+					continue
+				}
+				list, _, ok := strings.Cut(k[len(managementPrefix):], ":")
+				if !ok {
+					continue
+				}
+				if err := it.Item().Value(
+					func(val []byte) error {
+						var e ManagedEntry
+						if err := json.Unmarshal(val, &e); err != nil {
+							return err
+						}
+						if lists[list] == nil {
+							lists[list] = make(map[string]ManagedEntry)
+						}
+						lists[list][e.Key] = e
+						return nil
+					},
+				); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	); err != nil {
+		return
+	}
+	d.managed.Lock()
+	d.managed.lists = lists
+	d.managed.Unlock()
+	return
+}
+
+// AddManaged adds an entry to a relay management list, replacing the reason
+// if it is already there.
+func (d *D) AddManaged(list, key, reason string) (err error) {
+	e := ManagedEntry{Key: key, Reason: reason}
+	var data []byte
+	if data, err = json.Marshal(&e); err != nil {
+		return
+	}
+	d.managed.Lock()
+	defer d.managed.Unlock()
+	if err = d.DB.Update(
+		func(txn *badger.Txn) error {
+			return txn.Set(managedKey(list, key), data)
+		},
+	); err != nil {
+		return
+	}
+	if d.managed.lists == nil {
+		d.managed.lists = make(map[string]map[string]ManagedEntry)
+	}
+	if d.managed.lists[list] == nil {
+		d.managed.lists[list] = make(map[string]ManagedEntry)
+	}
+	d.managed.lists[list][key] = e
+	return
+}
+
+// RemoveManaged removes an entry from a relay management list.
+func (d *D) RemoveManaged(list, key string) (err error) {
+	d.managed.Lock()
+	defer d.managed.Unlock()
+	if err = d.DB.Update(
+		func(txn *badger.Txn) error {
+			return txn.Delete(managedKey(list, key))
+		},
+	); err != nil {
+		return
+	}
+	delete(d.managed.lists[list], key)
+	return
+}
+
+// IsManaged returns whether a key is in a relay management list.
+func (d *D) IsManaged(list, key string) (found bool) {
+	d.managed.RLock()
+	defer d.managed.RUnlock()
+	_, found = d.managed.lists[list][key]
+	return
+}
+
+// ListManaged returns the entries of a relay management list, sorted by key.
+func (d *D) ListManaged(list string) (entries []ManagedEntry, err error) {
+	d.managed.RLock()
+	for _, e := range d.managed.lists[list] {
+		entries = append(entries, e)
+	}
+	d.managed.RUnlock()
+	sort.Slice(
+		entries, func(i, j int) bool { return entries[i].Key < entries[j].Key },
+	)
+	return
+}
+
+// HasManaged returns whether a relay management list has any entries.
+func (d *D) HasManaged(list string) (found bool) {
+	d.managed.RLock()
+	defer d.managed.RUnlock()
+	return len(d.managed.lists[list]) > 0
+}
+
+// SetRelayInfo stores a relay information field set by NIP-86, which
+// overrides the configured value. An empty value removes the override.
+func (d *D) SetRelayInfo(field, value string) (err error) {
+	key := []byte(relayInfoPrefix + field)
+	return d.DB.Update(
+		func(txn *badger.Txn) error {
+			if strings.TrimSpace(value) == "" {
+				return txn.Delete(key)
+			}
+			return txn.Set(key, []byte(value))
+		},
+	)
+}
+
+// GetRelayInfo returns a relay information field set by NIP-86, or an empty
+// string if it has not been set.
+func (d *D) GetRelayInfo(field string) (value string) {
+	_ = d.DB.View(
+		func(txn *badger.Txn) error {
+			item, err := txn.Get([]byte(relayInfoPrefix + field))
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			return item.Value(
+				func(val []byte) error {
+					value = string(val)
+					return nil
+				},
+			)
+		},
+	)
+	return
+}
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"os"
+	"testing"
+)
+
+func TestManagedLists(t *testing.T) {
+	db, _, cancel, tempDir := newTestDB(t)
+	defer func() {
+		cancel()
+		db.Close()
+		os.RemoveAll(tempDir)
+	}()
+
+	if db.HasManaged(BannedPubkeys) {
+		t.Fatal("new database has banned pubkeys")
+	}
+	if err := db.AddManaged(BannedPubkeys, "aa", "spam"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddManaged(BannedPubkeys, "bb", ""); err != nil {
+		t.Fatal(err)
+	}
+	// entries in other lists don't show up
+	if err := db.AddManaged(AllowedPubkeys, "cc", ""); err != nil {
+		t.Fatal(err)
+	}
+	if !db.IsManaged(BannedPubkeys, "aa") || db.IsManaged(BannedPubkeys, "cc") {
+		t.Fatal("IsManaged returned the wrong result")
+	}
+	entries, err := db.ListManaged(BannedPubkeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Key != "aa" ||
+		entries[0].Reason != "spam" || entries[1].Key != "bb" {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+	if err = db.RemoveManaged(BannedPubkeys, "aa"); err != nil {
+		t.Fatal(err)
+	}
+	if db.IsManaged(BannedPubkeys, "aa") {
+		t.Fatal("entry was not removed")
+	}
+	// the lists in memory are the same as those stored
+	if err = db.loadManaged(); err != nil {
+		t.Fatal(err)
+	}
+	if db.IsManaged(BannedPubkeys, "aa") || !db.IsManaged(BannedPubkeys, "bb") ||
+		!db.HasManaged(AllowedPubkeys) {
+		t.Fatal("stored lists differ from the lists in memory")
+	}
+
+	if v := db.GetRelayInfo(RelayInfoName); v != "" {
+		t.Fatalf("unexpected relay name %q", v)
+	}
+	if err = db.SetRelayInfo(RelayInfoName, "my relay"); err != nil {
+		t.Fatal(err)
+	}
+	if v := db.GetRelayInfo(RelayInfoName); v != "my relay" {
+		t.Fatalf("unexpected relay name %q", v)
+	}
+	if err = db.SetRelayInfo(RelayInfoName, ""); err != nil {
+		t.Fatal(err)
+	}
+	if v := db.GetRelayInfo(RelayInfoName); v != "" {
+		t.Fatalf("relay name was not cleared, got %q", v)
+	}
+}
@@ -0,0 +1,47 @@
+package database
+
+import (
+	"errors"
+
+	"github.com/dgraph-io/badger/v4"
+	"lol.mleku.dev/chk"
+)
+
+// promotedKey is the key of the URL of the leader the relay was promoted
+// away from. It is not a marker, so no wipe deletes it: a wiped relay that
+// forgot its promotion would follow the old leader again while clients still
+// write to it as the new one.
+const promotedKey = "replication:promoted"
+
+// SetPromoted records the URL of the leader the relay was promoted away
+// from.
+func (d *D) SetPromoted(leader string) (err error) {
+	return d.DB.Update(
+		func(txn *badger.Txn) error {
+			return txn.Set([]byte(promotedKey), []byte(leader))
+		},
+	)
+}
+
+// GetPromoted returns the URL of the leader the relay was promoted away
+// from, or an empty string if it was not promoted.
+func (d *D) GetPromoted() (leader string, err error) {
+	err = d.DB.View(
+		func(txn *badger.Txn) (err error) {
+			var item *badger.Item
+			if item, err = txn.Get([]byte(promotedKey)); err != nil {
+				return
+			}
+			var v []byte
+			if v, err = item.ValueCopy(nil); chk.E(err) {
+				return
+			}
+			leader = string(v)
+			return
+		},
+	)
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		err = nil
+	}
+	return
+}
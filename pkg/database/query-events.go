@@ -18,6 +18,7 @@ import (
 	"next.orly.dev/pkg/encoders/kind"
 	"next.orly.dev/pkg/encoders/tag"
 	"next.orly.dev/pkg/interfaces/store"
+	"next.orly.dev/pkg/metrics"
 	"next.orly.dev/pkg/utils"
 )
 
@@ -38,6 +39,9 @@ func CheckExpiration(ev *event.E) (expired bool) {
 func (d *D) QueryEvents(c context.Context, f *filter.F) (
 	evs event.S, err error,
 ) {
+	defer func(start time.Time) {
+		metrics.QueryEventsSeconds.Observe(time.Since(start).Seconds())
+	}(time.Now())
 	// if there is Ids in the query, this overrides anything else
 	var expDeletes types.Uint40s
 	var expEvs event.S
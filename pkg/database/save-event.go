@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/dgraph-io/badger/v4"
 	"lol.mleku.dev/chk"
@@ -17,6 +18,7 @@ import (
 	"next.orly.dev/pkg/encoders/hex"
 	"next.orly.dev/pkg/encoders/kind"
 	"next.orly.dev/pkg/encoders/tag"
+	"next.orly.dev/pkg/metrics"
 )
 
 func (d *D) GetSerialsFromFilter(f *filter.F) (
@@ -38,10 +40,18 @@ func (d *D) GetSerialsFromFilter(f *filter.F) (
 
 // SaveEvent saves an event to the database, generating all the necessary indexes.
 func (d *D) SaveEvent(c context.Context, ev *event.E) (kc, vc int, err error) {
+	defer func(start time.Time) {
+		metrics.SaveEventSeconds.Observe(time.Since(start).Seconds())
+	}(time.Now())
 	if ev == nil {
 		err = errors.New("nil event")
 		return
 	}
+	// ephemeral events are only ever relayed, never stored
+	if kind.IsEphemeral(ev.Kind) {
+		err = errors.New("blocked: ephemeral events are not stored")
+		return
+	}
 	// check if the event already exists
 	var ser *types.Uint40
 	if ser, err = d.GetSerialById(ev.ID); err == nil && ser != nil {
@@ -68,6 +78,10 @@ func (d *D) SaveEvent(c context.Context, ev *event.E) (kc, vc int, err error) {
 		err = fmt.Errorf("blocked: %s", err.Error())
 		return
 	}
+	// refuse events from, or addressed to, pubkeys that requested to vanish
+	if err = d.CheckForVanished(ev); err != nil {
+		return
+	}
 	// check for replacement
 	if kind.IsReplaceable(ev.Kind) {
 		// find the events and check timestamps before deleting
@@ -182,11 +196,14 @@ func (d *D) SaveEvent(c context.Context, ev *event.E) (kc, vc int, err error) {
 			}
 		}
 	}
-	// Get the next sequence number for the event
+	// Get the next sequence number for the event, which the change feed
+	// does not pass until the event is written
 	var serial uint64
-	if serial, err = d.seq.Next(); chk.E(err) {
+	var done func()
+	if serial, done, err = d.nextSerial(); chk.E(err) {
 		return
 	}
+	defer done()
 	// Generate all indexes for the event
 	var idxs [][]byte
 	if idxs, err = GetIndexesForEvent(ev, serial); chk.E(err) {
@@ -232,6 +249,9 @@ func (d *D) SaveEvent(c context.Context, ev *event.E) (kc, vc int, err error) {
 			return
 		},
 	)
+	if err != nil {
+		return
+	}
 	log.T.F(
 		"total data written: %d bytes keys %d bytes values for event ID %s", kc,
 		vc, hex.Enc(ev.ID),
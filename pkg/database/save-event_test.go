@@ -223,3 +223,38 @@ func TestSaveExistingEvent(t *testing.T) {
 		)
 	}
 }
+
+// TestSaveEphemeralEvent tests that ephemeral events are rejected by the
+// store, as they are only ever relayed to live subscribers.
+func TestSaveEphemeralEvent(t *testing.T) {
+	db, ctx, cancel, tempDir := newTestDB(t)
+	defer func() {
+		cancel()
+		db.Close()
+		os.RemoveAll(tempDir)
+	}()
+
+	sign := new(p256k.Signer)
+	if err := sign.Generate(); chk.E(err) {
+		t.Fatal(err)
+	}
+
+	ev := event.New()
+	ev.Kind = 20001
+	ev.Pubkey = sign.Pub()
+	ev.CreatedAt = timestamp.Now().V
+	ev.Content = []byte("ephemeral")
+	ev.Tags = tag.NewS()
+	ev.Sign(sign)
+
+	_, _, err := db.SaveEvent(ctx, ev)
+	if err == nil {
+		t.Fatal("Expected error when saving an ephemeral event, but got nil")
+	}
+	if !bytes.HasPrefix([]byte(err.Error()), []byte("blocked:")) {
+		t.Fatalf("Expected a blocked error, got '%s'", err.Error())
+	}
+	if ser, err := db.GetSerialById(ev.ID); err == nil && ser != nil {
+		t.Fatal("ephemeral event was written to the database")
+	}
+}
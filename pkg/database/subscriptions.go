@@ -16,6 +16,13 @@ type Subscription struct {
 	PaidUntil time.Time `json:"paid_until"`
 }
 
+// Active returns whether the trial or the paid period of a subscription has
+// not ended at a time.
+func (s *Subscription) Active(now time.Time) bool {
+	return now.Before(s.TrialEnd) ||
+		(!s.PaidUntil.IsZero() && now.Before(s.PaidUntil))
+}
+
 func (d *D) GetSubscription(pubkey []byte) (*Subscription, error) {
 	key := fmt.Sprintf("sub:%s", hex.EncodeToString(pubkey))
 	var sub *Subscription
@@ -71,7 +78,7 @@ func (d *D) IsSubscriptionActive(pubkey []byte) (bool, error) {
 				return err
 			}
 
-			active = now.Before(sub.TrialEnd) || (!sub.PaidUntil.IsZero() && now.Before(sub.PaidUntil))
+			active = sub.Active(now)
 			return nil
 		},
 	)
@@ -0,0 +1,164 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+
+	"github.com/dgraph-io/badger/v4"
+	"lol.mleku.dev/chk"
+	"lol.mleku.dev/errorf"
+	"next.orly.dev/pkg/database/indexes"
+	"next.orly.dev/pkg/database/indexes/types"
+	"next.orly.dev/pkg/encoders/event"
+	"next.orly.dev/pkg/encoders/filter"
+	"next.orly.dev/pkg/encoders/hex"
+	"next.orly.dev/pkg/encoders/kind"
+	"next.orly.dev/pkg/encoders/tag"
+)
+
+// Vanish processes a NIP-62 request to vanish, deleting every event by the
+// pubkey of the request that is older than it, found through the pubkey
+// index, and every gift wrap addressed to the pubkey that is older than it.
+//
+// The created_at of the request is recorded with MarkVanished as the
+// tombstone that CheckForVanished uses to refuse the events being stored
+// again, so it outlives the request itself.
+func (d *D) Vanish(c context.Context, req *event.E) (count int, err error) {
+	if err = d.MarkVanished(req); chk.E(err) {
+		return
+	}
+	var sers types.Uint40s
+	if err = d.View(
+		func(txn *badger.Txn) (err error) {
+			ph := &types.PubHash{}
+			if err = ph.FromPubkey(req.Pubkey); chk.E(err) {
+				return
+			}
+			prf := new(bytes.Buffer)
+			if err = indexes.PubkeyEnc(ph, nil, nil).MarshalWrite(prf); chk.E(err) {
+				return
+			}
+			it := txn.NewIterator(badger.IteratorOptions{Prefix: prf.Bytes()})
+			defer it.Close()
+			for it.Rewind(); it.Valid(); it.Next() {
+				buf := bytes.NewBuffer(it.Item().Key())
+				p, ca, ser := indexes.PubkeyVars()
+				if err = indexes.PubkeyDec(p, ca, ser).UnmarshalRead(buf); chk.E(err) {
+					err = nil
+					continue
+				}
+				if int64(ca.Get()) < req.CreatedAt {
+					sers = append(sers, ser)
+				}
+			}
+			return
+		},
+	); chk.E(err) {
+		return
+	}
+	// gift wraps addressed to the pubkey are also theirs
+	var gw types.Uint40s
+	if gw, err = d.GetSerialsFromFilter(
+		&filter.F{
+			Kinds: kind.NewS(kind.GiftWrap),
+			Tags:  tag.NewS(tag.NewFromAny("#p", hex.Enc(req.Pubkey))),
+		},
+	); chk.E(err) {
+		return
+	}
+	sers = append(sers, gw...)
+	for _, ser := range sers {
+		var ev *event.E
+		var e error
+		if ev, e = d.FetchEventBySerial(ser); e != nil {
+			continue
+		}
+		if ev.CreatedAt >= req.CreatedAt {
+			ev.Free()
+			continue
+		}
+		if e = d.DeleteEventBySerial(c, ser, ev); chk.E(e) {
+			ev.Free()
+			continue
+		}
+		ev.Free()
+		count++
+	}
+	return
+}
+
+// vanishedMarkerPrefix is the prefix of the markers of the requests to
+// vanish.
+const vanishedMarkerPrefix = "vanished:"
+
+// vanishedMarker is the key of the marker holding the created_at of the
+// latest request to vanish of a pubkey.
+func vanishedMarker(pk []byte) string {
+	return vanishedMarkerPrefix + hex.Enc(pk)
+}
+
+// MarkVanished records the created_at of a NIP-62 request to vanish as the
+// tombstone of its pubkey, unless a later one is already recorded. Unlike the
+// request, the marker can't be removed by a deletion, expiration or ban.
+func (d *D) MarkVanished(req *event.E) (err error) {
+	key := []byte(markerPrefix + vanishedMarker(req.Pubkey))
+	return d.Update(
+		func(txn *badger.Txn) (err error) {
+			var item *badger.Item
+			if item, err = txn.Get(key); err == nil {
+				var v []byte
+				if v, err = item.ValueCopy(nil); chk.E(err) {
+					return
+				}
+				if len(v) == 8 &&
+					int64(binary.BigEndian.Uint64(v)) >= req.CreatedAt {
+					return
+				}
+			} else if !errors.Is(err, badger.ErrKeyNotFound) {
+				return
+			}
+			v := make([]byte, 8)
+			binary.BigEndian.PutUint64(v, uint64(req.CreatedAt))
+			return txn.Set(key, v)
+		},
+	)
+}
+
+// VanishedAt returns the created_at of the latest request to vanish of a
+// pubkey, if it has made one.
+func (d *D) VanishedAt(pk []byte) (ts int64, ok bool) {
+	v, err := d.GetMarker(vanishedMarker(pk))
+	if err != nil || len(v) != 8 {
+		return
+	}
+	return int64(binary.BigEndian.Uint64(v)), true
+}
+
+// CheckForVanished returns an error with the prefix "blocked:" if an event
+// is by, or is a gift wrap addressed to, a pubkey that has since requested
+// to vanish with NIP-62.
+func (d *D) CheckForVanished(ev *event.E) (err error) {
+	if ev.Kind == kind.RequestToVanish.K {
+		return
+	}
+	pubkeys := [][]byte{ev.Pubkey}
+	if ev.Kind == kind.GiftWrap.K {
+		for _, p := range ev.Tags.GetAll([]byte("p")) {
+			if pk, e := hex.Dec(string(p.Value())); e == nil {
+				pubkeys = append(pubkeys, pk)
+			}
+		}
+	}
+	for _, pk := range pubkeys {
+		if ts, ok := d.VanishedAt(pk); ok && ev.CreatedAt < ts {
+			err = errorf.E(
+				"blocked: %0x is older than the request to vanish of %0x",
+				ev.ID, pk,
+			)
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,117 @@
+package database
+
+import (
+	"os"
+	"testing"
+
+	"lol.mleku.dev/chk"
+	"next.orly.dev/pkg/crypto/p256k"
+	"next.orly.dev/pkg/encoders/event"
+	"next.orly.dev/pkg/encoders/hex"
+	"next.orly.dev/pkg/encoders/kind"
+	"next.orly.dev/pkg/encoders/tag"
+	"next.orly.dev/pkg/encoders/timestamp"
+)
+
+func TestVanish(t *testing.T) {
+	db, ctx, cancel, tempDir := newTestDB(t)
+	defer func() {
+		cancel()
+		db.Close()
+		os.RemoveAll(tempDir)
+	}()
+
+	sign := new(p256k.Signer)
+	if err := sign.Generate(); chk.E(err) {
+		t.Fatal(err)
+	}
+	other := new(p256k.Signer)
+	if err := other.Generate(); chk.E(err) {
+		t.Fatal(err)
+	}
+	now := timestamp.Now().V
+	newEvent := func(
+		s *p256k.Signer, k uint16, createdAt int64, tags ...*tag.T,
+	) *event.E {
+		ev := event.New()
+		ev.Kind = k
+		ev.Pubkey = s.Pub()
+		ev.CreatedAt = createdAt
+		ev.Content = []byte("vanish test")
+		ev.Tags = tag.NewS(tags...)
+		if err := ev.Sign(s); chk.E(err) {
+			t.Fatal(err)
+		}
+		return ev
+	}
+	note := newEvent(sign, kind.TextNote.K, now-3600)
+	wrap := newEvent(
+		other, kind.GiftWrap.K, now-3600,
+		tag.NewFromAny("p", hex.Enc(sign.Pub())),
+	)
+	unrelated := newEvent(other, kind.TextNote.K, now-3600)
+	for _, ev := range []*event.E{note, wrap, unrelated} {
+		if _, _, err := db.SaveEvent(ctx, ev); err != nil {
+			t.Fatalf("Failed to save event: %v", err)
+		}
+	}
+
+	req := newEvent(
+		sign, kind.RequestToVanish.K, now,
+		tag.NewFromAny("relay", "ALL_RELAYS"),
+	)
+	if _, _, err := db.SaveEvent(ctx, req); err != nil {
+		t.Fatalf("Failed to save request to vanish: %v", err)
+	}
+	// storing a request does not mark its pubkey, as only the relay checks
+	// that it is named by the request
+	if _, ok := db.VanishedAt(sign.Pub()); ok {
+		t.Fatal("storing a request to vanish marked its pubkey")
+	}
+	count, err := db.Vanish(ctx, req)
+	if err != nil {
+		t.Fatalf("Vanish: %v", err)
+	}
+	if count != 2 {
+		t.Fatalf("Expected 2 events deleted, got %d", count)
+	}
+	for _, ev := range []*event.E{note, wrap} {
+		if ser, err := db.GetSerialById(ev.ID); err == nil && ser != nil {
+			t.Fatalf("event %0x is still in the database", ev.ID)
+		}
+	}
+	for _, ev := range []*event.E{unrelated, req} {
+		if _, err = db.GetSerialById(ev.ID); err != nil {
+			t.Fatalf("event %0x was deleted: %v", ev.ID, err)
+		}
+	}
+
+	// the vanished events can't be stored again, but newer events can
+	for _, ev := range []*event.E{note, wrap} {
+		if _, _, err = db.SaveEvent(ctx, ev); err == nil {
+			t.Fatalf("vanished event %0x was stored again", ev.ID)
+		}
+	}
+	if _, _, err = db.SaveEvent(
+		ctx, newEvent(sign, kind.TextNote.K, now+1),
+	); err != nil {
+		t.Fatalf("Failed to save event after vanish: %v", err)
+	}
+
+	// removing the request does not lift the tombstone
+	if err = db.DeleteEvent(ctx, req.ID); err != nil {
+		t.Fatalf("Failed to delete request to vanish: %v", err)
+	}
+	if _, _, err = db.SaveEvent(ctx, note); err == nil {
+		t.Fatal("vanished event was stored after the request was deleted")
+	}
+	// an older request does not move the tombstone back
+	if err = db.MarkVanished(
+		newEvent(sign, kind.RequestToVanish.K, now-7200),
+	); err != nil {
+		t.Fatalf("MarkVanished: %v", err)
+	}
+	if ts, ok := db.VanishedAt(sign.Pub()); !ok || ts != now {
+		t.Fatalf("Expected tombstone at %d, got %d, %v", now, ts, ok)
+	}
+}
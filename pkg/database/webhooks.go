@@ -0,0 +1,247 @@
+package database
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"lol.mleku.dev/chk"
+	"next.orly.dev/pkg/encoders/hex"
+	"next.orly.dev/pkg/encoders/json"
+)
+
+const (
+	webhookPrefix = "webhook:"
+	// webhookDuePrefix indexes the queued deliveries by when they are due,
+	// followed by the time in nanoseconds, the webhook and the event ID. The
+	// value is the key of the delivery. Deliveries that were given up are
+	// not indexed.
+	webhookDuePrefix = "webhook-due:"
+)
+
+// WebhookDelivery is a pending or failed delivery of a stored event to a
+// webhook.
+type WebhookDelivery struct {
+	// Hook is the name of the webhook.
+	Hook string `json:"hook"`
+	// EventID is the hex ID of the event, which is fetched when it is sent.
+	EventID string `json:"event_id"`
+	// Attempts is the number of failed attempts.
+	Attempts int `json:"attempts"`
+	// Next is when the next attempt is due.
+	Next time.Time `json:"next"`
+	// LastError is why the last attempt failed.
+	LastError string `json:"last_error,omitempty"`
+	// Failed is set when the delivery was given up, and is kept to be
+	// reported.
+	Failed bool `json:"failed,omitempty"`
+}
+
+func (w *WebhookDelivery) key() []byte {
+	return []byte(webhookPrefix + w.Hook + ":" + w.EventID)
+}
+
+func (w *WebhookDelivery) dueKey() (k []byte) {
+	k = binary.BigEndian.AppendUint64(
+		[]byte(webhookDuePrefix), uint64(w.Next.UnixNano()),
+	)
+	return append(k, w.Hook+":"+w.EventID...)
+}
+
+// QueueWebhook adds a delivery of an event to a webhook that is due at a
+// time.
+func (d *D) QueueWebhook(hook string, id []byte, due time.Time) (err error) {
+	return d.UpdateWebhook(
+		&WebhookDelivery{Hook: hook, EventID: hex.Enc(id), Next: due},
+	)
+}
+
+// getWebhook returns the delivery stored at a key.
+func getWebhook(txn *badger.Txn, key []byte) (w *WebhookDelivery, err error) {
+	var item *badger.Item
+	if item, err = txn.Get(key); err != nil {
+		return
+	}
+	w = new(WebhookDelivery)
+	err = item.Value(func(val []byte) error { return json.Unmarshal(val, w) })
+	return
+}
+
+// unindexWebhook removes the due time index entry of the delivery stored at
+// a key, if there is one.
+func unindexWebhook(txn *badger.Txn, key []byte) (err error) {
+	var old *WebhookDelivery
+	if old, err = getWebhook(txn, key); err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			err = nil
+		}
+		return
+	}
+	if old.Failed {
+		return
+	}
+	return txn.Delete(old.dueKey())
+}
+
+// UpdateWebhook stores a delivery, replacing it if it is queued.
+func (d *D) UpdateWebhook(w *WebhookDelivery) (err error) {
+	var b []byte
+	if b, err = json.Marshal(w); chk.E(err) {
+		return
+	}
+	return d.Update(
+		func(txn *badger.Txn) (err error) {
+			if err = unindexWebhook(txn, w.key()); err != nil {
+				return
+			}
+			if !w.Failed {
+				if err = txn.Set(w.dueKey(), w.key()); err != nil {
+					return
+				}
+			}
+			return txn.Set(w.key(), b)
+		},
+	)
+}
+
+// DeleteWebhook removes a delivery from the queue.
+func (d *D) DeleteWebhook(w *WebhookDelivery) (err error) {
+	return d.Update(
+		func(txn *badger.Txn) (err error) {
+			if err = unindexWebhook(txn, w.key()); err != nil {
+				return
+			}
+			return txn.Delete(w.key())
+		},
+	)
+}
+
+// DueWebhooks returns up to limit queued deliveries that are due at a time,
+// in the order they are due, which are found through the due time index
+// without loading the rest of the queue.
+func (d *D) DueWebhooks(now time.Time, limit int) (
+	ws []*WebhookDelivery, err error,
+) {
+	end := binary.BigEndian.AppendUint64(
+		[]byte(webhookDuePrefix), uint64(now.UnixNano()),
+	)
+	err = d.View(
+		func(txn *badger.Txn) error {
+			it := txn.NewIterator(
+				badger.IteratorOptions{Prefix: []byte(webhookDuePrefix)},
+			)
+			defer it.Close()
+			for it.Rewind(); it.Valid() && len(ws) < limit; it.Next() {
+				item := it.Item()
+				if bytes.Compare(item.Key()[:len(end)], end) > 0 {
+					break
+				}
+				key, err := item.ValueCopy(nil)
+				if chk.E(err) {
+					continue
+				}
+				var w *WebhookDelivery
+				if w, err = getWebhook(txn, key); chk.E(err) {
+					continue
+				}
+				ws = append(ws, w)
+			}
+			return nil
+		},
+	)
+	return
+}
+
+// IndexWebhooks adds the queued deliveries that are missing from the due
+// time index, such as those queued before it existed.
+func (d *D) IndexWebhooks() (err error) {
+	var ws []*WebhookDelivery
+	if ws, err = d.WebhookDeliveries(); chk.E(err) {
+		return
+	}
+	for _, w := range ws {
+		if w.Failed {
+			continue
+		}
+		if err = d.Update(
+			func(txn *badger.Txn) error {
+				return txn.Set(w.dueKey(), w.key())
+			},
+		); chk.E(err) {
+			return
+		}
+	}
+	return
+}
+
+// PruneFailedWebhooks removes the oldest of the deliveries to a webhook that
+// were given up, keeping the latest keep of them to be reported.
+func (d *D) PruneFailedWebhooks(hook string, keep int) (err error) {
+	var failed []*WebhookDelivery
+	if err = d.View(
+		func(txn *badger.Txn) error {
+			it := txn.NewIterator(
+				badger.IteratorOptions{
+					Prefix: []byte(webhookPrefix + hook + ":"),
+				},
+			)
+			defer it.Close()
+			for it.Rewind(); it.Valid(); it.Next() {
+				w := new(WebhookDelivery)
+				if err := it.Item().Value(
+					func(val []byte) error {
+						return json.Unmarshal(val, w)
+					},
+				); chk.E(err) {
+					continue
+				}
+				if w.Failed {
+					failed = append(failed, w)
+				}
+			}
+			return nil
+		},
+	); chk.E(err) {
+		return
+	}
+	if len(failed) <= keep {
+		return
+	}
+	sort.Slice(
+		failed, func(i, j int) bool { return failed[i].Next.After(failed[j].Next) },
+	)
+	for _, w := range failed[keep:] {
+		if err = d.DeleteWebhook(w); chk.E(err) {
+			return
+		}
+	}
+	return
+}
+
+// WebhookDeliveries returns the queued and failed deliveries.
+func (d *D) WebhookDeliveries() (ws []*WebhookDelivery, err error) {
+	err = d.View(
+		func(txn *badger.Txn) error {
+			it := txn.NewIterator(
+				badger.IteratorOptions{Prefix: []byte(webhookPrefix)},
+			)
+			defer it.Close()
+			for it.Rewind(); it.Valid(); it.Next() {
+				w := new(WebhookDelivery)
+				if err := it.Item().Value(
+					func(val []byte) error {
+						return json.Unmarshal(val, w)
+					},
+				); chk.E(err) {
+					continue
+				}
+				ws = append(ws, w)
+			}
+			return nil
+		},
+	)
+	return
+}
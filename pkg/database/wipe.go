@@ -0,0 +1,121 @@
+package database
+
+import (
+	"strings"
+
+	"github.com/dgraph-io/badger/v4"
+	"lol.mleku.dev/chk"
+	"lol.mleku.dev/log"
+	"next.orly.dev/pkg/database/indexes"
+)
+
+// WipeOptions are the records that WipeWith keeps.
+type WipeOptions struct {
+	// KeepIdentity keeps the relay identity secret key.
+	KeepIdentity bool `json:"keep_identity"`
+	// KeepSubscriptions keeps the paid subscriptions, their payments and the
+	// first logins of users.
+	KeepSubscriptions bool `json:"keep_subscriptions"`
+	// WipeMarkers also deletes the markers that are kept by default, which
+	// are the NIP-62 vanish tombstones and those registered with KeepOnWipe.
+	WipeMarkers bool `json:"wipe_markers"`
+}
+
+// keptMarkers are the prefixes of the markers that WipeWith keeps unless
+// WipeMarkers is set, as they record decisions about the relay rather than
+// state derived from the stored events.
+var keptMarkers = []string{vanishedMarkerPrefix}
+
+// KeepOnWipe registers the prefix of markers that WipeWith keeps unless
+// WipeMarkers is set. It must be called from an init function.
+func KeepOnWipe(prefix string) { keptMarkers = append(keptMarkers, prefix) }
+
+// Wipe deletes the events, their indexes, the markers other than those kept
+// by default, the relay identity and the subscriptions.
+func (d *D) Wipe() (err error) { return d.WipeWith(WipeOptions{}) }
+
+// WipeWith deletes the events, their indexes and the queued webhook
+// deliveries, the markers other than the vanish tombstones and those
+// registered with KeepOnWipe, and the relay identity and subscriptions,
+// unless the options keep them or also wipe the kept markers. The relay
+// management lists and the promotion of a follower are kept, as is the event
+// sequence, so the serials of new events are not reused.
+//
+// The version key is an index, so the migrations run again on the empty
+// database when the relay restarts.
+func (d *D) WipeWith(o WipeOptions) (err error) {
+	var prefixes [][]byte
+	for i := 0; indexes.Prefix(i) != ""; i++ {
+		prefixes = append(prefixes, []byte(indexes.Prefix(i)))
+	}
+	prefixes = append(
+		prefixes, []byte(webhookPrefix), []byte(webhookDuePrefix),
+	)
+	if !o.KeepIdentity {
+		prefixes = append(prefixes, []byte(relayIdentitySecretKey))
+	}
+	if !o.KeepSubscriptions {
+		prefixes = append(
+			prefixes, []byte("sub:"), []byte("payment:"),
+			[]byte("firstlogin:"),
+		)
+	}
+	if o.WipeMarkers {
+		prefixes = append(prefixes, []byte(markerPrefix))
+	}
+	log.W.F(
+		"%s: wiping database, keeping identity %v, subscriptions %v and "+
+			"markers %v", d.dataDir, o.KeepIdentity, o.KeepSubscriptions,
+		!o.WipeMarkers,
+	)
+	if err = d.DB.DropPrefix(prefixes...); chk.E(err) {
+		return
+	}
+	if !o.WipeMarkers {
+		if err = d.wipeMarkers(); chk.E(err) {
+			return
+		}
+	}
+	return
+}
+
+// wipeMarkers deletes the markers other than those kept by default.
+func (d *D) wipeMarkers() (err error) {
+	var keys [][]byte
+	if err = d.View(
+		func(txn *badger.Txn) error {
+			it := txn.NewIterator(
+				badger.IteratorOptions{Prefix: []byte(markerPrefix)},
+			)
+			defer it.Close()
+			for it.Rewind(); it.Valid(); it.Next() {
+				key := it.Item().KeyCopy(nil)
+				if !keptMarker(string(key[len(markerPrefix):])) {
+					keys = append(keys, key)
+				}
+			}
+			return nil
+		},
+	); chk.E(err) {
+		return
+	}
+	for _, key := range keys {
+		if err = d.Update(
+			func(txn *badger.Txn) error { return txn.Delete(key) },
+		); chk.E(err) {
+			return
+		}
+	}
+	return
+}
+
+// keptMarker returns whether a marker is kept by default when the database
+// is wiped.
+func keptMarker(key string) bool {
+	for _, prefix := range keptMarkers {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
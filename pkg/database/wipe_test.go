@@ -0,0 +1,98 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"next.orly.dev/pkg/encoders/event"
+	"next.orly.dev/pkg/encoders/event/examples"
+	"next.orly.dev/pkg/utils"
+)
+
+// countEvents returns the number of stored events.
+func countEvents(t *testing.T, d *D) (n int) {
+	t.Helper()
+	if err := d.ScanEvents(
+		context.Background(), func(uint64, *event.E) (err error) {
+			n++
+			return
+		},
+	); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestWipe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, err := New(ctx, cancel, t.TempDir(), "error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err = db.ImportEvents(bytes.NewBuffer(examples.Cache)); err != nil {
+		t.Fatal(err)
+	}
+	if countEvents(t, db) == 0 {
+		t.Fatal("no events were imported")
+	}
+	sk, err := db.GetOrCreateRelayIdentitySecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubkey := []byte("test_pubkey_32_bytes_long_enough")
+	if err = db.ExtendSubscription(pubkey, 30); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.SetMarker("test", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	vanished := []byte("vanished_pubkey_32_bytes_long_ok")
+	if err = db.SetMarker(vanishedMarker(vanished), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.AddManaged(BannedPubkeys, "abcd", "spam"); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.WipeWith(WipeOptions{KeepIdentity: true}); err != nil {
+		t.Fatal(err)
+	}
+	if n := countEvents(t, db); n != 0 {
+		t.Errorf("%d events left after wipe", n)
+	}
+	if db.HasMarker("test") {
+		t.Error("marker was not wiped")
+	}
+	if !db.HasMarker(vanishedMarker(vanished)) {
+		t.Error("vanish tombstone was wiped")
+	}
+	if sub, _ := db.GetSubscription(pubkey); sub != nil {
+		t.Error("subscription was not wiped")
+	}
+	if !db.IsManaged(BannedPubkeys, "abcd") {
+		t.Error("management list was wiped")
+	}
+	var kept []byte
+	if kept, err = db.GetRelayIdentitySecret(); err != nil ||
+		!utils.FastEqual(kept, sk) {
+		t.Errorf("relay identity was not kept: %v", err)
+	}
+	// events can be stored again after the wipe
+	if _, err = db.ImportEvents(bytes.NewBuffer(examples.Cache)); err != nil {
+		t.Fatal(err)
+	}
+	if countEvents(t, db) == 0 {
+		t.Error("no events were stored after the wipe")
+	}
+	if err = db.WipeWith(WipeOptions{WipeMarkers: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.GetRelayIdentitySecret(); err == nil {
+		t.Error("relay identity was not wiped")
+	}
+	if db.HasMarker(vanishedMarker(vanished)) {
+		t.Error("vanish tombstone was kept when markers were wiped")
+	}
+}
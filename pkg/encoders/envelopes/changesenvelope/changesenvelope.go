@@ -0,0 +1,192 @@
+// Package changesenvelope provides the encoders for the CHANGES request and
+// the CHANGE messages of the change feed of the relay, a variant of REQ that
+// returns the stored events and the tombstones of deleted events in the order
+// of their storage serials instead of matching a filter.
+//
+// A CHANGES request is answered with a CHANGE for each change from the
+// cursor, then an EOSE, and then the new changes as they happen until the
+// subscription is closed with CLOSE.
+package changesenvelope
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+
+	"lol.mleku.dev/chk"
+	"lol.mleku.dev/errorf"
+	"next.orly.dev/pkg/encoders/envelopes"
+	"next.orly.dev/pkg/encoders/ints"
+	"next.orly.dev/pkg/encoders/text"
+	"next.orly.dev/pkg/interfaces/codec"
+)
+
+// The labels associated with the change feed codec.Envelope types.
+const (
+	L       = "CHANGES"
+	LChange = "CHANGE"
+)
+
+// T is a CHANGES envelope, sent by a client to read the change feed after a
+// cursor, which is the serial of the last change it has read.
+type T struct {
+	Subscription []byte
+	// After is the cursor, or negative to read the feed from the start.
+	After int64
+}
+
+var _ codec.Envelope = (*T)(nil)
+
+// New creates an empty T that reads the feed from the start.
+func New() *T { return &T{After: -1} }
+
+// NewFrom creates a T populated with a subscription ID and a cursor, which is
+// negative to read the feed from the start.
+func NewFrom(id []byte, after int64) *T {
+	return &T{Subscription: id, After: after}
+}
+
+// Label returns the label of a T.
+func (en *T) Label() string { return L }
+
+// Write the T to a provided io.Writer.
+func (en *T) Write(w io.Writer) (err error) {
+	_, err = w.Write(en.Marshal(nil))
+	return
+}
+
+// Marshal a T in minified JSON, appending to a provided destination slice.
+// The cursor is left out when the feed is read from the start.
+func (en *T) Marshal(dst []byte) (b []byte) {
+	b = dst
+	b = envelopes.Marshal(
+		b, L,
+		func(bst []byte) (o []byte) {
+			o = bst
+			o = append(o, '"')
+			o = append(o, en.Subscription...)
+			o = append(o, '"')
+			if en.After >= 0 {
+				o = append(o, ',')
+				o = strconv.AppendInt(o, en.After, 10)
+			}
+			return
+		},
+	)
+	return
+}
+
+// Unmarshal a T from minified JSON, returning the remainder after the end of
+// the envelope.
+func (en *T) Unmarshal(b []byte) (r []byte, err error) {
+	r = b
+	en.After = -1
+	if en.Subscription, r, err = text.UnmarshalQuoted(r); chk.E(err) {
+		return
+	}
+	if end := bytes.IndexByte(r, ']'); end >= 0 {
+		if comma := bytes.IndexByte(r[:end], ','); comma >= 0 {
+			n := ints.New(0)
+			if r, err = n.Unmarshal(r[comma+1:]); chk.E(err) {
+				return
+			}
+			en.After = n.Int64()
+		}
+	}
+	if r, err = envelopes.SkipToTheEnd(r); chk.E(err) {
+		return
+	}
+	return
+}
+
+// Parse reads a CHANGES envelope from minified JSON into a newly allocated T.
+func Parse(b []byte) (t *T, rem []byte, err error) {
+	t = New()
+	if rem, err = t.Unmarshal(b); chk.E(err) {
+		return
+	}
+	return
+}
+
+// Change is a CHANGE envelope, sent by a relay with a change of the feed of a
+// CHANGES subscription, a JSON object with the serial and either the event or
+// the hex ID of the deleted event.
+type Change struct {
+	Subscription []byte
+	Change       []byte
+}
+
+var _ codec.Envelope = (*Change)(nil)
+
+// NewChange creates an empty Change.
+func NewChange() *Change { return new(Change) }
+
+// NewChangeFrom creates a Change populated with a subscription ID and the
+// JSON of a change.
+func NewChangeFrom(id, change []byte) *Change {
+	return &Change{Subscription: id, Change: change}
+}
+
+// Label returns the label of a Change.
+func (en *Change) Label() string { return LChange }
+
+// Write the Change to a provided io.Writer.
+func (en *Change) Write(w io.Writer) (err error) {
+	_, err = w.Write(en.Marshal(nil))
+	return
+}
+
+// Marshal a Change in minified JSON, appending to a provided destination
+// slice.
+func (en *Change) Marshal(dst []byte) (b []byte) {
+	b = dst
+	b = envelopes.Marshal(
+		b, LChange,
+		func(bst []byte) (o []byte) {
+			o = bst
+			o = append(o, '"')
+			o = append(o, en.Subscription...)
+			o = append(o, '"')
+			o = append(o, ',')
+			o = append(o, en.Change...)
+			return
+		},
+	)
+	return
+}
+
+// Unmarshal a Change from minified JSON, returning the remainder after the
+// end of the envelope. The change is the object up to the closing bracket of
+// the envelope.
+func (en *Change) Unmarshal(b []byte) (r []byte, err error) {
+	r = b
+	if en.Subscription, r, err = text.UnmarshalQuoted(r); chk.E(err) {
+		return
+	}
+	if r, err = text.Comma(r); chk.E(err) {
+		return
+	}
+	r = r[1:]
+	end := bytes.LastIndexByte(r, ']')
+	if end < 0 {
+		err = io.EOF
+		return
+	}
+	en.Change = bytes.TrimSpace(r[:end])
+	if len(en.Change) == 0 || en.Change[0] != '{' {
+		err = errorf.E("CHANGE does not contain an object")
+		return
+	}
+	r = r[:0]
+	return
+}
+
+// ParseChange reads a CHANGE envelope from minified JSON into a newly
+// allocated Change.
+func ParseChange(b []byte) (t *Change, rem []byte, err error) {
+	t = NewChange()
+	if rem, err = t.Unmarshal(b); chk.E(err) {
+		return
+	}
+	return
+}
@@ -0,0 +1,65 @@
+package changesenvelope
+
+import (
+	"testing"
+
+	"lol.mleku.dev/chk"
+	"lukechampine.com/frand"
+	"next.orly.dev/pkg/encoders/envelopes"
+	"next.orly.dev/pkg/interfaces/codec"
+	"next.orly.dev/pkg/utils"
+)
+
+// roundTrip marshals an envelope, unmarshals it into a new envelope of the
+// same type and checks that it marshals back to the same bytes.
+func roundTrip(t *testing.T, en, en2 codec.Envelope) {
+	var err error
+	rb := en.Marshal(nil)
+	rb1 := append([]byte(nil), rb...)
+	var l string
+	if l, rb, err = envelopes.Identify(rb); chk.E(err) {
+		t.Fatal(err)
+	}
+	if l != en.Label() {
+		t.Fatalf("invalid sentinel %s, expect %s", l, en.Label())
+	}
+	var rem []byte
+	if rem, err = en2.Unmarshal(rb); chk.E(err) {
+		t.Fatal(err)
+	}
+	if len(rem) > 0 {
+		t.Fatalf("unmarshal failed, remainder\n%d %s", len(rem), rem)
+	}
+	rb2 := en2.Marshal(nil)
+	if !utils.FastEqual(rb1, rb2) {
+		t.Fatalf("unmarshal failed\n%d %s\n%d %s\n", len(rb1), rb1, len(rb2), rb2)
+	}
+}
+
+func TestMarshalUnmarshal(t *testing.T) {
+	for i := range 100 {
+		s := utils.NewSubscription(i)
+		roundTrip(t, NewFrom(s, -1), New())
+		roundTrip(t, NewFrom(s, 0), New())
+		roundTrip(t, NewFrom(s, int64(frand.Intn(1<<30))), New())
+		roundTrip(
+			t, NewChangeFrom(s, []byte(`{"serial":12,"deleted":"ab"}`)),
+			NewChange(),
+		)
+		roundTrip(
+			t, NewChangeFrom(
+				s, []byte(`{"serial":3,"event":{"tags":[["e","x"]]}}`),
+			), NewChange(),
+		)
+	}
+}
+
+func TestFromStart(t *testing.T) {
+	env := New()
+	if _, err := env.Unmarshal([]byte(`"sub"]`)); err != nil {
+		t.Fatal(err)
+	}
+	if env.After != -1 || string(env.Subscription) != "sub" {
+		t.Errorf("unexpected CHANGES %+v", env)
+	}
+}
@@ -0,0 +1,115 @@
+// Package forwardenvelope provides the encoder for the FORWARD message, with
+// which a follower publishes an event sent to it by a client on its leader,
+// along with the pubkey the client authenticated as and its address, so the
+// leader checks the event as if the client had published it there.
+//
+// The leader answers a FORWARD with an OK for the event, as for EVENT.
+package forwardenvelope
+
+import (
+	"io"
+
+	"lol.mleku.dev/chk"
+	"lol.mleku.dev/errorf"
+	"next.orly.dev/pkg/encoders/envelopes"
+	"next.orly.dev/pkg/encoders/event"
+	"next.orly.dev/pkg/encoders/hex"
+	"next.orly.dev/pkg/encoders/text"
+	"next.orly.dev/pkg/interfaces/codec"
+)
+
+// L is the label associated with this type of codec.Envelope.
+const L = "FORWARD"
+
+// T is a FORWARD envelope.
+type T struct {
+	Event *event.E
+	// Pubkey is the pubkey the client authenticated as, or empty if it did
+	// not.
+	Pubkey []byte
+	// Remote is the address of the client.
+	Remote string
+}
+
+var _ codec.Envelope = (*T)(nil)
+
+// New creates an empty T.
+func New() *T { return new(T) }
+
+// NewFrom creates a T populated with an event and the authed pubkey and
+// address of the client that published it.
+func NewFrom(ev *event.E, pubkey []byte, remote string) *T {
+	return &T{Event: ev, Pubkey: pubkey, Remote: remote}
+}
+
+// Label returns the label of a T.
+func (en *T) Label() string { return L }
+
+// Id returns the ID of the forwarded event, which the OK refers to.
+func (en *T) Id() []byte { return en.Event.ID }
+
+// Write the T to a provided io.Writer.
+func (en *T) Write(w io.Writer) (err error) {
+	_, err = w.Write(en.Marshal(nil))
+	return
+}
+
+// Marshal a T in minified JSON, appending to a provided destination slice.
+func (en *T) Marshal(dst []byte) (b []byte) {
+	b = dst
+	b = envelopes.Marshal(
+		b, L,
+		func(bst []byte) (o []byte) {
+			o = bst
+			o = en.Event.Marshal(o)
+			o = append(o, ',', '"')
+			o = hex.EncAppend(o, en.Pubkey)
+			o = append(o, '"', ',')
+			o = text.AppendQuote(o, []byte(en.Remote), text.NostrEscape)
+			return
+		},
+	)
+	return
+}
+
+// Unmarshal a T from minified JSON, returning the remainder after the end of
+// the envelope.
+func (en *T) Unmarshal(b []byte) (r []byte, err error) {
+	r = b
+	en.Event = event.New()
+	if r, err = en.Event.Unmarshal(r); chk.E(err) {
+		return
+	}
+	var pk, remote []byte
+	if pk, r, err = text.UnmarshalQuoted(r); chk.E(err) {
+		return
+	}
+	en.Pubkey = nil
+	if len(pk) > 0 {
+		if en.Pubkey, err = hex.Dec(string(pk)); chk.E(err) {
+			return
+		}
+		if len(en.Pubkey) != 32 {
+			err = errorf.E("invalid forwarded pubkey length %d", len(en.Pubkey))
+			return
+		}
+	}
+	if remote, r, err = text.UnmarshalQuoted(r); chk.E(err) {
+		return
+	}
+	en.Remote = string(remote)
+	if r, err = envelopes.SkipToTheEnd(r); chk.E(err) {
+		return
+	}
+	return
+}
+
+// Parse reads a FORWARD envelope from minified JSON into a newly allocated
+// T.
+func Parse(b []byte) (t *T, rem []byte, err error) {
+	t = New()
+	if rem, err = t.Unmarshal(b); chk.E(err) {
+		return
+	}
+	return
+}
@@ -0,0 +1,56 @@
+package forwardenvelope
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"lol.mleku.dev/chk"
+	"next.orly.dev/pkg/encoders/envelopes"
+	"next.orly.dev/pkg/encoders/event"
+	"next.orly.dev/pkg/encoders/event/examples"
+	"next.orly.dev/pkg/utils"
+)
+
+func TestMarshalUnmarshal(t *testing.T) {
+	scanner := bufio.NewScanner(bytes.NewBuffer(examples.Cache))
+	var err error
+	var n int
+	for scanner.Scan() {
+		ev := event.New()
+		if _, err = ev.Unmarshal(scanner.Bytes()); chk.E(err) {
+			t.Fatal(err)
+		}
+		// every other client is unauthenticated
+		var pk []byte
+		if n++; n%2 == 0 {
+			pk = ev.Pubkey
+		}
+		en := NewFrom(ev, pk, "[2001:db8::1]:4321")
+		b := en.Marshal(nil)
+		c := append([]byte(nil), b...)
+		var l string
+		if l, b, err = envelopes.Identify(b); chk.E(err) {
+			t.Fatal(err)
+		}
+		if l != L {
+			t.Fatalf("invalid sentinel %s, expect %s", l, L)
+		}
+		en2 := New()
+		if b, err = en2.Unmarshal(b); chk.E(err) {
+			t.Fatal(err)
+		}
+		if len(b) != 0 {
+			t.Fatalf("some of input remaining after unmarshal: '%s'", b)
+		}
+		if !utils.FastEqual(en2.Pubkey, pk) || en2.Remote != en.Remote {
+			t.Fatalf(
+				"forwarded client %0x %s, expected %0x %s", en2.Pubkey,
+				en2.Remote, pk, en.Remote,
+			)
+		}
+		if out := en2.Marshal(nil); !utils.FastEqual(out, c) {
+			t.Fatalf("mismatched output\n%s\n\n%s\n", c, out)
+		}
+	}
+}
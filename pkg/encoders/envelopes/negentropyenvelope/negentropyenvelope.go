@@ -0,0 +1,320 @@
+// Package negentropyenvelope provides the encoders for the NIP-77 negentropy
+// messages NEG-OPEN, NEG-MSG, NEG-CLOSE and NEG-ERR, which carry a set
+// reconciliation between a client and a relay.
+package negentropyenvelope
+
+import (
+	"io"
+
+	"lol.mleku.dev/chk"
+	"next.orly.dev/pkg/encoders/envelopes"
+	"next.orly.dev/pkg/encoders/filter"
+	"next.orly.dev/pkg/encoders/hex"
+	"next.orly.dev/pkg/encoders/text"
+	"next.orly.dev/pkg/interfaces/codec"
+)
+
+// The labels associated with the negentropy codec.Envelope types.
+const (
+	LOpen  = "NEG-OPEN"
+	LMsg   = "NEG-MSG"
+	LClose = "NEG-CLOSE"
+	LErr   = "NEG-ERR"
+)
+
+// Open is a NEG-OPEN envelope, sent by a client to start a reconciliation of
+// the events matching a filter, with the first negentropy message.
+type Open struct {
+	Subscription []byte
+	Filter       *filter.F
+	Message      []byte
+}
+
+var _ codec.Envelope = (*Open)(nil)
+
+// NewOpen creates an empty Open.
+func NewOpen() *Open { return new(Open) }
+
+// NewOpenFrom creates an Open populated with a subscription ID, filter and
+// initial negentropy message.
+func NewOpenFrom(id []byte, f *filter.F, msg []byte) *Open {
+	return &Open{Subscription: id, Filter: f, Message: msg}
+}
+
+// Label returns the label of an Open.
+func (en *Open) Label() string { return LOpen }
+
+// Write the Open to a provided io.Writer.
+func (en *Open) Write(w io.Writer) (err error) {
+	_, err = w.Write(en.Marshal(nil))
+	return
+}
+
+// Marshal an Open in minified JSON, appending to a provided destination
+// slice. The negentropy message is hex encoded.
+func (en *Open) Marshal(dst []byte) (b []byte) {
+	b = dst
+	b = envelopes.Marshal(
+		b, LOpen,
+		func(bst []byte) (o []byte) {
+			o = bst
+			o = append(o, '"')
+			o = append(o, en.Subscription...)
+			o = append(o, '"')
+			o = append(o, ',')
+			o = en.Filter.Marshal(o)
+			o = append(o, ',')
+			o = append(o, '"')
+			o = hex.EncAppend(o, en.Message)
+			o = append(o, '"')
+			return
+		},
+	)
+	return
+}
+
+// Unmarshal an Open from minified JSON, returning the remainder after the end
+// of the envelope.
+func (en *Open) Unmarshal(b []byte) (r []byte, err error) {
+	r = b
+	if en.Subscription, r, err = text.UnmarshalQuoted(r); chk.E(err) {
+		return
+	}
+	if r, err = text.Comma(r); chk.E(err) {
+		return
+	}
+	en.Filter = filter.New()
+	if r, err = en.Filter.Unmarshal(r); chk.E(err) {
+		return
+	}
+	if en.Message, r, err = text.UnmarshalHex(r); chk.E(err) {
+		return
+	}
+	if r, err = envelopes.SkipToTheEnd(r); chk.E(err) {
+		return
+	}
+	return
+}
+
+// ParseOpen reads a NEG-OPEN envelope from minified JSON into a newly
+// allocated Open.
+func ParseOpen(b []byte) (t *Open, rem []byte, err error) {
+	t = NewOpen()
+	if rem, err = t.Unmarshal(b); chk.E(err) {
+		return
+	}
+	return
+}
+
+// Msg is a NEG-MSG envelope, which carries a negentropy message in either
+// direction.
+type Msg struct {
+	Subscription []byte
+	Message      []byte
+}
+
+var _ codec.Envelope = (*Msg)(nil)
+
+// NewMsg creates an empty Msg.
+func NewMsg() *Msg { return new(Msg) }
+
+// NewMsgFrom creates a Msg populated with a subscription ID and negentropy
+// message.
+func NewMsgFrom(id, msg []byte) *Msg {
+	return &Msg{Subscription: id, Message: msg}
+}
+
+// Label returns the label of a Msg.
+func (en *Msg) Label() string { return LMsg }
+
+// Write the Msg to a provided io.Writer.
+func (en *Msg) Write(w io.Writer) (err error) {
+	_, err = w.Write(en.Marshal(nil))
+	return
+}
+
+// Marshal a Msg in minified JSON, appending to a provided destination slice.
+// The negentropy message is hex encoded.
+func (en *Msg) Marshal(dst []byte) (b []byte) {
+	b = dst
+	b = envelopes.Marshal(
+		b, LMsg,
+		func(bst []byte) (o []byte) {
+			o = bst
+			o = append(o, '"')
+			o = append(o, en.Subscription...)
+			o = append(o, '"')
+			o = append(o, ',')
+			o = append(o, '"')
+			o = hex.EncAppend(o, en.Message)
+			o = append(o, '"')
+			return
+		},
+	)
+	return
+}
+
+// Unmarshal a Msg from minified JSON, returning the remainder after the end
+// of the envelope.
+func (en *Msg) Unmarshal(b []byte) (r []byte, err error) {
+	r = b
+	if en.Subscription, r, err = text.UnmarshalQuoted(r); chk.E(err) {
+		return
+	}
+	if en.Message, r, err = text.UnmarshalHex(r); chk.E(err) {
+		return
+	}
+	if r, err = envelopes.SkipToTheEnd(r); chk.E(err) {
+		return
+	}
+	return
+}
+
+// ParseMsg reads a NEG-MSG envelope from minified JSON into a newly
+// allocated Msg.
+func ParseMsg(b []byte) (t *Msg, rem []byte, err error) {
+	t = NewMsg()
+	if rem, err = t.Unmarshal(b); chk.E(err) {
+		return
+	}
+	return
+}
+
+// Close is a NEG-CLOSE envelope, sent by a client to end a reconciliation.
+type Close struct {
+	Subscription []byte
+}
+
+var _ codec.Envelope = (*Close)(nil)
+
+// NewClose creates an empty Close.
+func NewClose() *Close { return new(Close) }
+
+// NewCloseFrom creates a Close populated with a subscription ID.
+func NewCloseFrom(id []byte) *Close { return &Close{Subscription: id} }
+
+// Label returns the label of a Close.
+func (en *Close) Label() string { return LClose }
+
+// Write the Close to a provided io.Writer.
+func (en *Close) Write(w io.Writer) (err error) {
+	_, err = w.Write(en.Marshal(nil))
+	return
+}
+
+// Marshal a Close in minified JSON, appending to a provided destination
+// slice.
+func (en *Close) Marshal(dst []byte) (b []byte) {
+	b = dst
+	b = envelopes.Marshal(
+		b, LClose,
+		func(bst []byte) (o []byte) {
+			o = bst
+			o = append(o, '"')
+			o = append(o, en.Subscription...)
+			o = append(o, '"')
+			return
+		},
+	)
+	return
+}
+
+// Unmarshal a Close from minified JSON, returning the remainder after the end
+// of the envelope.
+func (en *Close) Unmarshal(b []byte) (r []byte, err error) {
+	r = b
+	if en.Subscription, r, err = text.UnmarshalQuoted(r); chk.E(err) {
+		return
+	}
+	if r, err = envelopes.SkipToTheEnd(r); chk.E(err) {
+		return
+	}
+	return
+}
+
+// ParseClose reads a NEG-CLOSE envelope from minified JSON into a newly
+// allocated Close.
+func ParseClose(b []byte) (t *Close, rem []byte, err error) {
+	t = NewClose()
+	if rem, err = t.Unmarshal(b); chk.E(err) {
+		return
+	}
+	return
+}
+
+// Err is a NEG-ERR envelope, sent by a relay when it will not or can no
+// longer run a reconciliation. The reason uses the same machine readable
+// prefixes as CLOSED.
+type Err struct {
+	Subscription []byte
+	Reason       []byte
+}
+
+var _ codec.Envelope = (*Err)(nil)
+
+// NewErr creates an empty Err.
+func NewErr() *Err { return new(Err) }
+
+// NewErrFrom creates an Err populated with a subscription ID and reason.
+func NewErrFrom(id, reason []byte) *Err {
+	return &Err{Subscription: id, Reason: reason}
+}
+
+// Label returns the label of an Err.
+func (en *Err) Label() string { return LErr }
+
+// ReasonString returns the Reason in the form of a string.
+func (en *Err) ReasonString() string { return string(en.Reason) }
+
+// Write the Err to a provided io.Writer.
+func (en *Err) Write(w io.Writer) (err error) {
+	_, err = w.Write(en.Marshal(nil))
+	return
+}
+
+// Marshal an Err in minified JSON, appending to a provided destination slice.
+// Note that this ensures correct string escaping on the Reason field.
+func (en *Err) Marshal(dst []byte) (b []byte) {
+	b = dst
+	b = envelopes.Marshal(
+		b, LErr,
+		func(bst []byte) (o []byte) {
+			o = bst
+			o = append(o, '"')
+			o = append(o, en.Subscription...)
+			o = append(o, '"')
+			o = append(o, ',')
+			o = append(o, '"')
+			o = text.NostrEscape(o, en.Reason)
+			o = append(o, '"')
+			return
+		},
+	)
+	return
+}
+
+// Unmarshal an Err from minified JSON, returning the remainder after the end
+// of the envelope.
+func (en *Err) Unmarshal(b []byte) (r []byte, err error) {
+	r = b
+	if en.Subscription, r, err = text.UnmarshalQuoted(r); chk.E(err) {
+		return
+	}
+	if en.Reason, r, err = text.UnmarshalQuoted(r); chk.E(err) {
+		return
+	}
+	if r, err = envelopes.SkipToTheEnd(r); chk.E(err) {
+		return
+	}
+	return
+}
+
+// ParseErr reads a NEG-ERR envelope from minified JSON into a newly allocated
+// Err.
+func ParseErr(b []byte) (t *Err, rem []byte, err error) {
+	t = NewErr()
+	if rem, err = t.Unmarshal(b); chk.E(err) {
+		return
+	}
+	return
+}
@@ -0,0 +1,57 @@
+package negentropyenvelope
+
+import (
+	"testing"
+
+	"lol.mleku.dev/chk"
+	"lukechampine.com/frand"
+	"next.orly.dev/pkg/encoders/envelopes"
+	"next.orly.dev/pkg/encoders/filter"
+	"next.orly.dev/pkg/interfaces/codec"
+	"next.orly.dev/pkg/utils"
+)
+
+// roundTrip marshals an envelope, unmarshals it into a new envelope of the
+// same type and checks that it marshals back to the same bytes.
+func roundTrip(t *testing.T, en, en2 codec.Envelope) {
+	var err error
+	rb := en.Marshal(nil)
+	rb1 := append([]byte(nil), rb...)
+	var l string
+	if l, rb, err = envelopes.Identify(rb); chk.E(err) {
+		t.Fatal(err)
+	}
+	if l != en.Label() {
+		t.Fatalf("invalid sentinel %s, expect %s", l, en.Label())
+	}
+	var rem []byte
+	if rem, err = en2.Unmarshal(rb); chk.E(err) {
+		t.Fatal(err)
+	}
+	if len(rem) > 0 {
+		t.Fatalf("unmarshal failed, remainder\n%d %s", len(rem), rem)
+	}
+	rb2 := en2.Marshal(nil)
+	if !utils.FastEqual(rb1, rb2) {
+		t.Fatalf("unmarshal failed\n%d %s\n%d %s\n", len(rb1), rb1, len(rb2), rb2)
+	}
+}
+
+func TestMarshalUnmarshal(t *testing.T) {
+	for i := range 100 {
+		var err error
+		var f *filter.F
+		if f, err = filter.GenFilter(); chk.E(err) {
+			t.Fatal(err)
+		}
+		s := utils.NewSubscription(i)
+		msg := frand.Bytes(frand.Intn(512) + 1)
+		roundTrip(t, NewOpenFrom(s, f, msg), NewOpen())
+		roundTrip(t, NewMsgFrom(s, msg), NewMsg())
+		roundTrip(t, NewCloseFrom(s), NewClose())
+		roundTrip(
+			t, NewErrFrom(s, []byte("blocked: this query is too big")),
+			NewErr(),
+		)
+	}
+}
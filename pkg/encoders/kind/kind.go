@@ -87,6 +87,24 @@ func IsPrivileged(k uint16) (is bool) {
 	return
 }
 
+// DMInbox are the kinds a relay acting as a NIP-17 DM inbox only releases to
+// their author or the recipient in their p tag.
+var DMInbox = []*K{
+	EncryptedDirectMessage,
+	GiftWrap,
+	DMRelaysList,
+}
+
+// IsDMInbox returns true if the type is one of the kinds of a DM inbox.
+func IsDMInbox(k uint16) (is bool) {
+	for i := range DMInbox {
+		if k == DMInbox[i].K {
+			return true
+		}
+	}
+	return
+}
+
 // Marshal renders the kind.K into bytes containing the ASCII string form of the
 // kind number.
 func (k *K) Marshal(dst []byte) (b []byte) {
@@ -206,6 +224,9 @@ var (
 	ChannelHideMessage = &K{43}
 	// ChannelMuteUser is an event type that...
 	ChannelMuteUser = &K{44}
+	// RequestToVanish is a NIP-62 request for relays to delete everything
+	// from a pubkey up to its created_at.
+	RequestToVanish = &K{62}
 	// Bid is an event type that...
 	Bid = &K{1021}
 	// BidConfirmation is an event type that...
@@ -237,7 +258,21 @@ var (
 	JobResultStart        = &K{6000}
 	JobResultEnd          = &K{6999}
 	JobFeedback           = &K{7000}
-	ZapGoal               = &K{9041}
+	// GroupModerationStart is the first of the NIP-29 group moderation kinds
+	// sent by group admins.
+	GroupModerationStart = &K{9000}
+	GroupPutUser         = &K{9000}
+	GroupRemoveUser      = &K{9001}
+	GroupEditMetadata    = &K{9002}
+	GroupDeleteEvent     = &K{9005}
+	GroupCreate          = &K{9007}
+	GroupDelete          = &K{9008}
+	GroupCreateInvite    = &K{9009}
+	// GroupModerationEnd is the last of the NIP-29 group moderation kinds.
+	GroupModerationEnd = &K{9020}
+	GroupJoinRequest   = &K{9021}
+	GroupLeaveRequest  = &K{9022}
+	ZapGoal            = &K{9041}
 	// ZapRequest is an event type that...
 	ZapRequest = &K{9734}
 	// Zap is an event type that...
@@ -331,7 +366,13 @@ var (
 	// WaveLakeTrack which has no spec and uses malformed tags
 	WaveLakeTrack       = &K{32123}
 	CommunityDefinition = &K{34550}
-	ACLEvent            = &K{39998}
+	// GroupMetadata, GroupAdmins, GroupMembers and GroupRoles are the NIP-29
+	// group state events signed by the relay.
+	GroupMetadata = &K{39000}
+	GroupAdmins   = &K{39001}
+	GroupMembers  = &K{39002}
+	GroupRoles    = &K{39003}
+	ACLEvent      = &K{39998}
 	// ParameterizedReplaceableEnd is an event type that...
 	ParameterizedReplaceableEnd = &K{40000}
 )
@@ -354,6 +395,7 @@ var Map = map[uint16]string{
 	ChannelMessage.K:              "ChannelMessage",
 	ChannelHideMessage.K:          "ChannelHideMessage",
 	ChannelMuteUser.K:             "ChannelMuteUser",
+	RequestToVanish.K:             "RequestToVanish",
 	Bid.K:                         "Bid",
 	BidConfirmation.K:             "BidConfirmation",
 	OpenTimestamps.K:              "OpenTimestamps",
@@ -368,6 +410,15 @@ var Map = map[uint16]string{
 	JobResultStart.K:              "JobResultStart",
 	JobResultEnd.K:                "JobResultEnd",
 	JobFeedback.K:                 "JobFeedback",
+	GroupPutUser.K:                "GroupPutUser",
+	GroupRemoveUser.K:             "GroupRemoveUser",
+	GroupEditMetadata.K:           "GroupEditMetadata",
+	GroupDeleteEvent.K:            "GroupDeleteEvent",
+	GroupCreate.K:                 "GroupCreate",
+	GroupDelete.K:                 "GroupDelete",
+	GroupCreateInvite.K:           "GroupCreateInvite",
+	GroupJoinRequest.K:            "GroupJoinRequest",
+	GroupLeaveRequest.K:           "GroupLeaveRequest",
 	ZapGoal.K:                     "ZapGoal",
 	ZapRequest.K:                  "ZapRequest",
 	Zap.K:                         "Zap",
@@ -421,4 +472,8 @@ var Map = map[uint16]string{
 	HandlerRecommendation.K:       "HandlerRecommendation",
 	HandlerInformation.K:          "HandlerInformation",
 	CommunityDefinition.K:         "CommunityDefinition",
+	GroupMetadata.K:               "GroupMetadata",
+	GroupAdmins.K:                 "GroupAdmins",
+	GroupMembers.K:                "GroupMembers",
+	GroupRoles.K:                  "GroupRoles",
 }
@@ -19,6 +19,7 @@ var (
 	Error        = R("error")
 	Unsupported  = R("unsupported")
 	Restricted   = R("restricted")
+	Closed       = R("closed")
 )
 
 // S returns the R as a string
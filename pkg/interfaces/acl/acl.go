@@ -2,10 +2,13 @@
 package acl
 
 import (
+	"next.orly.dev/pkg/encoders/event"
 	"next.orly.dev/pkg/interfaces/typer"
 )
 
 const (
+	// None means no access at all
+	None = "none"
 	// Read means read only
 	Read = "read"
 	// Write means read and write
@@ -14,11 +17,22 @@ const (
 	Admin = "admin"
 	// Owner means read, write, import/export, arbitrary delete and wipe
 	Owner = "owner"
-	// Group applies to communities and other groups; the content afterwards a
-	// set of comma separated <permission>:<pubkey> pairs designating permissions to groups.
-	Group = "group:"
 )
 
+// levels are the access levels from the least to the most access.
+var levels = []string{None, Read, Write, Admin, Owner}
+
+// Rank returns the position of a level from the least to the most access, or
+// -1 if it is unknown.
+func Rank(level string) int {
+	for i, l := range levels {
+		if l == level {
+			return i
+		}
+	}
+	return -1
+}
+
 type I interface {
 	Configure(cfg ...any) (err error)
 	// GetAccessLevel returns the access level string for a given pubkey.
@@ -33,3 +47,31 @@ type I interface {
 	Syncer()
 	typer.T
 }
+
+// Verdict is the decision of an ACL in a chain about a pubkey and address.
+type Verdict int
+
+const (
+	// Abstain leaves the decision to the next ACL of a chain. The level that
+	// goes with it is what the ACL would give on its own.
+	Abstain Verdict = iota
+	// Allow gives the level that goes with it, ending the chain.
+	Allow
+	// Deny gives the level that goes with it, usually none, ending the
+	// chain.
+	Deny
+)
+
+// Decider is implemented by ACLs that decide explicitly when they are part of
+// a chain. An ACL that does not is taken to allow the levels above read and
+// to abstain otherwise.
+type Decider interface {
+	Decide(pub []byte, address string) (verdict Verdict, level string)
+}
+
+// Reloader is implemented by ACLs that are configured from events stored by
+// the admins, and reports whether storing an event of an admin changes the
+// configuration, so only those ACLs are configured again.
+type Reloader interface {
+	ReloadsOn(ev *event.E) bool
+}
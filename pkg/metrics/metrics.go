@@ -0,0 +1,301 @@
+// Package metrics is a minimal implementation of counters, gauges and
+// histograms with labels, written in the Prometheus text exposition format.
+//
+// The metrics of the relay are declared in relay.go and registered in
+// Default, which is served at /metrics on the health port.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// labelSep separates the label values in the keys of the series of a
+// metric.
+const labelSep = "\xff"
+
+// metric is a metric that writes its series in the text format.
+type metric interface {
+	write(w io.Writer)
+}
+
+// Registry holds the metrics written together.
+type Registry struct {
+	mx         sync.Mutex
+	metrics    []metric
+	collectors []func()
+}
+
+// Default is the registry of the metrics of the relay.
+var Default = new(Registry)
+
+func (r *Registry) register(m metric) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// OnCollect adds a function that is called before the metrics are written,
+// to set gauges from state that is not tracked as it changes.
+func (r *Registry) OnCollect(f func()) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.collectors = append(r.collectors, f)
+}
+
+// Write calls the collectors and writes all metrics in the text format.
+func (r *Registry) Write(w io.Writer) {
+	r.mx.Lock()
+	collectors := append([]func(){}, r.collectors...)
+	metrics := append([]metric{}, r.metrics...)
+	r.mx.Unlock()
+	for _, f := range collectors {
+		f()
+	}
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// Handler serves the metrics of the registry.
+func (r *Registry) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		r.Write(w)
+	}
+}
+
+// desc is the name, help and label names of a metric.
+type desc struct {
+	name, help, typ string
+	labels          []string
+}
+
+// key joins label values into the key of a series, panicking if the number
+// of values does not match the label names, which is a programming error.
+func (d *desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf(
+			"metric %s has labels %v, got %d values", d.name, d.labels,
+			len(values),
+		))
+	}
+	return strings.Join(values, labelSep)
+}
+
+// header writes the HELP and TYPE lines.
+func (d *desc) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, d.help, d.name, d.typ)
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// format formats the name and labels of a series, with extra labels such as
+// le appended.
+func (d *desc) format(name, key string, extra ...string) string {
+	var values []string
+	if len(d.labels) > 0 {
+		values = strings.Split(key, labelSep)
+	}
+	var pairs []string
+	for i, l := range d.labels {
+		pairs = append(pairs, l+`="`+escaper.Replace(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+extra[i+1]+`"`)
+	}
+	if len(pairs) == 0 {
+		return name
+	}
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// values is a set of series with one value each, which counters and gauges
+// are.
+type values struct {
+	desc
+	mx     sync.Mutex
+	series map[string]float64
+}
+
+func (v *values) add(delta float64, labels []string) {
+	k := v.key(labels)
+	v.mx.Lock()
+	v.series[k] += delta
+	v.mx.Unlock()
+}
+
+func (v *values) write(w io.Writer) {
+	v.mx.Lock()
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	v.header(w)
+	for _, k := range keys {
+		fmt.Fprintf(
+			w, "%s %s\n", v.format(v.name, k), formatFloat(v.series[k]),
+		)
+	}
+	v.mx.Unlock()
+}
+
+// Counter is a value that only goes up, for each combination of the values
+// of its labels.
+type Counter struct{ values }
+
+// NewCounter registers a counter in Default.
+func NewCounter(name, help string, labels ...string) (c *Counter) {
+	c = &Counter{
+		values{
+			desc:   desc{name: name, help: help, typ: "counter", labels: labels},
+			series: make(map[string]float64),
+		},
+	}
+	if len(labels) == 0 {
+		c.series[""] = 0
+	}
+	Default.register(c)
+	return
+}
+
+// Inc adds one to the series of the label values.
+func (c *Counter) Inc(labels ...string) { c.add(1, labels) }
+
+// Add adds a positive delta to the series of the label values.
+func (c *Counter) Add(delta float64, labels ...string) {
+	if delta < 0 {
+		return
+	}
+	c.add(delta, labels)
+}
+
+// Gauge is a value that goes up and down, for each combination of the values
+// of its labels.
+type Gauge struct{ values }
+
+// NewGauge registers a gauge in Default.
+func NewGauge(name, help string, labels ...string) (g *Gauge) {
+	g = &Gauge{
+		values{
+			desc:   desc{name: name, help: help, typ: "gauge", labels: labels},
+			series: make(map[string]float64),
+		},
+	}
+	if len(labels) == 0 {
+		g.series[""] = 0
+	}
+	Default.register(g)
+	return
+}
+
+// Set sets the series of the label values.
+func (g *Gauge) Set(v float64, labels ...string) {
+	k := g.key(labels)
+	g.mx.Lock()
+	g.series[k] = v
+	g.mx.Unlock()
+}
+
+// Add adds a delta, which may be negative, to the series of the label
+// values.
+func (g *Gauge) Add(delta float64, labels ...string) { g.add(delta, labels) }
+
+// Histogram counts observations in buckets of upper bounds, for each
+// combination of the values of its labels.
+type Histogram struct {
+	desc
+	buckets []float64
+	mx      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// LatencyBuckets are upper bounds in seconds suited to database operations.
+var LatencyBuckets = []float64{
+	.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10,
+}
+
+// NewHistogram registers a histogram with sorted bucket upper bounds in
+// Default.
+func NewHistogram(
+	name, help string, buckets []float64, labels ...string,
+) (h *Histogram) {
+	h = &Histogram{
+		desc:    desc{name: name, help: help, typ: "histogram", labels: labels},
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}
+	Default.register(h)
+	return
+}
+
+// Observe adds an observation to the series of the label values.
+func (h *Histogram) Observe(v float64, labels ...string) {
+	k := h.key(labels)
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	s, ok := h.series[k]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[k] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h.header(w)
+	for _, k := range keys {
+		s := h.series[k]
+		var cumulative uint64
+		for i, b := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(
+				w, "%s %d\n",
+				h.format(h.name+"_bucket", k, "le", formatFloat(b)),
+				cumulative,
+			)
+		}
+		fmt.Fprintf(
+			w, "%s %d\n", h.format(h.name+"_bucket", k, "le", "+Inf"),
+			s.count,
+		)
+		fmt.Fprintf(
+			w, "%s %s\n", h.format(h.name+"_sum", k), formatFloat(s.sum),
+		)
+		fmt.Fprintf(w, "%s %d\n", h.format(h.name+"_count", k), s.count)
+	}
+}
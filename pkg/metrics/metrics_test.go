@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	c := NewCounter("test_requests_total", "requests by path", "path")
+	c.Inc(`/a"b`)
+	c.Add(2, `/a"b`)
+	c.Add(-1, `/a"b`)
+	c.Inc("/")
+	g := NewGauge("test_open", "open things")
+	g.Add(3)
+	g.Add(-1)
+	h := NewHistogram("test_seconds", "latency", []float64{.5, 1})
+	h.Observe(.25)
+	h.Observe(.75)
+	h.Observe(4)
+	collected := false
+	Default.OnCollect(func() { collected = true })
+	var buf bytes.Buffer
+	Default.Write(&buf)
+	out := buf.String()
+	if !collected {
+		t.Error("collector was not called")
+	}
+	for _, want := range []string{
+		"# TYPE test_requests_total counter\n",
+		`test_requests_total{path="/"} 1` + "\n",
+		`test_requests_total{path="/a\"b"} 3` + "\n",
+		"# TYPE test_open gauge\ntest_open 2\n",
+		"# TYPE test_seconds histogram\n",
+		`test_seconds_bucket{le="0.5"} 1` + "\n",
+		`test_seconds_bucket{le="1"} 2` + "\n",
+		`test_seconds_bucket{le="+Inf"} 3` + "\n",
+		"test_seconds_sum 5\ntest_seconds_count 3\n",
+		// the metrics of the relay are registered with their package
+		"# TYPE orly_envelopes_total counter\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics do not contain %q:\n%s", want, out)
+		}
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("wrong number of label values did not panic")
+		}
+	}()
+	c.Inc()
+}
@@ -0,0 +1,95 @@
+package metrics
+
+// The metrics of the relay, updated where the events they count happen.
+var (
+	// Envelopes counts the messages received from clients by the label of
+	// their envelope, or unknown.
+	Envelopes = NewCounter(
+		"orly_envelopes_total",
+		"messages received from clients by envelope type", "type",
+	)
+	// OKs counts the OK replies to EVENT messages by whether the event was
+	// accepted, and the machine readable prefix of the reason if it was not.
+	OKs = NewCounter(
+		"orly_ok_total",
+		"OK replies to EVENT messages by acceptance and reason prefix",
+		"accepted", "reason",
+	)
+	// SaveEventSeconds is the latency of storing events.
+	SaveEventSeconds = NewHistogram(
+		"orly_save_event_seconds", "latency of SaveEvent", LatencyBuckets,
+	)
+	// QueryEventsSeconds is the latency of queries for events.
+	QueryEventsSeconds = NewHistogram(
+		"orly_query_events_seconds", "latency of QueryEvents", LatencyBuckets,
+	)
+	// Connections is the number of open client websocket connections.
+	Connections = NewGauge(
+		"orly_connections", "open client websocket connections",
+	)
+	// Subscriptions is the number of open subscriptions of clients.
+	Subscriptions = NewGauge(
+		"orly_subscriptions", "open subscriptions of clients",
+	)
+	// DeliveryFailures counts the events that could not be written to a
+	// subscriber, whose connection is then closed, by timeout or error.
+	DeliveryFailures = NewCounter(
+		"orly_delivery_failures_total",
+		"events that could not be delivered to a subscriber", "reason",
+	)
+	// BadgerSize is the size of the LSM tree and of the value log of the
+	// database.
+	BadgerSize = NewGauge(
+		"orly_badger_size_bytes", "size of the badger LSM tree and value log",
+		"part",
+	)
+	// RelayConnected is whether the follows syncer or the follower of a
+	// leader is connected to a relay.
+	RelayConnected = NewGauge(
+		"orly_relay_connected",
+		"whether a connection to another relay is open", "component", "relay",
+	)
+	// RelayEvents counts the events saved from other relays by the spider
+	// and the follows syncer, and the changes applied by a follower.
+	RelayEvents = NewCounter(
+		"orly_relay_events_total", "events saved from other relays",
+		"component", "relay",
+	)
+	// RelayErrors counts the failed connections and queries to other relays.
+	RelayErrors = NewCounter(
+		"orly_relay_errors_total",
+		"failed connections and queries to other relays", "component", "relay",
+	)
+	// SpiderLastSync is when the spider last completed a sync.
+	SpiderLastSync = NewGauge(
+		"orly_spider_last_sync_timestamp_seconds",
+		"unix time of the last completed spider sync",
+	)
+	// ReplicationLag is how many seconds ago a follower was last caught up
+	// with the change feed of its leader.
+	ReplicationLag = NewGauge(
+		"orly_replication_lag_seconds",
+		"seconds since the follower was last caught up with its leader",
+	)
+	// ReplicationCursor is the serial of the last change of the leader that
+	// a follower applied.
+	ReplicationCursor = NewGauge(
+		"orly_replication_cursor",
+		"serial of the last change of the leader applied by the follower",
+	)
+	// Payments counts the payment notifications by whether a subscription
+	// was extended.
+	Payments = NewCounter(
+		"orly_payments_total", "payment notifications by result", "result",
+	)
+	// PaymentSats counts the satoshis paid for subscriptions.
+	PaymentSats = NewCounter(
+		"orly_payment_sats_total", "satoshis received for subscriptions",
+	)
+	// PaymentNotes counts the notes the payment processor publishes to
+	// subscribers, by type.
+	PaymentNotes = NewCounter(
+		"orly_payment_notes_total",
+		"notes published to subscribers by the payment processor", "type",
+	)
+)
@@ -0,0 +1,246 @@
+package policy
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"os/exec"
+	"sync"
+	"time"
+
+	"lol.mleku.dev/chk"
+	"lol.mleku.dev/errorf"
+	"lol.mleku.dev/log"
+	"next.orly.dev/pkg/encoders/event"
+	"next.orly.dev/pkg/encoders/hex"
+)
+
+const (
+	// Accept stores and relays the event.
+	Accept = "accept"
+	// Reject refuses the event with the message of the plugin.
+	Reject = "reject"
+	// ShadowReject tells the client the event was accepted, but drops it.
+	ShadowReject = "shadowReject"
+)
+
+// pluginRestartDelay is how long after starting a plugin that exited it is
+// started again.
+const pluginRestartDelay = time.Second
+
+// Request is the JSON line sent to a write policy plugin for each event.
+type Request struct {
+	Type        string          `json:"type"`
+	Event       json.RawMessage `json:"event"`
+	ReceivedAt  int64           `json:"receivedAt"`
+	SourceType  string          `json:"sourceType"`
+	SourceInfo  string          `json:"sourceInfo"`
+	Authed      string          `json:"authed,omitempty"`
+	AccessLevel string          `json:"accessLevel"`
+}
+
+// Response is the JSON line a write policy plugin answers a request with.
+type Response struct {
+	// ID is the hex ID of the event of the request.
+	ID string `json:"id"`
+	// Action is accept, reject or shadowReject.
+	Action string `json:"action"`
+	// Msg is sent to the client when the event is rejected.
+	Msg string `json:"msg,omitempty"`
+}
+
+// Plugin runs an executable that decides whether events are accepted, in the
+// manner of the write policy plugins of strfry. Each event is written to its
+// stdin as a JSON line, and it answers with a JSON line on its stdout. A
+// plugin that exits is started again, and one that does not read the request
+// or answer it in time is killed, to be started again on the next request.
+type Plugin struct {
+	ctx      context.Context
+	path     string
+	timeout  time.Duration
+	failOpen bool
+	// mx serializes requests, as the plugin answers them in order
+	mx      sync.Mutex
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	lines   chan []byte
+	started time.Time
+}
+
+// NewPlugin returns a plugin running the executable at path, which is started
+// on the first request. If it fails or does not answer within the timeout,
+// events are accepted if failOpen is set, and rejected otherwise.
+func NewPlugin(
+	ctx context.Context, path string, timeout time.Duration, failOpen bool,
+) (p *Plugin) {
+	return &Plugin{ctx: ctx, path: path, timeout: timeout, failOpen: failOpen}
+}
+
+// start runs the executable, reading its answers into p.lines, which is
+// closed when it exits.
+func (p *Plugin) start() (err error) {
+	if time.Since(p.started) < pluginRestartDelay {
+		return errorf.E("write policy plugin %s restarting too fast", p.path)
+	}
+	p.started = time.Now()
+	cmd := exec.CommandContext(p.ctx, p.path)
+	var stdin io.WriteCloser
+	if stdin, err = cmd.StdinPipe(); chk.E(err) {
+		return
+	}
+	var stdout, stderr io.ReadCloser
+	if stdout, err = cmd.StdoutPipe(); chk.E(err) {
+		return
+	}
+	if stderr, err = cmd.StderrPipe(); chk.E(err) {
+		return
+	}
+	if err = cmd.Start(); chk.E(err) {
+		return
+	}
+	log.I.F("started write policy plugin %s (pid %d)", p.path, cmd.Process.Pid)
+	lines := make(chan []byte)
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			log.W.F("write policy plugin: %s", scanner.Text())
+		}
+	}()
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(stdout)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+		for scanner.Scan() {
+			lines <- append([]byte(nil), scanner.Bytes()...)
+		}
+		err := cmd.Wait()
+		log.W.F("write policy plugin %s exited: %v", p.path, err)
+	}()
+	p.cmd, p.stdin, p.lines = cmd, stdin, lines
+	return
+}
+
+// stop kills the executable, whose answers may no longer match the requests.
+func (p *Plugin) stop() {
+	if p.cmd == nil {
+		return
+	}
+	chk.E(p.stdin.Close())
+	if err := p.cmd.Process.Kill(); err != nil {
+		log.D.F("killing write policy plugin: %v", err)
+	}
+	// drain the answers so the reader sees the exit
+	go func(lines chan []byte) {
+		for range lines {
+		}
+	}(p.lines)
+	p.cmd, p.stdin, p.lines = nil, nil, nil
+}
+
+// failed returns the response used when the plugin cannot decide.
+func (p *Plugin) failed(id []byte, err error) (res Response) {
+	log.E.F("write policy plugin failed on event %0x: %v", id, err)
+	if p.failOpen {
+		return Response{ID: hex.Enc(id), Action: Accept}
+	}
+	return Response{
+		ID: hex.Enc(id), Action: Reject,
+		Msg: "error: write policy is unavailable",
+	}
+}
+
+// Check asks the plugin whether an event received from an address, by a
+// client authenticated as authed or not at all when it is empty and with an
+// access level, is accepted.
+func (p *Plugin) Check(
+	ev *event.E, address string, authed []byte, level string,
+) (res Response) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	req := Request{
+		Type:        "new",
+		Event:       ev.Serialize(),
+		ReceivedAt:  time.Now().Unix(),
+		SourceType:  "IP4",
+		SourceInfo:  address,
+		AccessLevel: level,
+	}
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		req.SourceInfo = host
+	}
+	if ip := net.ParseIP(req.SourceInfo); ip != nil && ip.To4() == nil {
+		req.SourceType = "IP6"
+	}
+	if len(authed) > 0 {
+		req.Authed = hex.Enc(authed)
+	}
+	b, err := json.Marshal(req)
+	if chk.E(err) {
+		return p.failed(ev.ID, err)
+	}
+	if p.cmd == nil {
+		if err = p.start(); err != nil {
+			return p.failed(ev.ID, err)
+		}
+	}
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+	// the request is written within the timeout as well, as the write blocks
+	// once the pipe is full if the plugin stops reading it
+	written := make(chan error, 1)
+	go func(stdin io.Writer) {
+		_, err := stdin.Write(append(b, '\n'))
+		written <- err
+	}(p.stdin)
+	select {
+	case err = <-written:
+		if err != nil {
+			p.stop()
+			return p.failed(ev.ID, err)
+		}
+	case <-timer.C:
+		p.stop()
+		return p.failed(
+			ev.ID, errorf.E("timed out writing after %v", p.timeout),
+		)
+	case <-p.ctx.Done():
+		return p.failed(ev.ID, p.ctx.Err())
+	}
+	id := hex.Enc(ev.ID)
+	for {
+		select {
+		case line, ok := <-p.lines:
+			if !ok {
+				p.stop()
+				return p.failed(ev.ID, errorf.E("plugin exited"))
+			}
+			if err = json.Unmarshal(line, &res); err != nil {
+				log.W.F("invalid write policy plugin output %q", line)
+				continue
+			}
+			if res.ID != id {
+				log.W.F("write policy plugin answered for event %s", res.ID)
+				continue
+			}
+			switch res.Action {
+			case Accept, Reject, ShadowReject:
+				return
+			}
+			return p.failed(ev.ID, errorf.E("unknown action %q", res.Action))
+		case <-timer.C:
+			p.stop()
+			return p.failed(ev.ID, errorf.E("timed out after %v", p.timeout))
+		case <-p.ctx.Done():
+			return p.failed(ev.ID, p.ctx.Err())
+		}
+	}
+}
+
+// Close stops the executable.
+func (p *Plugin) Close() {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	p.stop()
+}
@@ -0,0 +1,83 @@
+package policy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writePlugin writes a shell script to use as a write policy plugin.
+func writePlugin(t *testing.T, script string) (path string) {
+	t.Helper()
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("no /bin/sh to run plugins with")
+	}
+	path = filepath.Join(t.TempDir(), "plugin.sh")
+	if err := os.WriteFile(
+		path, []byte("#!/bin/sh\n"+script+"\n"), 0755,
+	); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestPlugin(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sign := newSigner(t)
+	ev := newEvent(t, sign, 1, time.Now(), "spam")
+	check := func(p *Plugin, want string) {
+		t.Helper()
+		res := p.Check(ev, "192.0.2.1:1234", sign.Pub(), "write")
+		if res.Action != want {
+			t.Errorf("plugin answered %+v, want %s", res, want)
+		}
+	}
+	reject := NewPlugin(
+		ctx, writePlugin(
+			t, `sed -u 's/.*"id":"\([0-9a-f]*\)".*/{"id":"\1","action":"reject","msg":"blocked: spam"}/'`,
+		), 5*time.Second, true,
+	)
+	defer reject.Close()
+	check(reject, Reject)
+	if res := reject.Check(ev, "", nil, "read"); res.Msg != "blocked: spam" {
+		t.Errorf("plugin message is %q", res.Msg)
+	}
+	// a plugin that does not answer is killed, and the setting decides
+	silent := writePlugin(t, "cat >/dev/null")
+	closed := NewPlugin(ctx, silent, 100*time.Millisecond, false)
+	defer closed.Close()
+	check(closed, Reject)
+	open := NewPlugin(ctx, silent, 100*time.Millisecond, true)
+	defer open.Close()
+	check(open, Accept)
+	// a plugin that does not read is killed when the request fills the pipe
+	big := newEvent(
+		t, sign, 1, time.Now(), strings.Repeat("spam", 64*1024),
+	)
+	stuck := NewPlugin(
+		ctx, writePlugin(t, "exec sleep 60"), 100*time.Millisecond, false,
+	)
+	defer stuck.Close()
+	start := time.Now()
+	if res := stuck.Check(big, "", nil, "write"); res.Action != Reject {
+		t.Errorf("plugin that does not read answered %+v", res)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("plugin that does not read blocked the check for %v", d)
+	}
+	// a plugin that exits is started again once it has run for a while
+	crash := NewPlugin(
+		ctx, writePlugin(
+			t, `read line; echo "{\"id\":\"$(echo "$line" | sed 's/.*"id":"\([0-9a-f]*\)".*/\1/')\",\"action\":\"shadowReject\"}"`,
+		), 5*time.Second, false,
+	)
+	defer crash.Close()
+	check(crash, ShadowReject)
+	check(crash, Reject)
+	time.Sleep(pluginRestartDelay)
+	check(crash, ShadowReject)
+}
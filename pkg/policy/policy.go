@@ -0,0 +1,247 @@
+// Package policy is a table of rules for ranges of event kinds, saying who may
+// publish them, who may read them, and how large and old they may be.
+//
+// The rules are asked in order and the first whose kinds contain the kind of
+// an event applies to it. Kinds that no rule contains are not restricted, so
+// a relay that only accepts some kinds ends its table with a rule for the
+// kinds 0-65535 that nobody may write.
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"lol.mleku.dev/chk"
+	"lol.mleku.dev/errorf"
+	"next.orly.dev/pkg/encoders/bech32encoding"
+	"next.orly.dev/pkg/encoders/event"
+	"next.orly.dev/pkg/encoders/hex"
+	"next.orly.dev/pkg/interfaces/acl"
+)
+
+const (
+	// Anyone may write or read, which is the default.
+	Anyone = "anyone"
+	// Authed requires the client to have authenticated with NIP-42.
+	Authed = "authed"
+	// Pubkeys requires the author of an event, or the authenticated pubkey of
+	// a reader, to be one of the pubkeys of the rule.
+	Pubkeys = "pubkeys"
+	// Nobody may write or read.
+	Nobody = "none"
+)
+
+// Rule is the policy for a range of kinds.
+//
+// Write and Read are one of anyone, authed, pubkeys and none, or the access
+// level of the ACL that is at least required: read, write, admin or owner.
+type Rule struct {
+	// Kinds is a kind, or a range of kinds such as 30000-39999.
+	Kinds string `json:"kinds"`
+	// Write is who may publish events of the kinds.
+	Write string `json:"write,omitempty"`
+	// Read is who may receive events of the kinds.
+	Read string `json:"read,omitempty"`
+	// Pubkeys are the npubs or hex pubkeys allowed by pubkeys.
+	Pubkeys []string `json:"pubkeys,omitempty"`
+	// MaxSize is the maximum size in bytes of an event; 0 is unlimited.
+	MaxSize int `json:"max_size,omitempty"`
+	// MaxAge is the maximum age in seconds of the created_at of a new event;
+	// 0 is unlimited.
+	MaxAge int64 `json:"max_age,omitempty"`
+
+	min, max uint16
+	pubkeys  map[string]struct{}
+}
+
+// validWho returns whether a Write or Read setting is known.
+func validWho(who string) bool {
+	switch who {
+	case "", Anyone, Authed, Pubkeys, Nobody:
+		return true
+	}
+	return acl.Rank(who) > acl.Rank(acl.None)
+}
+
+// compile parses the kinds and pubkeys of the rule.
+func (r *Rule) compile() (err error) {
+	lo, hi, isRange := strings.Cut(strings.TrimSpace(r.Kinds), "-")
+	var k uint64
+	if k, err = strconv.ParseUint(strings.TrimSpace(lo), 10, 16); err != nil {
+		return errorf.E("invalid kinds %q", r.Kinds)
+	}
+	r.min, r.max = uint16(k), uint16(k)
+	if isRange {
+		if k, err = strconv.ParseUint(
+			strings.TrimSpace(hi), 10, 16,
+		); err != nil || uint16(k) < r.min {
+			return errorf.E("invalid kinds %q", r.Kinds)
+		}
+		r.max = uint16(k)
+	}
+	if !validWho(r.Write) {
+		return errorf.E("invalid writers %q for kinds %s", r.Write, r.Kinds)
+	}
+	if !validWho(r.Read) {
+		return errorf.E("invalid readers %q for kinds %s", r.Read, r.Kinds)
+	}
+	if r.MaxSize < 0 || r.MaxAge < 0 {
+		return errorf.E("negative limit for kinds %s", r.Kinds)
+	}
+	r.pubkeys = make(map[string]struct{}, len(r.Pubkeys))
+	for _, p := range r.Pubkeys {
+		var pk []byte
+		if pk, err = bech32encoding.NpubOrHexToPublicKeyBinary(p); chk.E(err) {
+			return errorf.E("invalid pubkey %q for kinds %s", p, r.Kinds)
+		}
+		r.pubkeys[hex.Enc(pk)] = struct{}{}
+	}
+	return
+}
+
+// Contains returns whether a kind is in the kinds of the rule.
+func (r *Rule) Contains(k uint16) bool { return k >= r.min && k <= r.max }
+
+// Bounds returns the lowest and the highest kind of the rule.
+func (r *Rule) Bounds() (min, max uint16) { return r.min, r.max }
+
+// allows returns whether a Write or Read setting admits a pubkey with an
+// access level.
+func (r *Rule) allows(who string, pub []byte, level string) bool {
+	switch who {
+	case "", Anyone:
+		return true
+	case Authed:
+		return len(pub) > 0
+	case Pubkeys:
+		if len(pub) == 0 {
+			return false
+		}
+		_, ok := r.pubkeys[hex.Enc(pub)]
+		return ok
+	case Nobody:
+		return false
+	}
+	return acl.Rank(level) >= acl.Rank(who)
+}
+
+// ReadRestricted returns whether not everyone may read the kinds.
+func (r *Rule) ReadRestricted() bool { return r.Read != "" && r.Read != Anyone }
+
+// CanRead returns whether a reader, authenticated as pub or not at all when
+// it is empty, with an access level may receive events of the kinds.
+func (r *Rule) CanRead(pub []byte, level string) bool {
+	return r.allows(r.Read, pub, level)
+}
+
+// WriteRejected returns why an event may not be published by a client,
+// authenticated as authed or not at all when it is empty, with an access
+// level, or an empty string if it may. The author of the event is what is
+// matched against the pubkeys of the rule. authRequired is set when
+// authenticating could make the client allowed.
+func (r *Rule) WriteRejected(
+	ev *event.E, authed []byte, level string, now time.Time,
+) (reason string, authRequired bool) {
+	switch r.Write {
+	case Pubkeys:
+		if !r.allows(r.Write, ev.Pubkey, level) {
+			return fmt.Sprintf(
+				"kind %d may only be published by selected pubkeys", ev.Kind,
+			), false
+		}
+	default:
+		if !r.allows(r.Write, authed, level) {
+			if r.Write == Nobody {
+				return fmt.Sprintf("kind %d is not accepted", ev.Kind), false
+			}
+			return fmt.Sprintf(
+				"kind %d requires %s to publish", ev.Kind, r.Write,
+			), len(authed) == 0
+		}
+	}
+	if r.MaxSize > 0 {
+		if size := len(ev.Serialize()); size > r.MaxSize {
+			return fmt.Sprintf(
+				"event is %d bytes, kind %d allows at most %d", size, ev.Kind,
+				r.MaxSize,
+			), false
+		}
+	}
+	if r.MaxAge > 0 && ev.CreatedAt < now.Unix()-r.MaxAge {
+		return fmt.Sprintf(
+			"event is too old, kind %d allows at most %s", ev.Kind,
+			time.Duration(r.MaxAge)*time.Second,
+		), false
+	}
+	return
+}
+
+// Parse decodes a JSON array of rules and checks them.
+func Parse(b []byte) (rules []Rule, err error) {
+	if err = json.Unmarshal(b, &rules); err != nil {
+		return nil, errorf.E("invalid policy: %v", err)
+	}
+	for i := range rules {
+		if err = rules[i].compile(); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+// Table is a list of rules that can be replaced while it is in use.
+type Table struct {
+	mx    sync.RWMutex
+	rules []Rule
+}
+
+// Set replaces the rules of the table, which must be checked by Parse.
+func (t *Table) Set(rules []Rule) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	t.rules = rules
+}
+
+// Rules returns the rules of the table.
+func (t *Table) Rules() (rules []Rule) {
+	if t == nil {
+		return
+	}
+	t.mx.RLock()
+	defer t.mx.RUnlock()
+	return append(rules, t.rules...)
+}
+
+// Rule returns the rule that applies to a kind, or nil if there is none.
+func (t *Table) Rule(k uint16) *Rule {
+	if t == nil {
+		return nil
+	}
+	t.mx.RLock()
+	defer t.mx.RUnlock()
+	for i := range t.rules {
+		if t.rules[i].Contains(k) {
+			return &t.rules[i]
+		}
+	}
+	return nil
+}
+
+// ReadRestricted returns whether any rule of the table restricts reads.
+func (t *Table) ReadRestricted() bool {
+	if t == nil {
+		return false
+	}
+	t.mx.RLock()
+	defer t.mx.RUnlock()
+	for i := range t.rules {
+		if t.rules[i].ReadRestricted() {
+			return true
+		}
+	}
+	return false
+}
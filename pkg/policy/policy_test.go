@@ -0,0 +1,130 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"next.orly.dev/pkg/crypto/p256k"
+	"next.orly.dev/pkg/encoders/event"
+	"next.orly.dev/pkg/encoders/hex"
+)
+
+func newSigner(t *testing.T) (sign *p256k.Signer) {
+	t.Helper()
+	sign = new(p256k.Signer)
+	if err := sign.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+// newEvent creates and signs an event of a kind created at a time.
+func newEvent(
+	t *testing.T, sign *p256k.Signer, k uint16, createdAt time.Time,
+	content string,
+) (ev *event.E) {
+	t.Helper()
+	ev = event.New()
+	ev.Kind = k
+	ev.Pubkey = sign.Pub()
+	ev.CreatedAt = createdAt.Unix()
+	ev.Content = []byte(content)
+	if err := ev.Sign(sign); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestParse(t *testing.T) {
+	for _, bad := range []string{
+		`{}`,
+		`[{"kinds": "x"}]`,
+		`[{"kinds": "10-5"}]`,
+		`[{"kinds": "70000"}]`,
+		`[{"kinds": "1", "write": "everyone"}]`,
+		`[{"kinds": "1", "read": "none", "pubkeys": ["npub1bogus"]}]`,
+		`[{"kinds": "1", "max_size": -1}]`,
+	} {
+		if _, err := Parse([]byte(bad)); err == nil {
+			t.Errorf("invalid policy %s was accepted", bad)
+		}
+	}
+	rules, err := Parse(
+		[]byte(`[{"kinds": "30000-39999", "write": "write"}, {"kinds": " 1 "}]`),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rules[0].Contains(30023) || rules[0].Contains(1) ||
+		!rules[1].Contains(1) || rules[1].Contains(2) {
+		t.Errorf("kinds of the rules do not match: %+v", rules)
+	}
+}
+
+func TestTable(t *testing.T) {
+	author, reader := newSigner(t), newSigner(t)
+	now := time.Now()
+	// a long-form only relay, whose drafts are only read by admins
+	rules, err := Parse(
+		[]byte(`[
+			{"kinds": "30023", "write": "authed", "max_size": 2000, "max_age": 3600},
+			{"kinds": "30024", "write": "pubkeys", "read": "admin",
+			 "pubkeys": ["` + hex.Enc(author.Pub()) + `"]},
+			{"kinds": "0-65535", "write": "none"}
+		]`),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tab Table
+	if tab.Rule(1) != nil || tab.ReadRestricted() {
+		t.Fatal("empty table has rules")
+	}
+	tab.Set(rules)
+	if !tab.ReadRestricted() || len(tab.Rules()) != 3 {
+		t.Fatal("table does not have the rules")
+	}
+	rejected := func(
+		ev *event.E, authed []byte, level string, want string, auth bool,
+	) {
+		t.Helper()
+		reason, authRequired := tab.Rule(ev.Kind).WriteRejected(
+			ev, authed, level, now,
+		)
+		if !strings.Contains(reason, want) || (want == "") != (reason == "") ||
+			authRequired != auth {
+			t.Errorf(
+				"kind %d rejected with %q, %v, want %q, %v", ev.Kind, reason,
+				authRequired, want, auth,
+			)
+		}
+	}
+	article := newEvent(t, author, 30023, now, "article")
+	rejected(article, nil, "write", "requires authed", true)
+	rejected(article, author.Pub(), "write", "", false)
+	rejected(
+		newEvent(t, author, 30023, now, strings.Repeat("x", 2000)),
+		author.Pub(), "write", "bytes", false,
+	)
+	rejected(
+		newEvent(t, author, 30023, now.Add(-2*time.Hour), "old"),
+		author.Pub(), "write", "too old", false,
+	)
+	// the author of the event is matched, not the client publishing it
+	rejected(newEvent(t, author, 30024, now, "draft"), nil, "read", "", false)
+	rejected(
+		newEvent(t, reader, 30024, now, "draft"), author.Pub(), "admin",
+		"selected pubkeys", false,
+	)
+	rejected(
+		newEvent(t, author, 1, now, "note"), author.Pub(), "owner",
+		"not accepted", false,
+	)
+	drafts := tab.Rule(30024)
+	if drafts.CanRead(reader.Pub(), "write") || !drafts.CanRead(
+		reader.Pub(), "admin",
+	) || !tab.Rule(30023).CanRead(nil, "none") {
+		t.Error("readers of the rules do not match")
+	}
+}
@@ -0,0 +1,254 @@
+// Package httpauth implements NIP-98 HTTP authentication, where a request
+// carries a signed kind 27235 event in its Authorization header that binds
+// the pubkey to the URL, method and optionally the body of the request.
+package httpauth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"lol.mleku.dev/chk"
+	"lol.mleku.dev/errorf"
+	"next.orly.dev/pkg/encoders/event"
+	"next.orly.dev/pkg/encoders/hex"
+	"next.orly.dev/pkg/encoders/kind"
+	"next.orly.dev/pkg/encoders/tag"
+	"next.orly.dev/pkg/interfaces/signer"
+	"next.orly.dev/pkg/utils"
+)
+
+const (
+	// HeaderKey is the name of the header carrying the auth event.
+	HeaderKey = "Authorization"
+	// Scheme is the prefix of the header value before the base64 event.
+	Scheme = "Nostr"
+	// DefaultTolerance is how far the created_at of an auth event may be from
+	// the current time.
+	DefaultTolerance = time.Minute
+	// DefaultMaxBody is the largest request body in bytes that is read to
+	// check the payload tag of an auth event.
+	DefaultMaxBody = 10 << 20
+)
+
+// Options are the settings of CheckAuthWith.
+type Options struct {
+	// RequirePayload requires the auth event to have a payload tag, so the
+	// body is always checked.
+	RequirePayload bool
+	// Tolerance is how far the created_at of the auth event may be from the
+	// current time, DefaultTolerance if zero.
+	Tolerance time.Duration
+	// MaxBody is the largest request body in bytes that is read to check a
+	// payload tag, DefaultMaxBody if zero. A larger body fails the check.
+	MaxBody int64
+	// TrustProxy takes the scheme and host of the request URL from the
+	// X-Forwarded-Proto and X-Forwarded-Host headers, which may only be
+	// trusted when a reverse proxy sets them.
+	TrustProxy bool
+}
+
+// RequestURL reconstructs the absolute URL a request was sent to, which is
+// what the u tag of an auth event must match. The headers set by a reverse
+// proxy are only taken into account if trustProxy is set, as anyone can send
+// them otherwise.
+func RequestURL(r *http.Request, trustProxy bool) (u string) {
+	var proto, host string
+	if trustProxy {
+		proto = r.Header.Get("X-Forwarded-Proto")
+		host = r.Header.Get("X-Forwarded-Host")
+	}
+	if proto == "" {
+		if r.TLS != nil {
+			proto = "https"
+		} else {
+			proto = "http"
+		}
+	}
+	if host == "" {
+		host = r.Host
+	}
+	return proto + "://" + host + r.URL.RequestURI()
+}
+
+// CheckAuth verifies the NIP-98 Authorization header of a request with the
+// default options, not trusting the headers of a reverse proxy. See
+// CheckAuthWith.
+func CheckAuth(
+	r *http.Request, requirePayload bool, tolerance ...time.Duration,
+) (valid bool, pubkey []byte, err error) {
+	o := Options{RequirePayload: requirePayload}
+	if len(tolerance) > 0 {
+		o.Tolerance = tolerance[0]
+	}
+	return CheckAuthWith(r, o)
+}
+
+// CheckAuthWith verifies the NIP-98 Authorization header of a request, and
+// returns the pubkey that signed it.
+//
+// The u tag must match the request URL and the method tag the request
+// method, and the created_at must be within the tolerance of the current
+// time. When a payload is required, or the event has a payload tag, the body
+// is read up to the maximum size and its sha256 hash must match the tag, and
+// the body is replaced so it can be read again.
+//
+// valid is false with a nil error when the request has no NIP-98 header.
+func CheckAuthWith(r *http.Request, o Options) (
+	valid bool, pubkey []byte, err error,
+) {
+	val := r.Header.Get(HeaderKey)
+	if !strings.HasPrefix(val, Scheme+" ") {
+		return
+	}
+	tol := o.Tolerance
+	if tol == 0 {
+		tol = DefaultTolerance
+	}
+	maxBody := o.MaxBody
+	if maxBody <= 0 {
+		maxBody = DefaultMaxBody
+	}
+	var b []byte
+	if b, err = base64.StdEncoding.DecodeString(
+		strings.TrimSpace(val[len(Scheme)+1:]),
+	); chk.D(err) {
+		err = errorf.E("invalid base64 in %s header: %v", HeaderKey, err)
+		return
+	}
+	ev := event.New()
+	defer ev.Free()
+	if _, err = ev.Unmarshal(b); chk.D(err) {
+		err = errorf.E("invalid auth event: %v", err)
+		return
+	}
+	if ev.Kind != kind.HTTPAuth.K {
+		err = errorf.E("auth event has kind %d, must be %d", ev.Kind, kind.HTTPAuth.K)
+		return
+	}
+	if !utils.FastEqual(ev.GetIDBytes(), ev.ID) {
+		err = errorf.E("auth event id is computed incorrectly")
+		return
+	}
+	var ok bool
+	if ok, err = ev.Verify(); err != nil || !ok {
+		err = errorf.E("auth event signature is invalid")
+		return
+	}
+	ts := time.Unix(ev.CreatedAt, 0)
+	if d := time.Since(ts); d > tol || d < -tol {
+		err = errorf.E(
+			"auth event created_at %d is more than %v from now", ev.CreatedAt,
+			tol,
+		)
+		return
+	}
+	var u []byte
+	if u = tagValue(ev.Tags, "u"); u == nil {
+		err = errorf.E("auth event has no u tag")
+		return
+	}
+	if ru := RequestURL(r, o.TrustProxy); !sameURL(string(u), ru) {
+		err = errorf.E("auth event u tag %s does not match %s", u, ru)
+		return
+	}
+	var method []byte
+	if method = tagValue(ev.Tags, "method"); method == nil {
+		err = errorf.E("auth event has no method tag")
+		return
+	}
+	if !strings.EqualFold(string(method), r.Method) {
+		err = errorf.E(
+			"auth event method %s does not match %s", method, r.Method,
+		)
+		return
+	}
+	payload := tagValue(ev.Tags, "payload")
+	if payload == nil && o.RequirePayload {
+		err = errorf.E("auth event has no payload tag")
+		return
+	}
+	if payload != nil {
+		var body []byte
+		if r.Body != nil {
+			if body, err = io.ReadAll(
+				io.LimitReader(r.Body, maxBody+1),
+			); chk.E(err) {
+				return
+			}
+			r.Body.Close()
+			if int64(len(body)) > maxBody {
+				err = errorf.E(
+					"request body is larger than %d bytes", maxBody,
+				)
+				return
+			}
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		h := sha256.Sum256(body)
+		if !strings.EqualFold(string(payload), hex.Enc(h[:])) {
+			err = errorf.E("auth event payload does not match the request body")
+			return
+		}
+	}
+	valid = true
+	pubkey = append([]byte(nil), ev.Pubkey...)
+	return
+}
+
+// tagValue returns the value of the first tag with a key, or nil.
+func tagValue(tags *tag.S, key string) (v []byte) {
+	if tags == nil {
+		return
+	}
+	if t := tags.GetFirst([]byte(key)); t != nil && t.Len() >= 2 {
+		v = t.Value()
+	}
+	return
+}
+
+// sameURL compares two URLs, ignoring a trailing slash, which clients are
+// inconsistent about for the root path.
+func sameURL(a, b string) bool {
+	return strings.TrimSuffix(a, "/") == strings.TrimSuffix(b, "/")
+}
+
+// MakeEvent creates a signed NIP-98 auth event for a request to a URL with a
+// method, and a payload tag if the body is not nil.
+func MakeEvent(
+	u, method string, body []byte, sign signer.I,
+) (ev *event.E, err error) {
+	ev = event.New()
+	ev.Kind = kind.HTTPAuth.K
+	ev.CreatedAt = time.Now().Unix()
+	ev.Tags = tag.NewS(
+		tag.NewFromAny("u", u),
+		tag.NewFromAny("method", strings.ToUpper(method)),
+	)
+	if body != nil {
+		h := sha256.Sum256(body)
+		ev.Tags.Append(tag.NewFromAny("payload", hex.Enc(h[:])))
+	}
+	if err = ev.Sign(sign); chk.E(err) {
+		return
+	}
+	return
+}
+
+// AddAuth signs a NIP-98 auth event for a request and sets its
+// Authorization header. The body, if any, is hashed into a payload tag.
+func AddAuth(r *http.Request, body []byte, sign signer.I) (err error) {
+	var ev *event.E
+	if ev, err = MakeEvent(r.URL.String(), r.Method, body, sign); chk.E(err) {
+		return
+	}
+	r.Header.Set(
+		HeaderKey,
+		Scheme+" "+base64.StdEncoding.EncodeToString(ev.Serialize()),
+	)
+	return
+}
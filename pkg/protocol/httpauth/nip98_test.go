@@ -0,0 +1,143 @@
+package httpauth
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"lol.mleku.dev/chk"
+	"next.orly.dev/pkg/crypto/p256k"
+	"next.orly.dev/pkg/utils"
+)
+
+func TestCheckAuth(t *testing.T) {
+	sign := new(p256k.Signer)
+	if err := sign.Generate(); chk.E(err) {
+		t.Fatal(err)
+	}
+	body := []byte(`{"method":"supportedmethods","params":[]}`)
+	newRequest := func() *http.Request {
+		return httptest.NewRequest(
+			http.MethodPost, "http://relay.example.com/", bytes.NewReader(body),
+		)
+	}
+
+	// a valid request with a payload
+	r := newRequest()
+	if err := AddAuth(r, body, sign); chk.E(err) {
+		t.Fatal(err)
+	}
+	valid, pubkey, err := CheckAuth(r, true)
+	if err != nil || !valid {
+		t.Fatalf("expected valid auth, got %v %v", valid, err)
+	}
+	if !utils.FastEqual(pubkey, sign.Pub()) {
+		t.Fatal("pubkey does not match the signer")
+	}
+	// the body can still be read by the handler
+	if b, _ := io.ReadAll(r.Body); !utils.FastEqual(b, body) {
+		t.Fatal("request body was not restored")
+	}
+
+	// no header is not an error, just not valid
+	if valid, _, err = CheckAuth(newRequest(), false); valid || err != nil {
+		t.Fatalf("expected no auth, got %v %v", valid, err)
+	}
+
+	// a body larger than the limit is not read
+	r = newRequest()
+	if err = AddAuth(r, body, sign); chk.E(err) {
+		t.Fatal(err)
+	}
+	if valid, _, err = CheckAuthWith(
+		r, Options{RequirePayload: true, MaxBody: int64(len(body) - 1)},
+	); valid || err == nil {
+		t.Fatalf("expected a too large body to fail, got %v %v", valid, err)
+	}
+
+	// the headers of a reverse proxy are only used when it is trusted
+	proxied := func() *http.Request {
+		r := httptest.NewRequest(
+			http.MethodPost, "http://127.0.0.1:3334/", bytes.NewReader(body),
+		)
+		r.Header.Set("X-Forwarded-Proto", "https")
+		r.Header.Set("X-Forwarded-Host", "relay.example.com")
+		ev, _ := MakeEvent("https://relay.example.com/", "POST", body, sign)
+		setHeader(r, ev.Serialize())
+		return r
+	}
+	if valid, _, err = CheckAuth(proxied(), true); valid || err == nil {
+		t.Fatalf("expected untrusted proxy headers to fail, got %v %v", valid, err)
+	}
+	if valid, _, err = CheckAuthWith(
+		proxied(), Options{RequirePayload: true, TrustProxy: true},
+	); !valid || err != nil {
+		t.Fatalf("expected trusted proxy headers to pass, got %v %v", valid, err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(r *http.Request)
+	}{
+		{
+			"wrong url", func(r *http.Request) {
+				ev, _ := MakeEvent("http://other.example.com/", "POST", body, sign)
+				setHeader(r, ev.Serialize())
+			},
+		},
+		{
+			"wrong method", func(r *http.Request) {
+				ev, _ := MakeEvent("http://relay.example.com/", "GET", body, sign)
+				setHeader(r, ev.Serialize())
+			},
+		},
+		{
+			"wrong payload", func(r *http.Request) {
+				ev, _ := MakeEvent(
+					"http://relay.example.com/", "POST", []byte("other"), sign,
+				)
+				setHeader(r, ev.Serialize())
+			},
+		},
+		{
+			"missing payload", func(r *http.Request) {
+				ev, _ := MakeEvent("http://relay.example.com/", "POST", nil, sign)
+				setHeader(r, ev.Serialize())
+			},
+		},
+		{
+			"too old", func(r *http.Request) {
+				ev, _ := MakeEvent("http://relay.example.com/", "POST", body, sign)
+				ev.CreatedAt = time.Now().Add(-time.Hour).Unix()
+				ev.Sign(sign)
+				setHeader(r, ev.Serialize())
+			},
+		},
+		{
+			"bad signature", func(r *http.Request) {
+				ev, _ := MakeEvent("http://relay.example.com/", "POST", body, sign)
+				ev.Sig[0]++
+				setHeader(r, ev.Serialize())
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				r := newRequest()
+				tt.modify(r)
+				if valid, _, err := CheckAuth(r, true); valid || err == nil {
+					t.Fatalf("expected auth to fail, got %v %v", valid, err)
+				}
+			},
+		)
+	}
+}
+
+func setHeader(r *http.Request, ev []byte) {
+	r.Header.Set(HeaderKey, Scheme+" "+base64.StdEncoding.EncodeToString(ev))
+}
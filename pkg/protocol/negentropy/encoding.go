@@ -0,0 +1,158 @@
+package negentropy
+
+import (
+	"bytes"
+	"math"
+
+	"lol.mleku.dev/errorf"
+)
+
+// Bound is the upper bound of a range, a timestamp and the shortest prefix of
+// an id that separates the range from the next.
+type Bound struct {
+	Timestamp uint64
+	Prefix    []byte
+}
+
+// infinity is the bound that is past every item.
+var infinity = Bound{Timestamp: math.MaxUint64}
+
+// greaterThan reports whether an item sorts before the bound.
+func (b Bound) greaterThan(it Item) bool {
+	if it.Timestamp != b.Timestamp {
+		return it.Timestamp < b.Timestamp
+	}
+	return bytes.Compare(it.Id[:], b.Prefix) < 0
+}
+
+// minimalBound returns the shortest bound that sorts after prev and not after
+// curr.
+func minimalBound(prev, curr Item) (b Bound) {
+	if curr.Timestamp != prev.Timestamp {
+		return Bound{Timestamp: curr.Timestamp}
+	}
+	var shared int
+	for shared < IdSize && curr.Id[shared] == prev.Id[shared] {
+		shared++
+	}
+	b = Bound{Timestamp: curr.Timestamp, Prefix: make([]byte, shared+1)}
+	copy(b.Prefix, curr.Id[:shared+1])
+	return
+}
+
+// appendVarint appends a varint in the negentropy encoding, which is base 128
+// with the most significant digit first and the high bit set on all but the
+// last byte.
+func appendVarint(dst []byte, n uint64) []byte {
+	if n == 0 {
+		return append(dst, 0)
+	}
+	var buf [10]byte
+	i := len(buf)
+	for n > 0 {
+		i--
+		buf[i] = byte(n & 0x7f)
+		n >>= 7
+	}
+	for j := i; j < len(buf)-1; j++ {
+		buf[j] |= 0x80
+	}
+	return append(dst, buf[i:]...)
+}
+
+// reader decodes the fields of a negentropy message.
+type reader struct {
+	b []byte
+}
+
+func (r *reader) empty() bool { return len(r.b) == 0 }
+
+func (r *reader) varint() (n uint64, err error) {
+	for {
+		if len(r.b) == 0 {
+			err = errorf.E("negentropy: premature end of varint")
+			return
+		}
+		c := r.b[0]
+		r.b = r.b[1:]
+		if n > math.MaxUint64>>7 {
+			err = errorf.E("negentropy: varint overflow")
+			return
+		}
+		n = n<<7 | uint64(c&0x7f)
+		if c&0x80 == 0 {
+			return
+		}
+	}
+}
+
+func (r *reader) bytes(n int) (b []byte, err error) {
+	if n < 0 || len(r.b) < n {
+		err = errorf.E("negentropy: premature end of message")
+		return
+	}
+	b, r.b = r.b[:n], r.b[n:]
+	return
+}
+
+// codec tracks the previous timestamp of the bounds in a message, which the
+// timestamps are encoded relative to.
+type codec struct {
+	lastIn, lastOut uint64
+}
+
+func (c *codec) reset() { c.lastIn, c.lastOut = 0, 0 }
+
+func (c *codec) appendBound(dst []byte, b Bound) []byte {
+	dst = c.appendTimestamp(dst, b.Timestamp)
+	dst = appendVarint(dst, uint64(len(b.Prefix)))
+	return append(dst, b.Prefix...)
+}
+
+func (c *codec) appendTimestamp(dst []byte, ts uint64) []byte {
+	if ts == math.MaxUint64 {
+		c.lastOut = math.MaxUint64
+		return appendVarint(dst, 0)
+	}
+	delta := ts - c.lastOut
+	c.lastOut = ts
+	return appendVarint(dst, delta+1)
+}
+
+func (c *codec) readBound(r *reader) (b Bound, err error) {
+	if b.Timestamp, err = c.readTimestamp(r); err != nil {
+		return
+	}
+	var n uint64
+	if n, err = r.varint(); err != nil {
+		return
+	}
+	if n > IdSize {
+		err = errorf.E("negentropy: bound prefix is too long")
+		return
+	}
+	var p []byte
+	if p, err = r.bytes(int(n)); err != nil {
+		return
+	}
+	b.Prefix = append([]byte(nil), p...)
+	return
+}
+
+func (c *codec) readTimestamp(r *reader) (ts uint64, err error) {
+	if ts, err = r.varint(); err != nil {
+		return
+	}
+	if ts == 0 {
+		ts = math.MaxUint64
+	} else {
+		ts--
+	}
+	if c.lastIn == math.MaxUint64 || ts == math.MaxUint64 {
+		c.lastIn = math.MaxUint64
+		return math.MaxUint64, nil
+	}
+	ts += c.lastIn
+	c.lastIn = ts
+	return
+}
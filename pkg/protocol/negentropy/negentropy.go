@@ -0,0 +1,242 @@
+// Package negentropy implements the range-based set reconciliation protocol
+// used by NIP-77, version 1 (0x61).
+//
+// Each side holds a sorted Storage of event ids and timestamps. The initiator
+// sends fingerprints of ranges of its set, the other side answers with
+// fingerprints of sub-ranges where they differ, and once a range is small
+// enough the ids themselves are exchanged, so the differences between two
+// large sets are found in a few round trips.
+package negentropy
+
+import (
+	"lol.mleku.dev/errorf"
+)
+
+// ProtocolVersion is the negentropy protocol version implemented here.
+const ProtocolVersion = 0x61
+
+// the modes of a range in a message
+const (
+	modeSkip        = 0
+	modeFingerprint = 1
+	modeIdList      = 2
+)
+
+// buckets is the number of sub-ranges a range is split into when its
+// fingerprints differ.
+const buckets = 16
+
+// T is one side of a reconciliation.
+type T struct {
+	storage        *Storage
+	frameSizeLimit int
+	isInitiator    bool
+	codec
+}
+
+// New creates a reconciliation over a storage, which is sealed if it was not
+// already. A frameSizeLimit above zero bounds the size of the messages
+// produced, and must be at least 4096.
+func New(storage *Storage, frameSizeLimit int) (n *T, err error) {
+	if frameSizeLimit != 0 && frameSizeLimit < 4096 {
+		err = errorf.E("negentropy: frame size limit must be 0 or at least 4096")
+		return
+	}
+	storage.Seal()
+	n = &T{storage: storage, frameSizeLimit: frameSizeLimit}
+	return
+}
+
+// Initiate returns the first message of a reconciliation, and makes this
+// side the initiator.
+func (n *T) Initiate() (msg []byte) {
+	n.isInitiator = true
+	n.reset()
+	msg = append(msg, ProtocolVersion)
+	msg = n.splitRange(msg, 0, n.storage.Size(), infinity)
+	return
+}
+
+// Reconcile processes a message from the other side and returns the reply.
+//
+// On the initiator the ids found only on this side are returned in have and
+// the ids found only on the other side in need, and a nil reply means the
+// reconciliation is complete. The other side never learns the differences, so
+// it only returns replies.
+func (n *T) Reconcile(msg []byte) (
+	reply []byte, have, need [][]byte, err error,
+) {
+	n.reset()
+	r := &reader{b: msg}
+	var v []byte
+	if v, err = r.bytes(1); err != nil {
+		return
+	}
+	reply = append(reply, ProtocolVersion)
+	if v[0] < 0x60 || v[0] > 0x6f {
+		err = errorf.E("negentropy: invalid protocol version byte %x", v[0])
+		return
+	}
+	if v[0] != ProtocolVersion {
+		if n.isInitiator {
+			err = errorf.E("negentropy: unsupported protocol version %x", v[0])
+			return
+		}
+		// reply with the version we do support
+		return
+	}
+	size := n.storage.Size()
+	var prevBound Bound
+	var prevIndex int
+	var skip bool
+	for !r.empty() {
+		var o []byte
+		// if o is dropped for the frame size limit, the skip and the
+		// timestamp it was encoded relative to are needed again
+		skipped, lastOut := skip, n.lastOut
+		doSkip := func() {
+			if skip {
+				skip = false
+				o = n.appendBound(o, prevBound)
+				o = appendVarint(o, modeSkip)
+			}
+		}
+		var currBound Bound
+		if currBound, err = n.readBound(r); err != nil {
+			return
+		}
+		var mode uint64
+		if mode, err = r.varint(); err != nil {
+			return
+		}
+		lower := prevIndex
+		upper := n.storage.findLowerBound(prevIndex, size, currBound)
+		switch mode {
+		case modeSkip:
+			skip = true
+		case modeFingerprint:
+			var theirs []byte
+			if theirs, err = r.bytes(FingerprintSize); err != nil {
+				return
+			}
+			ours := n.storage.fingerprint(lower, upper)
+			if string(theirs) != string(ours[:]) {
+				doSkip()
+				o = n.splitRange(o, lower, upper, currBound)
+			} else {
+				skip = true
+			}
+		case modeIdList:
+			var num uint64
+			if num, err = r.varint(); err != nil {
+				return
+			}
+			theirs := make(map[[IdSize]byte]struct{}, num)
+			for i := uint64(0); i < num; i++ {
+				var id []byte
+				if id, err = r.bytes(IdSize); err != nil {
+					return
+				}
+				theirs[[IdSize]byte(id)] = struct{}{}
+			}
+			for i := lower; i < upper; i++ {
+				id := n.storage.Item(i).Id
+				if _, ok := theirs[id]; ok {
+					delete(theirs, id)
+				} else if n.isInitiator {
+					have = append(have, append([]byte(nil), id[:]...))
+				}
+			}
+			if n.isInitiator {
+				skip = true
+				for id := range theirs {
+					need = append(need, append([]byte(nil), id[:]...))
+				}
+				break
+			}
+			doSkip()
+			var ids []byte
+			var count uint64
+			endBound := currBound
+			for i := lower; i < upper; i++ {
+				if n.exceededFrameSizeLimit(len(reply) + len(ids)) {
+					it := n.storage.Item(i)
+					endBound = Bound{Timestamp: it.Timestamp, Prefix: it.Id[:]}
+					upper = i
+					break
+				}
+				id := n.storage.Item(i).Id
+				ids = append(ids, id[:]...)
+				count++
+			}
+			o = n.appendBound(o, endBound)
+			o = appendVarint(o, modeIdList)
+			o = appendVarint(o, count)
+			o = append(o, ids...)
+		default:
+			err = errorf.E("negentropy: unknown mode %d", mode)
+			return
+		}
+		if n.exceededFrameSizeLimit(len(reply) + len(o)) {
+			// stop here and send a fingerprint of everything from the start
+			// of this range, so the other side picks it up in the next round
+			n.lastOut = lastOut
+			if skipped {
+				reply = n.appendBound(reply, prevBound)
+				reply = appendVarint(reply, modeSkip)
+			}
+			fp := n.storage.fingerprint(lower, size)
+			reply = n.appendBound(reply, infinity)
+			reply = appendVarint(reply, modeFingerprint)
+			reply = append(reply, fp[:]...)
+			break
+		}
+		reply = append(reply, o...)
+		prevIndex = upper
+		prevBound = currBound
+	}
+	if n.isInitiator && len(reply) == 1 {
+		// nothing left to reconcile
+		reply = nil
+	}
+	return
+}
+
+// splitRange appends the ranges covering [lower, upper), either as the ids
+// when there are few of them or as the fingerprints of buckets.
+func (n *T) splitRange(dst []byte, lower, upper int, upperBound Bound) []byte {
+	num := upper - lower
+	if num < buckets*2 {
+		dst = n.appendBound(dst, upperBound)
+		dst = appendVarint(dst, modeIdList)
+		dst = appendVarint(dst, uint64(num))
+		for i := lower; i < upper; i++ {
+			id := n.storage.Item(i).Id
+			dst = append(dst, id[:]...)
+		}
+		return dst
+	}
+	perBucket := num / buckets
+	withExtra := num % buckets
+	curr := lower
+	for i := 0; i < buckets; i++ {
+		size := perBucket
+		if i < withExtra {
+			size++
+		}
+		fp := n.storage.fingerprint(curr, curr+size)
+		curr += size
+		next := upperBound
+		if curr != upper {
+			next = minimalBound(n.storage.Item(curr-1), n.storage.Item(curr))
+		}
+		dst = n.appendBound(dst, next)
+		dst = appendVarint(dst, modeFingerprint)
+		dst = append(dst, fp[:]...)
+	}
+	return dst
+}
+
+func (n *T) exceededFrameSizeLimit(size int) bool {
+	return n.frameSizeLimit != 0 && size > n.frameSizeLimit-200
+}
@@ -0,0 +1,152 @@
+package negentropy
+
+import (
+	"bytes"
+	"sort"
+	"testing"
+
+	"lukechampine.com/frand"
+)
+
+// reconcile runs a reconciliation between two storages to completion and
+// returns what the initiator has and needs, and the number of round trips.
+func reconcile(
+	t *testing.T, client, relay *Storage, frameSizeLimit int,
+) (have, need [][]byte, rounds int) {
+	var err error
+	var c, r *T
+	if c, err = New(client, frameSizeLimit); err != nil {
+		t.Fatal(err)
+	}
+	if r, err = New(relay, frameSizeLimit); err != nil {
+		t.Fatal(err)
+	}
+	msg := c.Initiate()
+	for msg != nil {
+		rounds++
+		if rounds > 100 {
+			t.Fatal("reconciliation did not converge")
+		}
+		if frameSizeLimit > 0 && len(msg) > frameSizeLimit {
+			t.Fatalf("message of %d bytes exceeds frame size limit", len(msg))
+		}
+		var reply []byte
+		if reply, _, _, err = r.Reconcile(msg); err != nil {
+			t.Fatal(err)
+		}
+		var h, n [][]byte
+		if msg, h, n, err = c.Reconcile(reply); err != nil {
+			t.Fatal(err)
+		}
+		have = append(have, h...)
+		need = append(need, n...)
+	}
+	return
+}
+
+func sortIds(ids [][]byte) [][]byte {
+	sort.Slice(ids, func(i, j int) bool { return bytes.Compare(ids[i], ids[j]) < 0 })
+	return ids
+}
+
+func equalIds(t *testing.T, what string, got, want [][]byte) {
+	sortIds(got)
+	sortIds(want)
+	if len(got) != len(want) {
+		t.Fatalf("%s: got %d ids, want %d", what, len(got), len(want))
+	}
+	for i := range got {
+		if !bytes.Equal(got[i], want[i]) {
+			t.Fatalf("%s: id %d differs", what, i)
+		}
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	tests := []struct {
+		name                 string
+		shared, onlyC, onlyR int
+		frameSizeLimit       int
+	}{
+		{"empty", 0, 0, 0, 0},
+		{"identical", 1000, 0, 0, 0},
+		{"small", 10, 3, 4, 0},
+		{"client empty", 0, 0, 100, 0},
+		{"relay empty", 0, 100, 0, 0},
+		{"large", 20000, 150, 170, 0},
+		{"frame size limit", 5000, 600, 700, 4096},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				client := NewStorage(tt.shared + tt.onlyC)
+				relay := NewStorage(tt.shared + tt.onlyR)
+				add := func(s *Storage) []byte {
+					id := frand.Bytes(IdSize)
+					// a narrow timestamp range so ids often break the ties
+					if err := s.Insert(1700000000+frand.Uint64n(1000), id); err != nil {
+						t.Fatal(err)
+					}
+					return id
+				}
+				for i := 0; i < tt.shared; i++ {
+					id := frand.Bytes(IdSize)
+					ts := 1700000000 + frand.Uint64n(1000)
+					if err := client.Insert(ts, id); err != nil {
+						t.Fatal(err)
+					}
+					if err := relay.Insert(ts, id); err != nil {
+						t.Fatal(err)
+					}
+				}
+				var wantHave, wantNeed [][]byte
+				for i := 0; i < tt.onlyC; i++ {
+					wantHave = append(wantHave, add(client))
+				}
+				for i := 0; i < tt.onlyR; i++ {
+					wantNeed = append(wantNeed, add(relay))
+				}
+				have, need, rounds := reconcile(t, client, relay, tt.frameSizeLimit)
+				equalIds(t, "have", have, wantHave)
+				equalIds(t, "need", need, wantNeed)
+				t.Logf("reconciled in %d round trips", rounds)
+			},
+		)
+	}
+}
+
+func TestVarint(t *testing.T) {
+	for _, n := range []uint64{0, 1, 127, 128, 16383, 16384, 1 << 40, 1<<64 - 1} {
+		b := appendVarint(nil, n)
+		r := &reader{b: b}
+		got, err := r.varint()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != n || !r.empty() {
+			t.Fatalf("varint %d decoded as %d", n, got)
+		}
+	}
+	// 128 is two bytes, most significant digit first
+	if b := appendVarint(nil, 128); !bytes.Equal(b, []byte{0x81, 0x00}) {
+		t.Fatalf("varint 128 encoded as %x", b)
+	}
+}
+
+func TestUnsupportedVersion(t *testing.T) {
+	n, err := New(NewStorage(0), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the relay side answers an unknown version with the one it supports
+	reply, _, _, err := n.Reconcile([]byte{0x62})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reply, []byte{ProtocolVersion}) {
+		t.Fatalf("got reply %x", reply)
+	}
+	if _, _, _, err = n.Reconcile([]byte{0x10}); err == nil {
+		t.Fatal("expected an error for an invalid version byte")
+	}
+}
@@ -0,0 +1,118 @@
+package negentropy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"sort"
+
+	"lol.mleku.dev/errorf"
+)
+
+const (
+	// IdSize is the length of an event id in bytes.
+	IdSize = 32
+	// FingerprintSize is the length of a range fingerprint in bytes.
+	FingerprintSize = 16
+)
+
+// Item is an element of the reconciled set, an event id and its created_at
+// timestamp, which the set is ordered by.
+type Item struct {
+	Timestamp uint64
+	Id        [IdSize]byte
+}
+
+// Less reports whether the item sorts before another, by timestamp then id.
+func (it Item) Less(o Item) bool {
+	if it.Timestamp != o.Timestamp {
+		return it.Timestamp < o.Timestamp
+	}
+	return bytes.Compare(it.Id[:], o.Id[:]) < 0
+}
+
+// Storage is the sorted set of items one side of a reconciliation holds.
+//
+// Items are added with Insert and the storage must be sealed before it is
+// used, which sorts the items.
+type Storage struct {
+	items  []Item
+	sealed bool
+}
+
+// NewStorage creates an empty Storage with capacity for n items.
+func NewStorage(n int) *Storage {
+	return &Storage{items: make([]Item, 0, n)}
+}
+
+// Insert adds an event id and timestamp to the storage.
+func (s *Storage) Insert(timestamp uint64, id []byte) (err error) {
+	if s.sealed {
+		err = errorf.E("negentropy: storage is already sealed")
+		return
+	}
+	if len(id) != IdSize {
+		err = errorf.E("negentropy: id must be %d bytes, got %d", IdSize, len(id))
+		return
+	}
+	it := Item{Timestamp: timestamp}
+	copy(it.Id[:], id)
+	s.items = append(s.items, it)
+	return
+}
+
+// Seal sorts the items and removes duplicates. No more items can be added
+// after this.
+func (s *Storage) Seal() {
+	if s.sealed {
+		return
+	}
+	sort.Slice(
+		s.items, func(i, j int) bool { return s.items[i].Less(s.items[j]) },
+	)
+	// drop duplicates, which would otherwise corrupt the fingerprints
+	if len(s.items) > 1 {
+		out := s.items[:1]
+		for _, it := range s.items[1:] {
+			if it != out[len(out)-1] {
+				out = append(out, it)
+			}
+		}
+		s.items = out
+	}
+	s.sealed = true
+}
+
+// Size returns the number of items in the storage.
+func (s *Storage) Size() int { return len(s.items) }
+
+// Item returns the item at index i.
+func (s *Storage) Item(i int) Item { return s.items[i] }
+
+// findLowerBound returns the index of the first item in [begin, end) that
+// is not less than the bound, or end if there is none.
+func (s *Storage) findLowerBound(begin, end int, b Bound) int {
+	return begin + sort.Search(
+		end-begin, func(i int) bool { return !b.greaterThan(s.items[begin+i]) },
+	)
+}
+
+// fingerprint returns the fingerprint of the items in [begin, end), which is
+// the first 16 bytes of the sha256 hash of the sum of the ids, as 256 bit
+// little-endian integers, followed by the varint count of the items.
+func (s *Storage) fingerprint(begin, end int) (fp [FingerprintSize]byte) {
+	var acc [IdSize]byte
+	for i := begin; i < end; i++ {
+		var carry uint16
+		id := s.items[i].Id
+		for j := 0; j < IdSize; j++ {
+			carry += uint16(acc[j]) + uint16(id[j])
+			acc[j] = byte(carry)
+			carry >>= 8
+		}
+	}
+	h := sha256.New()
+	h.Write(acc[:])
+	h.Write(appendVarint(nil, uint64(end-begin)))
+	copy(fp[:], h.Sum(nil))
+	return
+}
@@ -0,0 +1,63 @@
+// Package pow implements the NIP-13 proof of work check, where the difficulty
+// of an event is the number of leading zero bits of its ID, and the nonce tag
+// commits to the target difficulty the author was mining for.
+package pow
+
+import (
+	"math/bits"
+	"strconv"
+
+	"lol.mleku.dev/errorf"
+	"next.orly.dev/pkg/encoders/event"
+)
+
+// Difficulty returns the number of leading zero bits of an event ID.
+func Difficulty(id []byte) (n int) {
+	for _, b := range id {
+		if b == 0 {
+			n += 8
+			continue
+		}
+		n += bits.LeadingZeros8(b)
+		break
+	}
+	return
+}
+
+// Committed returns the target difficulty committed to in the third field of
+// the nonce tag of an event, if there is one.
+func Committed(ev *event.E) (target int, ok bool) {
+	if ev.Tags == nil {
+		return
+	}
+	t := ev.Tags.GetFirst([]byte("nonce"))
+	if t == nil || t.Len() < 3 {
+		return
+	}
+	var err error
+	if target, err = strconv.Atoi(string(t.T[2])); err != nil {
+		return
+	}
+	ok = true
+	return
+}
+
+// Check returns an error if an event has less than the minimum difficulty.
+//
+// When the nonce tag commits to a target, that target must also meet the
+// minimum, so an event mined for a lower target that happens to have a lucky
+// ID is still rejected.
+func Check(ev *event.E, min int) (err error) {
+	if min <= 0 {
+		return
+	}
+	if d := Difficulty(ev.ID); d < min {
+		err = errorf.E("difficulty %d is less than %d", d, min)
+		return
+	}
+	if target, ok := Committed(ev); ok && target < min {
+		err = errorf.E("committed target %d is less than %d", target, min)
+		return
+	}
+	return
+}
@@ -0,0 +1,48 @@
+package pow
+
+import (
+	"testing"
+
+	"next.orly.dev/pkg/encoders/event"
+	"next.orly.dev/pkg/encoders/tag"
+)
+
+func TestDifficulty(t *testing.T) {
+	tests := []struct {
+		id   []byte
+		want int
+	}{
+		{[]byte{0xff, 0x00}, 0},
+		{[]byte{0x7f, 0x00}, 1},
+		{[]byte{0x00, 0x0f}, 12},
+		{[]byte{0x00, 0x00, 0x01}, 23},
+		{[]byte{0x00, 0x00}, 16},
+	}
+	for _, tt := range tests {
+		if got := Difficulty(tt.id); got != tt.want {
+			t.Errorf("Difficulty(%x) = %d, want %d", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	id := make([]byte, 32)
+	id[2] = 0x10 // 19 leading zero bits
+	ev := event.New()
+	ev.ID = id
+	if err := Check(ev, 19); err != nil {
+		t.Fatalf("expected difficulty 19 to pass: %v", err)
+	}
+	if err := Check(ev, 20); err == nil {
+		t.Fatal("expected difficulty 20 to fail")
+	}
+	// a lucky ID does not pass when the committed target is too low
+	ev.Tags = tag.NewS(tag.NewFromAny("nonce", "12345", "8"))
+	if err := Check(ev, 16); err == nil {
+		t.Fatal("expected a committed target of 8 to fail at 16")
+	}
+	ev.Tags = tag.NewS(tag.NewFromAny("nonce", "12345", "16"))
+	if err := Check(ev, 16); err != nil {
+		t.Fatalf("expected a committed target of 16 to pass: %v", err)
+	}
+}
@@ -62,6 +62,8 @@ var (
 	NIP11                    = RelayInformationDocument
 	GenericTagQueries        = NIP{"Generic Tag Queries", 12}
 	NIP12                    = GenericTagQueries
+	ProofOfWork              = NIP{"Proof of Work", 13}
+	NIP13                    = ProofOfWork
 	SubjectTag               = NIP{"Subject tag in text events", 14}
 	NIP14                    = SubjectTag
 	NostrMarketplace         = NIP{
@@ -92,6 +94,8 @@ var (
 	NIP27                          = TextNoteReferences
 	PublicChat                     = NIP{"Public Chat", 28}
 	NIP28                          = PublicChat
+	RelayBasedGroups               = NIP{"Relay-based Groups", 29}
+	NIP29                          = RelayBasedGroups
 	CustomEmoji                    = NIP{"Custom Emoji", 30}
 	NIP30                          = CustomEmoji
 	Labeling                       = NIP{"Labeling", 32}
@@ -134,6 +138,8 @@ var (
 	NIP57                          = LightningZaps
 	Badges                         = NIP{"Badges", 58}
 	NIP58                          = Badges
+	RequestToVanish                = NIP{"Request to Vanish", 62}
+	NIP62                          = RequestToVanish
 	RelayListMetadata              = NIP{"Client List Metadata", 65}
 	NIP65                          = RelayListMetadata
 	ProtectedEvents                = NIP{"Protected Events", 70}
@@ -142,6 +148,8 @@ var (
 	NIP72                          = ModeratedCommunities
 	ZapGoals                       = NIP{"Zap Goals", 75}
 	NIP75                          = ZapGoals
+	NegentropySyncing              = NIP{"Negentropy Syncing", 77}
+	NIP77                          = NegentropySyncing
 	ApplicationSpecificData        = NIP{"Application-specific data", 78}
 	NIP78                          = ApplicationSpecificData
 	Highlights                     = NIP{"Highlights", 84}
@@ -152,6 +160,8 @@ var (
 	NIP90                          = DataVendingMachines
 	FileMetadata                   = NIP{"File Metadata", 94}
 	NIP94                          = FileMetadata
+	RelayManagementAPI             = NIP{"Relay Management API", 86}
+	NIP86                          = RelayManagementAPI
 	HTTPFileStorageIntegration     = NIP{"HTTP File Storage Integration", 96}
 	NIP96                          = HTTPFileStorageIntegration
 	HTTPAuth                       = NIP{"HTTP IsAuthed", 98}
@@ -162,17 +172,17 @@ var (
 
 var NIPMap = map[int]NIP{
 	1: NIP1, 2: NIP2, 3: NIP3, 4: NIP4, 5: NIP5, 8: NIP8, 9: NIP9,
-	11: NIP11, 12: NIP12, 14: NIP14, 15: NIP15, 16: NIP16, 18: NIP18, 19: NIP19,
+	11: NIP11, 12: NIP12, 13: NIP13, 14: NIP14, 15: NIP15, 16: NIP16, 18: NIP18, 19: NIP19,
 	20: NIP20,
 	21: NIP21, 22: NIP22, 23: NIP23, 24: NIP24, 25: NIP25, 26: NIP26, 27: NIP27,
-	28: NIP28,
+	28: NIP28, 29: NIP29,
 	30: NIP30, 32: NIP32, 33: NIP33, 36: NIP36, 38: NIP38, 39: NIP39, 40: NIP40,
 	42: NIP42,
 	44: NIP44, 45: NIP45, 46: NIP46, 47: NIP47, 48: NIP48, 50: NIP50, 51: NIP51,
 	52: NIP52,
-	53: NIP53, 56: NIP56, 57: NIP57, 58: NIP58, 65: NIP65, 72: NIP72, 75: NIP75,
-	78: NIP78,
-	84: NIP84, 89: NIP89, 90: NIP90, 94: NIP94, 96: NIP96, 98: NIP98, 99: NIP99,
+	53: NIP53, 56: NIP56, 57: NIP57, 58: NIP58, 62: NIP62, 65: NIP65, 72: NIP72, 75: NIP75,
+	77: NIP77, 78: NIP78,
+	84: NIP84, 86: NIP86, 89: NIP89, 90: NIP90, 94: NIP94, 96: NIP96, 98: NIP98, 99: NIP99,
 }
 
 // Limits are rules about what is acceptable for events and filters on a relay.
@@ -21,6 +21,7 @@ import (
 	"next.orly.dev/pkg/encoders/envelopes/closedenvelope"
 	"next.orly.dev/pkg/encoders/envelopes/eoseenvelope"
 	"next.orly.dev/pkg/encoders/envelopes/eventenvelope"
+	"next.orly.dev/pkg/encoders/envelopes/negentropyenvelope"
 	"next.orly.dev/pkg/encoders/envelopes/noticeenvelope"
 	"next.orly.dev/pkg/encoders/envelopes/okenvelope"
 	"next.orly.dev/pkg/encoders/event"
@@ -30,6 +31,7 @@ import (
 	"next.orly.dev/pkg/encoders/tag"
 	"next.orly.dev/pkg/interfaces/codec"
 	"next.orly.dev/pkg/interfaces/signer"
+	"next.orly.dev/pkg/utils/bytecount"
 	"next.orly.dev/pkg/utils/normalize"
 )
 
@@ -53,9 +55,16 @@ type Client struct {
 	notices                       chan []byte  // NIP-01 NOTICEs
 	customHandler                 func(string) // nonstandard unparseable messages
 	okCallbacks                   *xsync.MapOf[string, func(bool, string)]
+	negentropySubs                *xsync.MapOf[string, chan codec.Envelope]
 	writeQueue                    chan writeRequest
 	subscriptionChannelCloseQueue chan []byte
 
+	compression Compression // permessage-deflate negotiated when connecting
+
+	// Traffic counts the bytes sent and received, before compression and on
+	// the wire.
+	Traffic bytecount.Counter
+
 	// custom things that aren't often used
 	//
 	AssumeValid bool // this will skip verifying signatures for events received from this relay
@@ -77,9 +86,11 @@ func NewRelay(ctx context.Context, url string, opts ...RelayOption) *Client {
 		okCallbacks: xsync.NewMapOf[string, func(
 			bool, string,
 		)](),
+		negentropySubs:                xsync.NewMapOf[string, chan codec.Envelope](),
 		writeQueue:                    make(chan writeRequest),
 		subscriptionChannelCloseQueue: make(chan []byte),
 		requestHeader:                 nil,
+		compression:                   DefaultCompression,
 	}
 
 	for _, opt := range opts {
@@ -111,6 +122,7 @@ type RelayOption interface {
 var (
 	_ RelayOption = (WithCustomHandler)(nil)
 	_ RelayOption = (WithRequestHeader)(nil)
+	_ RelayOption = WithCompression{}
 )
 
 // WithCustomHandler must be a function that handles any relay message that couldn't be
@@ -128,6 +140,14 @@ func (ch WithRequestHeader) ApplyRelayOption(r *Client) {
 	r.requestHeader = http.Header(ch)
 }
 
+// WithCompression sets the permessage-deflate compression mode and threshold
+// negotiated with the relay.
+type WithCompression Compression
+
+func (c WithCompression) ApplyRelayOption(r *Client) {
+	r.compression = Compression(c)
+}
+
 // String just returns the relay URL.
 func (r *Client) String() string {
 	return r.URL
@@ -209,7 +229,9 @@ func (r *Client) ConnectWithTLS(
 		defer cancel()
 	}
 
-	conn, err := NewConnection(ctx, r.URL, r.requestHeader, tlsConfig)
+	conn, err := NewConnection(
+		ctx, r.URL, r.requestHeader, tlsConfig, r.compression, &r.Traffic,
+	)
 	if err != nil {
 		return fmt.Errorf("error opening websocket to '%s': %w", r.URL, err)
 	}
@@ -369,6 +391,18 @@ func (r *Client) ConnectWithTLS(
 					if subscription, ok := r.Subscriptions.Load(string(env.Subscription)); ok {
 						subscription.handleClosed(env.ReasonString())
 					}
+				case negentropyenvelope.LMsg:
+					env := negentropyenvelope.NewMsg()
+					if env, message, err = negentropyenvelope.ParseMsg(message); chk.E(err) {
+						continue
+					}
+					r.dispatchNegentropy(env.Subscription, env)
+				case negentropyenvelope.LErr:
+					env := negentropyenvelope.NewErr()
+					if env, message, err = negentropyenvelope.ParseErr(message); chk.E(err) {
+						continue
+					}
+					r.dispatchNegentropy(env.Subscription, env)
 				case okenvelope.L:
 					env := okenvelope.New()
 					if env, message, err = okenvelope.Parse(message); chk.E(err) {
@@ -409,6 +443,14 @@ func (r *Client) Publish(ctx context.Context, ev *event.E) error {
 	)
 }
 
+// PublishEnvelope sends an envelope that carries an event to the relay r and
+// waits for the OK response for the event with the given ID, like Publish.
+func (r *Client) PublishEnvelope(
+	ctx context.Context, id []byte, env codec.Envelope,
+) error {
+	return r.publish(ctx, hex.Enc(id), env)
+}
+
 // Auth sends an "AUTH" command client->relay as in NIP-42 and waits for an OK response.
 //
 // You don't have to build the AUTH event yourself, this function takes a function to which the
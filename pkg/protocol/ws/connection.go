@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"lol.mleku.dev/errorf"
+	"next.orly.dev/pkg/utils/bytecount"
 	"next.orly.dev/pkg/utils/units"
 
 	ws "github.com/coder/websocket"
@@ -17,23 +18,29 @@ import (
 
 // Connection represents a websocket connection to a Nostr relay.
 type Connection struct {
-	conn *ws.Conn
+	conn    *ws.Conn
+	traffic *bytecount.Counter
 }
 
-// NewConnection creates a new websocket connection to a Nostr relay.
+// NewConnection creates a new websocket connection to a Nostr relay,
+// negotiating the given compression. If traffic is not nil, the bytes sent
+// and received are counted in it.
 func NewConnection(
 	ctx context.Context, url string, reqHeader http.Header,
-	tlsConfig *tls.Config,
+	tlsConfig *tls.Config, compression Compression,
+	traffic *bytecount.Counter,
 ) (c *Connection, err error) {
 	var conn *ws.Conn
 	if conn, _, err = ws.Dial(
-		ctx, url, getConnectionOptions(reqHeader, tlsConfig),
+		ctx, url,
+		getConnectionOptions(reqHeader, tlsConfig, compression, traffic),
 	); err != nil {
 		return
 	}
 	conn.SetReadLimit(33 * units.Mb)
 	return &Connection{
-		conn: conn,
+		conn:    conn,
+		traffic: traffic,
 	}, nil
 }
 
@@ -45,6 +52,9 @@ func (c *Connection) WriteMessage(
 		err = errorf.E("failed to write message: %w", err)
 		return
 	}
+	if c.traffic != nil {
+		c.traffic.RawOut.Add(uint64(len(data)))
+	}
 	return nil
 }
 
@@ -57,10 +67,14 @@ func (c *Connection) ReadMessage(
 		err = fmt.Errorf("failed to get reader: %w", err)
 		return
 	}
-	if _, err = io.Copy(buf, reader); err != nil {
+	var n int64
+	if n, err = io.Copy(buf, reader); err != nil {
 		err = fmt.Errorf("failed to read message: %w", err)
 		return
 	}
+	if c.traffic != nil {
+		c.traffic.RawIn.Add(uint64(n))
+	}
 	return
 }
 
@@ -3,34 +3,96 @@
 package ws
 
 import (
+	"context"
 	"crypto/tls"
+	"fmt"
+	"net"
 	"net/http"
 	"net/textproto"
+	"time"
 
 	ws "github.com/coder/websocket"
+	"next.orly.dev/pkg/utils/bytecount"
 )
 
-var defaultConnectionOptions = &ws.DialOptions{
-	CompressionMode: ws.CompressionContextTakeover,
-	HTTPHeader: http.Header{
-		textproto.CanonicalMIMEHeaderKey("User-Agent"): {"github.com/nbd-wtf/go-nostr"},
-	},
+// Compression is the permessage-deflate mode negotiated for a connection, and
+// the minimum size of a message to compress, zero meaning the library
+// default.
+type Compression struct {
+	Mode      ws.CompressionMode
+	Threshold int
+}
+
+// DefaultCompression is the compression negotiated by a Client unless it is
+// created WithCompression.
+var DefaultCompression = Compression{Mode: ws.CompressionContextTakeover}
+
+// ParseCompressionMode parses a permessage-deflate mode as given in the
+// configuration: "disabled", "no-context-takeover" or "context-takeover".
+func ParseCompressionMode(s string) (mode ws.CompressionMode, err error) {
+	switch s {
+	case "disabled", "none", "":
+		return ws.CompressionDisabled, nil
+	case "no-context-takeover":
+		return ws.CompressionNoContextTakeover, nil
+	case "context-takeover":
+		return ws.CompressionContextTakeover, nil
+	}
+	err = fmt.Errorf("unknown websocket compression mode %q", s)
+	return
+}
+
+// NewCompression returns the Compression for a mode as parsed by
+// ParseCompressionMode and a threshold.
+func NewCompression(mode string, threshold int) (c Compression, err error) {
+	if c.Mode, err = ParseCompressionMode(mode); err != nil {
+		return
+	}
+	c.Threshold = threshold
+	return
+}
+
+var userAgent = http.Header{
+	textproto.CanonicalMIMEHeaderKey("User-Agent"): {"github.com/nbd-wtf/go-nostr"},
+}
+
+// NewDialOptions returns the options to dial a websocket with a request
+// header, TLS configuration and compression, counting the bytes on the wire
+// in traffic if it is not nil. A nil header sends the default User-Agent.
+func NewDialOptions(
+	requestHeader http.Header, tlsConfig *tls.Config, compression Compression,
+	traffic *bytecount.Counter,
+) *ws.DialOptions {
+	return getConnectionOptions(requestHeader, tlsConfig, compression, traffic)
 }
 
 func getConnectionOptions(
-	requestHeader http.Header, tlsConfig *tls.Config,
+	requestHeader http.Header, tlsConfig *tls.Config, compression Compression,
+	traffic *bytecount.Counter,
 ) *ws.DialOptions {
-	if requestHeader == nil && tlsConfig == nil {
-		return defaultConnectionOptions
+	if requestHeader == nil {
+		requestHeader = userAgent
 	}
-
-	return &ws.DialOptions{
-		HTTPHeader:      requestHeader,
-		CompressionMode: ws.CompressionContextTakeover,
-		HTTPClient: &http.Client{
-			Transport: &http.Transport{
-				TLSClientConfig: tlsConfig,
-			},
-		},
+	opts := &ws.DialOptions{
+		HTTPHeader:           requestHeader,
+		CompressionMode:      compression.Mode,
+		CompressionThreshold: compression.Threshold,
+	}
+	if tlsConfig == nil && traffic == nil {
+		return opts
+	}
+	transport := &http.Transport{TLSClientConfig: tlsConfig}
+	if traffic != nil {
+		dialer := &net.Dialer{Timeout: 30 * time.Second}
+		transport.DialContext = func(
+			ctx context.Context, network, addr string,
+		) (conn net.Conn, err error) {
+			if conn, err = dialer.DialContext(ctx, network, addr); err != nil {
+				return
+			}
+			return traffic.Conn(conn), nil
+		}
 	}
+	opts.HTTPClient = &http.Client{Transport: transport}
+	return opts
 }
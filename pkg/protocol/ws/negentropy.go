@@ -0,0 +1,112 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"lol.mleku.dev/log"
+	"next.orly.dev/pkg/encoders/envelopes/negentropyenvelope"
+	"next.orly.dev/pkg/encoders/filter"
+	"next.orly.dev/pkg/interfaces/codec"
+	"next.orly.dev/pkg/protocol/negentropy"
+)
+
+// NegentropyFrameSizeLimit bounds the negentropy messages the client sends,
+// which are doubled in size by the hex encoding.
+const NegentropyFrameSizeLimit = 128 * 1024
+
+// Reconcile runs a NIP-77 negentropy reconciliation with the relay over the
+// events matching a filter, using storage as the local set, which should
+// hold the ids and timestamps of the local events that match the filter.
+//
+// It returns the ids of the events that only the local side has, and of the
+// events that only the relay has, so they can be published or fetched.
+func (r *Client) Reconcile(
+	ctx context.Context, f *filter.F, storage *negentropy.Storage,
+) (have, need [][]byte, err error) {
+	if _, ok := ctx.Deadline(); !ok {
+		// if no timeout is set, force it to a minute
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(
+			ctx, time.Minute, errors.New("Reconcile() took too long"),
+		)
+		defer cancel()
+	}
+	var n *negentropy.T
+	if n, err = negentropy.New(storage, NegentropyFrameSizeLimit); err != nil {
+		return
+	}
+	id := []byte(
+		strconv.FormatInt(subscriptionIDCounter.Add(1), 10) + ":negentropy",
+	)
+	// replies come one at a time, as each is answered before the next
+	ch := make(chan codec.Envelope, 1)
+	r.negentropySubs.Store(string(id), ch)
+	defer r.negentropySubs.Delete(string(id))
+	msg := n.Initiate()
+	if err = <-r.Write(
+		negentropyenvelope.NewOpenFrom(id, f, msg).Marshal(nil),
+	); err != nil {
+		return
+	}
+	defer func() {
+		// the relay may already have closed it after a NEG-ERR, but this is
+		// harmless
+		<-r.Write(negentropyenvelope.NewCloseFrom(id).Marshal(nil))
+	}()
+	for rounds := 1; ; rounds++ {
+		select {
+		case <-ctx.Done():
+			err = context.Cause(ctx)
+			return
+		case <-r.connectionContext.Done():
+			err = fmt.Errorf(
+				"relay connection closed: %w", context.Cause(r.connectionContext),
+			)
+			return
+		case env := <-ch:
+			switch e := env.(type) {
+			case *negentropyenvelope.Err:
+				err = fmt.Errorf("negentropy: %s", e.ReasonString())
+				return
+			case *negentropyenvelope.Msg:
+				var h, nd [][]byte
+				if msg, h, nd, err = n.Reconcile(e.Message); err != nil {
+					return
+				}
+				have = append(have, h...)
+				need = append(need, nd...)
+				if msg == nil {
+					log.T.F(
+						"WS.Reconcile: %s done in %d rounds, have %d need %d",
+						r.URL, rounds, len(have), len(need),
+					)
+					return
+				}
+				if err = <-r.Write(
+					negentropyenvelope.NewMsgFrom(id, msg).Marshal(nil),
+				); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// dispatchNegentropy passes a NEG-MSG or NEG-ERR to the reconciliation it
+// belongs to.
+func (r *Client) dispatchNegentropy(sub []byte, env codec.Envelope) {
+	ch, ok := r.negentropySubs.Load(string(sub))
+	if !ok {
+		log.D.F("{%s} no reconciliation with id '%s'", r.URL, sub)
+		return
+	}
+	select {
+	case ch <- env:
+	default:
+		log.D.F("{%s} unexpected negentropy message for '%s'", r.URL, sub)
+	}
+}
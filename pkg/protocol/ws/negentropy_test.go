@@ -0,0 +1,117 @@
+//go:build !js
+
+package ws
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+	"lukechampine.com/frand"
+	"next.orly.dev/pkg/encoders/envelopes"
+	"next.orly.dev/pkg/encoders/envelopes/negentropyenvelope"
+	"next.orly.dev/pkg/encoders/filter"
+	"next.orly.dev/pkg/encoders/kind"
+	"next.orly.dev/pkg/protocol/negentropy"
+)
+
+func TestReconcile(t *testing.T) {
+	local := negentropy.NewStorage(0)
+	remote := negentropy.NewStorage(0)
+	var wantHave, wantNeed int
+	for i := 0; i < 3000; i++ {
+		id := frand.Bytes(negentropy.IdSize)
+		ts := uint64(1700000000 + i)
+		switch i % 50 {
+		case 0:
+			wantHave++
+			require.NoError(t, local.Insert(ts, id))
+		case 1:
+			wantNeed++
+			require.NoError(t, remote.Insert(ts, id))
+		default:
+			require.NoError(t, local.Insert(ts, id))
+			require.NoError(t, remote.Insert(ts, id))
+		}
+	}
+
+	// fake relay server answering negentropy messages from its own set
+	ws := newWebsocketServer(
+		func(conn *websocket.Conn) {
+			n, err := negentropy.New(remote, 0)
+			require.NoError(t, err)
+			for {
+				var msg []byte
+				if err = websocket.Message.Receive(conn, &msg); err != nil {
+					return
+				}
+				var l string
+				l, msg, err = envelopes.Identify(msg)
+				require.NoError(t, err)
+				var sub, m []byte
+				switch l {
+				case negentropyenvelope.LOpen:
+					env, _, err := negentropyenvelope.ParseOpen(msg)
+					require.NoError(t, err)
+					sub, m = env.Subscription, env.Message
+				case negentropyenvelope.LMsg:
+					env, _, err := negentropyenvelope.ParseMsg(msg)
+					require.NoError(t, err)
+					sub, m = env.Subscription, env.Message
+				default:
+					continue
+				}
+				reply, _, _, err := n.Reconcile(m)
+				require.NoError(t, err)
+				err = websocket.Message.Send(
+					conn, string(
+						negentropyenvelope.NewMsgFrom(sub, reply).Marshal(nil),
+					),
+				)
+				require.NoError(t, err)
+			}
+		},
+	)
+	defer ws.Close()
+
+	rl := mustRelayConnect(t, ws.URL)
+	have, need, err := rl.Reconcile(
+		context.Background(),
+		&filter.F{Kinds: kind.NewS(kind.TextNote)}, local,
+	)
+	require.NoError(t, err)
+	assert.Len(t, have, wantHave)
+	assert.Len(t, need, wantNeed)
+}
+
+func TestReconcileError(t *testing.T) {
+	ws := newWebsocketServer(
+		func(conn *websocket.Conn) {
+			var msg []byte
+			if err := websocket.Message.Receive(conn, &msg); err != nil {
+				return
+			}
+			_, msg, _ = envelopes.Identify(msg)
+			env, _, err := negentropyenvelope.ParseOpen(msg)
+			require.NoError(t, err)
+			websocket.Message.Send(
+				conn, string(
+					negentropyenvelope.NewErrFrom(
+						env.Subscription,
+						[]byte("blocked: this query is too big"),
+					).Marshal(nil),
+				),
+			)
+			discardingHandler(conn)
+		},
+	)
+	defer ws.Close()
+
+	rl := mustRelayConnect(t, ws.URL)
+	_, _, err := rl.Reconcile(
+		context.Background(), filter.New(), negentropy.NewStorage(0),
+	)
+	assert.ErrorContains(t, err, "blocked: this query is too big")
+}
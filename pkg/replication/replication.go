@@ -0,0 +1,438 @@
+// Package replication runs a relay as a hot standby follower of another ORLY
+// relay, its leader. The follower reads the change feed of the leader, the
+// stored events and the tombstones of deleted events in order of their
+// serials, and applies them to its own database, persisting the serial of the
+// last change it applied as its cursor so it continues where it left off.
+//
+// The change feed is read with a NIP-98 signed request by the relay identity
+// of the follower, which must be an admin of the leader. Events published to
+// the follower are forwarded to the leader over a websocket, authenticated by
+// the same identity, with the pubkey and address of the client that sent
+// them, so the leader applies its own ACL, blocks and rate limits to the
+// client. They come back through the feed once the leader has stored them.
+//
+// A follower is promoted to a leader by recording it in the database, in a
+// key that wiping the database keeps, after which it no longer follows the
+// leader it was configured with.
+package replication
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"lol.mleku.dev/chk"
+	"lol.mleku.dev/errorf"
+	"lol.mleku.dev/log"
+	"next.orly.dev/pkg/crypto/p256k"
+	"next.orly.dev/pkg/database"
+	"next.orly.dev/pkg/encoders/envelopes/forwardenvelope"
+	"next.orly.dev/pkg/encoders/event"
+	"next.orly.dev/pkg/metrics"
+	"next.orly.dev/pkg/protocol/httpauth"
+	"next.orly.dev/pkg/protocol/ws"
+)
+
+const (
+	// CursorMarker is the prefix of the marker of the cursor of the feed of
+	// a leader, followed by the URL of the leader, since the serials of
+	// different leaders are unrelated.
+	CursorMarker = "replication_cursor:"
+	// heartbeatTimeout is how long the feed may be silent before the
+	// connection is considered dead. The leader sends an empty line each
+	// second while there are no new changes.
+	heartbeatTimeout = 30 * time.Second
+	// retryBase is the delay before reconnecting after the first failure,
+	// which doubles with each failure after it.
+	retryBase = time.Second
+	// retryMax is the longest delay before reconnecting.
+	retryMax = time.Minute
+	// saveEvery is the number of changes applied after which the cursor is
+	// persisted, besides each time the follower has caught up.
+	saveEvery = 100
+	// maxLine is the longest change accepted from the feed.
+	maxLine = 16 << 20
+)
+
+// the cursor is kept when the database is wiped, unless the markers are
+// wiped as well
+func init() { database.KeepOnWipe(CursorMarker) }
+
+// Status is the state of the replication reported on the health port.
+type Status struct {
+	// Role is leader or follower.
+	Role string `json:"role"`
+	// Leader is the URL of the leader of a follower.
+	Leader string `json:"leader,omitempty"`
+	// Connected is whether the change feed of the leader is open.
+	Connected bool `json:"connected"`
+	// Cursor is the serial of the last change applied, or -1 before the
+	// first.
+	Cursor int64 `json:"cursor"`
+	// Applied is the number of changes applied since the follower started.
+	Applied int64 `json:"applied"`
+	// CaughtUp is when the follower last had applied all changes of the
+	// leader.
+	CaughtUp time.Time `json:"caught_up,omitzero"`
+	// LagSeconds is how long ago the follower was last caught up, or since
+	// it started if it has not been yet.
+	LagSeconds float64 `json:"lag_seconds"`
+	LastError  string  `json:"last_error,omitempty"`
+}
+
+// Rejected is the error of Forward when the leader rejected an event, with
+// the message of its OK.
+type Rejected struct{ Msg string }
+
+func (r *Rejected) Error() string { return r.Msg }
+
+// Follower replicates the database of a leader.
+type Follower struct {
+	ctx     context.Context
+	db      *database.D
+	leader  string
+	sign    *p256k.Signer
+	client  *http.Client
+	deliver func(ev *event.E)
+	started time.Time
+	mx      sync.Mutex
+	status  Status
+	relayMx sync.Mutex
+	relay   *ws.Client
+	// authed is whether the follower authenticated on the connection
+	authed bool
+}
+
+// New returns a follower of a leader that signs with the relay identity key
+// and calls deliver with each event it saves, to be started with Run. The URL
+// of the leader may have a websocket or an HTTP scheme.
+func New(
+	ctx context.Context, db *database.D, leader string, sign *p256k.Signer,
+	deliver func(ev *event.E),
+) (f *Follower) {
+	leader = strings.TrimSuffix(leader, "/")
+	switch {
+	case strings.HasPrefix(leader, "wss://"):
+		leader = "https://" + leader[len("wss://"):]
+	case strings.HasPrefix(leader, "ws://"):
+		leader = "http://" + leader[len("ws://"):]
+	}
+	return &Follower{
+		ctx: ctx, db: db, leader: leader, sign: sign,
+		client:  new(http.Client),
+		deliver: deliver,
+		started: time.Now(),
+		status:  Status{Role: "follower", Leader: leader, Cursor: -1},
+	}
+}
+
+// Promoted returns whether the relay was promoted away from following a
+// leader.
+func Promoted(db *database.D, leader string) bool {
+	v, err := db.GetPromoted()
+	return !chk.E(err) && v != "" && v == strings.TrimSuffix(leader, "/")
+}
+
+// Promote records that the relay no longer follows a leader, which it will
+// follow again only if it is configured with a different one.
+func Promote(db *database.D, leader string) (err error) {
+	return db.SetPromoted(strings.TrimSuffix(leader, "/"))
+}
+
+// Status returns the state of the replication.
+func (f *Follower) Status() (s Status) {
+	f.mx.Lock()
+	s = f.status
+	f.mx.Unlock()
+	since := s.CaughtUp
+	if since.IsZero() {
+		since = f.started
+	}
+	s.LagSeconds = time.Since(since).Seconds()
+	return
+}
+
+// Run follows the change feed of the leader until the context is canceled,
+// reconnecting with exponential backoff when it fails.
+func (f *Follower) Run() {
+	defer f.closeRelay()
+	delay := retryBase
+	for {
+		start := time.Now()
+		err := f.follow()
+		f.mx.Lock()
+		f.status.Connected = false
+		if err != nil && f.ctx.Err() == nil {
+			f.status.LastError = err.Error()
+		}
+		f.mx.Unlock()
+		metrics.RelayConnected.Set(0, "replication", f.leader)
+		if f.ctx.Err() != nil {
+			return
+		}
+		log.W.F("following leader %s failed: %v", f.leader, err)
+		metrics.RelayErrors.Inc("replication", f.leader)
+		// a feed that was followed for a while is retried quickly again
+		if time.Since(start) > retryMax {
+			delay = retryBase
+		}
+		select {
+		case <-f.ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > retryMax {
+			delay = retryMax
+		}
+	}
+}
+
+// follow reads the change feed of the leader from the cursor and applies the
+// changes until the feed ends or fails.
+func (f *Follower) follow() (err error) {
+	marker := CursorMarker + f.leader
+	u := f.leader + "/api/changes?follow=true"
+	from := "the start"
+	if f.db.HasMarker(marker) {
+		var cursor []byte
+		if cursor, err = f.db.GetMarker(marker); chk.E(err) {
+			return
+		}
+		var after uint64
+		if after, err = strconv.ParseUint(string(cursor), 10, 64); err != nil {
+			return errorf.E("invalid replication cursor %q", cursor)
+		}
+		u += "&after=" + string(cursor)
+		from = "serial " + string(cursor)
+		f.mx.Lock()
+		f.status.Cursor = int64(after)
+		f.mx.Unlock()
+	}
+	ctx, cancel := context.WithCancel(f.ctx)
+	defer cancel()
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(
+		ctx, http.MethodGet, u, nil,
+	); chk.E(err) {
+		return
+	}
+	if err = httpauth.AddAuth(req, nil, f.sign); chk.E(err) {
+		return
+	}
+	var res *http.Response
+	if res, err = f.client.Do(req); err != nil {
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return errorf.E(
+			"leader answered %s: %s", res.Status, bytes.TrimSpace(msg),
+		)
+	}
+	log.I.F("following leader %s after %s", f.leader, from)
+	f.mx.Lock()
+	f.status.Connected = true
+	f.status.LastError = ""
+	f.mx.Unlock()
+	metrics.RelayConnected.Set(1, "replication", f.leader)
+	// the connection is dropped when the leader stops sending heartbeats
+	watchdog := time.AfterFunc(heartbeatTimeout, cancel)
+	defer watchdog.Stop()
+	var last uint64
+	var unsaved int
+	save := func() (err error) {
+		if unsaved == 0 {
+			return
+		}
+		unsaved = 0
+		return f.db.SetMarker(
+			marker, strconv.AppendUint(nil, last, 10),
+		)
+	}
+	defer func() { chk.E(save()) }()
+	r := bufio.NewReaderSize(res.Body, 64<<10)
+	for {
+		var line []byte
+		if line, err = readLine(r); err != nil {
+			if ctx.Err() != nil && f.ctx.Err() == nil {
+				err = errorf.E("no heartbeat from the leader")
+			}
+			return
+		}
+		watchdog.Reset(heartbeatTimeout)
+		if len(line) == 0 {
+			// a heartbeat, the feed has caught up
+			if err = save(); chk.E(err) {
+				return
+			}
+			f.mx.Lock()
+			f.status.CaughtUp = time.Now()
+			f.mx.Unlock()
+			continue
+		}
+		ch := new(database.Change)
+		if err = ch.Unmarshal(line); err != nil {
+			return
+		}
+		if err = f.apply(ch); err != nil {
+			return
+		}
+		last = ch.Serial
+		f.mx.Lock()
+		f.status.Cursor = int64(last)
+		f.status.Applied++
+		f.mx.Unlock()
+		if unsaved++; unsaved >= saveEvery {
+			if err = save(); chk.E(err) {
+				return
+			}
+		}
+	}
+}
+
+// readLine reads a line of the feed without its newline, failing on lines
+// longer than maxLine.
+func readLine(r *bufio.Reader) (line []byte, err error) {
+	for {
+		var part []byte
+		var more bool
+		if part, more, err = r.ReadLine(); err != nil {
+			return
+		}
+		line = append(line, part...)
+		if len(line) > maxLine {
+			return nil, errorf.E("change longer than %d bytes", maxLine)
+		}
+		if !more {
+			return
+		}
+	}
+}
+
+// apply saves the event of a change or deletes the event of a tombstone.
+// Changes that were already applied are skipped, as the changes since the
+// last persisted cursor are read again after a reconnect.
+func (f *Follower) apply(ch *database.Change) (err error) {
+	if ch.Event == nil {
+		if _, err = f.db.GetSerialById(ch.Deleted); err != nil {
+			// already deleted, or replaced by a newer event here
+			log.D.F("deleted event %0x is not stored", ch.Deleted)
+			return nil
+		}
+		if err = f.db.DeleteEvent(f.ctx, ch.Deleted); chk.E(err) {
+			return
+		}
+		metrics.RelayEvents.Inc("replication", f.leader)
+		return
+	}
+	if _, _, err = f.db.SaveEvent(f.ctx, ch.Event); err != nil {
+		if strings.HasPrefix(err.Error(), "blocked:") {
+			log.D.F("skipping event %0x: %v", ch.Event.ID, err)
+			return nil
+		}
+		return
+	}
+	metrics.RelayEvents.Inc("replication", f.leader)
+	if f.deliver != nil {
+		f.deliver(ch.Event)
+	}
+	return
+}
+
+// wsURL returns the websocket URL of the leader.
+func (f *Follower) wsURL() string {
+	if strings.HasPrefix(f.leader, "https://") {
+		return "wss://" + f.leader[len("https://"):]
+	}
+	return "ws://" + strings.TrimPrefix(f.leader, "http://")
+}
+
+// Forward publishes an event to the leader on behalf of the client that sent
+// it, with the pubkey the client authenticated as and its address, and waits
+// for the OK of the leader, returning a Rejected error if the leader did not
+// accept it. The leader checks the event as if the client had published it
+// there, and accepts forwarded events only from its admins, so the follower
+// authenticates to the leader when the leader asks it to.
+func (f *Follower) Forward(
+	ctx context.Context, ev *event.E, pubkey []byte, remote string,
+) (err error) {
+	var r *ws.Client
+	var authed bool
+	if r, authed, err = f.connectRelay(ctx); err != nil {
+		return errorf.E("connecting to the leader: %v", err)
+	}
+	env := forwardenvelope.NewFrom(ev, pubkey, remote)
+	if err = publish(ctx, r, env); err == nil {
+		return
+	}
+	// once the follower is authenticated, auth-required is for the client
+	if rej, ok := err.(*Rejected); !ok || authed ||
+		!strings.HasPrefix(rej.Msg, "auth-required") {
+		return
+	}
+	if err = r.Auth(ctx, f.sign); err != nil {
+		return errorf.E("authenticating to the leader: %v", err)
+	}
+	f.setAuthed(r)
+	return publish(ctx, r, env)
+}
+
+// publish sends a forwarded event to a relay, turning the OK message of a
+// rejection into a Rejected error.
+func publish(
+	ctx context.Context, r *ws.Client, env *forwardenvelope.T,
+) (err error) {
+	if err = r.PublishEnvelope(ctx, env.Id(), env); err != nil &&
+		strings.HasPrefix(err.Error(), "msg: ") {
+		err = &Rejected{Msg: strings.TrimPrefix(err.Error(), "msg: ")}
+	}
+	return
+}
+
+// connectRelay returns the websocket connection to the leader, connecting
+// again if it is closed, and whether the follower has authenticated on it.
+func (f *Follower) connectRelay(ctx context.Context) (
+	r *ws.Client, authed bool, err error,
+) {
+	f.relayMx.Lock()
+	defer f.relayMx.Unlock()
+	if f.ctx.Err() != nil {
+		return nil, false, f.ctx.Err()
+	}
+	if f.relay != nil && f.relay.IsConnected() {
+		return f.relay, f.authed, nil
+	}
+	f.authed = false
+	if f.relay, err = ws.RelayConnect(ctx, f.wsURL()); err != nil {
+		f.relay = nil
+		return
+	}
+	return f.relay, false, nil
+}
+
+// setAuthed records that the follower authenticated on a connection to the
+// leader, unless it was replaced in the meantime.
+func (f *Follower) setAuthed(r *ws.Client) {
+	f.relayMx.Lock()
+	defer f.relayMx.Unlock()
+	if f.relay == r {
+		f.authed = true
+	}
+}
+
+// closeRelay closes the websocket connection to the leader.
+func (f *Follower) closeRelay() {
+	f.relayMx.Lock()
+	defer f.relayMx.Unlock()
+	if f.relay != nil {
+		chk.E(f.relay.Close())
+		f.relay = nil
+	}
+}
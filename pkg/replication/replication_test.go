@@ -0,0 +1,196 @@
+package replication
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"next.orly.dev/pkg/crypto/p256k"
+	"next.orly.dev/pkg/database"
+	"next.orly.dev/pkg/encoders/event"
+	"next.orly.dev/pkg/encoders/event/examples"
+	"next.orly.dev/pkg/encoders/timestamp"
+	"next.orly.dev/pkg/protocol/httpauth"
+	"next.orly.dev/pkg/utils"
+)
+
+func newDB(t *testing.T) (db *database.D) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	db, err := database.New(ctx, cancel, t.TempDir(), "error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return
+}
+
+// serveFeed serves the change feed of a database like the leader, sending a
+// heartbeat after the stored changes and then holding the response open. The
+// requests must be signed by a pubkey.
+func serveFeed(t *testing.T, db *database.D, pubkey []byte) *httptest.Server {
+	return httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				valid, pk, err := httpauth.CheckAuth(r, false)
+				if err != nil || !valid || !utils.FastEqual(pk, pubkey) {
+					http.Error(w, "Forbidden", http.StatusForbidden)
+					return
+				}
+				var from uint64
+				if a := r.URL.Query().Get("after"); a != "" {
+					after, _ := strconv.ParseUint(a, 10, 64)
+					from = after + 1
+				}
+				if err = db.Changes(
+					r.Context(), from, 0, func(ch *database.Change) (err error) {
+						_, err = w.Write(append(ch.Marshal(nil), '\n'))
+						return
+					},
+				); err != nil {
+					return
+				}
+				w.Write([]byte{'\n'})
+				w.(http.Flusher).Flush()
+				<-r.Context().Done()
+			},
+		),
+	)
+}
+
+// follow runs a follower of a leader until it has caught up.
+func follow(
+	t *testing.T, db *database.D, leader string, sign *p256k.Signer,
+	delivered *atomic.Int64,
+) (f *Follower) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	f = New(
+		ctx, db, leader, sign, func(ev *event.E) { delivered.Add(1) },
+	)
+	go f.Run()
+	deadline := time.Now().Add(30 * time.Second)
+	for f.Status().CaughtUp.IsZero() {
+		if time.Now().After(deadline) {
+			t.Fatalf("follower did not catch up: %+v", f.Status())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	return
+}
+
+// countEvents returns the number of events stored in a database.
+func countEvents(t *testing.T, db *database.D) (n int) {
+	t.Helper()
+	if err := db.ScanEvents(
+		context.Background(), func(_ uint64, _ *event.E) (err error) {
+			n++
+			return
+		},
+	); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestFollow(t *testing.T) {
+	leader, follower := newDB(t), newDB(t)
+	if _, err := leader.ImportEvents(
+		bytes.NewBuffer(examples.Cache),
+	); err != nil {
+		t.Fatal(err)
+	}
+	sign := new(p256k.Signer)
+	if err := sign.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	srv := serveFeed(t, leader, sign.Pub())
+	defer srv.Close()
+	var delivered atomic.Int64
+	f := follow(t, follower, srv.URL, sign, &delivered)
+	n := countEvents(t, leader)
+	if got := countEvents(t, follower); got != n {
+		t.Fatalf("follower has %d events, leader %d", got, n)
+	}
+	if delivered.Load() != int64(n) {
+		t.Errorf("delivered %d events, expected %d", delivered.Load(), n)
+	}
+	cursor, err := follower.GetMarker(CursorMarker + srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(cursor) != strconv.FormatInt(f.Status().Cursor, 10) {
+		t.Errorf("persisted cursor %s, status %+v", cursor, f.Status())
+	}
+	// a new follower continues after the cursor, and applies deletions
+	var first *event.E
+	if err = leader.ScanEvents(
+		context.Background(), func(_ uint64, ev *event.E) (err error) {
+			if first == nil {
+				first = ev.Clone()
+			}
+			return
+		},
+	); err != nil {
+		t.Fatal(err)
+	}
+	if err = leader.DeleteEvent(context.Background(), first.ID); err != nil {
+		t.Fatal(err)
+	}
+	ev := event.New()
+	ev.Kind = 1
+	ev.CreatedAt = timestamp.Now().V
+	ev.Content = []byte("replicated")
+	if err = ev.Sign(sign); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = leader.SaveEvent(context.Background(), ev); err != nil {
+		t.Fatal(err)
+	}
+	f = follow(t, follower, srv.URL, sign, &delivered)
+	if applied := f.Status().Applied; applied != 2 {
+		t.Errorf("expected 2 changes after the cursor, applied %d", applied)
+	}
+	if got := countEvents(t, follower); got != n {
+		t.Errorf("follower has %d events, expected %d", got, n)
+	}
+	if _, err = follower.GetSerialById(first.ID); err == nil {
+		t.Errorf("deleted event is still stored on the follower")
+	}
+	if _, err = follower.GetSerialById(ev.ID); err != nil {
+		t.Errorf("new event was not replicated: %v", err)
+	}
+}
+
+func TestPromote(t *testing.T) {
+	db := newDB(t)
+	if Promoted(db, "https://leader.example.com") {
+		t.Fatal("promoted before promotion")
+	}
+	if err := Promote(db, "https://leader.example.com/"); err != nil {
+		t.Fatal(err)
+	}
+	if !Promoted(db, "https://leader.example.com") {
+		t.Error("not promoted after promotion")
+	}
+	if Promoted(db, "https://other.example.com") {
+		t.Error("promoted away from a different leader")
+	}
+	// the promotion outlives a wipe that also deletes the markers
+	if err := db.WipeWith(
+		database.WipeOptions{WipeMarkers: true},
+	); err != nil {
+		t.Fatal(err)
+	}
+	if !Promoted(db, "https://leader.example.com") {
+		t.Error("promotion was wiped")
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"next.orly.dev/pkg/encoders/kind"
 	"next.orly.dev/pkg/encoders/tag"
 	"next.orly.dev/pkg/encoders/timestamp"
+	"next.orly.dev/pkg/metrics"
 	"next.orly.dev/pkg/protocol/ws"
 	"next.orly.dev/pkg/utils/normalize"
 )
@@ -26,21 +27,29 @@ const (
 )
 
 type Spider struct {
-	db     *database.D
-	cfg    *config.C
-	ctx    context.Context
-	cancel context.CancelFunc
+	db          *database.D
+	cfg         *config.C
+	ctx         context.Context
+	cancel      context.CancelFunc
+	compression ws.Compression
 }
 
 func New(
 	db *database.D, cfg *config.C, ctx context.Context,
 	cancel context.CancelFunc,
 ) *Spider {
+	compression, err := ws.NewCompression(
+		cfg.WSCompression, cfg.WSCompressionMin,
+	)
+	if chk.E(err) {
+		log.W.F("Spider: websocket compression disabled: %v", err)
+	}
 	return &Spider{
-		db:     db,
-		cfg:    cfg,
-		ctx:    ctx,
-		cancel: cancel,
+		db:          db,
+		cfg:         cfg,
+		ctx:         ctx,
+		cancel:      cancel,
+		compression: compression,
 	}
 }
 
@@ -145,10 +154,10 @@ func (s *Spider) performSync(startTime, endTime time.Time) error {
 		endTime,
 	)
 
-	// 1. Check ACL mode is set to "follows"
-	if s.cfg.ACLMode != "follows" {
+	// 1. Check the ACL chain includes "follows"
+	if !acl.Registry.Has("follows") {
 		log.D.F(
-			"Spider sync skipped - ACL mode is not 'follows' (current: %s)",
+			"Spider sync skipped - ACL mode does not include 'follows' (current: %s)",
 			s.cfg.ACLMode,
 		)
 		return nil
@@ -188,10 +197,13 @@ func (s *Spider) performSync(startTime, endTime time.Time) error {
 		)
 		if err != nil {
 			log.E.F("Spider sync: error querying relay %s: %v", relayURL, err)
+			metrics.RelayErrors.Inc("spider", relayURL)
 			continue
 		}
+		metrics.RelayEvents.Add(float64(count), "spider", relayURL)
 		eventsFound += count
 	}
+	metrics.SpiderLastSync.Set(float64(time.Now().Unix()))
 
 	log.I.F(
 		"Spider sync completed: found %d new events from %d relays",
@@ -206,15 +218,9 @@ func (s *Spider) getFollowedPubkeys() ([][]byte, error) {
 	// Access the ACL registry to get the current ACL instance
 	var followedPubkeys [][]byte
 
-	// Get all ACL instances and find the active one
-	for _, aclInstance := range acl.Registry.ACL {
-		if aclInstance.Type() == acl.Registry.Active.Load() {
-			// Cast to *Follows to access the follows field
-			if followsACL, ok := aclInstance.(*acl.Follows); ok {
-				followedPubkeys = followsACL.GetFollowedPubkeys()
-				break
-			}
-		}
+	// Find the follows ACL in the active chain
+	if followsACL, ok := acl.Registry.Get("follows").(*acl.Follows); ok {
+		followedPubkeys = followsACL.GetFollowedPubkeys()
 	}
 
 	return followedPubkeys, nil
@@ -281,11 +287,16 @@ func (s *Spider) queryRelayForEvents(
 	ctx, cancel := context.WithTimeout(s.ctx, 30*time.Second)
 	defer cancel()
 
-	client, err := ws.RelayConnect(ctx, relayURL)
+	client, err := ws.RelayConnect(
+		ctx, relayURL, ws.WithCompression(s.compression),
+	)
 	if err != nil {
 		return 0, err
 	}
 	defer client.Close()
+	defer func() {
+		log.D.F("Spider sync: traffic with %s: %s", relayURL, &client.Traffic)
+	}()
 
 	// Create filter for the time range and followed pubkeys
 	f := &filter.F{
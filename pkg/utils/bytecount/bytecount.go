@@ -0,0 +1,107 @@
+// Package bytecount counts the bytes of websocket messages before compression
+// and on the wire after it, to show what permessage-deflate saves.
+package bytecount
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// Counter counts the bytes sent and received over a connection, both as
+// message payloads and as the bytes on the wire, including framing.
+type Counter struct {
+	// RawIn and RawOut count message payloads, after decompression and before
+	// compression.
+	RawIn, RawOut atomic.Uint64
+	// WireIn and WireOut count the bytes read from and written to the
+	// connection.
+	WireIn, WireOut atomic.Uint64
+}
+
+// Add adds the counts of another Counter to c.
+func (c *Counter) Add(o *Counter) {
+	c.RawIn.Add(o.RawIn.Load())
+	c.RawOut.Add(o.RawOut.Load())
+	c.WireIn.Add(o.WireIn.Load())
+	c.WireOut.Add(o.WireOut.Load())
+}
+
+// Saved returns the fraction of the raw bytes in each direction that did not
+// go over the wire, which is negative if framing costs more than compression
+// saves.
+func (c *Counter) Saved() (in, out float64) {
+	return saved(c.RawIn.Load(), c.WireIn.Load()),
+		saved(c.RawOut.Load(), c.WireOut.Load())
+}
+
+func saved(raw, wire uint64) float64 {
+	if raw == 0 {
+		return 0
+	}
+	return 1 - float64(wire)/float64(raw)
+}
+
+// String summarises the counts and the savings.
+func (c *Counter) String() string {
+	in, out := c.Saved()
+	return fmt.Sprintf(
+		"in raw=%d wire=%d saved=%.1f%%, out raw=%d wire=%d saved=%.1f%%",
+		c.RawIn.Load(), c.WireIn.Load(), in*100,
+		c.RawOut.Load(), c.WireOut.Load(), out*100,
+	)
+}
+
+// Conn wraps a net.Conn so the bytes read and written are counted.
+func (c *Counter) Conn(conn net.Conn) net.Conn {
+	return &countingConn{Conn: conn, c: c}
+}
+
+type countingConn struct {
+	net.Conn
+	c *Counter
+}
+
+func (cc *countingConn) Read(p []byte) (n int, err error) {
+	n, err = cc.Conn.Read(p)
+	cc.c.WireIn.Add(uint64(n))
+	return
+}
+
+func (cc *countingConn) Write(p []byte) (n int, err error) {
+	n, err = cc.Conn.Write(p)
+	cc.c.WireOut.Add(uint64(n))
+	return
+}
+
+// ResponseWriter wraps an http.ResponseWriter so that a connection hijacked
+// from it, such as by a websocket upgrade, is counted.
+func (c *Counter) ResponseWriter(w http.ResponseWriter) http.ResponseWriter {
+	return &hijacker{ResponseWriter: w, c: c}
+}
+
+type hijacker struct {
+	http.ResponseWriter
+	c *Counter
+}
+
+// Unwrap returns the wrapped http.ResponseWriter, for http.ResponseController.
+func (h *hijacker) Unwrap() http.ResponseWriter { return h.ResponseWriter }
+
+// Hijack hijacks the wrapped http.ResponseWriter and returns the connection
+// counted, with a buffered writer that writes to it.
+func (h *hijacker) Hijack() (conn net.Conn, brw *bufio.ReadWriter, err error) {
+	hj, ok := h.ResponseWriter.(http.Hijacker)
+	if !ok {
+		err = fmt.Errorf("%T does not implement http.Hijacker", h.ResponseWriter)
+		return
+	}
+	if conn, brw, err = hj.Hijack(); err != nil {
+		return
+	}
+	conn = h.c.Conn(conn)
+	brw = bufio.NewReadWriter(brw.Reader, bufio.NewWriter(conn))
+	return
+}
@@ -0,0 +1,89 @@
+package bytecount
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestConn(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	var c Counter
+	conn := c.Conn(a)
+	go func() {
+		buf := make([]byte, 5)
+		io.ReadFull(b, buf)
+		b.Write([]byte("hi"))
+	}()
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if c.WireOut.Load() != 5 || c.WireIn.Load() != 2 {
+		t.Fatalf(
+			"expected 5 out and 2 in, got %d and %d",
+			c.WireOut.Load(), c.WireIn.Load(),
+		)
+	}
+}
+
+func TestSaved(t *testing.T) {
+	var c Counter
+	c.RawIn.Store(100)
+	c.WireIn.Store(25)
+	c.RawOut.Store(10)
+	c.WireOut.Store(20)
+	in, out := c.Saved()
+	if in != 0.75 || out != -1 {
+		t.Fatalf("expected 0.75 and -1, got %v and %v", in, out)
+	}
+	var total Counter
+	total.Add(&c)
+	total.Add(&c)
+	if total.RawIn.Load() != 200 || total.WireOut.Load() != 40 {
+		t.Fatalf("Add did not sum the counts: %s", total.String())
+	}
+}
+
+func TestResponseWriter(t *testing.T) {
+	var c Counter
+	srv := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				hj := c.ResponseWriter(w).(http.Hijacker)
+				conn, brw, err := hj.Hijack()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				defer conn.Close()
+				brw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+				brw.Flush()
+			},
+		),
+	)
+	defer srv.Close()
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	req := "GET / HTTP/1.1\r\nHost: x\r\n\r\n"
+	conn.Write([]byte(req))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if c.WireOut.Load() == 0 {
+		t.Fatal("written bytes of the hijacked connection were not counted")
+	}
+}
@@ -0,0 +1,102 @@
+// Package ratelimit implements token buckets keyed by a string, such as an IP
+// address or a pubkey, for throttling clients.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// bucket is a token bucket that refills continuously up to its burst size.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter is a set of token buckets keyed by a string, which all refill at
+// the same rate per second up to the same burst size.
+type Limiter struct {
+	Rate    float64
+	Burst   float64
+	mx      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+// New creates a Limiter with a refill rate per second and a burst size. A
+// rate of zero or less creates a Limiter that allows everything.
+func New(rate, burst float64) (l *Limiter) {
+	if burst < rate {
+		burst = rate
+	}
+	return &Limiter{
+		Rate:    rate,
+		Burst:   burst,
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Allow takes cost tokens from the bucket of each key, and returns true if
+// every bucket had enough. Nothing is taken unless all of them have enough.
+// The rate and burst are multiplied by scale, which gives a higher limit to
+// more trusted clients.
+func (l *Limiter) Allow(cost, scale float64, keys ...string) bool {
+	if l == nil || l.Rate <= 0 {
+		return true
+	}
+	if scale <= 0 {
+		scale = 1
+	}
+	rate, burst := l.Rate*scale, l.Burst*scale
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	now := l.now()
+	bs := make([]*bucket, 0, len(keys))
+	for _, k := range keys {
+		if k == "" {
+			continue
+		}
+		b, ok := l.buckets[k]
+		if !ok {
+			b = &bucket{tokens: burst, last: now}
+			l.buckets[k] = b
+		}
+		b.tokens += now.Sub(b.last).Seconds() * rate
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+		b.last = now
+		if b.tokens < cost {
+			return false
+		}
+		bs = append(bs, b)
+	}
+	for _, b := range bs {
+		b.tokens -= cost
+	}
+	return true
+}
+
+// Prune removes the buckets that have been idle long enough to have refilled,
+// so the set does not grow without bound.
+func (l *Limiter) Prune() {
+	if l == nil || l.Rate <= 0 {
+		return
+	}
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	now := l.now()
+	for k, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.Rate >= l.Burst {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// Len returns the number of buckets being tracked.
+func (l *Limiter) Len() int {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	return len(l.buckets)
+}
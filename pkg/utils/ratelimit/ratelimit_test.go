@@ -0,0 +1,60 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	l := New(1, 3)
+	l.now = func() time.Time { return now }
+
+	// the burst is available immediately, then the bucket is empty
+	for i := 0; i < 3; i++ {
+		if !l.Allow(1, 1, "a") {
+			t.Fatalf("request %d within the burst was refused", i)
+		}
+	}
+	if l.Allow(1, 1, "a") {
+		t.Fatal("request beyond the burst was allowed")
+	}
+	// other keys have their own buckets
+	if !l.Allow(1, 1, "b") {
+		t.Fatal("request for another key was refused")
+	}
+	// nothing is taken unless every bucket has enough
+	if l.Allow(1, 1, "b", "a") {
+		t.Fatal("request was allowed with one bucket empty")
+	}
+	if !l.Allow(2, 1, "b") {
+		t.Fatal("tokens were taken from a bucket for a refused request")
+	}
+	// the bucket refills at the rate
+	now = now.Add(2 * time.Second)
+	if !l.Allow(2, 1, "a") {
+		t.Fatal("refilled tokens were refused")
+	}
+	if l.Allow(1, 1, "a") {
+		t.Fatal("bucket refilled more than the rate")
+	}
+	// a scale raises the limits
+	if !l.Allow(1, 4, "c") || !l.Allow(11, 4, "c") {
+		t.Fatal("scaled burst was refused")
+	}
+	// full buckets are pruned
+	now = now.Add(time.Minute)
+	l.Prune()
+	if n := l.Len(); n != 0 {
+		t.Fatalf("expected all buckets pruned, %d left", n)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	l := New(0, 0)
+	for i := 0; i < 1000; i++ {
+		if !l.Allow(1, 1, "a") {
+			t.Fatal("disabled limiter refused a request")
+		}
+	}
+}
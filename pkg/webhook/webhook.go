@@ -0,0 +1,385 @@
+// Package webhook sends the events stored by the relay that match the filters
+// of configured webhooks to their URLs. Deliveries are queued in the database
+// and retried with exponential backoff until they succeed, so they survive
+// restarts of the relay.
+//
+// Each request is a POST of a JSON object with the name of the webhook, the
+// time it was sent and the event. The body is signed with the relay identity
+// key: the X-Nostr-Signature header is the hex BIP-340 signature of the
+// SHA-256 hash of the body by the pubkey in the X-Nostr-Pubkey header.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"lol.mleku.dev/chk"
+	"lol.mleku.dev/errorf"
+	"lol.mleku.dev/log"
+	"next.orly.dev/pkg/crypto/p256k"
+	"next.orly.dev/pkg/crypto/sha256"
+	"next.orly.dev/pkg/database"
+	"next.orly.dev/pkg/database/indexes/types"
+	"next.orly.dev/pkg/encoders/event"
+	"next.orly.dev/pkg/encoders/filter"
+	"next.orly.dev/pkg/encoders/hex"
+)
+
+const (
+	// retryBase is the delay after the first failed attempt, which doubles
+	// with each attempt after it.
+	retryBase = 10 * time.Second
+	// retryMax is the longest delay between attempts.
+	retryMax = time.Hour
+	// maxAttempts is the number of attempts after which a delivery is given
+	// up.
+	maxAttempts = 20
+	// pollInterval is how often the queue is checked for due deliveries.
+	pollInterval = 5 * time.Second
+	// dueBatch is the most deliveries that are attempted in one poll.
+	dueBatch = 1000
+	// requestTimeout is how long a webhook has to answer a delivery.
+	requestTimeout = 10 * time.Second
+	// keepFailures is the number of deliveries that were given up that are
+	// kept to be reported for each webhook, dropping the oldest.
+	keepFailures = 100
+)
+
+// Hook is a webhook, sent the stored events matching its filter.
+type Hook struct {
+	// Name identifies the webhook in the queue and the status.
+	Name string `json:"name"`
+	// URL is where the events are posted.
+	URL string `json:"url"`
+	// Filter is a NIP-01 filter the events must match.
+	Filter json.RawMessage `json:"filter"`
+
+	f *filter.F
+}
+
+// Load reads a JSON array of webhooks from a file.
+func Load(path string) (hooks []*Hook, err error) {
+	var b []byte
+	if b, err = os.ReadFile(path); chk.E(err) {
+		return
+	}
+	if err = json.Unmarshal(b, &hooks); err != nil {
+		return nil, errorf.E("invalid webhooks in %s: %v", path, err)
+	}
+	names := make(map[string]struct{})
+	for _, h := range hooks {
+		if h.Name == "" || strings.Contains(h.Name, ":") {
+			return nil, errorf.E("invalid webhook name %q", h.Name)
+		}
+		if _, ok := names[h.Name]; ok {
+			return nil, errorf.E("duplicate webhook name %q", h.Name)
+		}
+		names[h.Name] = struct{}{}
+		if !strings.HasPrefix(h.URL, "http://") &&
+			!strings.HasPrefix(h.URL, "https://") {
+			return nil, errorf.E("invalid URL %q of webhook %s", h.URL, h.Name)
+		}
+		h.f = filter.New()
+		if len(h.Filter) > 0 {
+			var buf bytes.Buffer
+			if err = json.Compact(&buf, h.Filter); err != nil {
+				return nil, errorf.E("invalid filter of webhook %s", h.Name)
+			}
+			if _, err = h.f.Unmarshal(buf.Bytes()); err != nil {
+				return nil, errorf.E(
+					"invalid filter of webhook %s: %v", h.Name, err,
+				)
+			}
+		}
+	}
+	return
+}
+
+// Payload is the body of the requests to a webhook.
+type Payload struct {
+	Hook   string          `json:"hook"`
+	SentAt int64           `json:"sent_at"`
+	Event  json.RawMessage `json:"event"`
+}
+
+// Stats counts the deliveries of a webhook since the relay started.
+type Stats struct {
+	Delivered     int64     `json:"delivered"`
+	Failed        int64     `json:"failed"`
+	LastDelivered time.Time `json:"last_delivered,omitzero"`
+	LastError     string    `json:"last_error,omitempty"`
+}
+
+// Status is the state of a webhook reported by the admin API.
+type Status struct {
+	Name    string `json:"name"`
+	URL     string `json:"url"`
+	Pending int    `json:"pending"`
+	Stats
+	// Failures are the latest deliveries that were given up.
+	Failures []*database.WebhookDelivery `json:"failures,omitempty"`
+}
+
+// S sends the events queued for the webhooks.
+type S struct {
+	ctx    context.Context
+	db     *database.D
+	hooks  []*Hook
+	signMx sync.Mutex
+	sign   *p256k.Signer
+	client *http.Client
+	wake   chan struct{}
+	// now is the clock, replaced in tests
+	now     func() time.Time
+	statsMx sync.Mutex
+	stats   map[string]*Stats
+}
+
+// New returns a sender of the webhooks that signs with the relay identity
+// key, to be started with Run.
+func New(
+	ctx context.Context, db *database.D, hooks []*Hook, sign *p256k.Signer,
+) (s *S) {
+	s = &S{
+		ctx: ctx, db: db, hooks: hooks, sign: sign,
+		client: &http.Client{Timeout: requestTimeout},
+		wake:   make(chan struct{}, 1),
+		now:    time.Now,
+		stats:  make(map[string]*Stats),
+	}
+	for _, h := range hooks {
+		s.stats[h.Name] = new(Stats)
+	}
+	return
+}
+
+// SetSigner replaces the key the requests are signed with, such as after the
+// relay identity was wiped.
+func (s *S) SetSigner(sign *p256k.Signer) {
+	s.signMx.Lock()
+	defer s.signMx.Unlock()
+	s.sign = sign
+}
+
+// signer returns the key the requests are signed with.
+func (s *S) signer() *p256k.Signer {
+	s.signMx.Lock()
+	defer s.signMx.Unlock()
+	return s.sign
+}
+
+// Enqueue queues the deliveries of a stored event to the webhooks whose
+// filters it matches.
+func (s *S) Enqueue(ev *event.E) {
+	queued := false
+	for _, h := range s.hooks {
+		if !h.f.Matches(ev) {
+			continue
+		}
+		if err := s.db.QueueWebhook(h.Name, ev.ID, s.now()); chk.E(err) {
+			continue
+		}
+		queued = true
+	}
+	if !queued {
+		return
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends the due deliveries until the context is canceled.
+func (s *S) Run() {
+	chk.E(s.db.IndexWebhooks())
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		s.deliverDue()
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// hook returns the webhook with a name, or nil if it is no longer
+// configured.
+func (s *S) hook(name string) *Hook {
+	for _, h := range s.hooks {
+		if h.Name == name {
+			return h
+		}
+	}
+	return nil
+}
+
+// deliverDue attempts the queued deliveries that are due. The webhooks are
+// sent to concurrently, so a slow one does not hold up the others, and the
+// deliveries to each webhook are sent in order of when they are due.
+func (s *S) deliverDue() {
+	ws, err := s.db.DueWebhooks(s.now(), dueBatch)
+	if chk.E(err) {
+		return
+	}
+	byHook := make(map[string][]*database.WebhookDelivery)
+	for _, w := range ws {
+		byHook[w.Hook] = append(byHook[w.Hook], w)
+	}
+	var wg sync.WaitGroup
+	for name, ws := range byHook {
+		h := s.hook(name)
+		if h == nil {
+			log.W.F("dropping %d deliveries to removed webhook %s", len(ws), name)
+			for _, w := range ws {
+				chk.E(s.db.DeleteWebhook(w))
+			}
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, w := range ws {
+				if s.ctx.Err() != nil {
+					return
+				}
+				if err := s.deliver(h, w); err != nil {
+					s.retry(w, err)
+					continue
+				}
+				chk.E(s.db.DeleteWebhook(w))
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// retry records a failed attempt, backing off or giving up.
+func (s *S) retry(w *database.WebhookDelivery, err error) {
+	w.Attempts++
+	w.LastError = err.Error()
+	delay := retryBase
+	for i := 1; i < w.Attempts && delay < retryMax; i++ {
+		delay *= 2
+	}
+	delay = min(delay, retryMax)
+	w.Next = s.now().Add(delay)
+	s.statsMx.Lock()
+	st := s.stats[w.Hook]
+	st.LastError = w.LastError
+	if w.Attempts >= maxAttempts {
+		w.Failed = true
+		st.Failed++
+	}
+	s.statsMx.Unlock()
+	if w.Failed {
+		log.E.F(
+			"giving up delivery of event %s to webhook %s: %v", w.EventID,
+			w.Hook, err,
+		)
+	} else {
+		log.W.F(
+			"delivery of event %s to webhook %s failed, retrying in %v: %v",
+			w.EventID, w.Hook, delay, err,
+		)
+	}
+	chk.E(s.db.UpdateWebhook(w))
+	if w.Failed {
+		chk.E(s.db.PruneFailedWebhooks(w.Hook, keepFailures))
+	}
+}
+
+// deliver posts the event of a delivery to a webhook.
+func (s *S) deliver(h *Hook, w *database.WebhookDelivery) (err error) {
+	var id []byte
+	if id, err = hex.Dec(w.EventID); chk.E(err) {
+		return
+	}
+	var ser *types.Uint40
+	if ser, err = s.db.GetSerialById(id); err != nil {
+		// the event was deleted after it was queued, so there is nothing to
+		// send
+		log.D.F("event %s of webhook %s is gone", w.EventID, w.Hook)
+		return nil
+	}
+	var ev *event.E
+	if ev, err = s.db.FetchEventBySerial(ser); err != nil {
+		return nil
+	}
+	defer ev.Free()
+	var body []byte
+	if body, err = json.Marshal(
+		Payload{Hook: h.Name, SentAt: s.now().Unix(), Event: ev.Serialize()},
+	); chk.E(err) {
+		return
+	}
+	hash := sha256.Sum256(body)
+	sign := s.signer()
+	var sig []byte
+	if sig, err = sign.Sign(hash[:]); chk.E(err) {
+		return
+	}
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(
+		s.ctx, http.MethodPost, h.URL, bytes.NewReader(body),
+	); chk.E(err) {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Nostr-Pubkey", hex.Enc(sign.Pub()))
+	req.Header.Set("X-Nostr-Signature", hex.Enc(sig))
+	var res *http.Response
+	if res, err = s.client.Do(req); err != nil {
+		return
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64*1024))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return errorf.E("webhook answered %s", res.Status)
+	}
+	s.statsMx.Lock()
+	st := s.stats[h.Name]
+	st.Delivered++
+	st.LastDelivered = s.now()
+	s.statsMx.Unlock()
+	return
+}
+
+// Status returns the state of the webhooks, with the counts of their queued
+// deliveries and the deliveries that were given up.
+func (s *S) Status() (status []Status, err error) {
+	var ws []*database.WebhookDelivery
+	if ws, err = s.db.WebhookDeliveries(); chk.E(err) {
+		return
+	}
+	byName := make(map[string]*Status, len(s.hooks))
+	status = make([]Status, len(s.hooks))
+	s.statsMx.Lock()
+	for i, h := range s.hooks {
+		status[i] = Status{Name: h.Name, URL: h.URL, Stats: *s.stats[h.Name]}
+		byName[h.Name] = &status[i]
+	}
+	s.statsMx.Unlock()
+	for _, w := range ws {
+		st, ok := byName[w.Hook]
+		if !ok {
+			continue
+		}
+		if w.Failed {
+			st.Failures = append(st.Failures, w)
+		} else {
+			st.Pending++
+		}
+	}
+	return
+}
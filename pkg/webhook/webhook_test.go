@@ -0,0 +1,274 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"next.orly.dev/pkg/crypto/p256k"
+	"next.orly.dev/pkg/crypto/sha256"
+	"next.orly.dev/pkg/database"
+	"next.orly.dev/pkg/encoders/event"
+	"next.orly.dev/pkg/encoders/hex"
+	"next.orly.dev/pkg/encoders/timestamp"
+)
+
+func newSigner(t *testing.T) (sign *p256k.Signer) {
+	t.Helper()
+	sign = new(p256k.Signer)
+	if err := sign.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+// newEvent creates and signs an event of a kind.
+func newEvent(t *testing.T, sign *p256k.Signer, k uint16) (ev *event.E) {
+	t.Helper()
+	ev = event.New()
+	ev.Kind = k
+	ev.Pubkey = sign.Pub()
+	ev.CreatedAt = timestamp.Now().V
+	ev.Content = []byte("webhook test")
+	if err := ev.Sign(sign); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+// writeHooks writes a webhooks file.
+func writeHooks(t *testing.T, hooks string) (path string) {
+	t.Helper()
+	path = filepath.Join(t.TempDir(), "webhooks.json")
+	if err := os.WriteFile(path, []byte(hooks), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestLoad(t *testing.T) {
+	for _, bad := range []string{
+		`[{"name": "", "url": "http://example.com"}]`,
+		`[{"name": "a:b", "url": "http://example.com"}]`,
+		`[{"name": "a", "url": "ftp://example.com"}]`,
+		`[{"name": "a", "url": "http://a"}, {"name": "a", "url": "http://b"}]`,
+		`[{"name": "a", "url": "http://a", "filter": {"kinds": [}}]`,
+	} {
+		if _, err := Load(writeHooks(t, bad)); err == nil {
+			t.Errorf("invalid webhooks %s were accepted", bad)
+		}
+	}
+}
+
+func TestDelivery(t *testing.T) {
+	tempDir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, err := database.New(ctx, cancel, tempDir, "error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	relay, author := newSigner(t), newSigner(t)
+	var fail atomic.Bool
+	received := make(chan Payload, 10)
+	srv := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				if fail.Load() {
+					http.Error(w, "down", http.StatusServiceUnavailable)
+					return
+				}
+				body, _ := io.ReadAll(r.Body)
+				sig, _ := hex.Dec(r.Header.Get("X-Nostr-Signature"))
+				pub, _ := hex.Dec(r.Header.Get("X-Nostr-Pubkey"))
+				verifier := new(p256k.Signer)
+				if err := verifier.InitPub(pub); err != nil {
+					t.Error(err)
+				}
+				hash := sha256.Sum256(body)
+				if ok, _ := verifier.Verify(hash[:], sig); !ok {
+					t.Error("webhook request signature is invalid")
+				}
+				var p Payload
+				if err := json.Unmarshal(body, &p); err != nil {
+					t.Error(err)
+				}
+				received <- p
+			},
+		),
+	)
+	defer srv.Close()
+	hooks, err := Load(
+		writeHooks(
+			t, `[{"name": "zaps", "url": "`+srv.URL+`", "filter": {"kinds": [9735]}}]`,
+		),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(ctx, db, hooks, relay)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	note, zap := newEvent(t, author, 1), newEvent(t, author, 9735)
+	for _, ev := range []*event.E{note, zap} {
+		if _, _, err = db.SaveEvent(ctx, ev); err != nil {
+			t.Fatal(err)
+		}
+		s.Enqueue(ev)
+	}
+	status := func() Status {
+		t.Helper()
+		st, err := s.Status()
+		if err != nil || len(st) != 1 {
+			t.Fatalf("unexpected status %+v: %v", st, err)
+		}
+		return st[0]
+	}
+	if st := status(); st.Pending != 1 {
+		t.Fatalf("expected the zap to be queued, status %+v", st)
+	}
+	// a failed delivery is kept and retried after a delay
+	fail.Store(true)
+	s.deliverDue()
+	ws, err := db.WebhookDeliveries()
+	if err != nil || len(ws) != 1 || ws[0].Attempts != 1 ||
+		!ws[0].Next.Equal(now.Add(retryBase)) {
+		t.Fatalf("failed delivery was not rescheduled: %+v, %v", ws, err)
+	}
+	fail.Store(false)
+	s.deliverDue()
+	if len(received) != 0 {
+		t.Fatal("delivery was retried before it was due")
+	}
+	now = now.Add(retryBase)
+	s.deliverDue()
+	select {
+	case p := <-received:
+		if p.Hook != "zaps" || !json.Valid(p.Event) {
+			t.Errorf("unexpected payload %+v", p)
+		}
+	default:
+		t.Fatal("zap was not delivered")
+	}
+	if st := status(); st.Pending != 0 || st.Delivered != 1 {
+		t.Errorf("unexpected status after delivery %+v", st)
+	}
+	// a delivery is given up after the maximum number of attempts
+	fail.Store(true)
+	zap2 := newEvent(t, author, 9735)
+	if _, _, err = db.SaveEvent(ctx, zap2); err != nil {
+		t.Fatal(err)
+	}
+	s.Enqueue(zap2)
+	for i := 0; i < maxAttempts; i++ {
+		now = now.Add(retryMax)
+		s.deliverDue()
+	}
+	if st := status(); st.Pending != 0 || st.Failed != 1 ||
+		len(st.Failures) != 1 {
+		t.Errorf("unexpected status after giving up %+v", st)
+	}
+}
+
+func TestConcurrentDelivery(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, err := database.New(ctx, cancel, t.TempDir(), "error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	release := make(chan struct{})
+	slow := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) { <-release },
+		),
+	)
+	defer slow.Close()
+	received := make(chan struct{}, 1)
+	fast := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				received <- struct{}{}
+			},
+		),
+	)
+	defer fast.Close()
+	hooks, err := Load(
+		writeHooks(
+			t, `[{"name": "slow", "url": "`+slow.URL+`"}, `+
+				`{"name": "fast", "url": "`+fast.URL+`"}]`,
+		),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(ctx, db, hooks, newSigner(t))
+	ev := newEvent(t, newSigner(t), 1)
+	if _, _, err = db.SaveEvent(ctx, ev); err != nil {
+		t.Fatal(err)
+	}
+	s.Enqueue(ev)
+	done := make(chan struct{})
+	go func() {
+		s.deliverDue()
+		close(done)
+	}()
+	// the fast webhook is not held up by the slow one
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("delivery to the fast webhook waited for the slow one")
+	}
+	close(release)
+	<-done
+	if ws, err := db.DueWebhooks(time.Now(), dueBatch); err != nil ||
+		len(ws) != 0 {
+		t.Errorf("deliveries are still due after they were sent: %+v", ws)
+	}
+}
+
+func TestPruneFailed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, err := database.New(ctx, cancel, t.TempDir(), "error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		if err = db.UpdateWebhook(
+			&database.WebhookDelivery{
+				Hook: "a", EventID: hex.Enc([]byte{byte(i)}),
+				Next: now.Add(time.Duration(i) * time.Second), Failed: true,
+			},
+		); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ws, err := db.DueWebhooks(now.Add(time.Hour), dueBatch); err != nil ||
+		len(ws) != 0 {
+		t.Fatalf("deliveries that were given up are due: %+v", ws)
+	}
+	if err = db.PruneFailedWebhooks("a", 2); err != nil {
+		t.Fatal(err)
+	}
+	ws, err := db.WebhookDeliveries()
+	if err != nil || len(ws) != 2 {
+		t.Fatalf("expected 2 failed deliveries to be kept, got %+v", ws)
+	}
+	for _, w := range ws {
+		if w.EventID != "03" && w.EventID != "04" {
+			t.Errorf("an older failed delivery %s was kept", w.EventID)
+		}
+	}
+}